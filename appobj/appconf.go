@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/1414C/libraryapp/controllers"
 	"github.com/1414C/lw"
 )
 
@@ -26,6 +27,9 @@ type LeadSetGetConfig struct {
 	Redis      RedisGKVSConfig      `json:"redis"`
 	Memcached  MemcachedGKVSConfig  `json:"memcached"`
 	Sluggo     SluggoGKVSConfig     `json:"sluggo"`
+	Raft       RaftGKVSConfig       `json:"raft"`
+	File       FileGKVSConfig       `json:"file"`
+	Database   DatabaseGKVSConfig   `json:"database"`
 }
 
 // StandAloneGKVSConfig holds the configuration values used to establish
@@ -63,6 +67,62 @@ type SluggoGKVSConfig struct {
 	SluggoAddress string `json:"sluggo_address"`
 }
 
+// GroupTLSConfig holds the mutual-TLS configuration of the internal
+// group-membership traffic.  Each process presents the certificate in
+// CertFile, which must be issued by the cluster CA in CAFile and be valid
+// for the host of the process's internal_address.  Peers presenting a
+// certificate that was not issued by the cluster CA are rejected.
+type GroupTLSConfig struct {
+	Active   bool   `json:"active"`
+	CAFile   string `json:"ca_file"`
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+}
+
+// RaftGKVSConfig holds the configuration values of the embedded raft
+// cluster when it is being used as the group-leadership KVS.  Each
+// application server runs a raft node listening on RaftAddress; Peers
+// holds the raft addresses of all nodes of the cluster.  The group-leader
+// record can only be written while a majority of the nodes is reachable.
+// The state of the node is persisted in DataFile.
+type RaftGKVSConfig struct {
+	Active              bool     `json:"active"`
+	RaftAddress         string   `json:"raft_address"`
+	Peers               []string `json:"peers"`
+	DataFile            string   `json:"data_file"`
+	ElectionTimeoutMs   uint     `json:"election_timeout_ms"`
+	HeartbeatIntervalMs uint     `json:"heartbeat_interval_ms"`
+}
+
+// FileGKVSConfig holds the configuration values used when a local file
+// is being used as the group-leadership KVS.  This is suitable for running
+// several application servers on a single host; all of them must share the
+// same FilePath.  The file is guarded by a lock-file created alongside it.
+type FileGKVSConfig struct {
+	Active   bool   `json:"active"`
+	FilePath string `json:"file_path"`
+}
+
+// DatabaseGKVSConfig holds the configuration values used when the
+// application's own db is being used as the group-leadership KVS.  The
+// group-leader record is kept in the groupleader table and is updated
+// using compare-and-swap.
+type DatabaseGKVSConfig struct {
+	Active bool `json:"active"`
+}
+
+// FailureDetectorConfig selects the failure detector of the group-membership
+// server.  The "counter" detector declares a suspect process failed after it has
+// missed failure_threshold pings.  The "phi_accrual" detector adapts to the
+// observed ping round-trips of each process and declares a suspect process
+// failed once its suspicion level reaches PhiThreshold; PhiWindowSize is the
+// number of round-trips kept per process.
+type FailureDetectorConfig struct {
+	Type          string  `json:"type"`
+	PhiThreshold  float64 `json:"phi_threshold"`
+	PhiWindowSize int     `json:"phi_window_size"`
+}
+
 // LogConfig holds logging config info
 type LogConfig struct {
 	Active        bool `json:"active"`
@@ -171,6 +231,21 @@ func DefaultLeadSetGetConfig() LeadSetGetConfig {
 			Active:        false,
 			SluggoAddress: "127.0.0.1:7070",
 		},
+		Raft: RaftGKVSConfig{
+			Active:              false,
+			RaftAddress:         "127.0.0.1:4445",
+			Peers:               []string{"127.0.0.1:4445"},
+			DataFile:            "raft.state",
+			ElectionTimeoutMs:   1000,
+			HeartbeatIntervalMs: 200,
+		},
+		File: FileGKVSConfig{
+			Active:   false,
+			FilePath: "group_leader.kvs",
+		},
+		Database: DatabaseGKVSConfig{
+			Active: false,
+		},
 	}
 }
 
@@ -179,32 +254,47 @@ func DefaultLeadSetGetConfig() LeadSetGetConfig {
 // generate the jwt-token in cases where the access token was not created
 // by an IDP.
 type Config struct {
-	ExternalAddress     string              `json:"external_address"`
-	InternalAddress     string              `json:"internal_address"`
-	Env                 string              `json:"env"`
-	PingCycle           uint                `json:"ping_cycle"`
-	FailureThreshold    uint64              `json:"failure_threshold"`
-	Pepper              string              `json:"pepper"`
-	Database            DBConfig            `json:"database"`
-	LeadSetGet          LeadSetGetConfig    `json:"group_leader_kvs"`
-	Logging             LogConfig           `json:"logging"`
-	CertFile            string              `json:"cert_file"`
-	KeyFile             string              `json:"key_file"`
-	RSA256PrivKeyFile   string              `json:"rsa256_priv_key_file"`
-	RSA256PubKeyFile    string              `json:"rsa256_pub_key_file"`
-	RSA384PrivKeyFile   string              `json:"rsa384_priv_key_file"`
-	RSA384PubKeyFile    string              `json:"rsa384_pub_key_file"`
-	RSA512PrivKeyFile   string              `json:"rsa512_priv_key_file"`
-	RSA512PubKeyFile    string              `json:"rsa512_pub_key_file"`
-	ECDSA256PrivKeyFile string              `json:"ecdsa256_priv_key_file"`
-	ECDSA256PubKeyFile  string              `json:"ecdsa256_pub_key_file"`
-	ECDSA384PrivKeyFile string              `json:"ecdsa384_priv_key_file"`
-	ECDSA384PubKeyFile  string              `json:"ecdsa384_pub_key_file"`
-	ECDSA521PrivKeyFile string              `json:"ecdsa521_priv_key_file"`
-	ECDSA521PubKeyFile  string              `json:"ecdsa521_pub_key_file"`
-	JWTSignMethod       string              `json:"jwt_sign_method"`
-	JWTLifetime         uint                `json:"jwt_lifetime"`
-	ServiceActivations  []ServiceActivation `json:"service_activations"`
+	ExternalAddress     string                         `json:"external_address"`
+	InternalAddress     string                         `json:"internal_address"`
+	Env                 string                         `json:"env"`
+	PingCycle           uint                           `json:"ping_cycle"`
+	FailureThreshold    uint64                         `json:"failure_threshold"`
+	FailureDetector     FailureDetectorConfig          `json:"failure_detector"`
+	AntiEntropyCycle    uint                           `json:"anti_entropy_cycle"`
+	Pepper              string                         `json:"pepper"`
+	Database            DBConfig                       `json:"database"`
+	LeadSetGet          LeadSetGetConfig               `json:"group_leader_kvs"`
+	GroupTLS            GroupTLSConfig                 `json:"group_tls"`
+	ClusterKeyFile      string                         `json:"cluster_key_file"`
+	ClusterKeyEnv       string                         `json:"cluster_key_env"`
+	JWTKeyEncKeyFile    string                         `json:"jwt_key_enc_key_file"`
+	JWTKeyEncKeyEnv     string                         `json:"jwt_key_enc_key_env"`
+	Logging             LogConfig                      `json:"logging"`
+	CertFile            string                         `json:"cert_file"`
+	KeyFile             string                         `json:"key_file"`
+	RSA256PrivKeyFile   string                         `json:"rsa256_priv_key_file"`
+	RSA256PubKeyFile    string                         `json:"rsa256_pub_key_file"`
+	RSA384PrivKeyFile   string                         `json:"rsa384_priv_key_file"`
+	RSA384PubKeyFile    string                         `json:"rsa384_pub_key_file"`
+	RSA512PrivKeyFile   string                         `json:"rsa512_priv_key_file"`
+	RSA512PubKeyFile    string                         `json:"rsa512_pub_key_file"`
+	ECDSA256PrivKeyFile string                         `json:"ecdsa256_priv_key_file"`
+	ECDSA256PubKeyFile  string                         `json:"ecdsa256_pub_key_file"`
+	ECDSA384PrivKeyFile string                         `json:"ecdsa384_priv_key_file"`
+	ECDSA384PubKeyFile  string                         `json:"ecdsa384_pub_key_file"`
+	ECDSA521PrivKeyFile string                         `json:"ecdsa521_priv_key_file"`
+	ECDSA521PubKeyFile  string                         `json:"ecdsa521_pub_key_file"`
+	Ed25519PrivKeyFile  string                         `json:"ed25519_priv_key_file"`
+	Ed25519PubKeyFile   string                         `json:"ed25519_pub_key_file"`
+	HMACKeyFile         string                         `json:"hmac_key_file"`
+	HMACKeyEnv          string                         `json:"hmac_key_env"`
+	JWTSignMethod       string                         `json:"jwt_sign_method"`
+	JWTLifetime         uint                           `json:"jwt_lifetime"`
+	OIDC                controllers.OIDCConfig         `json:"oidc"`
+	Registration        controllers.RegistrationConfig `json:"registration"`
+	UsrMemberCleanup    uint                           `json:"usr_member_cleanup_interval"`
+	PolicyFile          string                         `json:"policy_file"`
+	ServiceActivations  []ServiceActivation            `json:"service_activations"`
 }
 
 // IsProd informs the app which environment it is running in
@@ -246,9 +336,16 @@ func DefaultConfig() Config {
 		Env:                 "def",
 		PingCycle:           1,
 		FailureThreshold:    5,
+		FailureDetector:     FailureDetectorConfig{Type: "counter", PhiThreshold: 8, PhiWindowSize: 100},
+		AntiEntropyCycle:    30,
 		Pepper:              "secret-pepper-key",
 		Database:            DefaultDBConfig(),
 		LeadSetGet:          DefaultLeadSetGetConfig(),
+		GroupTLS:            GroupTLSConfig{Active: false},
+		ClusterKeyFile:      "",
+		ClusterKeyEnv:       "",
+		JWTKeyEncKeyFile:    "",
+		JWTKeyEncKeyEnv:     "",
 		Logging:             DefaultLogConfig(),
 		CertFile:            "", // https
 		KeyFile:             "", // https
@@ -264,8 +361,16 @@ func DefaultConfig() Config {
 		ECDSA384PubKeyFile:  "jwtkeys/ecdsa/ec384.priv.pem",
 		ECDSA521PrivKeyFile: "",
 		ECDSA521PubKeyFile:  "",
+		Ed25519PrivKeyFile:  "",
+		Ed25519PubKeyFile:   "",
+		HMACKeyFile:         "",
+		HMACKeyEnv:          "",
 		JWTSignMethod:       "ES384",
 		JWTLifetime:         120,
+		OIDC:                controllers.OIDCConfig{Active: false},
+		Registration:        controllers.RegistrationConfig{Mode: controllers.RegistrationDisabled, InviteLifetime: 72},
+		UsrMemberCleanup:    60,
+		PolicyFile:          "",
 		ServiceActivations:  DefaultServiceActivations(),
 	}
 }
@@ -2,6 +2,7 @@ package appobj
 
 import (
 	"context"
+	"crypto"
 	"crypto/tls"
 	"errors"
 	"fmt"
@@ -27,16 +28,25 @@ import (
 
 // AppObj is the one and only application object
 type AppObj struct {
-	cfg        Config
-	dbConfig   DBConfig
-	services   *models.Services
-	libraryC   *controllers.LibraryController
-	bookC      *controllers.BookController
-	usrC       *controllers.UsrController
-	usrgroupC  *controllers.UsrGroupController
-	authC      *controllers.AuthController
-	groupauthC *controllers.GroupAuthController
-	router     *mux.Router
+	cfg         Config
+	dbConfig    DBConfig
+	services    *models.Services
+	libraryC    *controllers.LibraryController
+	bookC       *controllers.BookController
+	usrC        *controllers.UsrController
+	usrgroupC   *controllers.UsrGroupController
+	authC       *controllers.AuthController
+	groupauthC  *controllers.GroupAuthController
+	apikeyC     *controllers.APIKeyController
+	usrinviteC  *controllers.UsrInviteController
+	jwtkeyC     *controllers.JWTKeyController
+	authzC      *controllers.AuthzController
+	authzTC     *controllers.AuthzTransferController
+	introspectC *controllers.IntrospectController
+	clusterC    *controllers.ClusterController
+	cacheC      *controllers.CacheController
+	gmServ      *gmsrv.GMServ
+	router      *mux.Router
 	// jwt support
 	jwtKeyMap map[string]interface{}
 }
@@ -78,12 +88,23 @@ func (a *AppObj) Initialize(dev, prd, dr, rs bool) {
 		a.cfg = LoadConfig(cDev)
 	}
 
+	// the P-521 curve is signed with jwt alg ES512; accept the curve name too
+	if a.cfg.JWTSignMethod == "ES521" {
+		a.cfg.JWTSignMethod = "ES512"
+	}
+
 	// get the DB config
 	a.dbConfig = a.cfg.Database
 
 	// initialize application logging
 	a.initializeLogging(a.cfg.Logging)
 
+	// protect the group-membership traffic with mutual-TLS if configured
+	a.initializeGroupTLS()
+
+	// authenticate the group-membership traffic with the cluster key if configured
+	a.initializeClusterKey()
+
 	// create Services
 	a.createServices(a.dbConfig.ORMDebugTraceActive, a.dbConfig.ORMLogActive)
 
@@ -102,16 +123,25 @@ func (a *AppObj) Initialize(dev, prd, dr, rs bool) {
 	a.createControllers()
 
 	// initialize active usr cache/buffer
-	a.initializeCachedActiveUsrs()
+	fatal(a.initializeCachedActiveUsrs())
 	// a.actUsrs.ActiveUsrs["admin"] = false
 
 	// initialize the auths cache/buffer
-	a.initializeCachedAuths()
+	fatal(a.initializeCachedAuths())
 
-	a.initializeCachedUsrGroups()
+	fatal(a.initializeCachedUsrGroups())
+
+	// initialize the usr group-membership cache/buffer
+	fatal(a.initializeCachedUsrMembers())
 
 	// intitialize the group auths cache/buffer
-	a.initializeCachedGroupAuths()
+	fatal(a.initializeCachedGroupAuths())
+
+	// initialize the jwt key-set cache/buffer
+	a.initializeCachedJWTKeys()
+
+	// initialize the revoked usr session cache/buffer
+	a.initializeCachedSessions()
 
 	// initialize Routes
 	a.initializeRoutes()
@@ -124,7 +154,7 @@ func (a *AppObj) Initialize(dev, prd, dr, rs bool) {
 		// assign all current Auths to the Super UsrGroup / rebuild Super UsrGroup Auths?
 		a.initializeSuperGroup(auths, rs)
 		if rs {
-			a.initializeCachedGroupAuths()
+			fatal(a.initializeCachedGroupAuths())
 			a.initializeRoutes()
 		}
 	}
@@ -146,6 +176,11 @@ func (a *AppObj) automigrate() {
 	if err := a.services.AlterAllTables(); err != nil {
 		panic(err)
 	}
+
+	// move legacy Usr.Groups strings into the UsrGroupMember relation
+	if err := a.services.MigrateUsrGroupMembers(); err != nil {
+		panic(err)
+	}
 }
 
 // initializeLogging sets up the logger to stdout.  replace nil with your
@@ -183,6 +218,15 @@ func (a *AppObj) CreateLeadSetGet() gmcom.GMLeaderSetterGetter {
 	if a.cfg.LeadSetGet.Sluggo.Active {
 		c++
 	}
+	if a.cfg.LeadSetGet.Raft.Active {
+		c++
+	}
+	if a.cfg.LeadSetGet.File.Active {
+		c++
+	}
+	if a.cfg.LeadSetGet.Database.Active {
+		c++
+	}
 
 	if c == 0 || c > 1 {
 		lw.Fatal(errors.New("only one group_leader_kvs subsystem may be set as active in the application server configuration file"))
@@ -215,6 +259,30 @@ func (a *AppObj) CreateLeadSetGet() gmcom.GMLeaderSetterGetter {
 			internalAddress: a.cfg.LeadSetGet.Sluggo.SluggoAddress,
 		}
 	}
+	if a.cfg.LeadSetGet.Raft.Active {
+		k := &RaftLeadSetGet{}
+		err := k.InitializeRaftLeadSetGet(a.cfg.LeadSetGet.Raft)
+		if err != nil {
+			panic(err)
+		}
+		return k
+	}
+	if a.cfg.LeadSetGet.File.Active {
+		k := &FileLeadSetGet{}
+		err := k.InitializeFileLeadSetGet(a.cfg.LeadSetGet.File)
+		if err != nil {
+			panic(err)
+		}
+		return k
+	}
+	if a.cfg.LeadSetGet.Database.Active {
+		k := &DatabaseLeadSetGet{}
+		err := k.InitializeDatabaseLeadSetGet(a.services.GroupLeader)
+		if err != nil {
+			panic(err)
+		}
+		return k
+	}
 	return nil
 }
 
@@ -226,8 +294,14 @@ func (a *AppObj) createServices(dbDebugLog, dbLog bool) {
 		models.WithLogMode(dbDebugLog),
 		models.WithUsr(a.cfg.Pepper),
 		models.WithUsrGroup(),
+		models.WithUsrGroupMember(),
 		models.WithAuth(),
 		models.WithGroupAuth(),
+		models.WithAPIKey(a.cfg.Pepper),
+		models.WithUsrInvite(a.cfg.Pepper),
+		models.WithUsrSession(),
+		models.WithGroupLeader(),
+		models.WithJWTKey(a.jwtKeyEncKey()),
 		models.WithLibrary(),
 		models.WithBook(),
 		// models.With<Entity>,
@@ -376,88 +450,303 @@ func (a *AppObj) initializeJWTKeys() {
 		a.jwtKeyMap["RS512VerifyKey"] = verifyKey
 	}
 
+	lw.Console("Ed25519PrivKeyFile: %v", a.cfg.Ed25519PrivKeyFile)
+	lw.Console("Ed25519PubKeyFile: %v", a.cfg.Ed25519PubKeyFile)
+	if a.cfg.Ed25519PrivKeyFile != "" {
+		signBytes, err := ioutil.ReadFile(a.cfg.Ed25519PrivKeyFile)
+		fatal(err)
+
+		signKey, err := gmcom.ParseEdPrivateKeyFromPEM(signBytes)
+		fatal(err)
+
+		a.jwtKeyMap["EdDSASignKey"] = signKey
+	}
+
+	if a.cfg.Ed25519PubKeyFile != "" {
+		verifyBytes, err := ioutil.ReadFile(a.cfg.Ed25519PubKeyFile)
+		fatal(err)
+
+		verifyKey, err := gmcom.ParseEdPublicKeyFromPEM(verifyBytes)
+		fatal(err)
+
+		a.jwtKeyMap["EdDSAVerifyKey"] = verifyKey
+	}
+
+	// the hmac shared-secret is read from a file or an environment variable so
+	// that it does not have to be kept in the config file.
+	lw.Console("HMACKeyFile: %v", a.cfg.HMACKeyFile)
+	lw.Console("HMACKeyEnv: %v", a.cfg.HMACKeyEnv)
+	var secret []byte
+	if a.cfg.HMACKeyFile != "" {
+		b, err := ioutil.ReadFile(a.cfg.HMACKeyFile)
+		fatal(err)
+		secret = []byte(strings.TrimSpace(string(b)))
+	} else if a.cfg.HMACKeyEnv != "" {
+		secret = []byte(os.Getenv(a.cfg.HMACKeyEnv))
+	}
+	if len(secret) > 0 {
+		a.jwtKeyMap["HMACKey"] = secret
+	}
+
+	// RFC 7518 3.2 - the secret must be at least as long as the hash output
+	switch a.cfg.JWTSignMethod {
+	case "HS256", "HS384", "HS512":
+		minLen, _ := strconv.Atoi(a.cfg.JWTSignMethod[2:])
+		if len(secret) < minLen/8 {
+			fatal(fmt.Errorf("jwt signing-method %s requires an hmac secret of at least %d bytes via hmac_key_file or hmac_key_env", a.cfg.JWTSignMethod, minLen/8))
+		}
+	}
 }
 
 // createControllers for each entity
 func (a *AppObj) createControllers() {
-	a.usrC = controllers.NewUsrController(a.services.Usr, a.jwtKeyMap, a.cfg.JWTSignMethod, a.cfg.JWTLifetime, a.cfg.InternalAddress)
-	a.usrgroupC = controllers.NewUsrGroupController(a.services.UsrGroup, a.cfg.InternalAddress)
+	a.usrC = controllers.NewUsrController(a.services.Usr, a.services.UsrGroup, a.services.UsrGroupMember, a.jwtKeyMap, a.cfg.JWTSignMethod, a.cfg.JWTLifetime, a.cfg.InternalAddress)
+	a.usrgroupC = controllers.NewUsrGroupController(a.services.UsrGroup, a.services.UsrGroupMember, a.services.Usr, a.cfg.InternalAddress)
 	a.authC = controllers.NewAuthController(a.services.Auth, a.cfg.InternalAddress)
 	a.groupauthC = controllers.NewGroupAuthController(a.services.GroupAuth, a.cfg.InternalAddress)
+	a.apikeyC = controllers.NewAPIKeyController(a.services.APIKey, a.services.Usr)
+	a.usrinviteC = controllers.NewUsrInviteController(a.services.UsrInvite, a.services.UsrGroup, a.cfg.Registration.InviteLifetime)
+	a.jwtkeyC = controllers.NewJWTKeyController(a.services.JWTKey, a.cfg.JWTSignMethod, a.cfg.JWTLifetime, a.cfg.InternalAddress)
 	a.libraryC = controllers.NewLibraryController(a.services.Library, *a.services)
 	a.bookC = controllers.NewBookController(a.services.Book, *a.services)
+
+	// the group-membership server is started in Run
+	a.gmServ = &gmsrv.GMServ{
+		FailureDetector:  a.newFailureDetector(),
+		AntiEntropyCycle: time.Duration(a.cfg.AntiEntropyCycle) * time.Second,
+	}
+	a.clusterC = controllers.NewClusterController(a.gmServ)
+	a.cacheC = controllers.NewCacheController(a.reloadAuthzCaches)
+
+	// enable the oidc relying-party login if configured
+	if a.cfg.OIDC.Active {
+		fatal(a.usrC.InitOIDC(a.cfg.OIDC))
+	}
+
+	// self-registration mode; disabled unless configured
+	fatal(a.usrC.InitRegistration(a.cfg.Registration, a.services.UsrInvite))
+
+	// record a usr session for each issued token
+	a.usrC.InitSessions(a.services.UsrSession)
+}
+
+// newFailureDetector returns the configured failure detector of the
+// group-membership server.  nil is returned for the counter detector, which the
+// server creates from the failure_threshold.
+func (a *AppObj) newFailureDetector() gmsrv.FailureDetector {
+
+	fd := a.cfg.FailureDetector
+	switch fd.Type {
+	case "", "counter":
+		return nil
+	case "phi_accrual":
+		return gmsrv.NewPhiAccrualDetector(fd.PhiThreshold, fd.PhiWindowSize, time.Duration(a.cfg.PingCycle)*time.Second)
+	default:
+		fatal(fmt.Errorf("unknown failure_detector type %q", fd.Type))
+	}
+	return nil
+}
+
+// initializeGroupTLS enables mutual-TLS for the group-membership listener and
+// the cache-update clients.
+func (a *AppObj) initializeGroupTLS() {
+
+	if !a.cfg.GroupTLS.Active {
+		return
+	}
+	ct, err := gmcom.NewClusterTLS(a.cfg.GroupTLS.CAFile, a.cfg.GroupTLS.CertFile, a.cfg.GroupTLS.KeyFile)
+	fatal(err)
+	gmcom.SetClusterTLS(ct)
 }
 
-// initialize the list of cached active usrs
-func (a *AppObj) initializeCachedActiveUsrs() {
+// initializeClusterKey enables the authentication of the group-membership and
+// cache-update traffic with a shared cluster key.  As with the jwt hmac secret,
+// the key is read from a file or an environment variable so that it does not
+// have to be kept in the config file.  All group-members must share the key.
+func (a *AppObj) initializeClusterKey() {
 
-	if a.usrC.ActUsrsH != nil {
-		a.usrC.ActUsrsH.Lock()
-		defer a.usrC.ActUsrsH.Unlock()
+	var key []byte
+	if a.cfg.ClusterKeyFile != "" {
+		b, err := ioutil.ReadFile(a.cfg.ClusterKeyFile)
+		fatal(err)
+		key = []byte(strings.TrimSpace(string(b)))
+	} else if a.cfg.ClusterKeyEnv != "" {
+		key = []byte(os.Getenv(a.cfg.ClusterKeyEnv))
+	}
+	if len(key) == 0 {
+		return
+	}
+
+	// the key is used with hmac-sha256
+	if len(key) < 32 {
+		fatal(fmt.Errorf("the cluster key must be at least 32 bytes long"))
+	}
+	gmcom.SetClusterKey(key)
+	lw.Console("group-membership traffic is authenticated with the cluster key")
+}
+
+// jwtKeyEncKey returns the key used to encrypt the private keys of the jwt
+// key-set in the db.  As with the cluster key, the key is read from a file or
+// an environment variable.  Signing-key rotation is unavailable without it.
+func (a *AppObj) jwtKeyEncKey() []byte {
+
+	var key []byte
+	if a.cfg.JWTKeyEncKeyFile != "" {
+		b, err := ioutil.ReadFile(a.cfg.JWTKeyEncKeyFile)
+		fatal(err)
+		key = []byte(strings.TrimSpace(string(b)))
+	} else if a.cfg.JWTKeyEncKeyEnv != "" {
+		key = []byte(os.Getenv(a.cfg.JWTKeyEncKeyEnv))
+	}
+	if len(key) != 0 && len(key) < 32 {
+		fatal(fmt.Errorf("the jwt key encryption key must be at least 32 bytes long"))
+	}
+	return key
+}
+
+// initialize the list of cached active usrs.  The cache is refilled in place
+// if it exists already, as the group-membership server and the middleware
+// hold on to it.  The version stamps of the cache date from the start of the
+// db read; see gmcom/gmantientropy.go.  The cache and its version stamps are
+// left unchanged if the db read fails, as a fresh Base would cause the other
+// group-members to drop their entries.
+func (a *AppObj) initializeCachedActiveUsrs() error {
+
+	if a.usrC.ActUsrsH == nil {
+		a.usrC.ActUsrsH = &gmcom.ActUsrsH{}
+	}
+	base := time.Now().UnixNano()
+	activeUsrs := make(map[uint64]bool)
+	u, err := a.services.Usr.GetUsrs()
+	if err != nil {
+		return err
 	}
-	a.usrC.ActUsrsH = &gmcom.ActUsrsH{}
-	a.usrC.ActUsrsH.ActiveUsrs = make(map[uint64]bool)
-	u := a.services.Usr.GetUsrs()
 	for _, v := range u {
 		if v.Active == true {
-			a.usrC.ActUsrsH.ActiveUsrs[v.ID] = true
+			activeUsrs[v.ID] = true
 		}
 	}
+
+	a.usrC.ActUsrsH.Lock()
+	defer a.usrC.ActUsrsH.Unlock()
+	a.usrC.ActUsrsH.ActiveUsrs = activeUsrs
+	a.usrC.ActUsrsH.Versions.Reset(base)
+	return nil
 }
 
-// initialize the list of cached auths
-func (a *AppObj) initializeCachedAuths() {
+// initialize the list of cached auths; the cache is refilled in place if it
+// exists already.
+func (a *AppObj) initializeCachedAuths() error {
 
-	// use mutex as a precautionary measure in case the method is called in a running process
-	if a.authC.AuthsH != nil {
-		a.authC.AuthsH.Lock()
-		defer a.authC.AuthsH.Unlock()
+	if a.authC.AuthsH == nil {
+		a.authC.AuthsH = &gmcom.AuthsH{}
+	}
+	base := time.Now().UnixNano()
+	auths := make(map[uint64]string)
+	at, err := a.services.Auth.GetAuths()
+	if err != nil {
+		return err
 	}
-	a.authC.AuthsH = &gmcom.AuthsH{}
-	a.authC.AuthsH.Auths = make(map[uint64]string)
-	at := a.services.Auth.GetAuths()
 	for _, v := range at {
-		a.authC.AuthsH.Auths[v.ID] = v.AuthName
+		auths[v.ID] = v.AuthName
 	}
+
+	a.authC.AuthsH.Lock()
+	defer a.authC.AuthsH.Unlock()
+	a.authC.AuthsH.Auths = auths
+	a.authC.AuthsH.Versions.Reset(base)
+	return nil
 }
 
-// initialize the list of cached usrgroups
-func (a *AppObj) initializeCachedUsrGroups() {
+// initialize the list of cached usrgroups; the cache is refilled in place if
+// it exists already.
+func (a *AppObj) initializeCachedUsrGroups() error {
 
-	// use mutex as a precautionary measure in case the method is called in a running process
-	if a.usrgroupC.UsrGroupsH != nil {
-		a.usrgroupC.UsrGroupsH.Lock()
-		defer a.usrgroupC.UsrGroupsH.Unlock()
+	if a.usrgroupC.UsrGroupsH == nil {
+		a.usrgroupC.UsrGroupsH = &gmcom.UsrGroupsH{}
+	}
+	base := time.Now().UnixNano()
+	groupNames := make(map[uint64]string)
+	ug, err := a.services.UsrGroup.GetUsrGroups()
+	if err != nil {
+		return err
 	}
-	a.usrgroupC.UsrGroupsH = &gmcom.UsrGroupsH{}
-	a.usrgroupC.UsrGroupsH.GroupNames = make(map[uint64]string)
-	ug := a.services.UsrGroup.GetUsrGroups()
 	for _, v := range ug {
-		a.usrgroupC.UsrGroupsH.GroupNames[v.ID] = v.GroupName
+		groupNames[v.ID] = v.GroupName
 	}
+
+	a.usrgroupC.UsrGroupsH.Lock()
+	defer a.usrgroupC.UsrGroupsH.Unlock()
+	a.usrgroupC.UsrGroupsH.GroupNames = groupNames
+	a.usrgroupC.UsrGroupsH.Versions.Reset(base)
+	return nil
 }
 
-// initialize the list of cached groupauths
-func (a *AppObj) initializeCachedGroupAuths() {
+// initialize the cached usr group-memberships; the cache is refilled in place
+// if it exists already, as the middleware and the authz and introspection
+// controllers hold on to it.
+func (a *AppObj) initializeCachedUsrMembers() error {
 
-	// use mutex as a precautionary measure in case the method is called in a running process
-	if a.groupauthC.GroupAuthsH != nil {
-		a.groupauthC.GroupAuthsH.Lock()
-		defer a.groupauthC.GroupAuthsH.Unlock()
-	}
-	a.groupauthC.GroupAuthsH = &gmcom.GroupAuthsH{}
-	a.groupauthC.GroupAuthsH.GroupAuths = make(map[string]map[string]bool)
-	a.groupauthC.GroupAuthsH.GroupAuthsID = make(map[uint64]gmcom.GroupAuthNames) // deletion support
-	g := a.services.GroupAuth.GetGroupAuths()
+	if a.usrC.UsrMembersH == nil {
+		a.usrC.UsrMembersH = &gmcom.UsrMembersH{}
+	}
+	base := time.Now().UnixNano()
+	usrGroupIDs := make(map[uint64]map[uint64]bool)
+	windows := make(map[uint64]map[uint64]gmcom.UsrMemberWindow)
+	m, err := a.services.UsrGroupMember.GetUsrGroupMembers()
+	if err != nil {
+		return err
+	}
+	for _, v := range m {
+		if usrGroupIDs[v.UsrID] == nil {
+			usrGroupIDs[v.UsrID] = make(map[uint64]bool)
+		}
+		usrGroupIDs[v.UsrID][v.UsrGroupID] = true
+
+		// record the validity window of temporal memberships
+		if w, ok := controllers.UsrMemberWindowOf(v); ok {
+			if windows[v.UsrID] == nil {
+				windows[v.UsrID] = make(map[uint64]gmcom.UsrMemberWindow)
+			}
+			windows[v.UsrID][v.UsrGroupID] = w
+		}
+	}
+
+	a.usrC.UsrMembersH.Lock()
+	defer a.usrC.UsrMembersH.Unlock()
+	a.usrC.UsrMembersH.UsrGroupIDs = usrGroupIDs
+	a.usrC.UsrMembersH.Windows = windows
+	a.usrC.UsrMembersH.Versions.Reset(base)
+	return nil
+}
+
+// initialize the list of cached groupauths; the cache is refilled in place if
+// it exists already.
+func (a *AppObj) initializeCachedGroupAuths() error {
+
+	if a.groupauthC.GroupAuthsH == nil {
+		a.groupauthC.GroupAuthsH = &gmcom.GroupAuthsH{}
+	}
+	base := time.Now().UnixNano()
+	g, err := a.services.GroupAuth.GetGroupAuths()
+	if err != nil {
+		return err
+	}
+
+	gh := a.groupauthC.GroupAuthsH
+	gh.Lock()
+	defer gh.Unlock()
+	gh.GroupAuths = make(map[string]map[string]bool)
+	gh.GroupAuthsID = make(map[uint64]gmcom.GroupAuthNames) // deletion support
+	gh.GroupAuthScopes = make(map[string]map[string]string)
+	gh.Versions.Reset(base)
 	for _, v := range g {
 		// create a new authMap for the group, then add the group and the auth
 		// to mapGroupAuths
-		mapAuth := a.groupauthC.GroupAuthsH.GroupAuths[v.GroupName]
+		mapAuth := gh.GroupAuths[v.GroupName]
 		if mapAuth == nil {
 			mapAuth = make(map[string]bool)
-			mapAuth[v.AuthName] = true
-			a.groupauthC.GroupAuthsH.GroupAuths[v.GroupName] = mapAuth
-			continue
+			gh.GroupAuths[v.GroupName] = mapAuth
 		}
 
 		// if the groupName does exist in the top-level map, add the auth to
@@ -465,15 +754,157 @@ func (a *AppObj) initializeCachedGroupAuths() {
 		mapAuth[v.AuthName] = true
 
 		// add the groupauth to the ID-based cache map
-		a.groupauthC.GroupAuthsH.GroupAuthsID[v.ID] = gmcom.GroupAuthNames{GroupName: v.GroupName, AuthName: v.AuthName}
+		gh.GroupAuthsID[v.ID] = gmcom.GroupAuthNames{GroupName: v.GroupName, AuthName: v.AuthName}
+
+		// record the data-scope of scoped groupauths
+		if v.Scope != nil {
+			gh.SetScope(v.GroupName, v.AuthName, *v.Scope)
+		}
+	}
+	return nil
+}
+
+// reloadAuthzCaches refills the authorization caches from the db.  The
+// reloaded entries supersede the entries of the other group-members as these
+// reconcile their caches with the local process.  The reload stops at the
+// first cache that cannot be read; that cache and the ones following it are
+// left unchanged.
+func (a *AppObj) reloadAuthzCaches() error {
+
+	lw.Console("reloading the authorization caches from the db...")
+	loads := []func() error{
+		a.initializeCachedActiveUsrs,
+		a.initializeCachedAuths,
+		a.initializeCachedUsrGroups,
+		a.initializeCachedGroupAuths,
+		a.initializeCachedUsrMembers,
+	}
+	for _, load := range loads {
+		if err := load(); err != nil {
+			lw.ErrorWithPrefixString("reloadAuthzCaches:", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// initialize the cache of revoked usr sessions whose tokens have not yet expired
+func (a *AppObj) initializeCachedSessions() {
+
+	// use mutex as a precautionary measure in case the method is called in a running process
+	if a.usrC.SessionsH != nil {
+		a.usrC.SessionsH.Lock()
+		defer a.usrC.SessionsH.Unlock()
+	}
+	a.usrC.SessionsH = &gmcom.SessionsH{}
+	a.usrC.SessionsH.Revoked = make(map[string]int64)
+	ss := a.services.UsrSession.GetRevokedUsrSessions(time.Now())
+	for _, v := range ss {
+		a.usrC.SessionsH.Revoked[v.SID] = v.ExpiresOn.Unix()
+	}
+}
+
+// initialize the jwt key-set cache.  The key-set is comprised of the keys read
+// from the PEM files in the configuration and the non-expired keys created via
+// signing-key rotation.  The newest rotated key without an expiry date is used
+// for signing, falling back to the configured key for the jwt signing-method
+// unless it has been retired by a rotation.  Only the private key of the
+// signing key is read from the db.
+func (a *AppObj) initializeCachedJWTKeys() {
+
+	d := gmcom.JWTKeysD{}
+
+	persisted := a.services.JWTKey.GetJWTKeys()
+	retired := make(map[string]int64)
+	for _, v := range persisted {
+		if v.ExpiresOn != nil {
+			retired[v.KID] = v.ExpiresOn.Unix()
+		}
+	}
+
+	// the configured keys; ES512 keys are held under 'ES521' in the jwtKeyMap
+	fileKeys := []struct{ alg, prefix string }{
+		{"ES256", "ES256"}, {"ES384", "ES384"}, {"ES512", "ES521"},
+		{"RS256", "RS256"}, {"RS384", "RS384"}, {"RS512", "RS512"},
+		{"EdDSA", "EdDSA"},
+	}
+	fileKIDs := make(map[string]bool)
+	for _, fk := range fileKeys {
+		signKey := a.jwtKeyMap[fk.prefix+"SignKey"]
+		verifyKey := a.jwtKeyMap[fk.prefix+"VerifyKey"]
+		if verifyKey == nil {
+			if s, ok := signKey.(crypto.Signer); ok {
+				verifyKey = s.Public()
+			}
+		}
+		if verifyKey == nil {
+			continue
+		}
+		k, err := gmcom.NewJWTKey("", fk.alg, signKey, verifyKey)
+		fatal(err)
+		fileKIDs[k.KID] = true
+		k.ExpiresOn = retired[k.KID]
+		if k.Expired() {
+			continue
+		}
+		d.Keys = append(d.Keys, *k)
+		if fk.alg == a.cfg.JWTSignMethod && signKey != nil && k.ExpiresOn == 0 {
+			d.SignKID = k.KID
+		}
+	}
+
+	// the rotated keys
+	for _, v := range persisted {
+		if fileKIDs[v.KID] {
+			continue
+		}
+		k := gmcom.JWTKey{
+			KID:    v.KID,
+			Alg:    v.Alg,
+			PubPEM: []byte(v.PubKeyPEM),
+		}
+		if v.CreatedOn != nil {
+			k.CreatedOn = v.CreatedOn.Unix()
+		}
+		if v.ExpiresOn != nil {
+			k.ExpiresOn = v.ExpiresOn.Unix()
+		}
+		if k.Expired() {
+			continue
+		}
+		d.Keys = append(d.Keys, k)
+		if k.Alg == a.cfg.JWTSignMethod && k.ExpiresOn == 0 && v.PrivKeyPEM != "" {
+			d.SignKID = k.KID
+		}
 	}
+
+	a.jwtkeyC.JWTKeysH = &gmcom.JWTKeysH{SignKeyLoader: a.loadJWTSignKey}
+	fatal(a.jwtkeyC.JWTKeysH.Replace(d))
+	a.usrC.JWTKeysH = a.jwtkeyC.JWTKeysH
+}
+
+// loadJWTSignKey reads the private key of a rotated jwt signing key from the
+// db.  It is used by the jwt key-set cache when a new signing key is announced.
+func (a *AppObj) loadJWTSignKey(kid string) (interface{}, error) {
+
+	for _, v := range a.services.JWTKey.GetJWTKeys() {
+		if v.KID == kid && v.PrivKeyPEM != "" {
+			return gmcom.DecodeJWTSignKey([]byte(v.PrivKeyPEM))
+		}
+	}
+	return nil, fmt.Errorf("the private key of jwt signing key %s is not available", kid)
 }
 
 // initializeRoutes creates routes and applies middleware
 func (a *AppObj) initializeRoutes() {
 
 	// create the RequireUsr middleware to ensure page access is secure.
-	requireUserMw := middleware.InitMW(a.services.Usr, a.jwtKeyMap, a.groupauthC.GroupAuthsH, a.usrC.ActUsrsH, a.authC.AuthsH, a.usrgroupC.UsrGroupsH)
+	requireUserMw := middleware.InitMW(a.services.Usr, a.services.APIKey, a.jwtKeyMap, a.jwtkeyC.JWTKeysH, a.groupauthC.GroupAuthsH, a.usrC.ActUsrsH, a.authC.AuthsH, a.usrgroupC.UsrGroupsH, a.usrC.UsrMembersH, a.usrC.SessionsH)
+
+	// the authorization diagnostics work on the same caches as the middleware
+	a.authzC = controllers.NewAuthzController(a.services.Usr, &requireUserMw, a.groupauthC.GroupAuthsH, a.usrC.ActUsrsH, a.authC.AuthsH, a.usrgroupC.UsrGroupsH, a.usrC.UsrMembersH)
+	a.authzTC = controllers.NewAuthzTransferController(a.usrgroupC, a.authC, a.groupauthC)
+	a.introspectC = controllers.NewIntrospectController(&requireUserMw, a.usrC.ActUsrsH, a.usrgroupC.UsrGroupsH, a.usrC.UsrMembersH, a.usrC.SessionsH)
 
 	// construct a map of local service activations
 	svcActv := make(map[string]bool)
@@ -491,11 +922,46 @@ func (a *AppObj) initializeRoutes() {
 
 	// add usr routes
 	a.router.HandleFunc("/usrs", requireUserMw.ApplyFn(a.usrC.GetUsrs)).Methods("GET").Name("usr.GET_SET")
-	a.router.HandleFunc("/usr", a.usrC.Create).Methods("POST").Name("usr.CREATE")
+	a.router.HandleFunc("/usr", requireUserMw.ApplyFn(a.usrC.Create)).Methods("POST").Name("usr.CREATE")
 	a.router.HandleFunc("/usr/{id:[0-9]+}", requireUserMw.ApplyFn(a.usrC.Get)).Methods("GET").Name("usr.GET_ID")
 	a.router.HandleFunc("/usr/login", a.usrC.Login).Methods("POST").Name("usr.LOGIN")
-	a.router.HandleFunc("/usr/{id:[0-9]+}", a.usrC.Delete).Methods("DELETE").Name("usr.DELETE")
-	a.router.HandleFunc("/usr/{id:[0-9]+}", a.usrC.Update).Methods("PUT").Name("usr.UPDATE")
+
+	// usr self-registration; governed by the registration mode in the config
+	a.router.HandleFunc("/usr/register", a.usrC.Register).Methods("POST").Name("usr.REGISTER")
+
+	// usr self-service routes require an authenticated usr only
+	a.router.HandleFunc("/usr/me", requireUserMw.AuthenticateFn(a.usrC.GetMe)).Methods("GET").Name("usr.ME_GET")
+	a.router.HandleFunc("/usr/me", requireUserMw.AuthenticateFn(a.usrC.UpdateMe)).Methods("PUT").Name("usr.ME_UPDATE")
+	a.router.HandleFunc("/usr/me/password", requireUserMw.AuthenticateFn(a.usrC.ChangeMyPassword)).Methods("POST").Name("usr.ME_PASSWORD")
+
+	a.router.HandleFunc("/usr/login/oidc", a.usrC.LoginOIDC).Methods("GET").Name("usr.LOGIN_OIDC")
+	a.router.HandleFunc("/usr/login/oidc/callback", a.usrC.LoginOIDCCallback).Methods("GET").Name("usr.LOGIN_OIDC_CALLBACK")
+	a.router.HandleFunc("/usr/{id:[0-9]+}", requireUserMw.ApplyFn(a.usrC.Delete)).Methods("DELETE").Name("usr.DELETE")
+	a.router.HandleFunc("/usr/{id:[0-9]+}", requireUserMw.ApplyFn(a.usrC.Update)).Methods("PUT").Name("usr.UPDATE")
+	a.router.HandleFunc("/usr/{id:[0-9]+}/oidc", requireUserMw.ApplyFn(a.usrC.LinkOIDC)).Methods("PUT").Name("usr.LINK_OIDC")
+
+	// usr group-membership routes
+	a.router.HandleFunc("/usr/{usr_id:[0-9]+}/togroups", requireUserMw.ApplyFn(a.usrC.GetUsrToGroups)).Methods("GET").Name("usr.REL_togroups")
+	a.router.HandleFunc("/usr/{usr_id:[0-9]+}/togroups", requireUserMw.ApplyFn(a.usrC.UpdateUsrToGroups)).Methods("PUT").Name("usr.REL_UPDATE_togroups")
+	a.router.HandleFunc("/usr/{usr_id:[0-9]+}/memberships", requireUserMw.ApplyFn(a.usrC.GetUsrMemberships)).Methods("GET").Name("usr.REL_memberships")
+
+	// usr session routes
+	a.router.HandleFunc("/usr/{usr_id:[0-9]+}/sessions", requireUserMw.ApplyFn(a.usrC.GetUsrSessions)).Methods("GET").Name("usr.SESSIONS")
+	a.router.HandleFunc("/usr/{usr_id:[0-9]+}/sessions/{sid:[0-9a-f]+}", requireUserMw.ApplyFn(a.usrC.DeleteUsrSession)).Methods("DELETE").Name("usr.DELETE_SESSION")
+
+	// authorization diagnostics routes
+	a.router.HandleFunc("/usr/{usr_id:[0-9]+}/permissions", requireUserMw.ApplyFn(a.authzC.GetUsrPermissions)).Methods("GET").Name("usr.PERMISSIONS")
+	a.router.HandleFunc("/authz/explain", requireUserMw.ApplyFn(a.authzC.Explain)).Methods("GET").Name("authz.EXPLAIN")
+	a.router.HandleFunc("/authz/export", requireUserMw.ApplyFn(a.authzTC.Export)).Methods("GET").Name("authz.EXPORT")
+	a.router.HandleFunc("/authz/import", requireUserMw.ApplyFn(a.authzTC.Import)).Methods("POST").Name("authz.IMPORT")
+
+	// token introspection for downstream services; callers are expected to
+	// authenticate with the api key of a service usr holding oauth.INTROSPECT
+	a.router.HandleFunc("/oauth/introspect", requireUserMw.ApplyFn(a.introspectC.Introspect)).Methods("POST").Name("oauth.INTROSPECT")
+
+	// group-membership state of the local process
+	a.router.HandleFunc("/admin/cluster", requireUserMw.ApplyFn(a.clusterC.GetClusterStatus)).Methods("GET").Name("admin.CLUSTER")
+	a.router.HandleFunc("/admin/caches/reload", requireUserMw.ApplyFn(a.cacheC.ReloadCaches)).Methods("POST").Name("admin.CACHE_RELOAD")
 
 	// usrgroup CRUD routes
 	a.router.HandleFunc("/usrgroups", requireUserMw.ApplyFn(a.usrgroupC.GetUsrGroups)).Methods("GET").Name("usrgroup.GET_SET")
@@ -504,6 +970,9 @@ func (a *AppObj) initializeRoutes() {
 	a.router.HandleFunc("/usrgroup/{id:[0-9]+}", requireUserMw.ApplyFn(a.usrgroupC.Update)).Methods("PUT").Name("usrgroup.CREATE")
 	a.router.HandleFunc("/usrgroup/{id:[0-9]+}", requireUserMw.ApplyFn(a.usrgroupC.Delete)).Methods("DELETE").Name("usrgroup.DELETE")
 
+	// usrgroup group-membership routes
+	a.router.HandleFunc("/usrgroup/{usrgroup_id:[0-9]+}/tousrs", requireUserMw.ApplyFn(a.usrgroupC.GetUsrGroupToUsrs)).Methods("GET").Name("usrgroup.REL_tousrs")
+
 	// usrgroup static filter routes
 	// http://127.0.0.1:<port>/usrgroups/group_name(EQ '<sel_string>')
 	a.router.HandleFunc("/usrgroups/group_name{group_name:[(]+(?:EQ|eq|LIKE|like)+[ ']+[a-zA-Z0-9_]+[')]+}",
@@ -552,6 +1021,22 @@ func (a *AppObj) initializeRoutes() {
 	// http://127.0.0.1:<port>/groupauths/group_id:
 	a.router.HandleFunc("/groupauths/{group_id:[0-9]+}", requireUserMw.ApplyFn(a.groupauthC.DeleteGroupAuthsByGroupID)).Methods("DELETE").Name("groupauth.DELETE_ByGroupID")
 
+	// jwt key-set routes - the jwks document is public
+	a.router.HandleFunc("/.well-known/jwks.json", a.jwtkeyC.JWKS).Methods("GET").Name("jwks.GET")
+	a.router.HandleFunc("/jwtkeys", requireUserMw.ApplyFn(a.jwtkeyC.GetJWTKeys)).Methods("GET").Name("jwtkey.GET_SET")
+	a.router.HandleFunc("/jwtkey/rotate", requireUserMw.ApplyFn(a.jwtkeyC.Rotate)).Methods("POST").Name("jwtkey.ROTATE")
+
+	// apikey routes - the plain-text key is only returned by apikey.CREATE
+	a.router.HandleFunc("/apikeys", requireUserMw.ApplyFn(a.apikeyC.GetAPIKeys)).Methods("GET").Name("apikey.GET_SET")
+	a.router.HandleFunc("/apikey", requireUserMw.ApplyFn(a.apikeyC.Create)).Methods("POST").Name("apikey.CREATE")
+	a.router.HandleFunc("/apikey/{id:[0-9]+}", requireUserMw.ApplyFn(a.apikeyC.Get)).Methods("GET").Name("apikey.GET_ID")
+	a.router.HandleFunc("/apikey/{id:[0-9]+}", requireUserMw.ApplyFn(a.apikeyC.Revoke)).Methods("DELETE").Name("apikey.REVOKE")
+
+	// usrinvite routes - the plain-text token is only returned by usrinvite.CREATE
+	a.router.HandleFunc("/usrinvites", requireUserMw.ApplyFn(a.usrinviteC.GetUsrInvites)).Methods("GET").Name("usrinvite.GET_SET")
+	a.router.HandleFunc("/usrinvite", requireUserMw.ApplyFn(a.usrinviteC.Create)).Methods("POST").Name("usrinvite.CREATE")
+	a.router.HandleFunc("/usrinvite/{id:[0-9]+}", requireUserMw.ApplyFn(a.usrinviteC.Delete)).Methods("DELETE").Name("usrinvite.DELETE")
+
 	var pActive, ok bool
 
 	// ====================== Library protected routes for standard CRUD access ======================
@@ -654,6 +1139,53 @@ func (a *AppObj) initializeRoutes() {
 			requireUserMw.ApplyFn(a.bookC.GetBooksByLibraryID)).Methods("GET").Name("book.STATICFLTR_CMD_ByLibraryID")
 
 	}
+
+	// load the attribute-based access policies
+	a.initializePolicies(&requireUserMw)
+}
+
+// initializePolicies loads the attribute-based access policies from the
+// policy file named in the config and installs them in the middleware.
+// Policies naming unknown routes are reported, but do not prevent startup.
+func (a *AppObj) initializePolicies(mw *middleware.RequireUsr) {
+
+	if a.cfg.PolicyFile == "" {
+		return
+	}
+
+	ps, err := middleware.LoadPolicyFile(a.cfg.PolicyFile)
+	fatal(err)
+
+	known := make(map[string]bool)
+	for _, n := range a.getRouteNames() {
+		known[n] = true
+	}
+	for _, r := range ps.Routes() {
+		if !known[r] {
+			lw.Console("warning: access policies refer to unknown route %s", r)
+		}
+	}
+
+	// entities that policies may refer to via entity.<field>
+	entities := map[string]middleware.PolicyEntityFunc{
+		"library": func(id uint64) (interface{}, error) {
+			l := models.Library{ID: id}
+			err := a.services.Library.Get(&l)
+			return l, err
+		},
+		"book": func(id uint64) (interface{}, error) {
+			b := models.Book{ID: id}
+			err := a.services.Book.Get(&b)
+			return b, err
+		},
+		"usrgroup": func(id uint64) (interface{}, error) {
+			g := models.UsrGroup{ID: id}
+			err := a.services.UsrGroup.Get(&g)
+			return g, err
+		},
+	}
+	mw.SetPolicies(ps, entities)
+	lw.Console("Loaded %d access policies from %s", len(ps.Policies), a.cfg.PolicyFile)
 }
 
 // getRouteNames walks the routes to get the route names for usr/group/auth lookup
@@ -686,7 +1218,8 @@ func (a *AppObj) initializeAuthsByRoute() map[string]uint64 {
 	var pendingAuths []string
 
 	// load auths from db (auth objects only)
-	auths := a.services.Auth.GetAuths()
+	auths, err := a.services.Auth.GetAuths()
+	fatal(err)
 	mapAuths := make(map[string]uint64)
 	for _, a := range auths {
 		mapAuths[a.AuthName] = a.ID
@@ -748,6 +1281,11 @@ func (a *AppObj) initializeSuperGroup(Auths map[string]uint64, rebuildSuperGroup
 			Description: "Super Group - use for admin only",
 		}
 		a.services.UsrGroup.Create(&sg)
+
+		// add the new UsrGroup to the local cache
+		a.usrgroupC.UsrGroupsH.Lock()
+		a.usrgroupC.UsrGroupsH.GroupNames[sg.ID] = sg.GroupName
+		a.usrgroupC.UsrGroupsH.Unlock()
 	} else {
 		if rebuildSuperGroup {
 			sg = superGroup[0]
@@ -807,6 +1345,17 @@ func (a *AppObj) initializeAdminUsr() {
 	}
 	lw.Console("admin user created with ID: %v and initial password of %v", usrAdmin.ID, "initpass")
 
+	// make the admin usr a member of the Super UsrGroup
+	superGroup := a.services.UsrGroup.GetUsrGroupsByGroupName("EQ", "Super")
+	if len(superGroup) == 0 {
+		panic("failed to read the Super UsrGroup in initializeAdminUsr")
+	}
+	err = a.services.UsrGroupMember.SetUsrGroups(usrAdmin.ID, []uint64{superGroup[0].ID})
+	if err != nil {
+		panic(fmt.Sprintf("failed to assign the admin user to the Super UsrGroup in intializeAdminUsr: %v\n", err))
+	}
+	a.usrC.UsrMembersH.Set(usrAdmin.ID, []uint64{superGroup[0].ID}, nil, time.Now().UnixNano())
+
 	// add the admin usr to the local cache
 	a.usrC.ActUsrsH.Lock()
 	a.usrC.ActUsrsH.ActiveUsrs[usrAdmin.ID] = true
@@ -817,12 +1366,15 @@ func (a *AppObj) initializeAdminUsr() {
 func (a *AppObj) Run(lsg gmcom.GMLeaderSetterGetter) {
 
 	// start the group-membership server
-	gv := &gmsrv.GMServ{}
-	go gv.Serve(a.cfg.InternalAddress, lsg, a.usrC.ActUsrsH, a.groupauthC.GroupAuthsH, a.authC.AuthsH, a.usrgroupC.UsrGroupsH, true, a.cfg.PingCycle, a.cfg.FailureThreshold)
+	gv := a.gmServ
+	go gv.Serve(a.cfg.InternalAddress, lsg, a.usrC.ActUsrsH, a.groupauthC.GroupAuthsH, a.authC.AuthsH, a.usrgroupC.UsrGroupsH, a.usrC.UsrMembersH, a.jwtkeyC.JWTKeysH, a.usrC.SessionsH, true, a.cfg.PingCycle, a.cfg.FailureThreshold)
 
 	// close db connection later
 	defer a.services.Close()
 
+	// periodically remove expired usr group-memberships and sessions
+	stopCleanup := a.startCleanup()
+
 	// set basic http server values
 	var err error
 	srv := http.Server{
@@ -897,11 +1449,41 @@ func (a *AppObj) Run(lsg gmcom.GMLeaderSetterGetter) {
 
 	// handle shutdown
 	<-done
+	close(stopCleanup)
 	srv.Shutdown(ctx)
 	lsg.Cleanup()
 	lw.Console("Shutdown complete")
 }
 
+// startCleanup starts a goroutine that deletes expired usr group-memberships
+// and usr sessions every cfg.UsrMemberCleanup minutes.  Expired memberships
+// and the tokens of expired sessions are already disregarded by the auth
+// checks, so the cleanup only keeps the db and the caches tidy.  Closing the
+// returned channel stops the goroutine.
+func (a *AppObj) startCleanup() chan struct{} {
+
+	interval := a.cfg.UsrMemberCleanup
+	if interval == 0 {
+		interval = 60
+	}
+
+	stop := make(chan struct{})
+	go func() {
+		t := time.NewTicker(time.Duration(interval) * time.Minute)
+		defer t.Stop()
+		for {
+			select {
+			case now := <-t.C:
+				a.usrC.CleanupUsrMembers(now)
+				a.usrC.CleanupUsrSessions(now)
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return stop
+}
+
 func fatal(err error) {
 	if err != nil {
 		lw.Fatal(err)
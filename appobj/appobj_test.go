@@ -0,0 +1,66 @@
+package appobj
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/1414C/libraryapp/controllers"
+	"github.com/1414C/libraryapp/group/gmcom"
+	"github.com/1414C/libraryapp/models"
+)
+
+var errDBDown = errors.New("db down")
+
+type stubUsrService struct {
+	models.UsrService
+	usrs []models.Usr
+}
+
+func (s stubUsrService) GetUsrs() ([]models.Usr, error) { return s.usrs, nil }
+
+type failingAuthService struct {
+	models.AuthService
+}
+
+func (s failingAuthService) GetAuths() ([]models.Auth, error) { return nil, errDBDown }
+
+// TestReloadAuthzCachesFailure checks that a reload during a db outage leaves
+// the caches as they were, so that the other group-members do not drop their
+// entries as they reconcile with the local process.
+func TestReloadAuthzCachesFailure(t *testing.T) {
+
+	auths := func() map[uint64]string { return map[uint64]string{1: "usr.GET", 2: "auth.GET", 3: "book.GET"} }
+	local := &gmcom.AuthsH{Auths: auths()}
+	local.Versions.Reset(100)
+	peer := &gmcom.AuthsH{Auths: auths()}
+	peer.Versions.Reset(100)
+
+	a := &AppObj{
+		services: &models.Services{
+			Usr:  stubUsrService{usrs: []models.Usr{{ID: 1, Active: true}}},
+			Auth: failingAuthService{},
+		},
+		usrC:  &controllers.UsrController{},
+		authC: &controllers.AuthController{AuthsH: local},
+	}
+	if err := a.reloadAuthzCaches(); err != errDBDown {
+		t.Fatalf("got %v; want %v", err, errDBDown)
+	}
+	if len(local.Auths) != 3 || local.Versions.Base != 100 {
+		t.Fatalf("failed reload changed the cache: %v, base %d", local.Auths, local.Versions.Base)
+	}
+
+	buckets := local.Digest().Diff(peer.Digest())
+	if n := peer.Merge(local.Delta(buckets)); n != 0 || len(peer.Auths) != 3 {
+		t.Errorf("peer applied %d changes and holds %v; want its 3 auths", n, peer.Auths)
+	}
+
+	// a controller wired to the reload reports the failure
+	w := httptest.NewRecorder()
+	controllers.NewCacheController(a.reloadAuthzCaches).ReloadCaches(w, httptest.NewRequest("POST", "/admin/caches/reload", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d; want %d", w.Code, http.StatusInternalServerError)
+	}
+}
@@ -2,10 +2,19 @@ package appobj
 
 import (
 	"bytes"
+	"context"
 	"encoding/gob"
 	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
 
 	"github.com/1414C/libraryapp/group/gmcom"
+	"github.com/1414C/libraryapp/group/gmraft"
+	"github.com/1414C/libraryapp/models"
 	"github.com/1414C/lw"
 	"github.com/1414C/sluggo/wscl"
 	"github.com/bradfitz/gomemcache/memcache"
@@ -22,6 +31,8 @@ import (
 type StandAloneLeadSetGet struct {
 	gmcom.GMLeaderSetterGetter
 	LocalLeaderIPAddress string
+	mu                   sync.Mutex
+	epoch                uint64
 }
 
 // Cleanup is needed to satisfy interface gmcom.GMLeaderSetterGetter but is
@@ -35,9 +46,12 @@ func (sa *StandAloneLeadSetGet) Cleanup() error {
 func (sa *StandAloneLeadSetGet) GetDBLeader() (*gmcom.GMLeader, error) {
 
 	// access the database here to read the current leader
+	sa.mu.Lock()
+	defer sa.mu.Unlock()
 	l := &gmcom.GMLeader{
 		LeaderID:        1,
 		LeaderIPAddress: sa.LocalLeaderIPAddress,
+		Epoch:           sa.epoch,
 	}
 	return l, nil
 }
@@ -52,6 +66,19 @@ func (sa *StandAloneLeadSetGet) SetDBLeader(l gmcom.GMLeader) error {
 	return nil
 }
 
+// CompareAndSetDBLeader advances the epoch of the stand-alone leader if it is
+// still epoch.  The leader information itself is immutable.
+func (sa *StandAloneLeadSetGet) CompareAndSetDBLeader(l gmcom.GMLeader, epoch uint64) (bool, error) {
+
+	sa.mu.Lock()
+	defer sa.mu.Unlock()
+	if sa.epoch != epoch {
+		return false, nil
+	}
+	sa.epoch = l.Epoch
+	return true, nil
+}
+
 // For testing with sluggo, go get -u github.com/1414C/sluggo
 //
 // Execute sluggo from the command-line as follows:
@@ -101,12 +128,31 @@ func (sg *SluggoLeadSetGet) SetDBLeader(l gmcom.GMLeader) error {
 	return nil
 }
 
+// CompareAndSetDBLeader stores the leader information if the epoch of the
+// stored record is epoch.  sluggo offers no conditional write, so the check and
+// the write are not atomic; two processes claiming the leadership at the same
+// moment may both succeed.
+func (sg *SluggoLeadSetGet) CompareAndSetDBLeader(l gmcom.GMLeader, epoch uint64) (bool, error) {
+
+	cur := &gmcom.GMLeader{}
+	wscl.GetCacheEntry("LEADER", cur, sg.internalAddress)
+	if cur.Epoch != epoch {
+		return false, nil
+	}
+	err := wscl.AddUpdCacheEntry("LEADER", &l, sg.internalAddress)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 // RedisLeadSetGet is a struct implementing the gmcom.GMLeaderSetterGetter
 // interface in order to support access to the redis KVS.
 type RedisLeadSetGet struct {
 	gmcom.GMLeaderSetterGetter
 	pool *redis.Pool
 	conn redis.Conn
+	mu   sync.Mutex // serializes the use of conn
 }
 
 // InitializeRedisLeadSetGet creates an instance of interface gmcom.GMLeaderSetterGetter
@@ -160,6 +206,14 @@ func (rg *RedisLeadSetGet) newPool(c RedisGKVSConfig) *redis.Pool {
 // GetDBLeader retrieves the current leader information from the persistence layer.
 func (rg *RedisLeadSetGet) GetDBLeader() (*gmcom.GMLeader, error) {
 
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+	return rg.readLeader()
+}
+
+// readLeader reads the leader information from redis.  rg.mu must be held.
+func (rg *RedisLeadSetGet) readLeader() (*gmcom.GMLeader, error) {
+
 	// access the database here to read the current leader
 	l := gmcom.GMLeader{}
 	// wscl.GetCacheEntry("LEADER", l, "192.168.112.192:7070")
@@ -186,6 +240,9 @@ func (rg *RedisLeadSetGet) GetDBLeader() (*gmcom.GMLeader, error) {
 // SetDBLeader stores the current leader information in the persistence layer.
 func (rg *RedisLeadSetGet) SetDBLeader(l gmcom.GMLeader) error {
 
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+
 	// access redis to set the new leader
 	// wscl.AddUpdCacheEntry("LEADER", &l, "192.168.112.192:7070")
 	encBuf := new(bytes.Buffer)
@@ -202,6 +259,44 @@ func (rg *RedisLeadSetGet) SetDBLeader(l gmcom.GMLeader) error {
 	return nil
 }
 
+// CompareAndSetDBLeader stores the leader information if the epoch of the
+// stored record is epoch.  The record is WATCHed while it is checked, and the
+// write is made in a MULTI/EXEC transaction that redis aborts if the record
+// was changed in the meantime.
+func (rg *RedisLeadSetGet) CompareAndSetDBLeader(l gmcom.GMLeader, epoch uint64) (bool, error) {
+
+	encBuf := new(bytes.Buffer)
+	err := gob.NewEncoder(encBuf).Encode(l)
+	if err != nil {
+		lw.Console("Redis KVS: CompareAndSetDBLeader failed to gob-encode the new leader record: %s", err)
+		return false, err
+	}
+
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+
+	_, err = rg.conn.Do("WATCH", "LEADER")
+	if err != nil {
+		return false, err
+	}
+	cur, err := rg.readLeader()
+	if err != nil || cur.Epoch != epoch {
+		rg.conn.Do("UNWATCH")
+		return false, err
+	}
+
+	rg.conn.Send("MULTI")
+	rg.conn.Send("SET", "LEADER", encBuf.Bytes())
+	reply, err := rg.conn.Do("EXEC")
+	if err != nil {
+		lw.Console("Redis KVS: CompareAndSetDBLeader failed to update redis with the new leader record: %s", err)
+		return false, err
+	}
+
+	// a nil reply means that redis aborted the transaction
+	return reply != nil, nil
+}
+
 // MemcachedLeadSetGet is a struct implementing the gmcom.GMLeaderSetterGetter
 // interface in order to support access to the memcached KVS.
 type MemcachedLeadSetGet struct {
@@ -241,6 +336,9 @@ func (mc *MemcachedLeadSetGet) GetDBLeader() (*gmcom.GMLeader, error) {
 
 	// read the gob-encoded leader information from memcached
 	it, err := mc.client.Get("LEADER")
+	if err == memcache.ErrCacheMiss {
+		return &l, nil
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -275,3 +373,412 @@ func (mc *MemcachedLeadSetGet) SetDBLeader(l gmcom.GMLeader) error {
 	}
 	return nil
 }
+
+// CompareAndSetDBLeader stores the leader information if the epoch of the
+// stored record is epoch.  The record is written with the memcached cas command
+// against the item that was checked, or with the add command if there is no
+// record, so memcached refuses the write if another process wrote the record
+// in the meantime.
+func (mc *MemcachedLeadSetGet) CompareAndSetDBLeader(l gmcom.GMLeader, epoch uint64) (bool, error) {
+
+	encBuf := new(bytes.Buffer)
+	err := gob.NewEncoder(encBuf).Encode(l)
+	if err != nil {
+		lw.Console("Memcached KVS: CompareAndSetDBLeader failed to gob-encode the new leader record: %s", err)
+		return false, err
+	}
+
+	it, err := mc.client.Get("LEADER")
+	if err == memcache.ErrCacheMiss {
+		if epoch != 0 {
+			return false, nil
+		}
+		err = mc.client.Add(&memcache.Item{Key: "LEADER", Value: encBuf.Bytes()})
+		if err == memcache.ErrNotStored {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	if err != nil {
+		return false, err
+	}
+
+	cur := gmcom.GMLeader{}
+	err = gob.NewDecoder(bytes.NewBuffer(it.Value)).Decode(&cur)
+	if err != nil {
+		return false, err
+	}
+	if cur.Epoch != epoch {
+		return false, nil
+	}
+
+	it.Value = encBuf.Bytes()
+	err = mc.client.CompareAndSwap(it)
+	if err == memcache.ErrCASConflict || err == memcache.ErrNotStored {
+		return false, nil
+	}
+	if err != nil {
+		lw.Console("Memcached KVS: CompareAndSetDBLeader failed to update the new leader record: %s", err)
+		return false, err
+	}
+	return true, nil
+}
+
+// RaftLeadSetGet is a struct implementing the gmcom.GMLeaderSetterGetter
+// interface on top of an embedded raft node.  The application servers of the
+// cluster elect a raft leader among themselves, and the group-leader record is
+// committed only once a majority of the nodes has accepted it, so no external
+// KVS is needed.
+type RaftLeadSetGet struct {
+	gmcom.GMLeaderSetterGetter
+	node *gmraft.Node
+	srv  *http.Server
+}
+
+// InitializeRaftLeadSetGet starts the local raft node and its listener.
+func (rl *RaftLeadSetGet) InitializeRaftLeadSetGet(c RaftGKVSConfig) error {
+
+	et := time.Duration(c.ElectionTimeoutMs) * time.Millisecond
+	node, err := gmraft.NewNode(gmraft.Config{
+		ID:                c.RaftAddress,
+		Peers:             c.Peers,
+		DataFile:          c.DataFile,
+		ElectionTimeout:   et,
+		HeartbeatInterval: time.Duration(c.HeartbeatIntervalMs) * time.Millisecond,
+	}, &gmraft.WSTransport{Timeout: et})
+	if err != nil {
+		return err
+	}
+	rl.node = node
+	rl.srv = gmraft.ListenAndServe(node, c.RaftAddress)
+	rl.node.Start()
+	lw.Console("Raft KVS: node %s started with peers %v", c.RaftAddress, c.Peers)
+	return nil
+}
+
+// Cleanup stops the raft node and closes its listener.
+func (rl *RaftLeadSetGet) Cleanup() error {
+
+	lw.Console("Raft KVS: RaftLeadSetGet running Cleanup()")
+	rl.node.Stop()
+	return rl.srv.Shutdown(context.Background())
+}
+
+// GetDBLeader retrieves the committed leader information via the raft leader.
+func (rl *RaftLeadSetGet) GetDBLeader() (*gmcom.GMLeader, error) {
+
+	l, err := rl.node.Get()
+	if err != nil {
+		lw.Console("Raft KVS: GetDBLeader failed to read the leader record: %s", err)
+		return nil, err
+	}
+	return &l, nil
+}
+
+// SetDBLeader stores the leader information via the raft leader.  An error is
+// returned if a majority of the raft nodes could not be reached; note that
+// gmsrv treats such an error during a group election as fatal, so a process
+// cut off from the majority stops rather than leading a second group.
+func (rl *RaftLeadSetGet) SetDBLeader(l gmcom.GMLeader) error {
+
+	err := rl.node.Set(l)
+	if err != nil {
+		lw.Console("Raft KVS: SetDBLeader failed to commit the new leader record: %s", err)
+	}
+	return err
+}
+
+// CompareAndSetDBLeader stores the leader information via the raft leader if
+// the epoch of the current record is epoch.  The raft leader checks the epoch
+// and writes the record in one step.
+func (rl *RaftLeadSetGet) CompareAndSetDBLeader(l gmcom.GMLeader, epoch uint64) (bool, error) {
+
+	ok, err := rl.node.CompareAndSet(l, epoch)
+	if err != nil {
+		lw.Console("Raft KVS: CompareAndSetDBLeader failed to commit the new leader record: %s", err)
+	}
+	return ok, err
+}
+
+// file-based KVS lock timing
+const (
+	fileLockRetry   = 10 * time.Millisecond
+	fileLockTimeout = 5 * time.Second
+)
+
+// FileLeadSetGet is a struct implementing the gmcom.GMLeaderSetterGetter
+// interface on top of a local file.  It allows several application servers
+// running on the same host to share the group-leader record without an
+// external KVS.  Writers serialize on an exclusive OS file-lock taken on a
+// lock-file next to the record file, and the record file is replaced
+// atomically so that readers never see a partial write.  The OS drops the
+// file-lock when its holder exits, so a lock cannot be left behind by a
+// process that died while holding it.
+type FileLeadSetGet struct {
+	gmcom.GMLeaderSetterGetter
+	path string
+}
+
+// InitializeFileLeadSetGet prepares the use of the file named in the config.
+func (fl *FileLeadSetGet) InitializeFileLeadSetGet(c FileGKVSConfig) error {
+
+	if c.FilePath == "" {
+		return errors.New("file KVS: InitializeFileLeadSetGet requires a file_path")
+	}
+	fl.path = c.FilePath
+	return nil
+}
+
+// Cleanup is needed to satisfy interface gmcom.GMLeaderSetterGetter; the
+// file is only held open while it is being read or written.
+func (fl *FileLeadSetGet) Cleanup() error {
+	// nothing to do for file
+	return nil
+}
+
+// lock acquires the file-lock of the record file and returns the func
+// releasing it.  The lock-file itself is never removed; removing it would let
+// a waiting process lock the unlinked file while another creates a new one.
+func (fl *FileLeadSetGet) lock() (func(), error) {
+
+	lockPath := fl.path + ".lock"
+	f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(fileLockTimeout)
+	for {
+		ok, err := tryLockFile(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if ok {
+			return func() {
+				unlockFile(f)
+				f.Close()
+			}, nil
+		}
+		if time.Now().After(deadline) {
+			f.Close()
+			return nil, fmt.Errorf("file KVS: timed out waiting for lock %s", lockPath)
+		}
+		time.Sleep(fileLockRetry)
+	}
+}
+
+// GetDBLeader retrieves the current leader information from the record file.
+// An empty record is returned if no leader has been stored yet.
+func (fl *FileLeadSetGet) GetDBLeader() (*gmcom.GMLeader, error) {
+
+	l, err := fl.readLeader()
+	if err != nil {
+		return nil, err
+	}
+	lw.Console("File KVS: FileLeadSetGet.GetDBLeader got: %v", *l)
+	return l, nil
+}
+
+// readLeader reads the record file.  The record file is replaced atomically,
+// so it can be read without holding the lock.
+func (fl *FileLeadSetGet) readLeader() (*gmcom.GMLeader, error) {
+
+	l := gmcom.GMLeader{}
+	raw, err := ioutil.ReadFile(fl.path)
+	if os.IsNotExist(err) {
+		return &l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = gob.NewDecoder(bytes.NewBuffer(raw)).Decode(&l)
+	if err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// SetDBLeader stores the leader information in the record file.  The record
+// is written to a temporary file under the lock and then renamed over the
+// record file.
+func (fl *FileLeadSetGet) SetDBLeader(l gmcom.GMLeader) error {
+
+	unlock, err := fl.lock()
+	if err != nil {
+		lw.Console("File KVS: SetDBLeader failed to lock the leader record: %s", err)
+		return err
+	}
+	defer unlock()
+
+	err = fl.writeLeader(l)
+	if err != nil {
+		lw.Console("File KVS: SetDBLeader failed to write the new leader record: %s", err)
+	}
+	return err
+}
+
+// CompareAndSetDBLeader stores the leader information in the record file if
+// the epoch of the stored record is epoch.  The record is checked and written
+// under the lock.
+func (fl *FileLeadSetGet) CompareAndSetDBLeader(l gmcom.GMLeader, epoch uint64) (bool, error) {
+
+	unlock, err := fl.lock()
+	if err != nil {
+		lw.Console("File KVS: CompareAndSetDBLeader failed to lock the leader record: %s", err)
+		return false, err
+	}
+	defer unlock()
+
+	cur, err := fl.readLeader()
+	if err != nil || cur.Epoch != epoch {
+		return false, err
+	}
+	err = fl.writeLeader(l)
+	if err != nil {
+		lw.Console("File KVS: CompareAndSetDBLeader failed to write the new leader record: %s", err)
+		return false, err
+	}
+	return true, nil
+}
+
+// writeLeader writes l to a temporary file and renames it over the record
+// file.  The lock must be held.
+func (fl *FileLeadSetGet) writeLeader(l gmcom.GMLeader) error {
+
+	encBuf := new(bytes.Buffer)
+	err := gob.NewEncoder(encBuf).Encode(l)
+	if err != nil {
+		return err
+	}
+
+	tmp := fl.path + ".tmp"
+	err = ioutil.WriteFile(tmp, encBuf.Bytes(), 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, fl.path)
+}
+
+// name of the group-leader record in the db and the number of times a
+// conflicting update of the record is retried
+const (
+	dbLeaderName        = "LEADER"
+	dbLeaderCASAttempts = 10
+)
+
+// DatabaseLeadSetGet is a struct implementing the gmcom.GMLeaderSetterGetter
+// interface on top of the application's own db.  The group-leader record is
+// kept in the groupleader table and updated using compare-and-swap on its
+// version, so concurrent writers cannot interleave their updates.
+type DatabaseLeadSetGet struct {
+	gmcom.GMLeaderSetterGetter
+	gl models.GroupLeaderService
+}
+
+// InitializeDatabaseLeadSetGet prepares the use of the GroupLeader service.
+func (dl *DatabaseLeadSetGet) InitializeDatabaseLeadSetGet(gl models.GroupLeaderService) error {
+
+	if gl == nil {
+		return errors.New("database KVS: InitializeDatabaseLeadSetGet requires the GroupLeader service")
+	}
+	dl.gl = gl
+	return nil
+}
+
+// Cleanup is needed to satisfy interface gmcom.GMLeaderSetterGetter; the db
+// connection belongs to the application's services and is closed with them.
+func (dl *DatabaseLeadSetGet) Cleanup() error {
+	// nothing to do for database
+	return nil
+}
+
+// GetDBLeader retrieves the current leader information from the db.  An empty
+// record is returned if no leader has been stored yet.
+func (dl *DatabaseLeadSetGet) GetDBLeader() (*gmcom.GMLeader, error) {
+
+	l := gmcom.GMLeader{}
+	rec, err := dl.gl.ByName(dbLeaderName)
+	if err == models.ErrNotFound {
+		return &l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	l.LeaderID = uint(rec.LeaderID)
+	l.LeaderIPAddress = rec.LeaderIPAddress
+	l.Epoch = rec.Epoch
+	lw.Console("Database KVS: DatabaseLeadSetGet.GetDBLeader got: %v", l)
+	return &l, nil
+}
+
+// SetDBLeader stores the leader information in the db.
+func (dl *DatabaseLeadSetGet) SetDBLeader(l gmcom.GMLeader) error {
+
+	_, err := dl.write(l, false, 0)
+	if err != nil {
+		lw.Console("Database KVS: SetDBLeader failed to update the new leader record: %s", err)
+	}
+	return err
+}
+
+// CompareAndSetDBLeader stores the leader information in the db if the epoch
+// of the stored record is epoch.
+func (dl *DatabaseLeadSetGet) CompareAndSetDBLeader(l gmcom.GMLeader, epoch uint64) (bool, error) {
+
+	ok, err := dl.write(l, true, epoch)
+	if err != nil {
+		lw.Console("Database KVS: CompareAndSetDBLeader failed to update the new leader record: %s", err)
+	}
+	return ok, err
+}
+
+// write stores the leader information in the db; if cas is set, only if the
+// epoch of the stored record is epoch.  The record is created if it does not
+// exist; otherwise it is updated against the version that was read, and the
+// read-update cycle is retried if another process changed the record in the
+// meantime.
+func (dl *DatabaseLeadSetGet) write(l gmcom.GMLeader, cas bool, epoch uint64) (bool, error) {
+
+	var err error
+	for i := 0; i < dbLeaderCASAttempts; i++ {
+		var rec *models.GroupLeader
+		rec, err = dl.gl.ByName(dbLeaderName)
+		if err == models.ErrNotFound {
+			if cas && epoch != 0 {
+				return false, nil
+			}
+
+			// another process may create the record concurrently, in
+			// which case the creation fails and the update is retried
+			err = dl.gl.Create(&models.GroupLeader{
+				Name:            dbLeaderName,
+				LeaderID:        uint64(l.LeaderID),
+				LeaderIPAddress: l.LeaderIPAddress,
+				Epoch:           l.Epoch,
+			})
+			if err == nil {
+				return true, nil
+			}
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if cas && rec.Epoch != epoch {
+			return false, nil
+		}
+
+		version := rec.Version
+		rec.LeaderID = uint64(l.LeaderID)
+		rec.LeaderIPAddress = l.LeaderIPAddress
+		rec.Epoch = l.Epoch
+		ok, err := dl.gl.CompareAndSwap(rec, version)
+		if err != nil || ok {
+			return ok, err
+		}
+		err = fmt.Errorf("database KVS: the leader record was changed concurrently %d times", i+1)
+	}
+	return false, err
+}
@@ -0,0 +1,20 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !windows
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!windows
+
+package appobj
+
+import (
+	"errors"
+	"os"
+)
+
+// tryLockFile reports that file-locking is not available on this platform,
+// so the file KVS cannot be used.
+func tryLockFile(f *os.File) (bool, error) {
+	return false, errors.New("file KVS: file locking is not supported on this platform")
+}
+
+// unlockFile is never reached on this platform.
+func unlockFile(f *os.File) error {
+	return nil
+}
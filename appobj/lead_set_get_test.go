@@ -0,0 +1,121 @@
+package appobj
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/1414C/libraryapp/group/gmcom"
+	"github.com/1414C/libraryapp/models"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// testCompareAndSet checks the compare-and-set of two KVS clients sharing
+// the same leader record.
+func testCompareAndSet(t *testing.T, a, b gmcom.GMLeaderSetterGetter) {
+
+	ok, err := a.CompareAndSetDBLeader(gmcom.GMLeader{LeaderID: 1, LeaderIPAddress: "127.0.0.1:4444", Epoch: 1}, 0)
+	if err != nil || !ok {
+		t.Fatalf("initial set got %v, %v", ok, err)
+	}
+
+	// b still expects the empty record
+	ok, err = b.CompareAndSetDBLeader(gmcom.GMLeader{LeaderID: 2, LeaderIPAddress: "127.0.0.1:4445", Epoch: 1}, 0)
+	if err != nil || ok {
+		t.Fatalf("stale set got %v, %v", ok, err)
+	}
+
+	// concurrent writers all expecting epoch 1; exactly one may win
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	won := 0
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			kvs := a
+			if i%2 == 1 {
+				kvs = b
+			}
+			ok, err := kvs.CompareAndSetDBLeader(gmcom.GMLeader{LeaderID: uint(10 + i), Epoch: 2}, 1)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if ok {
+				mu.Lock()
+				won++
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	if won != 1 {
+		t.Fatalf("%d writers won the same epoch", won)
+	}
+
+	l, err := b.GetDBLeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Epoch != 2 || l.LeaderID < 10 {
+		t.Errorf("got %v", *l)
+	}
+}
+
+func TestFileLeadSetGetCompareAndSet(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "appobj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c := FileGKVSConfig{FilePath: filepath.Join(dir, "leader.gob")}
+
+	// a lock-file left behind by an earlier process does not hold the lock
+	err = ioutil.WriteFile(c.FilePath+".lock", []byte("1\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, b := &FileLeadSetGet{}, &FileLeadSetGet{}
+	if err = a.InitializeFileLeadSetGet(c); err != nil {
+		t.Fatal(err)
+	}
+	if err = b.InitializeFileLeadSetGet(c); err != nil {
+		t.Fatal(err)
+	}
+	testCompareAndSet(t, a, b)
+}
+
+func TestDatabaseLeadSetGetCompareAndSet(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "appobj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := models.NewServices(
+		models.WithSqac("sqlite", filepath.Join(dir, "test.db"), false),
+		models.WithGroupLeader(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err = s.AlterAllTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	a, b := &DatabaseLeadSetGet{}, &DatabaseLeadSetGet{}
+	if err = a.InitializeDatabaseLeadSetGet(s.GroupLeader); err != nil {
+		t.Fatal(err)
+	}
+	if err = b.InitializeDatabaseLeadSetGet(s.GroupLeader); err != nil {
+		t.Fatal(err)
+	}
+	testCompareAndSet(t, a, b)
+}
@@ -0,0 +1,181 @@
+package controllers
+
+//=============================================================================================
+// APIKey entity controller code
+//=============================================================================================
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/1414C/libraryapp/models"
+	"github.com/1414C/lw"
+	"github.com/gorilla/mux"
+)
+
+// APIKeyController is the APIKey controller type for route binding
+type APIKeyController struct {
+	as models.APIKeyService
+	us models.UsrService
+}
+
+// NewAPIKeyController creates a new APIKeyController
+func NewAPIKeyController(as models.APIKeyService, us models.UsrService) *APIKeyController {
+	return &APIKeyController{
+		as: as,
+		us: us,
+	}
+}
+
+// Create facilitates the issue of a new APIKey to an existing (service) Usr.
+// The plain-text key is contained in the response body and cannot be
+// retrieved again.  This method is bound to the gorilla.mux router in appobj.go.
+//
+// POST /apikey
+func (ac *APIKeyController) Create(w http.ResponseWriter, r *http.Request) {
+
+	var a models.APIKey
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&a); err != nil {
+		lw.ErrorWithPrefixString("APIKey Create:", err)
+		respondWithError(w, http.StatusBadRequest, "apikeyc: Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	// the key must be issued to an existing usr
+	usr := models.Usr{
+		ID: a.UsrID,
+	}
+	err := ac.us.Get(&usr)
+	if err != nil || usr.ID == 0 {
+		lw.Warning("APIKey Create: usr %d could not be read", a.UsrID)
+		respondWithError(w, http.StatusBadRequest, models.ErrAPIKeyUsrRequired.Error())
+		return
+	}
+
+	// fill the model
+	apikey := models.APIKey{
+		UsrID:       a.UsrID,
+		Description: a.Description,
+		ExpiresOn:   a.ExpiresOn,
+	}
+
+	// build a base urlString for the JSON Body self-referencing Href tag
+	urlString := buildHrefStringFromCRUDReq(r, true)
+
+	// call the Create method on the apikey model
+	err = ac.as.Create(&apikey)
+	if err != nil {
+		lw.ErrorWithPrefixString("APIKey Create:", err)
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	apikey.Href = urlString + strconv.FormatUint(apikey.ID, 10)
+	respondWithJSON(w, http.StatusCreated, apikey)
+}
+
+// Get facilitates the retrieval of an existing APIKey.  The key material is
+// not included in the response.  This method is bound to the gorilla.mux
+// router in appobj.go.
+//
+// GET /apikey/:id
+func (ac *APIKeyController) Get(w http.ResponseWriter, r *http.Request) {
+
+	vars := mux.Vars(r)
+	id, err := strconv.ParseUint(vars["id"], 10, 64)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid apikey ID")
+		return
+	}
+
+	// build a base urlString for the JSON Body self-referencing Href tag
+	urlString := buildHrefStringFromCRUDReq(r, false)
+
+	apikey := models.APIKey{
+		ID: id,
+	}
+
+	err = ac.as.Get(&apikey)
+	if err != nil {
+		lw.Warning(err.Error())
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	apikey.Href = urlString
+	respondWithJSON(w, http.StatusOK, apikey)
+}
+
+// GetAPIKeys facilitates the retrieval of all existing APIKeys.  The result
+// may be restricted to the keys issued to a single Usr via the usr_id query
+// parameter.  This method is bound to the gorilla.mux router in appobj.go.
+//
+// GET /apikeys
+// GET /apikeys?usr_id=:usr_id
+func (ac *APIKeyController) GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+
+	var apikeys []models.APIKey
+
+	usrID := r.URL.Query().Get("usr_id")
+	if usrID != "" {
+		id, err := strconv.ParseUint(usrID, 10, 64)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid usr_id")
+			return
+		}
+		apikeys = ac.as.GetAPIKeysByUsrID(id)
+	} else {
+		apikeys = ac.as.GetAPIKeys()
+	}
+
+	// build base Href; common for each selected row
+	urlString := buildHrefBasic(r, true) + "apikey/"
+
+	if apikeys != nil {
+		for i, a := range apikeys {
+			apikeys[i].Href = urlString + strconv.FormatUint(a.ID, 10)
+		}
+		respondWithJSON(w, http.StatusOK, apikeys)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "[]")
+}
+
+// Revoke facilitates the revocation of an existing APIKey.  The key record
+// is retained in the db along with its last-used timestamp, but the key will
+// no longer be accepted by the middleware.  This method is bound to the
+// gorilla.mux router in appobj.go.
+//
+// DELETE /apikey/:id
+func (ac *APIKeyController) Revoke(w http.ResponseWriter, r *http.Request) {
+
+	vars := mux.Vars(r)
+	id, err := strconv.ParseUint(vars["id"], 10, 64)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid apikey ID")
+		return
+	}
+
+	apikey := models.APIKey{
+		ID: id,
+	}
+
+	err = ac.as.Get(&apikey)
+	if err != nil {
+		lw.Warning(err.Error())
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !apikey.Revoked {
+		apikey.Revoked = true
+		err = ac.as.Update(&apikey)
+		if err != nil {
+			lw.ErrorWithPrefixString("APIKey Revoke:", err)
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	respondWithHeader(w, http.StatusAccepted)
+}
@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/1414C/libraryapp/models"
+	"github.com/gorilla/mux"
+)
+
+// stubUsrService knows the usrs in its map
+type stubUsrService struct {
+	models.UsrService
+	usrs map[uint64]models.Usr
+}
+
+func (s *stubUsrService) Get(usr *models.Usr) error {
+	u, ok := s.usrs[usr.ID]
+	if !ok {
+		return models.ErrNotFound
+	}
+	*usr = u
+	return nil
+}
+
+// stubAPIKeyService holds the issued keys by ID
+type stubAPIKeyService struct {
+	models.APIKeyService
+	keys map[uint64]models.APIKey
+}
+
+func (s *stubAPIKeyService) Create(apikey *models.APIKey) error {
+	apikey.ID = uint64(len(s.keys) + 1)
+	apikey.KeyPrefix = "0a1b2c"
+	apikey.Key = apikey.KeyPrefix + ".secret"
+	s.keys[apikey.ID] = *apikey
+	return nil
+}
+
+func (s *stubAPIKeyService) Get(apikey *models.APIKey) error {
+	a, ok := s.keys[apikey.ID]
+	if !ok {
+		return models.ErrNotFound
+	}
+	a.Key = ""
+	*apikey = a
+	return nil
+}
+
+func (s *stubAPIKeyService) Update(apikey *models.APIKey) error {
+	s.keys[apikey.ID] = *apikey
+	return nil
+}
+
+func TestAPIKeyCreateAndRevoke(t *testing.T) {
+
+	as := &stubAPIKeyService{keys: make(map[uint64]models.APIKey)}
+	ac := NewAPIKeyController(as, &stubUsrService{usrs: map[uint64]models.Usr{5: {ID: 5}}})
+
+	// keys can only be issued to existing usrs
+	w := httptest.NewRecorder()
+	ac.Create(w, httptest.NewRequest("POST", "/apikey", strings.NewReader(`{"usr_id":6}`)))
+	if w.Code != http.StatusBadRequest || len(as.keys) != 0 {
+		t.Fatalf("got status %d with %d keys; want %d", w.Code, len(as.keys), http.StatusBadRequest)
+	}
+
+	// the plain-text key is returned once; the key material cannot be chosen by the caller
+	w = httptest.NewRecorder()
+	ac.Create(w, httptest.NewRequest("POST", "/apikey", strings.NewReader(`{"usr_id":5,"key_prefix":"mine","revoked":true}`)))
+	var ak models.APIKey
+	if err := json.Unmarshal(w.Body.Bytes(), &ak); err != nil || w.Code != http.StatusCreated {
+		t.Fatalf("got status %d, %v", w.Code, err)
+	}
+	if ak.Key != "0a1b2c.secret" || ak.KeyPrefix != "0a1b2c" || ak.Revoked || ak.UsrID != 5 {
+		t.Errorf("got %+v", ak)
+	}
+
+	w = httptest.NewRecorder()
+	ac.Get(w, mux.SetURLVars(httptest.NewRequest("GET", "/apikey/1", nil), map[string]string{"id": "1"}))
+	if strings.Contains(w.Body.String(), "secret") {
+		t.Errorf("got %s; the key must not be returned again", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	ac.Revoke(w, mux.SetURLVars(httptest.NewRequest("DELETE", "/apikey/1", nil), map[string]string{"id": "1"}))
+	if w.Code != http.StatusAccepted || !as.keys[1].Revoked {
+		t.Errorf("got status %d, revoked %v; want the key to be revoked", w.Code, as.keys[1].Revoked)
+	}
+}
@@ -193,7 +193,11 @@ func (ac *AuthController) GetAuths(w http.ResponseWriter, r *http.Request) {
 	urlString = strings.TrimSuffix(urlString, "s/")
 	urlString = urlString + "/"
 
-	auths := ac.as.GetAuths()
+	auths, err := ac.as.GetAuths()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
 	if auths != nil {
 		for i, u := range auths {
 			auths[i].Href = urlString + strconv.FormatUint(uint64(u.ID), 10)
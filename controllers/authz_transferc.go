@@ -0,0 +1,480 @@
+package controllers
+
+//=============================================================================================
+// Authorization matrix export / import controller code
+//=============================================================================================
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/1414C/libraryapp/group/gmcom"
+	"github.com/1414C/libraryapp/models"
+	"github.com/1414C/lw"
+)
+
+// authzDocVersion is the version of the AuthzDocument format
+const authzDocVersion = 1
+
+// superGroupName is the UsrGroup that the application maintains itself; it
+// always holds every Auth and is therefore not transferred.
+const superGroupName = "Super"
+
+// AuthzTransferController exports the permission matrix of the application
+// and imports it into another environment.  Entities are matched by name,
+// as their IDs differ between environments.  Changes are made via the
+// UsrGroup, Auth and GroupAuth controllers so that they are disseminated to
+// the caches of all group-members.
+type AuthzTransferController struct {
+	ugc *UsrGroupController
+	ac  *AuthController
+	gac *GroupAuthController
+}
+
+// AuthzDocument is the serialized permission matrix.  Its content is sorted
+// by name, so that the documents of two environments can be diffed.
+type AuthzDocument struct {
+	Version    int              `json:"version"`
+	UsrGroups  []AuthzUsrGroup  `json:"usr_groups"`
+	Auths      []AuthzAuth      `json:"auths"`
+	GroupAuths []AuthzGroupAuth `json:"group_auths"`
+}
+
+// AuthzUsrGroup is a UsrGroup in an AuthzDocument
+type AuthzUsrGroup struct {
+	GroupName   string `json:"group_name"`
+	Description string `json:"description"`
+}
+
+// AuthzAuth is an Auth in an AuthzDocument
+type AuthzAuth struct {
+	AuthName    string `json:"auth_name"`
+	AuthType    string `json:"auth_type"`
+	Description string `json:"description"`
+}
+
+// AuthzGroupAuth is the allocation of an Auth to a UsrGroup in an
+// AuthzDocument
+type AuthzGroupAuth struct {
+	GroupName string `json:"group_name"`
+	AuthName  string `json:"auth_name"`
+	Scope     string `json:"scope,omitempty"`
+}
+
+// AuthzChange is a change made, or to be made, by an import
+type AuthzChange struct {
+	Op   string `json:"op"`   // create, update or delete
+	Kind string `json:"kind"` // usrgroup, auth or groupauth
+	Name string `json:"name"` // group_name, auth_name or group_name/auth_name
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+
+	id     uint64 // ID of the existing entity for updates and deletions
+	authT  string
+	desc   string
+	groupN string
+	authN  string
+}
+
+// authzLoadError is returned by diff when the permission matrix of the
+// application could not be read, as opposed to an invalid document.
+type authzLoadError struct {
+	err error
+}
+
+func (e authzLoadError) Error() string {
+	return "could not read the authz entities: " + e.err.Error()
+}
+
+// AuthzImportResult is the response to an import request
+type AuthzImportResult struct {
+	DryRun  bool          `json:"dry_run"`
+	Changes []AuthzChange `json:"changes"`
+}
+
+// NewAuthzTransferController creates a new AuthzTransferController
+func NewAuthzTransferController(ugc *UsrGroupController, ac *AuthController, gac *GroupAuthController) *AuthzTransferController {
+	return &AuthzTransferController{
+		ugc: ugc,
+		ac:  ac,
+		gac: gac,
+	}
+}
+
+// Export returns the UsrGroups, Auths and GroupAuths of the application as an
+// AuthzDocument.  The Super UsrGroup is not exported.
+//
+// GET /authz/export
+func (tc *AuthzTransferController) Export(w http.ResponseWriter, r *http.Request) {
+
+	doc, err := tc.current()
+	if err != nil {
+		lw.ErrorWithPrefixString("Authz Export:", err)
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	b, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"authz.json\"")
+	w.WriteHeader(http.StatusOK)
+	w.Write(append(b, '\n'))
+}
+
+// Import applies an AuthzDocument.  UsrGroups and Auths missing in this
+// environment are created and their descriptions are updated; neither are
+// deleted.  The GroupAuths of each UsrGroup in the document are replaced
+// with those of the document.  UsrGroups that are not contained in the
+// document, as well as the Super UsrGroup, are left untouched.  With
+// ?dry_run=true the changes are reported but not made.
+//
+// POST /authz/import
+func (tc *AuthzTransferController) Import(w http.ResponseWriter, r *http.Request) {
+
+	dryRun := false
+	if s := r.URL.Query().Get("dry_run"); s != "" {
+		var err error
+		dryRun, err = strconv.ParseBool(s)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "invalid dry_run parameter")
+			return
+		}
+	}
+
+	var doc AuthzDocument
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	defer r.Body.Close()
+	if err := decoder.Decode(&doc); err != nil {
+		lw.Warning("Authz Import: %v", err)
+		respondWithError(w, http.StatusBadRequest, "invalid authz document: "+err.Error())
+		return
+	}
+	if doc.Version != authzDocVersion {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("unsupported authz document version %d", doc.Version))
+		return
+	}
+
+	changes, err := tc.diff(doc)
+	if err != nil {
+		if _, ok := err.(authzLoadError); ok {
+			lw.ErrorWithPrefixString("Authz Import:", err)
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		lw.Warning("Authz Import: %v", err)
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	res := AuthzImportResult{
+		DryRun:  dryRun,
+		Changes: changes,
+	}
+	if dryRun {
+		respondWithJSON(w, http.StatusOK, res)
+		return
+	}
+
+	n, err := tc.apply(changes)
+	if err != nil {
+		lw.ErrorWithPrefixString("Authz Import:", err)
+		respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("import failed after %d of %d changes: %v", n, len(changes), err))
+		return
+	}
+	lw.Console("Authz Import: applied %d changes", len(changes))
+	respondWithJSON(w, http.StatusOK, res)
+}
+
+// load reads the UsrGroups, Auths and GroupAuths of the application.
+func (tc *AuthzTransferController) load() ([]models.UsrGroup, []models.Auth, []models.GroupAuth, error) {
+
+	groups, err := tc.ugc.us.GetUsrGroups()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	auths, err := tc.ac.as.GetAuths()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	groupAuths, err := tc.gac.gs.GetGroupAuths()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return groups, auths, groupAuths, nil
+}
+
+// current reads the permission matrix of the application.
+func (tc *AuthzTransferController) current() (AuthzDocument, error) {
+
+	groups, auths, groupAuths, err := tc.load()
+	if err != nil {
+		return AuthzDocument{}, err
+	}
+	doc := AuthzDocument{
+		Version:    authzDocVersion,
+		UsrGroups:  []AuthzUsrGroup{},
+		Auths:      []AuthzAuth{},
+		GroupAuths: []AuthzGroupAuth{},
+	}
+	for _, g := range groups {
+		if g.GroupName == superGroupName {
+			continue
+		}
+		doc.UsrGroups = append(doc.UsrGroups, AuthzUsrGroup{GroupName: g.GroupName, Description: g.Description})
+	}
+	for _, a := range auths {
+		doc.Auths = append(doc.Auths, AuthzAuth{AuthName: a.AuthName, AuthType: a.AuthType, Description: a.Description})
+	}
+	for _, ga := range groupAuths {
+		if ga.GroupName == superGroupName {
+			continue
+		}
+		e := AuthzGroupAuth{GroupName: ga.GroupName, AuthName: ga.AuthName}
+		if ga.Scope != nil {
+			e.Scope = *ga.Scope
+		}
+		doc.GroupAuths = append(doc.GroupAuths, e)
+	}
+	doc.sort()
+	return doc, nil
+}
+
+// sort orders the content of the document by name.
+func (doc *AuthzDocument) sort() {
+
+	sort.Slice(doc.UsrGroups, func(i, j int) bool { return doc.UsrGroups[i].GroupName < doc.UsrGroups[j].GroupName })
+	sort.Slice(doc.Auths, func(i, j int) bool { return doc.Auths[i].AuthName < doc.Auths[j].AuthName })
+	sort.Slice(doc.GroupAuths, func(i, j int) bool {
+		if doc.GroupAuths[i].GroupName != doc.GroupAuths[j].GroupName {
+			return doc.GroupAuths[i].GroupName < doc.GroupAuths[j].GroupName
+		}
+		return doc.GroupAuths[i].AuthName < doc.GroupAuths[j].AuthName
+	})
+}
+
+// diff determines the changes required to bring the application in line
+// with the document.  The changes are ordered such that UsrGroups and Auths
+// exist before GroupAuths refer to them.
+func (tc *AuthzTransferController) diff(doc AuthzDocument) ([]AuthzChange, error) {
+
+	curGroups, curAuths, curGroupAuths, err := tc.load()
+	if err != nil {
+		return nil, authzLoadError{err}
+	}
+	changes := []AuthzChange{}
+
+	// usrgroups
+	groups := make(map[string]models.UsrGroup)
+	for _, g := range curGroups {
+		groups[g.GroupName] = g
+	}
+	docGroups := make(map[string]bool)
+	for _, g := range doc.UsrGroups {
+		if g.GroupName == "" {
+			return nil, fmt.Errorf("usr_groups: group_name is required")
+		}
+		if g.GroupName == superGroupName {
+			return nil, fmt.Errorf("usr_groups: the %s usrgroup cannot be imported", superGroupName)
+		}
+		if docGroups[g.GroupName] {
+			return nil, fmt.Errorf("usr_groups: %s is listed more than once", g.GroupName)
+		}
+		docGroups[g.GroupName] = true
+
+		cur, ok := groups[g.GroupName]
+		switch {
+		case !ok:
+			changes = append(changes, AuthzChange{Op: "create", Kind: "usrgroup", Name: g.GroupName, To: g.Description})
+		case cur.Description != g.Description:
+			changes = append(changes, AuthzChange{Op: "update", Kind: "usrgroup", Name: g.GroupName, From: cur.Description, To: g.Description, id: cur.ID})
+		}
+	}
+
+	// auths
+	auths := make(map[string]models.Auth)
+	for _, a := range curAuths {
+		auths[a.AuthName] = a
+	}
+	docAuths := make(map[string]bool)
+	for _, a := range doc.Auths {
+		if a.AuthName == "" {
+			return nil, fmt.Errorf("auths: auth_name is required")
+		}
+		if docAuths[a.AuthName] {
+			return nil, fmt.Errorf("auths: %s is listed more than once", a.AuthName)
+		}
+		docAuths[a.AuthName] = true
+
+		if a.AuthType == "" {
+			a.AuthType = "endpoint"
+		}
+		cur, ok := auths[a.AuthName]
+		switch {
+		case !ok:
+			changes = append(changes, AuthzChange{Op: "create", Kind: "auth", Name: a.AuthName, To: a.AuthType + ": " + a.Description, authT: a.AuthType, desc: a.Description})
+		case cur.Description != a.Description || cur.AuthType != a.AuthType:
+			changes = append(changes, AuthzChange{Op: "update", Kind: "auth", Name: a.AuthName, From: cur.AuthType + ": " + cur.Description, To: a.AuthType + ": " + a.Description, id: cur.ID, authT: a.AuthType, desc: a.Description})
+		}
+	}
+
+	// groupauths of the usrgroups in the document
+	existing := make(map[string]models.GroupAuth)
+	for _, ga := range curGroupAuths {
+		if docGroups[ga.GroupName] {
+			existing[ga.GroupName+"/"+ga.AuthName] = ga
+		}
+	}
+	wanted := make(map[string]bool)
+	for _, ga := range doc.GroupAuths {
+		key := ga.GroupName + "/" + ga.AuthName
+		if !docGroups[ga.GroupName] {
+			return nil, fmt.Errorf("group_auths: %s refers to usrgroup %s, which is not listed in usr_groups", key, ga.GroupName)
+		}
+		if !docAuths[ga.AuthName] {
+			if _, ok := auths[ga.AuthName]; !ok {
+				return nil, fmt.Errorf("group_auths: %s refers to unknown auth %s", key, ga.AuthName)
+			}
+		}
+		if wanted[key] {
+			return nil, fmt.Errorf("group_auths: %s is listed more than once", key)
+		}
+		wanted[key] = true
+
+		// compare the scopes in normalized form
+		scope := ""
+		ds, err := models.ParseDataScope(ga.Scope)
+		if err != nil {
+			return nil, fmt.Errorf("group_auths: %s: %v", key, err)
+		}
+		if ds != nil {
+			scope = ds.String()
+		}
+
+		cur, ok := existing[key]
+		curScope := ""
+		if ok && cur.Scope != nil {
+			curScope = *cur.Scope
+		}
+		switch {
+		case !ok:
+			changes = append(changes, AuthzChange{Op: "create", Kind: "groupauth", Name: key, To: scope, groupN: ga.GroupName, authN: ga.AuthName})
+		case curScope != scope:
+			changes = append(changes, AuthzChange{Op: "update", Kind: "groupauth", Name: key, From: curScope, To: scope, id: cur.ID, groupN: ga.GroupName, authN: ga.AuthName})
+		}
+	}
+
+	var deletions []AuthzChange
+	for key, ga := range existing {
+		if wanted[key] {
+			continue
+		}
+		c := AuthzChange{Op: "delete", Kind: "groupauth", Name: key, id: ga.ID}
+		if ga.Scope != nil {
+			c.From = *ga.Scope
+		}
+		deletions = append(deletions, c)
+	}
+	sort.Slice(deletions, func(i, j int) bool { return deletions[i].Name < deletions[j].Name })
+	return append(changes, deletions...), nil
+}
+
+// apply makes the changes and disseminates them to the group-members.  The
+// number of changes made is returned.
+func (tc *AuthzTransferController) apply(changes []AuthzChange) (int, error) {
+
+	groupIDs := make(map[string]uint64)
+	authIDs := make(map[string]uint64)
+
+	for i, c := range changes {
+		switch c.Kind + "." + c.Op {
+		case "usrgroup.create":
+			g := models.UsrGroup{GroupName: c.Name, Description: c.To}
+			if err := tc.ugc.us.Create(&g); err != nil {
+				return i, err
+			}
+			tc.ugc.disseminateUsrGroupChange(g.ID, true, g.GroupName, gmcom.COpCreate)
+
+		case "usrgroup.update":
+			g := models.UsrGroup{ID: c.id, GroupName: c.Name, Description: c.To}
+			if err := tc.ugc.us.Update(&g); err != nil {
+				return i, err
+			}
+			tc.ugc.disseminateUsrGroupChange(g.ID, true, g.GroupName, gmcom.COpUpdate)
+
+		case "auth.create", "auth.update":
+			a := models.Auth{ID: c.id, AuthName: c.Name, AuthType: c.authT, Description: c.desc}
+			var err error
+			op := gmcom.COpCreate
+			if c.Op == "create" {
+				err = tc.ac.as.Create(&a)
+			} else {
+				op = gmcom.COpUpdate
+				err = tc.ac.as.Update(&a)
+			}
+			if err != nil {
+				return i, err
+			}
+			tc.ac.disseminateAuthChange(a.ID, true, a.AuthName, op)
+
+		case "groupauth.create", "groupauth.update":
+			if len(groupIDs) == 0 {
+				if err := tc.loadIDs(groupIDs, authIDs); err != nil {
+					return i, err
+				}
+			}
+			ga := models.GroupAuth{ID: c.id, GroupID: groupIDs[c.groupN], AuthID: authIDs[c.authN]}
+			if c.To != "" {
+				s := c.To
+				ga.Scope = &s
+			}
+			var err error
+			op := gmcom.COpCreate
+			if c.Op == "create" {
+				err = tc.gac.gs.Create(&ga)
+			} else {
+				op = gmcom.COpUpdate
+				err = tc.gac.gs.Update(&ga)
+			}
+			if err != nil {
+				return i, err
+			}
+			tc.gac.disseminateGroupAuthChange(ga, op)
+
+		case "groupauth.delete":
+			ga := models.GroupAuth{ID: c.id}
+			if err := tc.gac.gs.Delete(&ga); err != nil {
+				return i, err
+			}
+			tc.gac.disseminateGroupAuthChange(ga, gmcom.COpDelete)
+		}
+	}
+	return len(changes), nil
+}
+
+// loadIDs reads the IDs of the UsrGroups and Auths by name; it is called once
+// the UsrGroups and Auths of the import have been created.
+func (tc *AuthzTransferController) loadIDs(groupIDs, authIDs map[string]uint64) error {
+
+	groups, err := tc.ugc.us.GetUsrGroups()
+	if err != nil {
+		return err
+	}
+	auths, err := tc.ac.as.GetAuths()
+	if err != nil {
+		return err
+	}
+	for _, g := range groups {
+		groupIDs[g.GroupName] = g.ID
+	}
+	for _, a := range auths {
+		authIDs[a.AuthName] = a.ID
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package controllers
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/1414C/libraryapp/models"
+)
+
+// stub services; only the methods used by the AuthzTransferController diff
+type stubUsrGroupService struct {
+	models.UsrGroupService
+	groups []models.UsrGroup
+}
+
+func (s stubUsrGroupService) GetUsrGroups() ([]models.UsrGroup, error) { return s.groups, nil }
+
+type stubAuthService struct {
+	models.AuthService
+	auths []models.Auth
+}
+
+func (s stubAuthService) GetAuths() ([]models.Auth, error) { return s.auths, nil }
+
+type stubGroupAuthService struct {
+	models.GroupAuthService
+	groupAuths []models.GroupAuth
+}
+
+func (s stubGroupAuthService) GetGroupAuths() ([]models.GroupAuth, error) { return s.groupAuths, nil }
+
+func TestAuthzDiff(t *testing.T) {
+
+	scope := "library_id IN (1,2)"
+	tc := NewAuthzTransferController(
+		&UsrGroupController{us: stubUsrGroupService{groups: []models.UsrGroup{
+			{ID: 1, GroupName: "Super", Description: "all"},
+			{ID: 2, GroupName: "Readers", Description: "read"},
+			{ID: 3, GroupName: "Ops", Description: "ops"},
+		}}},
+		&AuthController{as: stubAuthService{auths: []models.Auth{
+			{ID: 10, AuthName: "book.GET_ID", AuthType: "endpoint", Description: "get a book"},
+			{ID: 11, AuthName: "book.DELETE", AuthType: "endpoint", Description: "delete a book"},
+		}}},
+		&GroupAuthController{gs: stubGroupAuthService{groupAuths: []models.GroupAuth{
+			{ID: 100, GroupName: "Super", AuthName: "book.DELETE"},
+			{ID: 101, GroupName: "Readers", AuthName: "book.GET_ID"},
+			{ID: 102, GroupName: "Readers", AuthName: "book.DELETE", Scope: &scope},
+			{ID: 103, GroupName: "Ops", AuthName: "book.DELETE"},
+		}}},
+	)
+
+	// an export imported into the same environment changes nothing
+	cur, err := tc.current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, err := tc.diff(cur)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Fatalf("expected no changes, got %+v", changes)
+	}
+
+	doc := AuthzDocument{
+		Version: authzDocVersion,
+		UsrGroups: []AuthzUsrGroup{
+			{GroupName: "Readers", Description: "readers"},
+			{GroupName: "Writers", Description: "write"},
+		},
+		Auths: []AuthzAuth{
+			{AuthName: "book.GET_ID", AuthType: "endpoint", Description: "get a book"},
+		},
+		GroupAuths: []AuthzGroupAuth{
+			{GroupName: "Readers", AuthName: "book.GET_ID", Scope: "library_id = 1"},
+			{GroupName: "Writers", AuthName: "book.DELETE"},
+		},
+	}
+	changes, err = tc.diff(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"update usrgroup Readers",
+		"create usrgroup Writers",
+		"update groupauth Readers/book.GET_ID",
+		"create groupauth Writers/book.DELETE",
+		"delete groupauth Readers/book.DELETE",
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("got %+v", changes)
+	}
+	for i, c := range changes {
+		if got := fmt.Sprintf("%s %s %s", c.Op, c.Kind, c.Name); got != want[i] {
+			t.Errorf("change %d: got %s, want %s", i, got, want[i])
+		}
+	}
+
+	// references to unlisted groups, unknown auths and invalid scopes
+	bad := []AuthzDocument{
+		{Version: 1, GroupAuths: []AuthzGroupAuth{{GroupName: "Readers", AuthName: "book.GET_ID"}}},
+		{Version: 1, UsrGroups: []AuthzUsrGroup{{GroupName: "Readers"}}, GroupAuths: []AuthzGroupAuth{{GroupName: "Readers", AuthName: "nope"}}},
+		{Version: 1, UsrGroups: []AuthzUsrGroup{{GroupName: "Readers"}}, GroupAuths: []AuthzGroupAuth{{GroupName: "Readers", AuthName: "book.GET_ID", Scope: "city = x"}}},
+		{Version: 1, UsrGroups: []AuthzUsrGroup{{GroupName: "Super"}}},
+	}
+	for i, d := range bad {
+		if _, err := tc.diff(d); err == nil {
+			t.Errorf("document %d: expected an error", i)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package controllers
+
+//=============================================================================================
+// Authorization diagnostics controller code
+//=============================================================================================
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/1414C/libraryapp/group/gmcom"
+	"github.com/1414C/libraryapp/models"
+	"github.com/1414C/lw"
+	"github.com/gorilla/mux"
+)
+
+// PolicyChecker evaluates the attribute-based access policies of a route for
+// a described request.  It is implemented by the auth middleware, which holds
+// the policies.
+type PolicyChecker interface {
+	EvaluatePolicies(route, method string, uid uint64, email string, groups []string, entityID uint64) (bool, string)
+}
+
+// AuthzController offers read-only insight into the authorization decisions
+// made by the auth middleware.  It works on the same caches and policies as
+// the middleware so that its answers reflect the running process.
+type AuthzController struct {
+	us          models.UsrService
+	pc          PolicyChecker
+	GroupAuthsH *gmcom.GroupAuthsH // cache
+	ActUsrsH    *gmcom.ActUsrsH    // cache
+	AuthsH      *gmcom.AuthsH      // cache
+	UsrGroupsH  *gmcom.UsrGroupsH  // cache
+	UsrMembersH *gmcom.UsrMembersH // cache
+}
+
+// UsrPermission is an auth granted to a usr by way of one of its UsrGroups
+type UsrPermission struct {
+	AuthName  string `json:"auth_name"`
+	GroupName string `json:"group_name"`
+	Scope     string `json:"scope,omitempty"`
+}
+
+// AuthzExplanation describes why the auth middleware would allow or deny a
+// usr access to a route.
+type AuthzExplanation struct {
+	UsrID          uint64   `json:"usr_id"`
+	Route          string   `json:"route"`
+	Allowed        bool     `json:"allowed"`
+	Reason         string   `json:"reason"`
+	Detail         string   `json:"detail"`
+	Active         bool     `json:"active"`
+	Groups         []string `json:"groups"`
+	GrantingGroups []string `json:"granting_groups,omitempty"`
+	Scopes         []string `json:"scopes,omitempty"`
+	Policy         string   `json:"policy,omitempty"`
+}
+
+// reasons reported in addition to those of gmcom.DecideAuth
+const (
+	authUnknown      = "AUTH_UNKNOWN"  // the route name is not registered as an Auth
+	authPolicyDenied = "POLICY_DENIED" // the auth is granted, but a policy denies access
+)
+
+// NewAuthzController creates a new AuthzController
+func NewAuthzController(us models.UsrService, pc PolicyChecker, groupAuths *gmcom.GroupAuthsH, actUsrs *gmcom.ActUsrsH, auths *gmcom.AuthsH, usrGroups *gmcom.UsrGroupsH, usrMembers *gmcom.UsrMembersH) *AuthzController {
+	return &AuthzController{
+		us:          us,
+		pc:          pc,
+		GroupAuthsH: groupAuths,
+		ActUsrsH:    actUsrs,
+		AuthsH:      auths,
+		UsrGroupsH:  usrGroups,
+		UsrMembersH: usrMembers,
+	}
+}
+
+// GetUsrPermissions returns the effective permissions of a Usr; every auth
+// granted to the Usr together with the UsrGroup granting it.  An auth that is
+// granted by more than one UsrGroup is listed once per UsrGroup.
+//
+// GET /usr/:id/permissions
+func (ac *AuthzController) GetUsrPermissions(w http.ResponseWriter, r *http.Request) {
+
+	vars := mux.Vars(r)
+	usrID, err := strconv.ParseUint(vars["usr_id"], 10, 64)
+	if err != nil {
+		lw.Warning("Usr GetUsrPermissions: %s", err.Error())
+		respondWithError(w, http.StatusBadRequest, "Invalid usr number")
+		return
+	}
+
+	usr := models.Usr{
+		ID: usrID,
+	}
+	err = ac.us.Get(&usr)
+	if err != nil {
+		lw.Warning(err.Error())
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	perms := []UsrPermission{}
+	for _, g := range ac.GroupAuthsH.Grants(ac.UsrMembersH.GroupNames(usrID, ac.UsrGroupsH)) {
+		perms = append(perms, UsrPermission{AuthName: g.AuthName, GroupName: g.GroupName, Scope: g.Scope})
+	}
+	respondWithJSON(w, http.StatusOK, perms)
+}
+
+// Explain reports whether the auth middleware would allow the specified usr
+// access to the specified route, and why.  The attribute-based access policies
+// of the route are evaluated for the optional http method and entity id; a
+// policy condition on a claim other than uid or email does not hold.
+//
+// GET /authz/explain?route=book.UPDATE&usr=5[&method=PUT&id=12]
+func (ac *AuthzController) Explain(w http.ResponseWriter, r *http.Request) {
+
+	route := r.URL.Query().Get("route")
+	if route == "" {
+		respondWithError(w, http.StatusBadRequest, "missing route parameter")
+		return
+	}
+	usrID, err := strconv.ParseUint(r.URL.Query().Get("usr"), 10, 64)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "missing or invalid usr parameter")
+		return
+	}
+	var entityID uint64
+	if id := r.URL.Query().Get("id"); id != "" {
+		entityID, err = strconv.ParseUint(id, 10, 64)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "invalid id parameter")
+			return
+		}
+	}
+
+	usr := models.Usr{
+		ID: usrID,
+	}
+	err = ac.us.Get(&usr)
+	if err != nil {
+		lw.Warning(err.Error())
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	groups := ac.UsrMembersH.GroupNames(usrID, ac.UsrGroupsH)
+	sort.Strings(groups)
+
+	ac.ActUsrsH.RLock()
+	active := ac.ActUsrsH.ActiveUsrs[usrID]
+	ac.ActUsrsH.RUnlock()
+
+	d := gmcom.DecideAuth(route, groups, usrID, ac.ActUsrsH, ac.GroupAuthsH)
+	ex := AuthzExplanation{
+		UsrID:          usrID,
+		Route:          route,
+		Allowed:        d.Allowed,
+		Reason:         string(d.Reason),
+		Active:         active,
+		Groups:         groups,
+		GrantingGroups: d.GrantedBy,
+	}
+
+	switch d.Reason {
+	case gmcom.AuthGranted:
+		ex.Detail = fmt.Sprintf("auth %s is granted by usrgroup(s) %v", route, d.GrantedBy)
+		scopes, unrestricted := ac.GroupAuthsH.Scopes(route, groups)
+		if !unrestricted {
+			ex.Scopes = scopes
+			ex.Detail += "; access is restricted to the listed data-scopes"
+		}
+		if ac.pc == nil {
+			break
+		}
+		if ok, policy := ac.pc.EvaluatePolicies(route, r.URL.Query().Get("method"), usrID, usr.Email, groups, entityID); !ok {
+			ex.Allowed = false
+			ex.Reason = authPolicyDenied
+			ex.Policy = policy
+			ex.Detail = fmt.Sprintf("auth %s is granted by usrgroup(s) %v, but access is denied by policy %s", route, d.GrantedBy, policy)
+		}
+	case gmcom.AuthNoGroupAuths:
+		ex.Detail = "no usrgroup authorizations have been configured; all access is denied"
+	case gmcom.AuthUsrInactive:
+		ex.Detail = fmt.Sprintf("usr %d is not active", usrID)
+	case gmcom.AuthNoGroups:
+		ex.Detail = fmt.Sprintf("usr %d is not a member of any usrgroup", usrID)
+	case gmcom.AuthMissing:
+		ex.Detail = fmt.Sprintf("none of the usrgroups of usr %d hold auth %s", usrID, route)
+		if !ac.authExists(route) {
+			ex.Reason = authUnknown
+			ex.Detail = fmt.Sprintf("%s is not a known auth; check the route name", route)
+		}
+	}
+	respondWithJSON(w, http.StatusOK, ex)
+}
+
+// authExists reports whether an Auth with the specified name exists.
+func (ac *AuthzController) authExists(authName string) bool {
+
+	ac.AuthsH.RLock()
+	defer ac.AuthsH.RUnlock()
+	for _, n := range ac.AuthsH.Auths {
+		if n == authName {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,159 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/1414C/libraryapp/group/gmcom"
+	"github.com/1414C/libraryapp/models"
+	"github.com/gorilla/mux"
+)
+
+// stubPolicyChecker denies the routes in deny for the entity with the ID
+// entityID, and records the http method of the last evaluation.
+type stubPolicyChecker struct {
+	deny     map[string]string // map[route]policyName
+	entityID uint64
+	method   string
+}
+
+func (pc *stubPolicyChecker) EvaluatePolicies(route, method string, uid uint64, email string, groups []string, entityID uint64) (bool, string) {
+	pc.method = method
+	if p, ok := pc.deny[route]; ok && entityID == pc.entityID {
+		return false, p
+	}
+	return true, ""
+}
+
+func newTestAuthzController() *AuthzController {
+
+	ga := &gmcom.GroupAuthsH{
+		GroupAuths: map[string]map[string]bool{
+			"Readers": {"book.GET_SET": true, "book.GET_ID": true},
+			"North":   {"book.UPDATE": true},
+			"Editors": {"book.UPDATE": true},
+		},
+		GroupAuthScopes: make(map[string]map[string]string),
+	}
+	ga.SetScope("North", "book.UPDATE", "library_id IN (1,2)")
+
+	umh := &gmcom.UsrMembersH{UsrGroupIDs: make(map[uint64]map[uint64]bool)}
+	umh.Set(1, []uint64{1, 2}, nil, 0)
+	umh.Set(2, []uint64{1, 2, 3}, nil, 0)
+	umh.Set(3, []uint64{1}, nil, 0)
+
+	return NewAuthzController(
+		&stubUsrService{usrs: map[uint64]models.Usr{1: {ID: 1}, 2: {ID: 2}, 3: {ID: 3}, 4: {ID: 4}}},
+		nil,
+		ga,
+		&gmcom.ActUsrsH{ActiveUsrs: map[uint64]bool{1: true, 2: true, 4: true}},
+		&gmcom.AuthsH{Auths: map[uint64]string{1: "book.GET_SET", 2: "book.GET_ID", 3: "book.UPDATE", 4: "book.DELETE"}},
+		&gmcom.UsrGroupsH{GroupNames: map[uint64]string{1: "Readers", 2: "North", 3: "Editors"}},
+		umh,
+	)
+}
+
+func TestGetUsrPermissions(t *testing.T) {
+
+	ac := newTestAuthzController()
+	get := func(id string) (int, []UsrPermission) {
+		w := httptest.NewRecorder()
+		ac.GetUsrPermissions(w, mux.SetURLVars(httptest.NewRequest("GET", "/usr/"+id+"/permissions", nil), map[string]string{"usr_id": id}))
+		var perms []UsrPermission
+		json.Unmarshal(w.Body.Bytes(), &perms)
+		return w.Code, perms
+	}
+
+	code, perms := get("2")
+	want := []UsrPermission{
+		{AuthName: "book.GET_ID", GroupName: "Readers"},
+		{AuthName: "book.GET_SET", GroupName: "Readers"},
+		{AuthName: "book.UPDATE", GroupName: "Editors"},
+		{AuthName: "book.UPDATE", GroupName: "North", Scope: "library_id IN (1,2)"},
+	}
+	if code != http.StatusOK || !reflect.DeepEqual(perms, want) {
+		t.Errorf("got %d %+v; want %+v", code, perms, want)
+	}
+
+	// a usr without groups holds no permissions
+	if code, perms = get("4"); code != http.StatusOK || perms == nil || len(perms) != 0 {
+		t.Errorf("got %d %+v; want an empty list", code, perms)
+	}
+	if code, _ = get("9"); code != http.StatusNotFound {
+		t.Errorf("got status %d; want %d", code, http.StatusNotFound)
+	}
+}
+
+func TestAuthzExplain(t *testing.T) {
+
+	ac := newTestAuthzController()
+	explain := func(query string) (int, AuthzExplanation) {
+		w := httptest.NewRecorder()
+		ac.Explain(w, httptest.NewRequest("GET", "/authz/explain?"+query, nil))
+		var ex AuthzExplanation
+		json.Unmarshal(w.Body.Bytes(), &ex)
+		return w.Code, ex
+	}
+
+	tests := []struct {
+		query    string
+		allowed  bool
+		reason   string
+		granting []string
+		scopes   []string
+	}{
+		{"route=book.UPDATE&usr=1", true, "GRANTED", []string{"North"}, []string{"library_id IN (1,2)"}},
+		{"route=book.UPDATE&usr=2", true, "GRANTED", []string{"Editors", "North"}, nil}, // the unscoped grant lifts the scope
+		{"route=book.GET_ID&usr=3", false, "USR_INACTIVE", nil, nil},
+		{"route=book.GET_ID&usr=4", false, "NO_GROUPS", nil, nil},
+		{"route=book.DELETE&usr=1", false, "AUTH_MISSING", nil, nil},
+		{"route=book.REMOVE&usr=1", false, authUnknown, nil, nil},
+	}
+	for _, tt := range tests {
+		code, ex := explain(tt.query)
+		if code != http.StatusOK || ex.Allowed != tt.allowed || ex.Reason != tt.reason || ex.Detail == "" ||
+			!reflect.DeepEqual(ex.GrantingGroups, tt.granting) || !reflect.DeepEqual(ex.Scopes, tt.scopes) {
+			t.Errorf("%s: got %d %+v", tt.query, code, ex)
+		}
+	}
+
+	for query, want := range map[string]int{
+		"usr=1":                   http.StatusBadRequest,
+		"route=book.GET_ID":       http.StatusBadRequest,
+		"route=book.GET_ID&usr=x": http.StatusBadRequest,
+		"route=book.GET_ID&usr=9": http.StatusNotFound,
+	} {
+		if code, _ := explain(query); code != want {
+			t.Errorf("%s: got status %d; want %d", query, code, want)
+		}
+	}
+
+	// a granted auth is denied by the policies of the route
+	pc := &stubPolicyChecker{deny: map[string]string{"book.UPDATE": "book-update-closed"}, entityID: 12}
+	ac.pc = pc
+	code, ex := explain("route=book.UPDATE&usr=2&method=PUT&id=12")
+	if code != http.StatusOK || ex.Allowed || ex.Reason != authPolicyDenied || ex.Policy != "book-update-closed" || pc.method != "PUT" {
+		t.Errorf("got %d %+v", code, ex)
+	}
+	if _, ex = explain("route=book.UPDATE&usr=2&method=PUT&id=13"); !ex.Allowed || ex.Policy != "" {
+		t.Errorf("got %+v", ex)
+	}
+	if code, _ = explain("route=book.UPDATE&usr=2&id=x"); code != http.StatusBadRequest {
+		t.Errorf("got status %d; want %d", code, http.StatusBadRequest)
+	}
+
+	// policies are not evaluated for denied auths
+	if _, ex = explain("route=book.DELETE&usr=1&id=12"); ex.Reason != "AUTH_MISSING" {
+		t.Errorf("got %+v", ex)
+	}
+	ac.pc = nil
+
+	// without any groupauths all access is denied
+	ac.GroupAuthsH = &gmcom.GroupAuthsH{GroupAuths: map[string]map[string]bool{}}
+	if _, ex := explain("route=book.GET_ID&usr=1"); ex.Allowed || ex.Reason != "NO_GROUP_AUTHS" {
+		t.Errorf("got %+v", ex)
+	}
+}
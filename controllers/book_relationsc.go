@@ -78,6 +78,11 @@ func (bc *BookController) GetBookToLibrary(w http.ResponseWriter, r *http.Reques
 		libraryID = book.LibraryID
 	}
 
+	if !inScope(r, libraryID) {
+		respondWithError(w, http.StatusForbidden, errOutOfScope)
+		return
+	}
+
 	//// parse commands ($cmd) for the belongsTo selection
 	//if vars != nil {
 	//	_, ok := vars["cmd"]
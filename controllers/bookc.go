@@ -77,6 +77,11 @@ func (bc *BookController) Create(w http.ResponseWriter, r *http.Request) {
 		LibraryID: bm.LibraryID,
 	}
 
+	if !inScope(r, book.LibraryID) {
+		respondWithError(w, http.StatusForbidden, errOutOfScope)
+		return
+	}
+
 	// build a base urlString for the JSON Body self-referencing Href tag
 	urlString := buildHrefStringFromCRUDReq(r, true)
 
@@ -153,6 +158,12 @@ func (bc *BookController) Update(w http.ResponseWriter, r *http.Request) {
 		LibraryID: bm.LibraryID,
 	}
 
+	// the book may neither be maintained nor moved outside of the data-scope
+	if !inScope(r, book.LibraryID) || !bc.bookInScope(r, id) {
+		respondWithError(w, http.StatusForbidden, errOutOfScope)
+		return
+	}
+
 	// build a base urlString for the JSON Body self-referencing Href tag
 	urlString := buildHrefStringFromCRUDReq(r, false)
 	book.ID = id
@@ -224,6 +235,11 @@ func (bc *BookController) Get(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusBadRequest, err.Error())
 		return
 	}
+
+	if !inScope(r, book.LibraryID) {
+		respondWithError(w, http.StatusForbidden, errOutOfScope)
+		return
+	}
 	book.Href = urlString
 
 	// TODO: implement extension-point if required
@@ -251,6 +267,11 @@ func (bc *BookController) Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !bc.bookInScope(r, id) {
+		respondWithError(w, http.StatusForbidden, errOutOfScope)
+		return
+	}
+
 	book := models.Book{
 		ID: id,
 	}
@@ -264,6 +285,25 @@ func (bc *BookController) Delete(w http.ResponseWriter, r *http.Request) {
 	respondWithHeader(w, http.StatusAccepted)
 }
 
+// bookInScope reports whether the existing book belongs to a library in the
+// data-scope of the requesting usr.  Scoped usrs are refused access to books
+// that cannot be read, as their library cannot be checked.
+func (bc *BookController) bookInScope(r *http.Request, id uint64) bool {
+
+	if !isScoped(r) {
+		return true
+	}
+	book := models.Book{
+		ID: id,
+	}
+	err := bc.bs.Get(&book)
+	if err != nil {
+		lw.Warning("bookInScope: book %d got: %v", id, err)
+		return false
+	}
+	return inScope(r, book.LibraryID)
+}
+
 // getBookSet is used by all BookSet queries as a means of injecting parameters
 // returns ([]Book, $count, countRequested, error)
 func (bc *BookController) getBookSet(w http.ResponseWriter, r *http.Request, params []sqac.GetParam) ([]models.Book, uint64, bool, error) {
@@ -275,6 +315,9 @@ func (bc *BookController) getBookSet(w http.ResponseWriter, r *http.Request, par
 	var count uint64
 	countReq := false
 
+	// restrict the selection to the data-scope of the usr
+	params = scopeParams(r, "LibraryID", params)
+
 	// check for mux.vars
 	vars := mux.Vars(r)
 
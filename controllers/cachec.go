@@ -0,0 +1,45 @@
+package controllers
+
+//=============================================================================================
+// Authorization cache controller code
+//=============================================================================================
+
+import (
+	"net/http"
+	"time"
+)
+
+// CacheController supports the administration of the authorization caches of
+// the local process.
+type CacheController struct {
+	reload func() error
+}
+
+// CacheReload is the response of the cache reload endpoint.
+type CacheReload struct {
+	ReloadedOn time.Time `json:"reloaded_on"`
+}
+
+// NewCacheController creates a new CacheController.  reload refills the
+// authorization caches from the db; it leaves a cache unchanged if the cache
+// cannot be read.
+func NewCacheController(reload func() error) *CacheController {
+	return &CacheController{
+		reload: reload,
+	}
+}
+
+// ReloadCaches discards the active-usr, auth, usrgroup and groupauth caches
+// of the local process and reloads them from the db.  The other group-members
+// pick up the reloaded entries as they reconcile their caches with the local
+// process.  A failed reload is reported with a 500 status.
+//
+// POST /admin/caches/reload
+func (cc *CacheController) ReloadCaches(w http.ResponseWriter, r *http.Request) {
+
+	if err := cc.reload(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "cache reload failed: "+err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, CacheReload{ReloadedOn: time.Now()})
+}
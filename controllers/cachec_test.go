@@ -0,0 +1,39 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReloadCaches(t *testing.T) {
+
+	reloads := 0
+	cc := NewCacheController(func() error { reloads++; return nil })
+
+	w := httptest.NewRecorder()
+	cc.ReloadCaches(w, httptest.NewRequest("POST", "/admin/caches/reload", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d", w.Code)
+	}
+	var cr CacheReload
+	if err := json.Unmarshal(w.Body.Bytes(), &cr); err != nil {
+		t.Fatal(err)
+	}
+	if reloads != 1 || cr.ReloadedOn.IsZero() {
+		t.Errorf("got %d reloads, %+v", reloads, cr)
+	}
+}
+
+func TestReloadCachesFailure(t *testing.T) {
+
+	cc := NewCacheController(func() error { return errors.New("db down") })
+
+	w := httptest.NewRecorder()
+	cc.ReloadCaches(w, httptest.NewRequest("POST", "/admin/caches/reload", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d; want %d", w.Code, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,115 @@
+package controllers
+
+//=============================================================================================
+// Cluster status controller code
+//=============================================================================================
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/1414C/libraryapp/group/gmcom"
+)
+
+// GroupDetailer provides the local view of the process group.  It is
+// implemented by the group-membership server.
+type GroupDetailer interface {
+	GetLocalDetails(ctx context.Context) (*gmcom.GMMessage, error)
+}
+
+// ClusterController reports the state of the process group as seen by the
+// local process.
+type ClusterController struct {
+	gd      GroupDetailer
+	timeout time.Duration
+}
+
+// ClusterStatus is the response of the cluster status endpoint.
+type ClusterStatus struct {
+	ID                uint            `json:"id"`
+	IPAddress         string          `json:"ip_address"`
+	IncarnationNumber uint            `json:"incarnation_number"`
+	Leader            ClusterLeader   `json:"leader"`
+	IsLeader          bool            `json:"is_leader"`
+	InElection        bool            `json:"in_election"`
+	Members           []ClusterMember `json:"members"`
+}
+
+// ClusterLeader identifies the leader of the process group.
+type ClusterLeader struct {
+	ID        uint   `json:"id"`
+	IPAddress string `json:"ip_address"`
+}
+
+// ClusterMember is an entry of the local member list.
+type ClusterMember struct {
+	ID                uint   `json:"id"`
+	IPAddress         string `json:"ip_address"`
+	Status            string `json:"status"`
+	StatusCount       uint   `json:"status_count"`
+	IncarnationNumber uint   `json:"incarnation_number"`
+}
+
+// NewClusterController creates a new ClusterController
+func NewClusterController(gd GroupDetailer) *ClusterController {
+	return &ClusterController{
+		gd:      gd,
+		timeout: 5 * time.Second,
+	}
+}
+
+// GetClusterStatus returns the ID, incarnation number, leader and election
+// state of the local process, along with its member list.  The member list is
+// a snapshot of the local process's view of the group and may lag behind the
+// views of the other members.
+//
+// GET /admin/cluster
+func (cc *ClusterController) GetClusterStatus(w http.ResponseWriter, r *http.Request) {
+
+	// the group-membership server processes the request on its command
+	// channel; do not wait on it indefinitely if it is not running.
+	ctx, cancel := context.WithTimeout(r.Context(), cc.timeout)
+	defer cancel()
+	d, err := cc.gd.GetLocalDetails(ctx)
+	if err != nil {
+		respondWithError(w, http.StatusServiceUnavailable, "the group-membership server is not responding")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, clusterStatusOf(d))
+}
+
+// clusterStatusOf converts the local details of the group-membership server
+// into a ClusterStatus.
+func clusterStatusOf(d *gmcom.GMMessage) ClusterStatus {
+
+	cs := ClusterStatus{
+		ID:                d.TargetID,
+		IPAddress:         d.TargetIPAddress,
+		IncarnationNumber: d.TargetIncarnationNumber,
+		Leader: ClusterLeader{
+			ID:        d.SubjectID,
+			IPAddress: d.SubjectIPAddress,
+		},
+		IsLeader:   d.TargetID != 0 && d.TargetID == d.SubjectID,
+		InElection: d.InElection,
+		Members:    make([]ClusterMember, 0),
+	}
+
+	if d.MemberMap != nil {
+		for _, m := range d.MemberMap.IDMap {
+			cs.Members = append(cs.Members, ClusterMember{
+				ID:                m.ID,
+				IPAddress:         m.IPAddress,
+				Status:            string(m.Status),
+				StatusCount:       m.StatusCount,
+				IncarnationNumber: m.IncarnationNumber,
+			})
+		}
+	}
+	sort.Slice(cs.Members, func(i, j int) bool {
+		return cs.Members[i].ID < cs.Members[j].ID
+	})
+	return cs
+}
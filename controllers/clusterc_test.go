@@ -0,0 +1,67 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/1414C/libraryapp/group/gmcom"
+)
+
+// stubDetailer returns a fixed view of the process group; a nil message
+// simulates a group-membership server that is not running.
+type stubDetailer struct {
+	m *gmcom.GMMessage
+}
+
+func (sd stubDetailer) GetLocalDetails(ctx context.Context) (*gmcom.GMMessage, error) {
+	if sd.m == nil {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return sd.m, nil
+}
+
+func TestGetClusterStatus(t *testing.T) {
+
+	mm := &gmcom.OMap{}
+	mm.Init()
+	mm.Add(2, gmcom.GMMember{ID: 2, IPAddress: "10.0.0.2:4444", Status: gmcom.CStatusSuspect, StatusCount: 3, IncarnationNumber: 1})
+	mm.Add(1, gmcom.GMMember{ID: 1, IPAddress: "10.0.0.1:4444", Status: gmcom.CStatusAlive, StatusCount: 40, IncarnationNumber: 2})
+
+	cc := NewClusterController(stubDetailer{&gmcom.GMMessage{
+		TargetID:                1,
+		TargetIPAddress:         "10.0.0.1:4444",
+		TargetIncarnationNumber: 2,
+		SubjectID:               1,
+		SubjectIPAddress:        "10.0.0.1:4444",
+		MemberMap:               mm,
+	}})
+
+	w := httptest.NewRecorder()
+	cc.GetClusterStatus(w, httptest.NewRequest("GET", "/admin/cluster", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d", w.Code)
+	}
+	var cs ClusterStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &cs); err != nil {
+		t.Fatal(err)
+	}
+	if cs.ID != 1 || cs.IncarnationNumber != 2 || !cs.IsLeader || cs.Leader.IPAddress != "10.0.0.1:4444" || cs.InElection {
+		t.Errorf("got %+v", cs)
+	}
+	if len(cs.Members) != 2 || cs.Members[0].ID != 1 || cs.Members[1].Status != "SUSPECT" || cs.Members[1].StatusCount != 3 {
+		t.Errorf("got members %+v", cs.Members)
+	}
+
+	cc = NewClusterController(stubDetailer{})
+	cc.timeout = 10 * time.Millisecond
+	w = httptest.NewRecorder()
+	cc.GetClusterStatus(w, httptest.NewRequest("GET", "/admin/cluster", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("got status %d; want %d", w.Code, http.StatusServiceUnavailable)
+	}
+}
@@ -0,0 +1,65 @@
+package controllers
+
+//=============================================================================================
+// GroupAuth data-scope enforcement code
+//=============================================================================================
+
+import (
+	"net/http"
+
+	"github.com/1414C/libraryapp/models"
+	"github.com/1414C/sqac"
+)
+
+// errOutOfScope is returned to usrs accessing an entity outside of their data-scope
+const errOutOfScope = "the requested entity is outside of your data-scope"
+
+// scopeParams prepends the data-scope of the requesting usr to the parameters of a
+// collection query in the form fieldName IN (...).  sqac renders each GetParam as
+// 'field operand ? next', so the IN-list is built from one GetParam per library.
+func scopeParams(r *http.Request, fieldName string, params []sqac.GetParam) []sqac.GetParam {
+
+	ds := models.DataScopeFromContext(r.Context())
+	if ds == nil {
+		return params
+	}
+
+	next := ""
+	if len(params) > 0 {
+		next = " AND"
+	}
+
+	ids := ds.Libraries()
+	if len(ids) == 0 {
+		// an empty scope grants nothing; ids start at 1
+		return append([]sqac.GetParam{{FieldName: fieldName, Operand: "=", ParamValue: 0, NextOperator: next}}, params...)
+	}
+
+	scoped := make([]sqac.GetParam, 0, len(ids)+len(params))
+	for i, id := range ids {
+		p := sqac.GetParam{
+			Operand:    ",",
+			ParamValue: id,
+		}
+		if i == 0 {
+			p.FieldName = fieldName
+			p.Operand = "IN ("
+		}
+		if i == len(ids)-1 {
+			p.NextOperator = ")" + next
+		}
+		scoped = append(scoped, p)
+	}
+	return append(scoped, params...)
+}
+
+// inScope reports whether the requesting usr's data-scope permits access to the
+// rows of the specified library.
+func inScope(r *http.Request, libraryID uint64) bool {
+	return models.DataScopeFromContext(r.Context()).AllowsLibrary(libraryID)
+}
+
+// isScoped reports whether the requesting usr is restricted by a data-scope.
+func isScoped(r *http.Request) bool {
+	return models.DataScopeFromContext(r.Context()) != nil
+}
@@ -0,0 +1,135 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/1414C/libraryapp/models"
+	"github.com/1414C/sqac"
+	"github.com/1414C/sqac/common"
+	"github.com/gorilla/mux"
+)
+
+// renderParams renders the WHERE clause of a collection query in the same
+// way as sqac's GetEntitiesWithCommands.
+func renderParams(params []sqac.GetParam) (string, []interface{}) {
+
+	var s string
+	var pv []interface{}
+	for _, p := range params {
+		s = s + " " + common.CamelToSnake(p.FieldName) + " " + p.Operand + " ? " + p.NextOperator
+		pv = append(pv, p.ParamValue)
+	}
+	return strings.Join(strings.Fields(s), " "), pv
+}
+
+func scopedRequest(ids ...uint64) *http.Request {
+
+	r := httptest.NewRequest("GET", "/books", nil)
+	if ids == nil {
+		return r
+	}
+	ds := &models.DataScope{LibraryIDs: make(map[uint64]bool)}
+	for _, id := range ids {
+		if id != 0 {
+			ds.LibraryIDs[id] = true
+		}
+	}
+	return r.WithContext(models.ContextWithDataScope(context.Background(), ds))
+}
+
+func TestScopeParams(t *testing.T) {
+
+	title := []sqac.GetParam{{FieldName: "Title", Operand: "=", ParamValue: "Dune"}}
+	tests := []struct {
+		r      *http.Request
+		params []sqac.GetParam
+		where  string
+		values []interface{}
+	}{
+		{scopedRequest(), title, "title = ?", []interface{}{"Dune"}},
+		{scopedRequest(3, 1, 2), nil, "library_id IN ( ? , ? , ? )", []interface{}{uint64(1), uint64(2), uint64(3)}},
+		{scopedRequest(2), nil, "library_id IN ( ? )", []interface{}{uint64(2)}},
+		{scopedRequest(2, 5), title, "library_id IN ( ? , ? ) AND title = ?", []interface{}{uint64(2), uint64(5), "Dune"}},
+		{scopedRequest(0), title, "library_id = ? AND title = ?", []interface{}{0, "Dune"}}, // an empty scope grants nothing
+	}
+	for _, tt := range tests {
+		where, values := renderParams(scopeParams(tt.r, "LibraryID", tt.params))
+		if where != tt.where || len(values) != len(tt.values) {
+			t.Errorf("got %q %v; want %q %v", where, values, tt.where, tt.values)
+			continue
+		}
+		for i := range values {
+			if values[i] != tt.values[i] {
+				t.Errorf("got %q %v; want %q %v", where, values, tt.where, tt.values)
+				break
+			}
+		}
+	}
+}
+
+// stubBookService holds the books by ID
+type stubBookService struct {
+	models.BookService
+	books   map[uint64]models.Book
+	deleted []uint64
+}
+
+func (s *stubBookService) Get(book *models.Book) error {
+	b, ok := s.books[book.ID]
+	if !ok {
+		return models.ErrNotFound
+	}
+	*book = b
+	return nil
+}
+
+func (s *stubBookService) Delete(book *models.Book) error {
+	if _, ok := s.books[book.ID]; !ok {
+		return models.ErrNotFound
+	}
+	s.deleted = append(s.deleted, book.ID)
+	return nil
+}
+
+func TestBookDataScope(t *testing.T) {
+
+	bs := &stubBookService{books: map[uint64]models.Book{1: {ID: 1, LibraryID: 1}, 2: {ID: 2, LibraryID: 2}}}
+	bc := NewBookController(bs, models.Services{})
+
+	call := func(h http.HandlerFunc, method, id string, scope ...uint64) int {
+		r := scopedRequest(scope...)
+		r.Method = method
+		w := httptest.NewRecorder()
+		h(w, mux.SetURLVars(r, map[string]string{"id": id}))
+		return w.Code
+	}
+
+	tests := []struct {
+		name   string
+		h      http.HandlerFunc
+		method string
+		id     string
+		scope  []uint64
+		want   int
+	}{
+		{"get in scope", bc.Get, "GET", "1", []uint64{1}, http.StatusCreated},
+		{"get outside scope", bc.Get, "GET", "2", []uint64{1}, http.StatusForbidden},
+		{"get unscoped", bc.Get, "GET", "2", nil, http.StatusCreated},
+		{"delete outside scope", bc.Delete, "DELETE", "2", []uint64{1}, http.StatusForbidden},
+		{"delete unreadable book when scoped", bc.Delete, "DELETE", "9", []uint64{1}, http.StatusForbidden},
+		{"delete unreadable book when unscoped", bc.Delete, "DELETE", "9", nil, http.StatusBadRequest},
+		{"delete in scope", bc.Delete, "DELETE", "1", []uint64{1, 3}, http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		if c := call(tt.h, tt.method, tt.id, tt.scope...); c != tt.want {
+			t.Errorf("%s: got status %d; want %d", tt.name, c, tt.want)
+		}
+	}
+	if len(bs.deleted) != 1 || bs.deleted[0] != 1 {
+		t.Errorf("got deleted books %v; want [1]", bs.deleted)
+	}
+}
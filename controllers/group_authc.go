@@ -52,6 +52,7 @@ func (gc *GroupAuthController) Create(w http.ResponseWriter, r *http.Request) {
 	groupauth := models.GroupAuth{
 		GroupID: g.GroupID,
 		AuthID:  g.AuthID,
+		Scope:   g.Scope,
 	}
 
 	// build a base urlString for the JSON Body self-referencing Href tag
@@ -71,13 +72,16 @@ func (gc *GroupAuthController) Create(w http.ResponseWriter, r *http.Request) {
 	// get the groupName and authNames
 	gu := gmcom.GroupAuthD{
 		Forward:   true,
-		ID:        g.ID,
+		ID:        groupauth.ID,
 		GroupName: g.GroupName, // empty
 		AuthName:  g.AuthName,  // empty
 		GroupID:   g.GroupID,
 		AuthID:    g.AuthID,
 		Op:        gmcom.COpCreate,
 	}
+	if groupauth.Scope != nil {
+		gu.Scope = *groupauth.Scope
+	}
 
 	err = gmcl.AddUpdGroupAuthCache(gu, gc.internalAddress)
 	if err != nil {
@@ -113,6 +117,7 @@ func (gc *GroupAuthController) Update(w http.ResponseWriter, r *http.Request) {
 	groupauth := models.GroupAuth{
 		GroupID: g.GroupID,
 		AuthID:  g.AuthID,
+		Scope:   g.Scope,
 	}
 
 	// build a base urlString for the JSON Body self-referencing Href tag
@@ -134,13 +139,16 @@ func (gc *GroupAuthController) Update(w http.ResponseWriter, r *http.Request) {
 	// get the groupName and authNames
 	gu := gmcom.GroupAuthD{
 		Forward:   true,
-		ID:        g.ID,
+		ID:        groupauth.ID,
 		GroupName: g.GroupName, // empty
 		AuthName:  g.AuthName,  // empty
 		GroupID:   g.GroupID,
 		AuthID:    g.AuthID,
 		Op:        gmcom.COpUpdate,
 	}
+	if groupauth.Scope != nil {
+		gu.Scope = *groupauth.Scope
+	}
 
 	err = gmcl.AddUpdGroupAuthCache(gu, gc.internalAddress)
 	if err != nil {
@@ -218,6 +226,29 @@ func (gc *GroupAuthController) Delete(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// disseminateGroupAuthChange updates the local GroupAuths cache via the
+// group-membership server, which then forwards the change to all other
+// group-members that are in a non-failed status.  Deletions require the ID of
+// the groupauth only.
+func (gc *GroupAuthController) disseminateGroupAuthChange(groupauth models.GroupAuth, op gmcom.OpType) {
+
+	gu := gmcom.GroupAuthD{
+		Forward: true,
+		ID:      groupauth.ID,
+		GroupID: groupauth.GroupID,
+		AuthID:  groupauth.AuthID,
+		Op:      op,
+	}
+	if groupauth.Scope != nil {
+		gu.Scope = *groupauth.Scope
+	}
+
+	err := gmcl.AddUpdGroupAuthCache(gu, gc.internalAddress)
+	if err != nil {
+		lw.ErrorWithPrefixString("GroupAuthController cache update error message:", err)
+	}
+}
+
 // GetGroupAuths facilitates the retrieval of all existing GroupAuths.  This method is
 // bound to the gorilla.mux router in main.go.
 //
@@ -229,7 +260,11 @@ func (gc *GroupAuthController) GetGroupAuths(w http.ResponseWriter, r *http.Requ
 	urlString = strings.TrimSuffix(urlString, "s/")
 	urlString = urlString + "/"
 
-	groupauths := gc.gs.GetGroupAuths()
+	groupauths, err := gc.gs.GetGroupAuths()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
 	if groupauths != nil {
 		for i, u := range groupauths {
 			groupauths[i].Href = urlString + strconv.FormatUint(uint64(u.ID), 10)
@@ -0,0 +1,157 @@
+package controllers
+
+//=============================================================================================
+// Token introspection controller code
+//=============================================================================================
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/1414C/libraryapp/group/gmcom"
+	"github.com/1414C/lw"
+	"github.com/dgrijalva/jwt-go"
+)
+
+// TokenVerifier verifies a jwt issued by the application and returns its
+// claims.  It is implemented by the auth middleware, which holds the key
+// material and the key-set.
+type TokenVerifier interface {
+	VerifyToken(tokenString string) (jwt.MapClaims, error)
+}
+
+// IntrospectController answers RFC 7662-style token introspection requests on
+// behalf of downstream services that cannot verify the application's tokens
+// themselves.
+type IntrospectController struct {
+	tv          TokenVerifier
+	ActUsrsH    *gmcom.ActUsrsH    // cache
+	UsrGroupsH  *gmcom.UsrGroupsH  // cache
+	UsrMembersH *gmcom.UsrMembersH // cache
+	SessionsH   *gmcom.SessionsH   // cache
+}
+
+// Introspection is the RFC 7662 introspection response.  An inactive token is
+// reported as {"active": false}; Revoked is additionally set when the token is
+// correctly signed and unexpired, but its session has been revoked or its usr
+// has been deactivated or deleted.
+type Introspection struct {
+	Active    bool     `json:"active"`
+	Revoked   bool     `json:"revoked,omitempty"`
+	TokenType string   `json:"token_type,omitempty"`
+	Sub       string   `json:"sub,omitempty"`
+	UID       uint64   `json:"uid,omitempty"`
+	Email     string   `json:"email,omitempty"`
+	Groups    []string `json:"groups,omitempty"`
+	Exp       int64    `json:"exp,omitempty"`
+	Iat       int64    `json:"iat,omitempty"`
+}
+
+// NewIntrospectController creates a new IntrospectController
+func NewIntrospectController(tv TokenVerifier, actUsrs *gmcom.ActUsrsH, usrGroups *gmcom.UsrGroupsH, usrMembers *gmcom.UsrMembersH, sessions *gmcom.SessionsH) *IntrospectController {
+	return &IntrospectController{
+		tv:          tv,
+		ActUsrsH:    actUsrs,
+		UsrGroupsH:  usrGroups,
+		UsrMembersH: usrMembers,
+		SessionsH:   sessions,
+	}
+}
+
+// Introspect reports whether the presented token is active along with the uid,
+// current groups and expiry of its usr.  The token is passed in the token
+// parameter of a form-encoded body as per RFC 7662, or in a JSON body of the
+// form {"token": "..."}.  The groups are read from the group-membership cache,
+// so they reflect the usr's present memberships rather than those at the time
+// the token was issued.
+//
+// POST /oauth/introspect
+func (ic *IntrospectController) Introspect(w http.ResponseWriter, r *http.Request) {
+
+	tokenString, err := introspectionToken(r)
+	if err != nil || tokenString == "" {
+		respondWithError(w, http.StatusBadRequest, "invalid_request: a token parameter is required")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+
+	claims, err := ic.tv.VerifyToken(tokenString)
+	if err != nil {
+		lw.Debug("Introspect: %s", err.Error())
+		respondWithJSON(w, http.StatusOK, Introspection{Active: false})
+		return
+	}
+
+	uid := claimUint(claims, "uid")
+	if uid == 0 {
+		respondWithJSON(w, http.StatusOK, Introspection{Active: false})
+		return
+	}
+
+	// tokens of a revoked session are inactive until they expire
+	if sid, _ := claims["sid"].(string); ic.SessionsH.IsRevoked(sid) {
+		respondWithJSON(w, http.StatusOK, Introspection{Active: false, Revoked: true})
+		return
+	}
+
+	ic.ActUsrsH.RLock()
+	active := ic.ActUsrsH.ActiveUsrs[uid]
+	ic.ActUsrsH.RUnlock()
+	if !active {
+		respondWithJSON(w, http.StatusOK, Introspection{Active: false, Revoked: true})
+		return
+	}
+
+	groups := ic.UsrMembersH.GroupNames(uid, ic.UsrGroupsH)
+	sort.Strings(groups)
+	email, _ := claims["email"].(string)
+	respondWithJSON(w, http.StatusOK, Introspection{
+		Active:    true,
+		TokenType: "access_token",
+		Sub:       strconv.FormatUint(uid, 10),
+		UID:       uid,
+		Email:     email,
+		Groups:    groups,
+		Exp:       int64(claimUint(claims, "exp")),
+		Iat:       int64(claimUint(claims, "iat")),
+	})
+}
+
+// introspectionToken reads the token parameter from a form-encoded or JSON
+// request body.
+func introspectionToken(r *http.Request) (string, error) {
+
+	ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if ct == "application/json" {
+		var body struct {
+			Token string `json:"token"`
+		}
+		defer r.Body.Close()
+		err := json.NewDecoder(r.Body).Decode(&body)
+		return body.Token, err
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		return "", err
+	}
+	return r.PostForm.Get("token"), nil
+}
+
+// claimUint reads a numeric claim; encoding/json decodes numbers as float64.
+func claimUint(claims jwt.MapClaims, name string) uint64 {
+
+	switch v := claims[name].(type) {
+	case float64:
+		if v > 0 {
+			return uint64(v)
+		}
+	case json.Number:
+		n, _ := strconv.ParseUint(string(v), 10, 64)
+		return n
+	}
+	return 0
+}
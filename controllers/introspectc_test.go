@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/1414C/libraryapp/group/gmcom"
+	"github.com/dgrijalva/jwt-go"
+)
+
+// stubVerifier accepts the tokens in its map
+type stubVerifier map[string]jwt.MapClaims
+
+func (sv stubVerifier) VerifyToken(tokenString string) (jwt.MapClaims, error) {
+	c, ok := sv[tokenString]
+	if !ok {
+		return nil, fmt.Errorf("signature is invalid")
+	}
+	return c, nil
+}
+
+func TestIntrospect(t *testing.T) {
+
+	tv := stubVerifier{
+		"good":     {"uid": float64(1), "email": "a@b.com", "exp": float64(2000000000), "iat": float64(1000000000)},
+		"inactive": {"uid": float64(2), "exp": float64(2000000000)},
+		"session":  {"uid": float64(1), "sid": "s1", "exp": float64(2000000000)},
+		"revoked":  {"uid": float64(1), "sid": "s2", "exp": float64(2000000000)},
+	}
+	ic := NewIntrospectController(tv,
+		&gmcom.ActUsrsH{ActiveUsrs: map[uint64]bool{1: true, 2: false}},
+		&gmcom.UsrGroupsH{GroupNames: map[uint64]string{10: "Readers", 11: "Admin"}},
+		&gmcom.UsrMembersH{UsrGroupIDs: map[uint64]map[uint64]bool{1: {10: true, 11: true}}},
+		&gmcom.SessionsH{Revoked: map[string]int64{"s2": 2000000000}},
+	)
+
+	tests := []struct {
+		ct, body string
+		want     Introspection
+	}{
+		{"application/x-www-form-urlencoded", "token=good", Introspection{Active: true, TokenType: "access_token", Sub: "1", UID: 1, Email: "a@b.com", Groups: []string{"Admin", "Readers"}, Exp: 2000000000, Iat: 1000000000}},
+		{"application/json", `{"token":"good"}`, Introspection{Active: true, TokenType: "access_token", Sub: "1", UID: 1, Email: "a@b.com", Groups: []string{"Admin", "Readers"}, Exp: 2000000000, Iat: 1000000000}},
+		{"application/x-www-form-urlencoded", "token=forged", Introspection{Active: false}},
+		{"application/x-www-form-urlencoded", "token=inactive", Introspection{Active: false, Revoked: true}},
+		{"application/x-www-form-urlencoded", "token=session", Introspection{Active: true, TokenType: "access_token", Sub: "1", UID: 1, Groups: []string{"Admin", "Readers"}, Exp: 2000000000}},
+		{"application/x-www-form-urlencoded", "token=revoked", Introspection{Active: false, Revoked: true}},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/oauth/introspect", strings.NewReader(tt.body))
+		r.Header.Set("Content-Type", tt.ct)
+		w := httptest.NewRecorder()
+		ic.Introspect(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: got status %d", tt.body, w.Code)
+		}
+		var got Introspection
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprintf("%+v", got) != fmt.Sprintf("%+v", tt.want) {
+			t.Errorf("%s: got %+v; want %+v", tt.body, got, tt.want)
+		}
+	}
+
+	r := httptest.NewRequest("POST", "/oauth/introspect", strings.NewReader(""))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	ic.Introspect(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("missing token got status %d; want %d", w.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,309 @@
+package controllers
+
+//=============================================================================================
+// JWT key-set controller code
+//=============================================================================================
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/1414C/libraryapp/group/gmcl"
+	"github.com/1414C/libraryapp/group/gmcom"
+	"github.com/1414C/libraryapp/models"
+	"github.com/1414C/lw"
+)
+
+// JWTKeyController is the jwt key-set controller type for route binding
+type JWTKeyController struct {
+	js              models.JWTKeyService
+	jwtSignMethod   string
+	jwtLifetime     uint
+	internalAddress string
+	JWTKeysH        *gmcom.JWTKeysH // cache
+}
+
+// JWK is the RFC 7517 representation of a public verification key
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKSet is the RFC 7517 JWK Set document
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWTKeyInfo describes a member of the key-set without exposing key material
+type JWTKeyInfo struct {
+	Kid       string     `json:"kid"`
+	Alg       string     `json:"alg"`
+	Signing   bool       `json:"signing"`
+	CreatedOn time.Time  `json:"created_on"`
+	ExpiresOn *time.Time `json:"expires_on,omitempty"`
+}
+
+// NewJWTKeyController creates a new JWTKeyController
+func NewJWTKeyController(js models.JWTKeyService, jwtSignMethod string, jwtLifetime uint, internalAddress string) *JWTKeyController {
+	return &JWTKeyController{
+		js:              js,
+		jwtSignMethod:   jwtSignMethod,
+		jwtLifetime:     jwtLifetime,
+		internalAddress: internalAddress,
+	}
+}
+
+// JWKS publishes the public keys of the current key-set so that downstream
+// services can verify the application's tokens themselves.
+//
+// GET /.well-known/jwks.json
+func (jc *JWTKeyController) JWKS(w http.ResponseWriter, r *http.Request) {
+
+	set := JWKSet{Keys: []JWK{}}
+	for _, k := range jc.JWTKeysH.Snapshot().Keys {
+		jwk, err := toJWK(k.KID, k.Alg, k.VerifyKey())
+		if err != nil {
+			lw.Warning("JWKS: skipping key %s: %s", k.KID, err.Error())
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondWithJSON(w, http.StatusOK, set)
+}
+
+// GetJWTKeys lists the members of the current key-set.
+//
+// GET /jwtkeys
+func (jc *JWTKeyController) GetJWTKeys(w http.ResponseWriter, r *http.Request) {
+
+	snap := jc.JWTKeysH.Snapshot()
+	keys := []JWTKeyInfo{}
+	for _, k := range snap.Keys {
+		ki := JWTKeyInfo{
+			Kid:       k.KID,
+			Alg:       k.Alg,
+			Signing:   k.KID == snap.SignKID,
+			CreatedOn: time.Unix(k.CreatedOn, 0),
+		}
+		if k.ExpiresOn != 0 {
+			t := time.Unix(k.ExpiresOn, 0)
+			ki.ExpiresOn = &t
+		}
+		keys = append(keys, ki)
+	}
+	respondWithJSON(w, http.StatusOK, keys)
+}
+
+// Rotate creates a new signing key for the configured jwt signing-method.  The
+// previous signing key is retained for verification until the tokens it signed
+// have expired.  The new key-set is persisted and then the public keys are
+// disseminated to all group-members, each of which reads the new signing key
+// from the db.
+//
+// POST /jwtkey/rotate
+func (jc *JWTKeyController) Rotate(w http.ResponseWriter, r *http.Request) {
+
+	signKey, verifyKey, err := generateJWTKeyPair(jc.jwtSignMethod)
+	if err != nil {
+		lw.ErrorWithPrefixString("JWTKey Rotate:", err)
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	k, err := gmcom.NewJWTKey("", jc.jwtSignMethod, signKey, verifyKey)
+	if err != nil {
+		lw.ErrorWithPrefixString("JWTKey Rotate:", err)
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	privPEM, err := gmcom.EncodeJWTSignKey(signKey)
+	if err != nil {
+		lw.ErrorWithPrefixString("JWTKey Rotate:", err)
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	jwtkey := models.JWTKey{
+		KID:        k.KID,
+		Alg:        k.Alg,
+		PrivKeyPEM: string(privPEM),
+		PubKeyPEM:  string(k.PubPEM),
+	}
+	err = jc.js.Create(&jwtkey)
+	if err != nil {
+		lw.ErrorWithPrefixString("JWTKey Rotate:", err)
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// the previous signing key remains valid for the lifetime of a token
+	lifetime := time.Hour
+	if jc.jwtLifetime != 0 {
+		lifetime = time.Minute * time.Duration(jc.jwtLifetime)
+	}
+	expiry := time.Now().Add(lifetime + time.Minute)
+
+	snap := jc.JWTKeysH.Snapshot()
+	for i := range snap.Keys {
+		if snap.Keys[i].KID != snap.SignKID || snap.Keys[i].ExpiresOn != 0 {
+			continue
+		}
+		snap.Keys[i].ExpiresOn = expiry.Unix()
+		err = jc.retireKey(snap.Keys[i], expiry)
+		if err != nil {
+			lw.ErrorWithPrefixString("JWTKey Rotate:", err)
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	snap.Keys = append(snap.Keys, *k)
+	snap.SignKID = k.KID
+
+	// update the local cache and forward the key-set to all other group-members
+	err = jc.disseminateJWTKeyChange(snap, true)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, JWTKeyInfo{
+		Kid:       k.KID,
+		Alg:       k.Alg,
+		Signing:   true,
+		CreatedOn: time.Unix(k.CreatedOn, 0),
+	})
+}
+
+// retireKey persists the expiry date of the previous signing key.  Keys that
+// were read from the configured PEM files are persisted without their private
+// key, so that they are not used for signing again following a restart.
+func (jc *JWTKeyController) retireKey(k gmcom.JWTKey, expiry time.Time) error {
+
+	for _, pk := range jc.js.GetJWTKeys() {
+		if pk.KID != k.KID {
+			continue
+		}
+		pk.ExpiresOn = &expiry
+		return jc.js.Update(&pk)
+	}
+
+	created := time.Unix(k.CreatedOn, 0)
+	return jc.js.Create(&models.JWTKey{
+		KID:       k.KID,
+		Alg:       k.Alg,
+		PubKeyPEM: string(k.PubPEM),
+		CreatedOn: &created,
+		ExpiresOn: &expiry,
+	})
+}
+
+// disseminateJWTKeyChange replaces the local key-set via the group-membership server,
+// which then forwards the key-set to all other group-members that are in a
+// non-failed status.
+func (jc *JWTKeyController) disseminateJWTKeyChange(d gmcom.JWTKeysD, fwd bool) error {
+
+	d.Forward = fwd
+	err := gmcl.AddUpdJWTKeyCache(d, jc.internalAddress)
+	if err != nil {
+		lw.ErrorWithPrefixString("JWTKeyController cache update error message:", err)
+	}
+	return err
+}
+
+// generateJWTKeyPair creates a new key-pair suitable for the jwt signing-method.
+func generateJWTKeyPair(alg string) (signKey, verifyKey interface{}, err error) {
+
+	switch alg {
+	case "ES256", "ES384", "ES512":
+		curve := map[string]elliptic.Curve{"ES256": elliptic.P256(), "ES384": elliptic.P384(), "ES512": elliptic.P521()}[alg]
+		k, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		return k, &k.PublicKey, nil
+
+	case "RS256", "RS384", "RS512":
+		k, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, nil, err
+		}
+		return k, &k.PublicKey, nil
+
+	case "EdDSA":
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		return priv, pub, nil
+
+	default:
+		return nil, nil, fmt.Errorf("signing-key rotation is not supported for jwt signing-method %s", alg)
+	}
+}
+
+// toJWK converts a public verification key to its JWK representation.
+func toJWK(kid, alg string, verifyKey interface{}) (JWK, error) {
+
+	switch pk := verifyKey.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			Kty: "RSA",
+			Use: "sig",
+			Alg: alg,
+			Kid: kid,
+			N:   base64.RawURLEncoding.EncodeToString(pk.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pk.E)).Bytes()),
+		}, nil
+
+	case *ecdsa.PublicKey:
+		size := (pk.Curve.Params().BitSize + 7) / 8
+		return JWK{
+			Kty: "EC",
+			Use: "sig",
+			Alg: alg,
+			Kid: kid,
+			Crv: pk.Curve.Params().Name,
+			X:   base64.RawURLEncoding.EncodeToString(padBytes(pk.X.Bytes(), size)),
+			Y:   base64.RawURLEncoding.EncodeToString(padBytes(pk.Y.Bytes(), size)),
+		}, nil
+
+	case ed25519.PublicKey:
+		return JWK{
+			Kty: "OKP",
+			Use: "sig",
+			Alg: alg,
+			Kid: kid,
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(pk),
+		}, nil
+
+	default:
+		return JWK{}, fmt.Errorf("unsupported verification key type %T", verifyKey)
+	}
+}
+
+// padBytes left-pads b with zeros to the specified size.
+func padBytes(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	p := make([]byte, size)
+	copy(p[size-len(b):], b)
+	return p
+}
@@ -0,0 +1,132 @@
+package controllers
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/1414C/libraryapp/group/gmcom"
+	"github.com/1414C/libraryapp/models"
+)
+
+// stubJWTKeyService holds the persisted keys in creation order
+type stubJWTKeyService struct {
+	models.JWTKeyService
+	keys []models.JWTKey
+}
+
+func (s *stubJWTKeyService) Create(jwtkey *models.JWTKey) error {
+	jwtkey.ID = uint64(len(s.keys) + 1)
+	s.keys = append(s.keys, *jwtkey)
+	return nil
+}
+
+func (s *stubJWTKeyService) Update(jwtkey *models.JWTKey) error {
+	s.keys[jwtkey.ID-1] = *jwtkey
+	return nil
+}
+
+func (s *stubJWTKeyService) GetJWTKeys() []models.JWTKey {
+	return append([]models.JWTKey(nil), s.keys...)
+}
+
+func newTestJWTKeysH(t *testing.T, algs ...string) *gmcom.JWTKeysH {
+
+	d := gmcom.JWTKeysD{}
+	for _, alg := range algs {
+		signKey, verifyKey, err := generateJWTKeyPair(alg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		k, err := gmcom.NewJWTKey("", alg, signKey, verifyKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d.Keys = append(d.Keys, *k)
+		d.SignKID = k.KID
+	}
+	h := &gmcom.JWTKeysH{}
+	if err := h.Replace(d); err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestJWKS(t *testing.T) {
+
+	jc := NewJWTKeyController(&stubJWTKeyService{}, "EdDSA", 60, "127.0.0.1:1")
+	jc.JWTKeysH = newTestJWTKeysH(t, "ES256", "RS256", "EdDSA")
+
+	w := httptest.NewRecorder()
+	jc.JWKS(w, httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+	var set JWKSet
+	if err := json.Unmarshal(w.Body.Bytes(), &set); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("got status %d, %v", w.Code, err)
+	}
+	if strings.Contains(w.Body.String(), `"d"`) {
+		t.Errorf("got private key material in %s", w.Body.String())
+	}
+	if len(set.Keys) != 3 {
+		t.Fatalf("got %d keys; want 3", len(set.Keys))
+	}
+
+	for _, jwk := range set.Keys {
+		k, ok := jc.JWTKeysH.Lookup(jwk.Kid)
+		if !ok || k.Alg != jwk.Alg || jwk.Use != "sig" {
+			t.Errorf("got %+v", jwk)
+			continue
+		}
+		switch pk := k.VerifyKey().(type) {
+		case *ecdsa.PublicKey:
+			x, _ := base64.RawURLEncoding.DecodeString(jwk.X)
+			if jwk.Kty != "EC" || jwk.Crv != "P-256" || len(x) != 32 || pk.X.Cmp(new(big.Int).SetBytes(x)) != 0 {
+				t.Errorf("got %+v", jwk)
+			}
+		case ed25519.PublicKey:
+			x, _ := base64.RawURLEncoding.DecodeString(jwk.X)
+			if jwk.Kty != "OKP" || jwk.Crv != "Ed25519" || string(x) != string(pk) {
+				t.Errorf("got %+v", jwk)
+			}
+		default:
+			if jwk.Kty != "RSA" || jwk.N == "" || jwk.E != "AQAB" {
+				t.Errorf("got %+v", jwk)
+			}
+		}
+	}
+}
+
+func TestJWTKeyRetire(t *testing.T) {
+
+	js := &stubJWTKeyService{}
+	jc := NewJWTKeyController(js, "EdDSA", 60, "127.0.0.1:1")
+	jc.JWTKeysH = newTestJWTKeysH(t, "EdDSA")
+	fileKey := jc.JWTKeysH.Snapshot().Keys[0]
+
+	// the retirement of a key read from the PEM files is persisted without its private key
+	expiry := time.Now().Add(time.Hour).Truncate(time.Second)
+	if err := jc.retireKey(fileKey, expiry); err != nil {
+		t.Fatal(err)
+	}
+	if len(js.keys) != 1 {
+		t.Fatalf("got %d persisted keys; want 1", len(js.keys))
+	}
+	if pk := js.keys[0]; pk.KID != fileKey.KID || pk.PrivKeyPEM != "" || pk.PubKeyPEM != string(fileKey.PubPEM) || pk.ExpiresOn == nil || !pk.ExpiresOn.Equal(expiry) {
+		t.Errorf("got %+v", pk)
+	}
+
+	// the retirement of a rotated key updates its expiry date
+	js.keys = append(js.keys, models.JWTKey{ID: 2, KID: "rotated", Alg: "EdDSA", PrivKeyPEM: "priv", PubKeyPEM: "pub"})
+	if err := jc.retireKey(gmcom.JWTKey{KID: "rotated"}, expiry); err != nil {
+		t.Fatal(err)
+	}
+	if len(js.keys) != 2 || js.keys[1].ExpiresOn == nil || js.keys[1].PrivKeyPEM != "priv" {
+		t.Errorf("got %+v", js.keys)
+	}
+}
@@ -51,6 +51,11 @@ func (lc *LibraryController) GetLibraryToBooks(w http.ResponseWriter, r *http.Re
 		bSingle = true
 	}
 
+	if !inScope(r, libraryID) {
+		respondWithError(w, http.StatusForbidden, errOutOfScope)
+		return
+	}
+
 	// in all cases the library must be retrieved, as the validity of the
 	// the access-path must be verified.  Also consider that the book
 	// :id may not have been provided.
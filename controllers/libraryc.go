@@ -42,6 +42,12 @@ func (lc *LibraryController) Create(w http.ResponseWriter, r *http.Request) {
 	var err error
 	var lm models.Library
 
+	// branch staff manage their own branch only
+	if isScoped(r) {
+		respondWithError(w, http.StatusForbidden, errOutOfScope)
+		return
+	}
+
 	// TODO: implement extension-point if required
 	// TODO: safe to comment this block out if the extension-point is not needed
 	err = lc.ep.CrtEp.BeforeFirst(w, r)
@@ -124,6 +130,11 @@ func (lc *LibraryController) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !inScope(r, id) {
+		respondWithError(w, http.StatusForbidden, errOutOfScope)
+		return
+	}
+
 	decoder := json.NewDecoder(r.Body)
 	if err := decoder.Decode(&lm); err != nil {
 		lw.ErrorWithPrefixString("Library Update:", err)
@@ -196,6 +207,11 @@ func (lc *LibraryController) Get(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !inScope(r, id) {
+		respondWithError(w, http.StatusForbidden, errOutOfScope)
+		return
+	}
+
 	// build a base urlString for the JSON Body self-referencing Href tag
 	urlString := buildHrefStringFromCRUDReq(r, false)
 
@@ -245,6 +261,11 @@ func (lc *LibraryController) Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !inScope(r, id) {
+		respondWithError(w, http.StatusForbidden, errOutOfScope)
+		return
+	}
+
 	library := models.Library{
 		ID: id,
 	}
@@ -269,6 +290,9 @@ func (lc *LibraryController) getLibrarySet(w http.ResponseWriter, r *http.Reques
 	var count uint64
 	countReq := false
 
+	// restrict the selection to the data-scope of the usr
+	params = scopeParams(r, "ID", params)
+
 	// check for mux.vars
 	vars := mux.Vars(r)
 
@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rsa"
 	"encoding/json"
 	"fmt"
@@ -25,7 +26,16 @@ type UsrController struct {
 	jwtSignMethod   string
 	jwtLifetime     uint
 	internalAddress string
-	ActUsrsH        *gmcom.ActUsrsH //cache
+	ActUsrsH        *gmcom.ActUsrsH    //cache
+	UsrMembersH     *gmcom.UsrMembersH //cache
+	JWTKeysH        *gmcom.JWTKeysH    //cache
+	SessionsH       *gmcom.SessionsH   //cache
+	oidc            *oidcRP
+	ugs             models.UsrGroupService
+	ums             models.UsrGroupMemberService
+	uis             models.UsrInviteService
+	uss             models.UsrSessionService
+	registration    RegistrationConfig
 }
 
 // Token is the jwt return type
@@ -34,10 +44,12 @@ type Token struct {
 }
 
 // NewUsrController creates a new UsrController
-func NewUsrController(us models.UsrService, jwtKeyMap map[string]interface{}, jwtSignMethod string, jwtLifetime uint, internalAddress string) *UsrController {
+func NewUsrController(us models.UsrService, ugs models.UsrGroupService, ums models.UsrGroupMemberService, jwtKeyMap map[string]interface{}, jwtSignMethod string, jwtLifetime uint, internalAddress string) *UsrController {
 	lw.Console("Login() signing jwt's with %s", jwtSignMethod)
 	return &UsrController{
 		us:              us,
+		ugs:             ugs,
+		ums:             ums,
 		jwtKeyMap:       jwtKeyMap,
 		jwtSignMethod:   jwtSignMethod,
 		jwtLifetime:     jwtLifetime,
@@ -59,6 +71,14 @@ func (uc *UsrController) Login(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
+	// local password logins are replaced by the IdP login when the
+	// oidc relying-party mode is active, other than for the optional
+	// break-glass account.
+	if uc.oidc != nil && uc.oidc.cfg.DisableLocalLogin && !uc.oidc.isBreakGlass(u.Email) {
+		respondWithError(w, http.StatusForbidden, "local login is disabled; please use /usr/login/oidc")
+		return
+	}
+
 	// fill the Usr model with the data needed for authentication only
 	usr := models.Usr{
 		Email:    u.Email,
@@ -82,56 +102,103 @@ func (uc *UsrController) Login(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// groups
-	lw.Info("Login()->user groups: %v", *authenticatedUsr.Groups)
+	tokenString, httpStatus, err := uc.issueToken(authenticatedUsr, r)
+	if err != nil {
+		lw.Warning("Authentication failure for: %v", authenticatedUsr.Email)
+		respondWithError(w, httpStatus, err.Error())
+		return
+	}
+
+	response := Token{tokenString}
+	respondWithJSON(w, http.StatusOK, response)
+
+	// update the local process's Usr cache and then forward the information
+	// to all other group-members presently in a non-failed state.
+	uc.disseminateUsrChange(authenticatedUsr.ID, true, authenticatedUsr.Active)
+}
 
-	var tokenString string
-	var httpStatus int
+// issueToken creates and signs a JWT for the authenticated usr using the
+// configured jwtSignMethod.  A UsrSession is recorded for the token when
+// session tracking is enabled; r supplies its user-agent and ip address.
+func (uc *UsrController) issueToken(authenticatedUsr *models.Usr, r *http.Request) (tokenString string, httpStatus int, err error) {
 
-	lw.Info("AUTHENTICATED USR: %v", authenticatedUsr)
+	// groups; informational only - RequireUsr reads the current
+	// group-memberships of the usr on each request.
+	groups := *uc.usrGroupString(authenticatedUsr.ID)
+	lw.Info("issueToken()->user groups: %v", groups)
 
 	// prepare claims for the token
 	claims := make(jwt.MapClaims)
 	claims["email"] = authenticatedUsr.Email
 	claims["id"] = authenticatedUsr.ID
-	claims["iat"] = time.Now().Unix()
-	if uc.jwtLifetime == 0 {
-		claims["exp"] = time.Now().Add(time.Hour * time.Duration(1)).Unix()
-	} else {
-		claims["exp"] = time.Now().Add(time.Minute * time.Duration(uc.jwtLifetime)).Unix()
+	now := time.Now()
+	exp := now.Add(time.Hour * time.Duration(1))
+	if uc.jwtLifetime != 0 {
+		exp = now.Add(time.Minute * time.Duration(uc.jwtLifetime))
+	}
+	claims["iat"] = now.Unix()
+	claims["exp"] = exp.Unix()
+
+	// the sid claim ties the token to its UsrSession
+	sid, err := uc.recordSession(authenticatedUsr.ID, r, now, exp)
+	if err != nil {
+		lw.ErrorWithPrefixString("issueToken() failed to record the usr session:", err)
+		return "", http.StatusInternalServerError, fmt.Errorf("authentication failure")
+	}
+	if sid != "" {
+		claims["sid"] = sid
 	}
 
 	// set custom claims; auth groups as ; separated string
-	claims["Groups"] = *authenticatedUsr.Groups
+	claims["Groups"] = groups
 	claims["uid"] = authenticatedUsr.ID
 
+	// sign with the current key of the key-set when available; the kid
+	// header permits the verification key to be rotated.
+	if uc.JWTKeysH != nil {
+		if k, ok := uc.JWTKeysH.Signer(); ok && k.Alg == uc.jwtSignMethod {
+			return uc.signWithKey(claims, k)
+		}
+	}
+
 	switch uc.jwtSignMethod {
 	case "ES256", "ES384", "ES512":
-		tokenString, httpStatus, err = uc.signECDSA(claims)
+		return uc.signECDSA(claims)
 
 	case "RS256", "RS384", "RS512":
-		tokenString, httpStatus, err = uc.signRSA(claims)
+		return uc.signRSA(claims)
 
-	// case "HS256": // not supported
+	case "HS256", "HS384", "HS512":
+		return uc.signHmac(claims)
+
+	case "EdDSA":
+		return uc.signEdDSA(claims)
 
 	default:
-		lw.Warning("Authentication failure for: %v", authenticatedUsr.Email)
-		respondWithError(w, http.StatusBadRequest, "authentication failure")
-		return
+		return "", http.StatusBadRequest, fmt.Errorf("authentication failure")
 	}
+}
 
-	if err != nil {
-		lw.Warning("Authentication failure for: %v", authenticatedUsr.Email)
-		respondWithError(w, httpStatus, err.Error())
-		return
-	}
+// signWithKey creates a jwt.Token carrying the kid of the key-set signing key
+// and signs it with the key.
+func (uc *UsrController) signWithKey(claims jwt.MapClaims, k *gmcom.JWTKey) (tokenString string, httpStatus int, err error) {
 
-	response := Token{tokenString}
-	respondWithJSON(w, http.StatusOK, response)
+	method := jwt.GetSigningMethod(k.Alg)
+	if method == nil {
+		e := fmt.Errorf("error: unknown signing-method %v for key %s", k.Alg, k.KID)
+		lw.Error(e)
+		return "", http.StatusForbidden, e
+	}
 
-	// update the local process's Usr cache and then forward the information
-	// to all other group-members presently in a non-failed state.
-	uc.disseminateUsrChange(authenticatedUsr.ID, true, authenticatedUsr.Active)
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = k.KID
+	tokenString, err = token.SignedString(k.SignKey())
+	if err != nil {
+		e := fmt.Errorf("error: failed to sign jwt with signing-method %v and key %s", k.Alg, k.KID)
+		lw.Error(e)
+		return "", http.StatusInternalServerError, e
+	}
+	return tokenString, http.StatusOK, nil
 }
 
 // signECDSA creates a jwt.Token, set the claims and the signs via the specified curve
@@ -148,7 +215,7 @@ func (uc *UsrController) signECDSA(claims jwt.MapClaims) (tokenString string, ht
 	case "ES384":
 		token = jwt.New(jwt.SigningMethodES384)
 		signKey, ok = uc.jwtKeyMap["ES384SignKey"].(*ecdsa.PrivateKey)
-	case "ES521":
+	case "ES512": // P-521 keys are held under 'ES521'
 		token = jwt.New(jwt.SigningMethodES512)
 		signKey, ok = uc.jwtKeyMap["ES521SignKey"].(*ecdsa.PrivateKey)
 	default:
@@ -210,9 +277,58 @@ func (uc *UsrController) signRSA(claims jwt.MapClaims) (tokenString string, http
 	return tokenString, http.StatusOK, nil
 }
 
-// signHMac will one day create a jwt.Token, set the claims and the sign via the shared-secret
-func (uc *UsrController) signHmac() {
+// signEdDSA creates a jwt.Token, set the claims and the signs via the Ed25519 key
+func (uc *UsrController) signEdDSA(claims jwt.MapClaims) (tokenString string, httpStatus int, err error) {
+
+	signKey, ok := uc.jwtKeyMap["EdDSASignKey"].(ed25519.PrivateKey)
+	if !ok {
+		e := fmt.Errorf("error: could not read signKey in Login()")
+		lw.Error(e)
+		return "", http.StatusForbidden, e
+	}
+
+	token := jwt.NewWithClaims(gmcom.EdDSA, claims)
+	tokenString, err = token.SignedString(signKey)
+	if err != nil {
+		e := fmt.Errorf("error: failed to sign jwt with EdDSA signing-method")
+		lw.Error(e)
+		return "", http.StatusInternalServerError, e
+	}
+	return tokenString, http.StatusOK, nil
+}
+
+// signHmac creates a jwt.Token, set the claims and the signs via the shared-secret
+func (uc *UsrController) signHmac(claims jwt.MapClaims) (tokenString string, httpStatus int, err error) {
+
+	var token *jwt.Token
+
+	switch uc.jwtSignMethod {
+	case "HS256":
+		token = jwt.New(jwt.SigningMethodHS256)
+	case "HS384":
+		token = jwt.New(jwt.SigningMethodHS384)
+	case "HS512":
+		token = jwt.New(jwt.SigningMethodHS512)
+	default:
+		e := fmt.Errorf("error: unknown HMAC signing-method %v", uc.jwtSignMethod)
+		lw.Error(e)
+		return "", http.StatusForbidden, e
+	}
+	signKey, ok := uc.jwtKeyMap["HMACKey"].([]byte)
+	if !ok || len(signKey) == 0 {
+		e := fmt.Errorf("error: could not read hmac secret in Login()")
+		lw.Error(e)
+		return "", http.StatusForbidden, e
+	}
 
+	token.Claims = claims
+	tokenString, err = token.SignedString(signKey)
+	if err != nil {
+		e := fmt.Errorf("error: failed to sign jwt with HMAC signing-method %v", uc.jwtSignMethod)
+		lw.Error(e)
+		return "", http.StatusInternalServerError, e
+	}
+	return tokenString, http.StatusOK, nil
 }
 
 // Create - process the signup when a usr attempts to create a new usr account.
@@ -232,6 +348,18 @@ func (uc *UsrController) Create(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
+	// resolve the requested UsrGroups
+	if u.Groups == nil {
+		respondWithError(w, http.StatusBadRequest, models.ErrGroupRequired.Error())
+		return
+	}
+	groupIDs, err := uc.usrGroupIDsByName(*u.Groups)
+	if err != nil {
+		lw.Warning(err.Error())
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	// fill the Usr model
 	usr := models.Usr{
 		Name:     u.Name,
@@ -245,14 +373,23 @@ func (uc *UsrController) Create(w http.ResponseWriter, r *http.Request) {
 	urlString := buildHrefStringFromCRUDReq(r, false)
 
 	// call the create method on the usr model
-	err := uc.us.Create(&usr)
+	err = uc.us.Create(&usr)
 	if err != nil {
 		lw.Warning(err.Error())
 		respondWithError(w, http.StatusBadRequest, err.Error())
 		return
 	}
+
+	// create the group-memberships of the new usr
+	err = uc.setUsrGroups(usr.ID, groupIDs)
+	if err != nil {
+		lw.Warning(err.Error())
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
 	usr.Password = ""
 	usr.PasswordHash = ""
+	usr.Groups = uc.usrGroupString(usr.ID)
 	usr.Href = urlString + "/" + strconv.FormatUint(uint64(usr.ID), 10)
 	respondWithJSON(w, http.StatusCreated, usr)
 
@@ -270,7 +407,7 @@ func (uc *UsrController) Update(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id, err := strconv.ParseUint(vars["id"], 10, 64)
 	if err != nil {
-		lw.Warning("Usr Update:", err)
+		lw.Warning("Usr Update: %v", err)
 		respondWithError(w, http.StatusBadRequest, "invalid request")
 		return
 	}
@@ -279,7 +416,7 @@ func (uc *UsrController) Update(w http.ResponseWriter, r *http.Request) {
 	decoder := json.NewDecoder(r.Body)
 	defer r.Body.Close()
 	if err := decoder.Decode(&u); err != nil {
-		lw.Warning("Usr Update:", err)
+		lw.Warning("Usr Update: %v", err)
 		respondWithError(w, http.StatusBadRequest, "invalid request payload")
 		return
 	}
@@ -299,11 +436,22 @@ func (uc *UsrController) Update(w http.ResponseWriter, r *http.Request) {
 
 	err = uc.us.Get(&staleUsr)
 	if err != nil {
-		lw.Warning("Usr Update:", err)
+		lw.Warning("Usr Update: %v", err)
 		respondWithError(w, http.StatusBadRequest, "invalid request")
 		return
 	}
 
+	// the group-memberships are only replaced if groups were provided
+	var groupIDs []uint64
+	if u.Groups != nil {
+		groupIDs, err = uc.usrGroupIDsByName(*u.Groups)
+		if err != nil {
+			lw.Warning("Usr Update: %v", err)
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
 	// fill the model
 	uTime := time.Now()
 	// utcTime := uTime.UTC()
@@ -315,7 +463,10 @@ func (uc *UsrController) Update(w http.ResponseWriter, r *http.Request) {
 		CreatedOn:    staleUsr.CreatedOn,
 		UpdatedOn:    &uTime,
 		Active:       u.Active,
-		Groups:       u.Groups,
+		// the idp link is maintained via LinkOIDC only
+		OIDCIssuer:      staleUsr.OIDCIssuer,
+		OIDCSubject:     staleUsr.OIDCSubject,
+		OIDCProvisioned: staleUsr.OIDCProvisioned,
 	}
 
 	// build a base urlString for the JSON Body self-referencing Href tag
@@ -328,6 +479,15 @@ func (uc *UsrController) Update(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	if groupIDs != nil {
+		err = uc.setUsrGroups(usr.ID, groupIDs)
+		if err != nil {
+			lw.ErrorWithPrefixString("User Update:", err)
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	usr.Groups = uc.usrGroupString(usr.ID)
 
 	// remove the password info from the response data
 	usr.PasswordHash = ""
@@ -367,6 +527,7 @@ func (uc *UsrController) Get(w http.ResponseWriter, r *http.Request) {
 	}
 	usr.PasswordHash = ""
 	usr.PasswordHash = ""
+	usr.Groups = uc.usrGroupString(usr.ID)
 	usr.Href = urlString
 	respondWithJSON(w, http.StatusCreated, usr)
 }
@@ -387,6 +548,14 @@ func (uc *UsrController) Delete(w http.ResponseWriter, r *http.Request) {
 		ID: id,
 	}
 
+	// the group-memberships refer to the usr and must be removed first
+	err = uc.ums.DeleteUsrGroupMembersByUsrID(id)
+	if err != nil {
+		lw.Error(err)
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	err = uc.us.Delete(&usr)
 	if err != nil {
 		if err != nil {
@@ -399,6 +568,7 @@ func (uc *UsrController) Delete(w http.ResponseWriter, r *http.Request) {
 
 	// disseminate the user deletion info to self and group-members if any.
 	uc.disseminateUsrChange(usr.ID, true, false)
+	disseminateUsrMemberChange(usr.ID, nil, true, uc.internalAddress)
 }
 
 // GetUsrs facilitates the retrieval of all existing Usrs.  This method is
@@ -412,9 +582,20 @@ func (uc *UsrController) GetUsrs(w http.ResponseWriter, r *http.Request) {
 	urlString = strings.TrimSuffix(urlString, "s/")
 	urlString = urlString + "/"
 
-	usrs := uc.us.GetUsrs()
+	usrs, err := uc.us.GetUsrs()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
 	if usrs != nil {
+		groups, err := uc.usrGroupNames()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
 		for i, u := range usrs {
+			g := groups[u.ID]
+			usrs[i].Groups = &g
 			usrs[i].Href = urlString + strconv.FormatUint(uint64(u.ID), 10)
 		}
 		respondWithJSON(w, http.StatusOK, usrs)
@@ -0,0 +1,45 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func TestSignHmac(t *testing.T) {
+
+	secret := []byte("0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef")
+
+	for _, alg := range []string{"HS256", "HS384", "HS512"} {
+		uc := &UsrController{
+			jwtKeyMap:     map[string]interface{}{"HMACKey": secret},
+			jwtSignMethod: alg,
+		}
+		tokenString, _, err := uc.signHmac(jwt.MapClaims{"uid": 1})
+		if err != nil {
+			t.Fatalf("%s: signHmac got: %v", alg, err)
+		}
+
+		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+			return secret, nil
+		})
+		if err != nil || !token.Valid {
+			t.Fatalf("%s: parse got: %v", alg, err)
+		}
+		if token.Method.Alg() != alg {
+			t.Errorf("got alg %s; want %s", token.Method.Alg(), alg)
+		}
+
+		_, err = jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+			return []byte("some-other-secret"), nil
+		})
+		if err == nil {
+			t.Errorf("%s: token verified with the wrong secret", alg)
+		}
+	}
+
+	uc := &UsrController{jwtKeyMap: map[string]interface{}{}, jwtSignMethod: "HS256"}
+	if _, _, err := uc.signHmac(jwt.MapClaims{}); err == nil {
+		t.Error("signHmac without a secret should fail")
+	}
+}
@@ -0,0 +1,154 @@
+package controllers
+
+//=============================================================================================
+// Usr self-service controller code
+//=============================================================================================
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/1414C/libraryapp/models"
+	"github.com/1414C/lw"
+)
+
+// usrMeUpdate is the request body of PUT /usr/me.  Only the name of the usr
+// may be changed; the remaining fields are decoded in order to reject them.
+type usrMeUpdate struct {
+	Name     string  `json:"name"`
+	Email    *string `json:"email"`
+	Password *string `json:"password"`
+	Active   *bool   `json:"active"`
+	Groups   *string `json:"groups"`
+}
+
+// usrMePassword is the request body of POST /usr/me/password
+type usrMePassword struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// GetMe returns the Usr that made the request.  The usr is resolved from the
+// uid claim of the access token, so no usr auth is required.
+//
+// GET /usr/me
+func (uc *UsrController) GetMe(w http.ResponseWriter, r *http.Request) {
+
+	usr, ok := uc.me(w, r)
+	if !ok {
+		return
+	}
+	usr.PasswordHash = ""
+	usr.Groups = uc.usrGroupString(usr.ID)
+	usr.Href = buildHrefBasic(r, true) + "usr/me"
+	respondWithJSON(w, http.StatusOK, usr)
+}
+
+// UpdateMe allows the usr that made the request to change their own name.
+// Group-memberships, the active flag and the email address cannot be changed
+// via this route.
+//
+// PUT /usr/me
+func (uc *UsrController) UpdateMe(w http.ResponseWriter, r *http.Request) {
+
+	var u usrMeUpdate
+	decoder := json.NewDecoder(r.Body)
+	defer r.Body.Close()
+	if err := decoder.Decode(&u); err != nil {
+		lw.Warning("Usr UpdateMe: %v", err)
+		respondWithError(w, http.StatusBadRequest, "invalid request payload")
+		return
+	}
+
+	if u.Groups != nil || u.Active != nil || u.Email != nil {
+		respondWithError(w, http.StatusForbidden, "groups, active and email cannot be changed via /usr/me")
+		return
+	}
+	if u.Password != nil {
+		respondWithError(w, http.StatusBadRequest, "use POST /usr/me/password to change the password")
+		return
+	}
+	u.Name = strings.TrimSpace(u.Name)
+	if u.Name == "" {
+		respondWithError(w, http.StatusBadRequest, "a name is required")
+		return
+	}
+
+	usr, ok := uc.me(w, r)
+	if !ok {
+		return
+	}
+
+	uTime := time.Now()
+	usr.Name = u.Name
+	usr.UpdatedOn = &uTime
+	err := uc.us.Update(&usr)
+	if err != nil {
+		lw.ErrorWithPrefixString("Usr UpdateMe:", err)
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	usr.PasswordHash = ""
+	usr.Groups = uc.usrGroupString(usr.ID)
+	usr.Href = buildHrefBasic(r, true) + "usr/me"
+	respondWithJSON(w, http.StatusOK, usr)
+}
+
+// ChangeMyPassword allows the usr that made the request to change their own
+// password.  The current password must be provided.
+//
+// POST /usr/me/password
+func (uc *UsrController) ChangeMyPassword(w http.ResponseWriter, r *http.Request) {
+
+	var p usrMePassword
+	decoder := json.NewDecoder(r.Body)
+	defer r.Body.Close()
+	if err := decoder.Decode(&p); err != nil {
+		lw.Warning("Usr ChangeMyPassword: %v", err)
+		respondWithError(w, http.StatusBadRequest, "invalid request payload")
+		return
+	}
+
+	id, ok := models.UsrIDFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	err := uc.us.ChangePassword(id, p.CurrentPassword, p.NewPassword)
+	switch err {
+	case nil:
+		respondWithHeader(w, http.StatusNoContent)
+	case models.ErrInvalidPassword, models.ErrUserIsNotActive:
+		respondWithError(w, http.StatusForbidden, err.Error())
+	case models.ErrPasswordTooShort:
+		respondWithError(w, http.StatusBadRequest, err.Error())
+	default:
+		lw.ErrorWithPrefixString("Usr ChangeMyPassword:", err)
+		respondWithError(w, http.StatusInternalServerError, "unable to change the password")
+	}
+}
+
+// me reads the Usr that made the request.  An error response is written if
+// the usr cannot be read.
+func (uc *UsrController) me(w http.ResponseWriter, r *http.Request) (models.Usr, bool) {
+
+	id, ok := models.UsrIDFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return models.Usr{}, false
+	}
+
+	usr := models.Usr{
+		ID: id,
+	}
+	err := uc.us.Get(&usr)
+	if err != nil {
+		lw.Warning("Usr me: %v", err)
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return models.Usr{}, false
+	}
+	return usr, true
+}
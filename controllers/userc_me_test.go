@@ -0,0 +1,74 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/1414C/libraryapp/models"
+)
+
+func TestUpdateMe(t *testing.T) {
+
+	us := &stubUsrService{usrs: map[uint64]models.Usr{5: {ID: 5, Name: "Old", Email: "me@example.com", PasswordHash: "hash", Active: true}}}
+	ums := &stubUsrGroupMemberService{groups: map[uint64][]uint64{5: {2}}}
+	ugs := stubUsrGroupService{groups: []models.UsrGroup{{ID: 1, GroupName: "Admin"}, {ID: 2, GroupName: "Staff"}}}
+	uc := NewUsrController(us, ugs, ums, nil, "HS256", 60, "127.0.0.1:1")
+
+	updateMe := func(uid uint64, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("PUT", "/usr/me", strings.NewReader(body))
+		if uid != 0 {
+			r = r.WithContext(models.ContextWithUsrID(r.Context(), uid))
+		}
+		w := httptest.NewRecorder()
+		uc.UpdateMe(w, r)
+		return w
+	}
+
+	tests := []struct {
+		body string
+		want int
+	}{
+		{`{"name":"New","groups":"Admin"}`, http.StatusForbidden},
+		{`{"name":"New","groups":""}`, http.StatusForbidden},
+		{`{"name":"New","active":false}`, http.StatusForbidden},
+		{`{"name":"New","active":true}`, http.StatusForbidden},
+		{`{"name":"New","email":"admin"}`, http.StatusForbidden},
+		{`{"name":"New","password":"password1"}`, http.StatusBadRequest},
+		{`{"name":"  "}`, http.StatusBadRequest},
+		{`{"name":`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if w := updateMe(5, tt.body); w.Code != tt.want {
+			t.Errorf("%s: got status %d; want %d", tt.body, w.Code, tt.want)
+		}
+	}
+	if u := us.usrs[5]; u.Name != "Old" || !u.Active || u.Email != "me@example.com" {
+		t.Fatalf("got %+v following rejected requests", u)
+	}
+	if g := ums.groups[5]; len(g) != 1 || g[0] != 2 {
+		t.Fatalf("got groups %v following rejected requests", g)
+	}
+
+	// the name may be changed; the remaining fields are retained
+	w := updateMe(5, `{"name":" New "}`)
+	var got models.Usr
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("got status %d, %v", w.Code, err)
+	}
+	if got.Name != "New" || got.PasswordHash != "" || got.Groups == nil || *got.Groups != "Staff" {
+		t.Errorf("got %+v", got)
+	}
+	if u := us.usrs[5]; u.Name != "New" || !u.Active || u.Email != "me@example.com" || u.PasswordHash != "hash" {
+		t.Errorf("got stored %+v", u)
+	}
+
+	if w := updateMe(0, `{"name":"New"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("got status %d; want %d", w.Code, http.StatusUnauthorized)
+	}
+	if w := updateMe(9, `{"name":"New"}`); w.Code != http.StatusNotFound {
+		t.Errorf("got status %d; want %d", w.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,718 @@
+package controllers
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/1414C/libraryapp/models"
+	"github.com/1414C/lw"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+)
+
+// OIDCConfig holds the configuration values used to run the application as an
+// OpenID Connect relying-party.  Usrs authenticate with the external identity
+// provider via the authorization-code flow with PKCE, and the IdP group claims
+// are mapped onto UsrGroup names.  The application continues to mint its own
+// JWT once the IdP login has completed.  When DisableLocalLogin is set, only
+// the optional BreakGlassEmail account may still login with a password; no
+// break-glass account exists unless one is configured.
+type OIDCConfig struct {
+	Active            bool              `json:"active"`
+	Issuer            string            `json:"issuer"`
+	ClientID          string            `json:"client_id"`
+	ClientSecret      string            `json:"client_secret"`
+	RedirectURL       string            `json:"redirect_url"`
+	Scopes            []string          `json:"scopes"`
+	GroupsClaim       string            `json:"groups_claim"`
+	GroupMap          map[string]string `json:"group_map"`
+	DisableLocalLogin bool              `json:"disable_local_login"`
+	BreakGlassEmail   string            `json:"break_glass_email"`
+}
+
+// oidcLoginTTL is the maximum time permitted between the redirect to the
+// IdP and the arrival of the callback.
+const oidcLoginTTL = 10 * time.Minute
+
+// oidcDiscovery holds the subset of the IdP's discovery document that
+// is used by the relying-party.
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcJWK is a single key from the IdP's jwks_uri document.
+type oidcJWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// oidcPendingLogin holds the PKCE verifier and nonce of a login that
+// has been redirected to the IdP, keyed by the state parameter.
+type oidcPendingLogin struct {
+	verifier string
+	nonce    string
+	created  time.Time
+}
+
+// oidcRP is the OpenID Connect relying-party used by the UsrController.
+type oidcRP struct {
+	sync.Mutex
+	cfg       OIDCConfig
+	client    *http.Client
+	discovery *oidcDiscovery
+	keys      map[string]interface{} // map[kid]*rsa.PublicKey | *ecdsa.PublicKey
+	pending   map[string]oidcPendingLogin
+}
+
+// newOIDCRP creates a new relying-party from the supplied configuration.
+// The IdP discovery document is read on first use.
+func newOIDCRP(cfg OIDCConfig) (*oidcRP, error) {
+
+	if cfg.Issuer == "" || cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return nil, fmt.Errorf("oidc: issuer, client_id and redirect_url must be configured")
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile", "groups"}
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = "groups"
+	}
+	cfg.Issuer = strings.TrimSuffix(cfg.Issuer, "/")
+
+	return &oidcRP{
+		cfg:     cfg,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		keys:    make(map[string]interface{}),
+		pending: make(map[string]oidcPendingLogin),
+	}, nil
+}
+
+// discover reads and caches the IdP's discovery document.
+func (rp *oidcRP) discover() (*oidcDiscovery, error) {
+
+	rp.Lock()
+	d := rp.discovery
+	rp.Unlock()
+	if d != nil {
+		return d, nil
+	}
+
+	d = &oidcDiscovery{}
+	err := rp.getJSON(rp.cfg.Issuer+"/.well-known/openid-configuration", d)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(d.Issuer, "/") != rp.cfg.Issuer {
+		return nil, fmt.Errorf("oidc: discovery issuer %s does not match configured issuer %s", d.Issuer, rp.cfg.Issuer)
+	}
+
+	rp.Lock()
+	rp.discovery = d
+	rp.Unlock()
+	return d, nil
+}
+
+// authCodeURL creates a new pending login and returns the IdP authorization
+// URL to which the usr-agent should be redirected.
+func (rp *oidcRP) authCodeURL() (string, error) {
+
+	d, err := rp.discover()
+	if err != nil {
+		return "", err
+	}
+
+	state, err := randomURLString(24)
+	if err != nil {
+		return "", err
+	}
+	nonce, err := randomURLString(24)
+	if err != nil {
+		return "", err
+	}
+	verifier, err := randomURLString(48)
+	if err != nil {
+		return "", err
+	}
+
+	rp.Lock()
+	now := time.Now()
+	for k, v := range rp.pending {
+		if now.Sub(v.created) > oidcLoginTTL {
+			delete(rp.pending, k)
+		}
+	}
+	rp.pending[state] = oidcPendingLogin{verifier: verifier, nonce: nonce, created: now}
+	rp.Unlock()
+
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", rp.cfg.ClientID)
+	q.Set("redirect_uri", rp.cfg.RedirectURL)
+	q.Set("scope", strings.Join(rp.cfg.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", pkceChallenge(verifier))
+	q.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return d.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+// exchange redeems the authorization code at the IdP token endpoint and
+// returns the verified claims of the ID token.
+func (rp *oidcRP) exchange(code, state string) (jwt.MapClaims, error) {
+
+	rp.Lock()
+	pl, ok := rp.pending[state]
+	delete(rp.pending, state)
+	rp.Unlock()
+	if !ok || time.Since(pl.created) > oidcLoginTTL {
+		return nil, fmt.Errorf("oidc: unknown or expired login state")
+	}
+
+	d, err := rp.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", rp.cfg.RedirectURL)
+	form.Set("client_id", rp.cfg.ClientID)
+	form.Set("code_verifier", pl.verifier)
+
+	req, err := http.NewRequest("POST", d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if rp.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(rp.cfg.ClientID), url.QueryEscape(rp.cfg.ClientSecret))
+	}
+
+	resp, err := rp.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc: token endpoint returned status %d", resp.StatusCode)
+	}
+
+	var tr struct {
+		IDToken string `json:"id_token"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&tr)
+	if err != nil {
+		return nil, err
+	}
+	if tr.IDToken == "" {
+		return nil, fmt.Errorf("oidc: token response did not contain an id_token")
+	}
+	return rp.verifyIDToken(tr.IDToken, pl.nonce)
+}
+
+// verifyIDToken checks the signature of the ID token against the IdP's
+// published keys, then verifies the iss, aud, exp and nonce claims.
+func (rp *oidcRP) verifyIDToken(idToken, nonce string) (jwt.MapClaims, error) {
+
+	claims := jwt.MapClaims{}
+	token, err := jwt.ParseWithClaims(idToken, claims, func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA:
+		default:
+			return nil, fmt.Errorf("oidc: unexpected id_token signing method %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		return rp.key(kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, fmt.Errorf("oidc: id_token is not valid")
+	}
+
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != rp.cfg.Issuer {
+		return nil, fmt.Errorf("oidc: id_token issuer %s is not trusted", iss)
+	}
+	if !claimsHaveAudience(claims, rp.cfg.ClientID) {
+		return nil, fmt.Errorf("oidc: id_token was not issued for client %s", rp.cfg.ClientID)
+	}
+	if _, ok := claims["exp"]; !ok {
+		return nil, fmt.Errorf("oidc: id_token has no expiry")
+	}
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return nil, fmt.Errorf("oidc: id_token nonce mismatch")
+	}
+	return claims, nil
+}
+
+// key returns the IdP verification key for the specified kid.  The jwks_uri
+// document is re-read when the kid is not known, to accommodate IdP key
+// rotation.
+func (rp *oidcRP) key(kid string) (interface{}, error) {
+
+	rp.Lock()
+	k, ok := rp.keys[kid]
+	rp.Unlock()
+	if ok {
+		return k, nil
+	}
+
+	d, err := rp.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	var set struct {
+		Keys []oidcJWK `json:"keys"`
+	}
+	err = rp.getJSON(d.JWKSURI, &set)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]interface{})
+	for _, jwk := range set.Keys {
+		pk, err := jwk.publicKey()
+		if err != nil {
+			lw.Warning("oidc: skipping jwk %s: %s", jwk.Kid, err.Error())
+			continue
+		}
+		keys[jwk.Kid] = pk
+	}
+
+	rp.Lock()
+	rp.keys = keys
+	rp.Unlock()
+
+	k, ok = keys[kid]
+	if !ok {
+		// a single unnamed key is acceptable
+		if kid == "" && len(keys) == 1 {
+			for _, v := range keys {
+				return v, nil
+			}
+		}
+		return nil, fmt.Errorf("oidc: no IdP key found for kid '%s'", kid)
+	}
+	return k, nil
+}
+
+// getJSON reads the JSON document at the specified URL into v.
+func (rp *oidcRP) getJSON(u string, v interface{}) error {
+
+	resp, err := rp.client.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: GET %s returned status %d", u, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// mapGroups maps the IdP group claim values onto UsrGroup names.  When a
+// group_map has been configured, only mapped IdP groups are considered.
+// Otherwise IdP groups are matched directly against the known UsrGroup names.
+func (rp *oidcRP) mapGroups(claims jwt.MapClaims, knownGroups map[string]bool) []string {
+
+	var idpGroups []string
+	switch g := claims[rp.cfg.GroupsClaim].(type) {
+	case string:
+		idpGroups = strings.FieldsFunc(g, func(r rune) bool { return r == ',' || r == ';' || r == ' ' })
+	case []interface{}:
+		for _, v := range g {
+			if s, ok := v.(string); ok {
+				idpGroups = append(idpGroups, s)
+			}
+		}
+	}
+
+	seen := make(map[string]bool)
+	var groups []string
+	for _, ig := range idpGroups {
+		name := ig
+		if len(rp.cfg.GroupMap) > 0 {
+			name = rp.cfg.GroupMap[ig]
+		}
+		if name == "" || seen[name] || !knownGroups[name] {
+			continue
+		}
+		seen[name] = true
+		groups = append(groups, name)
+	}
+	return groups
+}
+
+// publicKey converts the jwk to an *rsa.PublicKey or *ecdsa.PublicKey.
+func (jwk oidcJWK) publicKey() (interface{}, error) {
+
+	switch jwk.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", jwk.Kty)
+	}
+}
+
+// claimsHaveAudience checks a string or array aud claim for the client-id.
+func claimsHaveAudience(claims jwt.MapClaims, clientID string) bool {
+
+	switch aud := claims["aud"].(type) {
+	case string:
+		return aud == clientID
+	case []interface{}:
+		for _, a := range aud {
+			if s, ok := a.(string); ok && s == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// pkceChallenge returns the S256 code_challenge for the code_verifier.
+func pkceChallenge(verifier string) string {
+	h := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(h[:])
+}
+
+// randomURLString returns a URL-safe random string built from n random bytes.
+func randomURLString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// isBreakGlass reports whether email names the configured break-glass
+// account that may login locally while local logins are disabled.
+func (rp *oidcRP) isBreakGlass(email string) bool {
+	bg := strings.ToLower(strings.TrimSpace(rp.cfg.BreakGlassEmail))
+	return bg != "" && strings.ToLower(strings.TrimSpace(email)) == bg
+}
+
+// InitOIDC activates the OpenID Connect relying-party mode of the UsrController.
+func (uc *UsrController) InitOIDC(cfg OIDCConfig) error {
+
+	rp, err := newOIDCRP(cfg)
+	if err != nil {
+		return err
+	}
+	uc.oidc = rp
+	lw.Console("OIDC relying-party mode enabled for issuer %s", rp.cfg.Issuer)
+	return nil
+}
+
+// LoginOIDC redirects the usr-agent to the IdP in order to start an
+// authorization-code login with PKCE.
+//
+// GET /usr/login/oidc
+func (uc *UsrController) LoginOIDC(w http.ResponseWriter, r *http.Request) {
+
+	if uc.oidc == nil {
+		respondWithError(w, http.StatusNotFound, "oidc login is not enabled")
+		return
+	}
+
+	u, err := uc.oidc.authCodeURL()
+	if err != nil {
+		lw.ErrorWithPrefixString("UsrController.LoginOIDC():", err)
+		respondWithError(w, http.StatusBadGateway, "identity provider is not available")
+		return
+	}
+	http.Redirect(w, r, u, http.StatusFound)
+}
+
+// LoginOIDCCallback receives the authorization code from the IdP, verifies the
+// ID token, provisions or updates the Usr and then responds with an application
+// JWT in the same format as Login.
+//
+// GET /usr/login/oidc/callback
+func (uc *UsrController) LoginOIDCCallback(w http.ResponseWriter, r *http.Request) {
+
+	if uc.oidc == nil {
+		respondWithError(w, http.StatusNotFound, "oidc login is not enabled")
+		return
+	}
+
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		lw.Warning("oidc login rejected by IdP: %s %s", e, q.Get("error_description"))
+		respondWithError(w, http.StatusUnauthorized, "authentication failure")
+		return
+	}
+
+	claims, err := uc.oidc.exchange(q.Get("code"), q.Get("state"))
+	if err != nil {
+		lw.ErrorWithPrefixString("UsrController.LoginOIDCCallback():", err)
+		respondWithError(w, http.StatusUnauthorized, "authentication failure")
+		return
+	}
+
+	usr, err := uc.provisionOIDCUsr(claims)
+	if err != nil {
+		lw.ErrorWithPrefixString("UsrController.LoginOIDCCallback():", err)
+		respondWithError(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	tokenString, httpStatus, err := uc.issueToken(usr, r)
+	if err != nil {
+		lw.Warning("Authentication failure for: %v", usr.Email)
+		respondWithError(w, httpStatus, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, Token{tokenString})
+
+	// update the local process's Usr cache and then forward the information
+	// to all other group-members presently in a non-failed state.
+	uc.disseminateUsrChange(usr.ID, true, usr.Active)
+}
+
+// LinkOIDC links an existing local Usr to the subject of an identity at the
+// configured IdP, allowing the Usr to login via /usr/login/oidc.  An empty
+// subject removes the link.  The Usr's group-memberships remain under local
+// control.
+//
+// PUT /usr/:id/oidc
+func (uc *UsrController) LinkOIDC(w http.ResponseWriter, r *http.Request) {
+
+	if uc.oidc == nil {
+		respondWithError(w, http.StatusNotFound, "oidc login is not enabled")
+		return
+	}
+
+	vars := mux.Vars(r)
+	id, err := strconv.ParseUint(vars["id"], 10, 64)
+	if err != nil {
+		lw.Warning("Usr LinkOIDC: %v", err)
+		respondWithError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+
+	var link struct {
+		Subject string `json:"subject"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	defer r.Body.Close()
+	if err := decoder.Decode(&link); err != nil {
+		lw.Warning("Usr LinkOIDC: %v", err)
+		respondWithError(w, http.StatusBadRequest, "invalid request payload")
+		return
+	}
+	link.Subject = strings.TrimSpace(link.Subject)
+
+	usr := models.Usr{
+		ID: id,
+	}
+	err = uc.us.Get(&usr)
+	if err != nil {
+		lw.Warning("Usr LinkOIDC: %v", err)
+		respondWithError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+
+	if link.Subject != "" {
+		linked, err := uc.us.ByOIDCSubject(uc.oidc.cfg.Issuer, link.Subject)
+		if err != nil && err != models.ErrNotFound {
+			lw.ErrorWithPrefixString("Usr LinkOIDC:", err)
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if linked != nil && linked.ID != id {
+			respondWithError(w, http.StatusConflict, fmt.Sprintf("idp subject is already linked to usr %d", linked.ID))
+			return
+		}
+		usr.OIDCIssuer = uc.oidc.cfg.Issuer
+	} else {
+		usr.OIDCIssuer = ""
+	}
+	usr.OIDCSubject = link.Subject
+	usr.OIDCProvisioned = false
+
+	uTime := time.Now()
+	usr.UpdatedOn = &uTime
+	err = uc.us.Update(&usr)
+	if err != nil {
+		lw.ErrorWithPrefixString("Usr LinkOIDC:", err)
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	lw.Info("oidc: usr %d linked to subject %q", usr.ID, usr.OIDCSubject)
+
+	usr.PasswordHash = ""
+	usr.Groups = uc.usrGroupString(usr.ID)
+	usr.Href = buildHrefStringFromCRUDReq(r, false)
+	respondWithJSON(w, http.StatusOK, usr)
+}
+
+// provisionOIDCUsr reads the Usr linked to the iss/sub identity of the ID token,
+// creating the Usr on first login.  Identities are never linked to an existing
+// Usr by email address; local Usrs must be linked explicitly via LinkOIDC.  The
+// group-memberships of Usrs provisioned by the IdP are replaced with the mapped
+// IdP groups on every login, while those of linked local Usrs are left as-is.
+func (uc *UsrController) provisionOIDCUsr(claims jwt.MapClaims) (*models.Usr, error) {
+
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return nil, fmt.Errorf("id_token does not contain a sub claim")
+	}
+	email, _ := claims["email"].(string)
+	if email == "" {
+		return nil, fmt.Errorf("id_token does not contain an email claim")
+	}
+	if v, _ := claims["email_verified"].(bool); !v {
+		return nil, fmt.Errorf("idp email address %s has not been verified", email)
+	}
+
+	usr, err := uc.us.ByOIDCSubject(uc.oidc.cfg.Issuer, subject)
+	if err != nil && err != models.ErrNotFound {
+		return nil, err
+	}
+	if usr != nil && !usr.Active {
+		return nil, models.ErrUserIsNotActive
+	}
+	if usr != nil && !usr.OIDCProvisioned {
+		return usr, nil
+	}
+
+	usrGroups, err := uc.ugs.GetUsrGroups()
+	if err != nil {
+		return nil, err
+	}
+	knownGroups := make(map[string]bool)
+	groupIDs := make(map[string]uint64)
+	for _, g := range usrGroups {
+		knownGroups[g.GroupName] = true
+		groupIDs[g.GroupName] = g.ID
+	}
+	groups := uc.oidc.mapGroups(claims, knownGroups)
+	if len(groups) == 0 {
+		return nil, fmt.Errorf("no UsrGroup mapping exists for the idp groups of %s", email)
+	}
+	strGroups := strings.Join(groups, ";")
+	var ids []uint64
+	for _, g := range groups {
+		ids = append(ids, groupIDs[g])
+	}
+
+	if usr == nil {
+
+		// first login - refuse to take over a local Usr with the same email
+		if existing, err := uc.us.ByEmail(email); err == nil && existing != nil {
+			return nil, fmt.Errorf("usr %s exists and has not been linked to the idp", email)
+		}
+
+		name, _ := claims["name"].(string)
+		if name == "" {
+			name = email
+		}
+
+		// create the Usr with an unusable random password
+		pw := make([]byte, 32)
+		if _, err := rand.Read(pw); err != nil {
+			return nil, err
+		}
+		usr = &models.Usr{
+			Name:            name,
+			Email:           email,
+			Password:        hex.EncodeToString(pw),
+			Active:          true,
+			Groups:          &strGroups,
+			OIDCIssuer:      uc.oidc.cfg.Issuer,
+			OIDCSubject:     subject,
+			OIDCProvisioned: true,
+		}
+		err = uc.us.Create(usr)
+		if err != nil {
+			return nil, err
+		}
+		err = uc.setUsrGroups(usr.ID, ids)
+		if err != nil {
+			return nil, err
+		}
+		lw.Info("oidc: provisioned usr %d for %s", usr.ID, email)
+		return usr, nil
+	}
+
+	sorted := append([]string(nil), groups...)
+	sort.Strings(sorted)
+	if *uc.usrGroupString(usr.ID) != strings.Join(sorted, ";") {
+		err = uc.setUsrGroups(usr.ID, ids)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return usr, nil
+}
@@ -0,0 +1,378 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/1414C/libraryapp/models"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+)
+
+// mockIdP is a minimal in-process OpenID Connect provider supporting
+// discovery, jwks and the authorization-code token exchange with PKCE.
+type mockIdP struct {
+	sync.Mutex
+	srv       *httptest.Server
+	key       *rsa.PrivateKey
+	challenge string
+	nonce     string
+	badNonce  bool
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey got: %v", err)
+	}
+	m := &mockIdP{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcDiscovery{
+			Issuer:                m.srv.URL,
+			AuthorizationEndpoint: m.srv.URL + "/authorize",
+			TokenEndpoint:         m.srv.URL + "/token",
+			JWKSURI:               m.srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string][]oidcJWK{
+			"keys": {{
+				Kty: "RSA",
+				Kid: "k1",
+				N:   base64.RawURLEncoding.EncodeToString(m.key.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(m.key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		m.Lock()
+		defer m.Unlock()
+		if r.Form.Get("grant_type") != "authorization_code" || pkceChallenge(r.Form.Get("code_verifier")) != m.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		nonce := m.nonce
+		if m.badNonce {
+			nonce = "not-the-nonce"
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss":            m.srv.URL,
+			"aud":            []string{"libraryapp"},
+			"sub":            "1234",
+			"email":          "patron@example.com",
+			"email_verified": true,
+			"name":           "A Patron",
+			"nonce":          nonce,
+			"groups":         []string{"idp-staff", "idp-unmapped"},
+			"exp":            time.Now().Add(time.Minute).Unix(),
+		})
+		token.Header["kid"] = "k1"
+		s, err := token.SignedString(m.key)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": s})
+	})
+	m.srv = httptest.NewServer(mux)
+	return m
+}
+
+// authorize simulates the usr-agent visiting the IdP authorization endpoint
+// and returns the state that would be sent to the callback.
+func (m *mockIdP) authorize(t *testing.T, rp *oidcRP) string {
+
+	u, err := rp.authCodeURL()
+	if err != nil {
+		t.Fatalf("authCodeURL got: %v", err)
+	}
+	pu, err := url.Parse(u)
+	if err != nil {
+		t.Fatalf("url.Parse got: %v", err)
+	}
+	q := pu.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		t.Fatalf("authorization request is missing the PKCE challenge: %s", u)
+	}
+	m.Lock()
+	m.challenge = q.Get("code_challenge")
+	m.nonce = q.Get("nonce")
+	m.Unlock()
+	return q.Get("state")
+}
+
+func newTestRP(t *testing.T, issuer string) *oidcRP {
+
+	rp, err := newOIDCRP(OIDCConfig{
+		Active:      true,
+		Issuer:      issuer,
+		ClientID:    "libraryapp",
+		RedirectURL: "http://127.0.0.1:3000/usr/login/oidc/callback",
+		GroupMap:    map[string]string{"idp-staff": "Staff"},
+	})
+	if err != nil {
+		t.Fatalf("newOIDCRP got: %v", err)
+	}
+	return rp
+}
+
+func TestOIDCLogin(t *testing.T) {
+
+	idp := newMockIdP(t)
+	defer idp.srv.Close()
+	rp := newTestRP(t, idp.srv.URL)
+
+	state := idp.authorize(t, rp)
+	claims, err := rp.exchange("code", state)
+	if err != nil {
+		t.Fatalf("exchange got: %v", err)
+	}
+	if claims["email"] != "patron@example.com" {
+		t.Errorf("expected email claim patron@example.com, got %v", claims["email"])
+	}
+
+	groups := rp.mapGroups(claims, map[string]bool{"Staff": true, "Super": true})
+	if len(groups) != 1 || groups[0] != "Staff" {
+		t.Errorf("expected mapped groups [Staff], got %v", groups)
+	}
+
+	// the state may only be redeemed once
+	_, err = rp.exchange("code", state)
+	if err == nil {
+		t.Errorf("expected replayed state to be rejected")
+	}
+}
+
+func TestOIDCLoginRejectsNonceMismatch(t *testing.T) {
+
+	idp := newMockIdP(t)
+	defer idp.srv.Close()
+	idp.badNonce = true
+	rp := newTestRP(t, idp.srv.URL)
+
+	state := idp.authorize(t, rp)
+	_, err := rp.exchange("code", state)
+	if err == nil {
+		t.Errorf("expected id_token with a mismatched nonce to be rejected")
+	}
+}
+
+func TestOIDCLoginRejectsWrongVerifier(t *testing.T) {
+
+	idp := newMockIdP(t)
+	defer idp.srv.Close()
+	rp := newTestRP(t, idp.srv.URL)
+
+	state := idp.authorize(t, rp)
+	idp.challenge = pkceChallenge("some-other-verifier")
+	_, err := rp.exchange("code", state)
+	if err == nil {
+		t.Errorf("expected token exchange with the wrong code_verifier to fail")
+	}
+}
+
+// stub methods used by the oidc provisioning; the usrs are keyed by ID
+func (s *stubUsrService) ByEmail(email string) (*models.Usr, error) {
+	for _, u := range s.usrs {
+		if u.Email == email {
+			return &u, nil
+		}
+	}
+	return nil, models.ErrNotFound
+}
+
+func (s *stubUsrService) ByOIDCSubject(issuer, subject string) (*models.Usr, error) {
+	for _, u := range s.usrs {
+		if u.OIDCIssuer == issuer && u.OIDCSubject == subject {
+			return &u, nil
+		}
+	}
+	return nil, models.ErrNotFound
+}
+
+func (s *stubUsrService) Create(usr *models.Usr) error {
+	usr.ID = uint64(len(s.usrs) + 1)
+	s.usrs[usr.ID] = *usr
+	return nil
+}
+
+func (s *stubUsrService) Update(usr *models.Usr) error {
+	s.usrs[usr.ID] = *usr
+	return nil
+}
+
+func (s stubUsrGroupService) Get(ug *models.UsrGroup) error {
+	for _, g := range s.groups {
+		if g.ID == ug.ID {
+			*ug = g
+			return nil
+		}
+	}
+	return models.ErrNotFound
+}
+
+func newTestOIDCUsrController(t *testing.T, usrs map[uint64]models.Usr, groups map[uint64][]uint64) (*UsrController, *stubUsrService, *stubUsrGroupMemberService) {
+
+	us := &stubUsrService{usrs: usrs}
+	ums := &stubUsrGroupMemberService{groups: groups}
+	ugs := stubUsrGroupService{groups: []models.UsrGroup{{ID: 1, GroupName: "Admin"}, {ID: 2, GroupName: "Staff"}}}
+	uc := NewUsrController(us, ugs, ums, nil, "HS256", 60, "127.0.0.1:1")
+	uc.oidc = newTestRP(t, "https://idp.example.com")
+	return uc, us, ums
+}
+
+func TestOIDCProvisionUsr(t *testing.T) {
+
+	claims := func(sub, email string, verified interface{}) jwt.MapClaims {
+		c := jwt.MapClaims{"sub": sub, "email": email, "groups": []interface{}{"idp-staff"}}
+		if verified != nil {
+			c["email_verified"] = verified
+		}
+		return c
+	}
+	uc, us, ums := newTestOIDCUsrController(t,
+		map[uint64]models.Usr{
+			1: {ID: 1, Email: "admin", Active: true},
+			2: {ID: 2, Email: "local@example.com", Active: true},
+		},
+		map[uint64][]uint64{1: {1}, 2: {1}},
+	)
+
+	// the email address must be verified by the idp
+	for _, v := range []interface{}{nil, false, "true"} {
+		if _, err := uc.provisionOIDCUsr(claims("9", "new@example.com", v)); err == nil {
+			t.Errorf("email_verified %v: expected the login to be rejected", v)
+		}
+	}
+
+	// identities are not linked to existing usrs by email
+	for _, email := range []string{"admin", "local@example.com"} {
+		if _, err := uc.provisionOIDCUsr(claims("9", email, true)); err == nil {
+			t.Errorf("%s: expected the login to be rejected", email)
+		}
+	}
+	if len(us.usrs) != 2 || len(ums.groups[1]) != 1 || ums.groups[1][0] != 1 {
+		t.Fatalf("existing usrs were modified: %+v %+v", us.usrs, ums.groups)
+	}
+
+	// first login provisions a usr linked to iss/sub with the mapped groups
+	usr, err := uc.provisionOIDCUsr(claims("9", "new@example.com", true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usr.OIDCIssuer != "https://idp.example.com" || usr.OIDCSubject != "9" || !usr.OIDCProvisioned {
+		t.Errorf("got %+v", usr)
+	}
+	if g := ums.groups[usr.ID]; len(g) != 1 || g[0] != 2 {
+		t.Errorf("got groups %v; want [2]", g)
+	}
+
+	// the groups of provisioned usrs follow the idp; the login is by sub
+	ums.groups[usr.ID] = []uint64{1}
+	again, err := uc.provisionOIDCUsr(claims("9", "renamed@example.com", true))
+	if err != nil || again.ID != usr.ID {
+		t.Fatalf("got %+v, %v", again, err)
+	}
+	if g := ums.groups[usr.ID]; len(g) != 1 || g[0] != 2 {
+		t.Errorf("got groups %v; want [2]", g)
+	}
+
+	// the groups of explicitly linked local usrs are left alone
+	local := us.usrs[2]
+	local.OIDCIssuer, local.OIDCSubject = "https://idp.example.com", "local-sub"
+	us.usrs[2] = local
+	linked, err := uc.provisionOIDCUsr(claims("local-sub", "local@example.com", true))
+	if err != nil || linked.ID != 2 {
+		t.Fatalf("got %+v, %v", linked, err)
+	}
+	if g := ums.groups[2]; len(g) != 1 || g[0] != 1 {
+		t.Errorf("got groups %v; want [1]", g)
+	}
+
+	// inactive usrs cannot login
+	local.Active = false
+	us.usrs[2] = local
+	if _, err := uc.provisionOIDCUsr(claims("local-sub", "local@example.com", true)); err != models.ErrUserIsNotActive {
+		t.Errorf("got %v; want %v", err, models.ErrUserIsNotActive)
+	}
+}
+
+func TestOIDCLinkUsr(t *testing.T) {
+
+	uc, us, _ := newTestOIDCUsrController(t,
+		map[uint64]models.Usr{
+			1: {ID: 1, Email: "admin", Active: true},
+			2: {ID: 2, Email: "local@example.com", Active: true},
+		},
+		map[uint64][]uint64{},
+	)
+	link := func(id, body string) int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("PUT", "/usr/"+id+"/oidc", strings.NewReader(body))
+		uc.LinkOIDC(w, mux.SetURLVars(r, map[string]string{"id": id}))
+		return w.Code
+	}
+
+	if c := link("2", `{"subject":"abc"}`); c != http.StatusOK {
+		t.Fatalf("got status %d", c)
+	}
+	if u := us.usrs[2]; u.OIDCIssuer != "https://idp.example.com" || u.OIDCSubject != "abc" || u.OIDCProvisioned {
+		t.Errorf("got %+v", u)
+	}
+
+	// a subject can only be linked to one usr
+	if c := link("1", `{"subject":"abc"}`); c != http.StatusConflict {
+		t.Errorf("got status %d; want %d", c, http.StatusConflict)
+	}
+	if c := link("3", `{"subject":"def"}`); c != http.StatusBadRequest {
+		t.Errorf("got status %d; want %d", c, http.StatusBadRequest)
+	}
+
+	// an empty subject removes the link
+	if c := link("2", `{"subject":""}`); c != http.StatusOK {
+		t.Fatalf("got status %d", c)
+	}
+	if u := us.usrs[2]; u.OIDCIssuer != "" || u.OIDCSubject != "" {
+		t.Errorf("got %+v", u)
+	}
+}
+
+func TestLoginBreakGlass(t *testing.T) {
+
+	uc, _, _ := newTestOIDCUsrController(t, map[uint64]models.Usr{}, map[uint64][]uint64{})
+	uc.oidc.cfg.DisableLocalLogin = true
+	login := func(email string) int {
+		w := httptest.NewRecorder()
+		uc.Login(w, httptest.NewRequest("POST", "/usr/login", strings.NewReader(`{"email":"`+email+`","password":"x"}`)))
+		return w.Code
+	}
+
+	// no account may login locally unless a break-glass account is configured
+	for _, email := range []string{"admin", "ADMIN", "someone@example.com"} {
+		if c := login(email); c != http.StatusForbidden {
+			t.Errorf("%s: got status %d; want %d", email, c, http.StatusForbidden)
+		}
+	}
+
+	uc.oidc.cfg.BreakGlassEmail = "recovery@example.com"
+	if c := login("admin"); c != http.StatusForbidden {
+		t.Errorf("admin: got status %d; want %d", c, http.StatusForbidden)
+	}
+	if !uc.oidc.isBreakGlass(" Recovery@example.com ") {
+		t.Errorf("expected the configured break-glass account to be recognized")
+	}
+}
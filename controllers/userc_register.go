@@ -0,0 +1,158 @@
+package controllers
+
+//=============================================================================================
+// Usr self-registration controller code
+//=============================================================================================
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/1414C/libraryapp/models"
+	"github.com/1414C/lw"
+)
+
+// Registration modes supported by POST /usr/register
+const (
+	RegistrationDisabled = "disabled" // no self-registration; usrs are created by an administrator
+	RegistrationOpen     = "open"     // anyone may register and receives the default groups
+	RegistrationInvite   = "invite"   // registration requires a UsrInvite token
+)
+
+// RegistrationConfig holds the configuration values governing the
+// self-registration of new Usrs.  DefaultGroups is a semicolon-separated
+// list of UsrGroup names assigned to newly registered usrs; it is used in
+// invite mode when the invite does not carry groups of its own.
+// InviteLifetime is the default validity of a UsrInvite in hours.
+type RegistrationConfig struct {
+	Mode           string `json:"mode"`
+	DefaultGroups  string `json:"default_groups"`
+	InviteLifetime uint   `json:"invite_lifetime"`
+}
+
+// usrRegistration is the request body of POST /usr/register
+type usrRegistration struct {
+	Name        string `json:"name"`
+	Email       string `json:"email"`
+	Password    string `json:"password"`
+	InviteToken string `json:"invite_token"`
+}
+
+// InitRegistration sets the self-registration mode of the UsrController.  An
+// empty mode disables self-registration.
+func (uc *UsrController) InitRegistration(cfg RegistrationConfig, uis models.UsrInviteService) error {
+
+	cfg.Mode = strings.ToLower(strings.TrimSpace(cfg.Mode))
+	switch cfg.Mode {
+	case "":
+		cfg.Mode = RegistrationDisabled
+	case RegistrationDisabled, RegistrationInvite:
+	case RegistrationOpen:
+		if strings.TrimSpace(cfg.DefaultGroups) == "" {
+			return fmt.Errorf("registration mode open requires default_groups")
+		}
+	default:
+		return fmt.Errorf("unknown registration mode %s; expected open, invite or disabled", cfg.Mode)
+	}
+	uc.registration = cfg
+	uc.uis = uis
+	lw.Console("Usr self-registration mode: %s", cfg.Mode)
+	return nil
+}
+
+// Register permits a new Usr to register themselves.  Depending on the
+// configured registration mode the route is disabled, open to anyone, or
+// requires a valid invitation token issued to the email address being
+// registered.  The new usr is created in an active state.
+//
+// POST /usr/register
+func (uc *UsrController) Register(w http.ResponseWriter, r *http.Request) {
+
+	mode := uc.registration.Mode
+	if mode == "" || mode == RegistrationDisabled {
+		respondWithError(w, http.StatusForbidden, "self-registration is disabled")
+		return
+	}
+
+	var u usrRegistration
+	decoder := json.NewDecoder(r.Body)
+	defer r.Body.Close()
+	if err := decoder.Decode(&u); err != nil {
+		lw.Warning("Usr Register: %v", err)
+		respondWithError(w, http.StatusBadRequest, "invalid request payload")
+		return
+	}
+
+	// admin is reserved for the bootstrap usr
+	if strings.ToLower(strings.TrimSpace(u.Email)) == "admin" {
+		respondWithError(w, http.StatusBadRequest, models.ErrEmailInvalid.Error())
+		return
+	}
+
+	// the invite is redeemed up-front so that concurrent registrations with
+	// the same token cannot both succeed; it is released again if the usr
+	// cannot be created.
+	var invite *models.UsrInvite
+	groups := uc.registration.DefaultGroups
+	if mode == RegistrationInvite {
+		var err error
+		invite, err = uc.uis.Redeem(u.InviteToken, u.Email)
+		if err != nil {
+			lw.Warning("Usr Register: %s: %v", u.Email, err)
+			respondWithError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		if invite.Groups != nil && strings.TrimSpace(*invite.Groups) != "" {
+			groups = *invite.Groups
+		}
+	}
+	release := func() {
+		if invite == nil {
+			return
+		}
+		if err := uc.uis.ClearRedeemed(invite.ID); err != nil {
+			lw.Warning("Usr Register: releasing usrinvite %d got: %v", invite.ID, err)
+		}
+	}
+
+	groupIDs, err := uc.usrGroupIDsByName(groups)
+	if err != nil {
+		release()
+		lw.Warning("Usr Register: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "registration is misconfigured; please contact an administrator")
+		return
+	}
+
+	usr := models.Usr{
+		Name:     u.Name,
+		Email:    u.Email,
+		Password: u.Password,
+		Active:   true,
+		Groups:   &groups,
+	}
+	err = uc.us.Create(&usr)
+	if err != nil {
+		release()
+		lw.Warning("Usr Register: %v", err)
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err = uc.setUsrGroups(usr.ID, groupIDs)
+	if err != nil {
+		lw.Warning(err.Error())
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	usr.Password = ""
+	usr.PasswordHash = ""
+	usr.Groups = uc.usrGroupString(usr.ID)
+	usr.Href = buildHrefBasic(r, true) + "usr/" + strconv.FormatUint(usr.ID, 10)
+	respondWithJSON(w, http.StatusCreated, usr)
+
+	// disseminate the new user info to self and group-members if any
+	uc.disseminateUsrChange(usr.ID, true, usr.Active)
+}
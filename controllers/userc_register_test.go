@@ -0,0 +1,50 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestInitRegistrationModes(t *testing.T) {
+
+	tests := []struct {
+		cfg     RegistrationConfig
+		mode    string
+		wantErr bool
+	}{
+		{RegistrationConfig{}, RegistrationDisabled, false},
+		{RegistrationConfig{Mode: " Invite "}, RegistrationInvite, false},
+		{RegistrationConfig{Mode: "open", DefaultGroups: "Readers"}, RegistrationOpen, false},
+		{RegistrationConfig{Mode: "open"}, "", true},
+		{RegistrationConfig{Mode: "anyone"}, "", true},
+	}
+
+	for _, tt := range tests {
+		uc := &UsrController{}
+		err := uc.InitRegistration(tt.cfg, nil)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("InitRegistration(%+v) got err %v; wantErr %v", tt.cfg, err, tt.wantErr)
+			continue
+		}
+		if err == nil && uc.registration.Mode != tt.mode {
+			t.Errorf("InitRegistration(%+v) got mode %s; want %s", tt.cfg, uc.registration.Mode, tt.mode)
+		}
+	}
+}
+
+func TestRegisterDisabled(t *testing.T) {
+
+	uc := &UsrController{}
+	if err := uc.InitRegistration(RegistrationConfig{Mode: RegistrationDisabled}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("POST", "/usr/register", strings.NewReader(`{"email":"a@b.com","password":"secret"}`))
+	w := httptest.NewRecorder()
+	uc.Register(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Register got status %d; want %d", w.Code, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+
+	"github.com/1414C/libraryapp/group/gmcom"
+	"github.com/dgrijalva/jwt-go"
+)
+
+func TestSignES512(t *testing.T) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uc := &UsrController{
+		jwtKeyMap:     map[string]interface{}{"ES521SignKey": key},
+		jwtSignMethod: "ES512",
+	}
+	tokenString, _, err := uc.signECDSA(jwt.MapClaims{"uid": 1})
+	if err != nil {
+		t.Fatalf("signECDSA got: %v", err)
+	}
+
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		return &key.PublicKey, nil
+	})
+	if err != nil || !token.Valid || token.Header["alg"] != "ES512" {
+		t.Fatalf("parse got: %v, alg %v", err, token.Header["alg"])
+	}
+}
+
+func TestSignEdDSA(t *testing.T) {
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uc := &UsrController{
+		jwtKeyMap:     map[string]interface{}{"EdDSASignKey": priv},
+		jwtSignMethod: "EdDSA",
+	}
+	tokenString, _, err := uc.signEdDSA(jwt.MapClaims{"uid": 1})
+	if err != nil {
+		t.Fatalf("signEdDSA got: %v", err)
+	}
+
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		return pub, nil
+	})
+	if err != nil || !token.Valid || token.Header["alg"] != "EdDSA" {
+		t.Fatalf("parse got: %v, alg %v", err, token.Header["alg"])
+	}
+
+	other, _, _ := ed25519.GenerateKey(rand.Reader)
+	_, err = jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		return other, nil
+	})
+	if err == nil {
+		t.Error("token verified with the wrong key")
+	}
+
+	// the key must survive the PEM round-trip of the key-set
+	k, err := gmcom.NewJWTKey("", "EdDSA", priv, pub)
+	if err != nil {
+		t.Fatalf("NewJWTKey got: %v", err)
+	}
+	if err := k.Parse(); err != nil {
+		t.Fatalf("Parse got: %v", err)
+	}
+	tokenString, _, err = uc.signWithKey(jwt.MapClaims{"uid": 1}, k)
+	if err != nil {
+		t.Fatalf("signWithKey got: %v", err)
+	}
+	if _, err = jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) { return k.VerifyKey(), nil }); err != nil {
+		t.Fatalf("parse of key-set token got: %v", err)
+	}
+
+	jwk, err := toJWK(k.KID, k.Alg, k.VerifyKey())
+	if err != nil || jwk.Kty != "OKP" || jwk.Crv != "Ed25519" || jwk.X == "" {
+		t.Errorf("toJWK got: %+v, %v", jwk, err)
+	}
+}
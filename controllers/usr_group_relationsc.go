@@ -0,0 +1,60 @@
+package controllers
+
+//=============================================================================================
+// UsrGroup entity controller_relations code
+//=============================================================================================
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/1414C/libraryapp/models"
+	"github.com/1414C/lw"
+	"github.com/gorilla/mux"
+)
+
+// GetUsrGroupToUsrs facilitates the retrieval of the Usrs that are members of
+// a UsrGroup by way of the UsrGroupMember relation.
+// This method is bound to the gorilla.mux router in appobj.go.
+// N:M
+//
+// GET /usrgroup/:id/tousrs
+func (uc *UsrGroupController) GetUsrGroupToUsrs(w http.ResponseWriter, r *http.Request) {
+
+	vars := mux.Vars(r)
+	usrgroupID, err := strconv.ParseUint(vars["usrgroup_id"], 10, 64)
+	if err != nil {
+		lw.Warning("UsrGroup GetUsrGroupToUsrs: %s", err.Error())
+		respondWithError(w, http.StatusBadRequest, "Invalid usrgroup number")
+		return
+	}
+
+	// the usrgroup must exist in order for the access-path to be valid
+	usrgroup := models.UsrGroup{
+		ID: usrgroupID,
+	}
+	err = uc.us.Get(&usrgroup)
+	if err != nil {
+		lw.Warning(err.Error())
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	urlString := buildHrefBasic(r, true) + "usr/"
+	usrs := []models.Usr{}
+	for _, m := range uc.ums.GetUsrGroupMembersByUsrGroupID(usrgroupID) {
+		usr := models.Usr{
+			ID: m.UsrID,
+		}
+		err = uc.usrs.Get(&usr)
+		if err != nil {
+			lw.Warning("UsrGroup GetUsrGroupToUsrs: usr %d got: %v", m.UsrID, err)
+			continue
+		}
+		usr.PasswordHash = ""
+		usr.Groups = nil
+		usr.Href = urlString + strconv.FormatUint(usr.ID, 10)
+		usrs = append(usrs, usr)
+	}
+	respondWithJSON(w, http.StatusOK, usrs)
+}
@@ -21,14 +21,18 @@ import (
 // UsrGroupController is the usrGroup controller type for route binding
 type UsrGroupController struct {
 	us              models.UsrGroupService
+	ums             models.UsrGroupMemberService
+	usrs            models.UsrService
 	internalAddress string
 	UsrGroupsH      *gmcom.UsrGroupsH // cache
 }
 
 // NewUsrGroupController creates a new UsrGroupController
-func NewUsrGroupController(us models.UsrGroupService, internalAddress string) *UsrGroupController {
+func NewUsrGroupController(us models.UsrGroupService, ums models.UsrGroupMemberService, usrs models.UsrService, internalAddress string) *UsrGroupController {
 	return &UsrGroupController{
 		us:              us,
+		ums:             ums,
+		usrs:            usrs,
 		internalAddress: internalAddress,
 	}
 }
@@ -81,7 +85,7 @@ func (uc *UsrGroupController) Update(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id, err := strconv.ParseUint(vars["id"], 10, 64)
 	if err != nil {
-		lw.Warning("User Group Update:", err)
+		lw.Warning("User Group Update: %v", err)
 		respondWithError(w, http.StatusBadRequest, "Invalid usrgroup id")
 		return
 	}
@@ -89,7 +93,7 @@ func (uc *UsrGroupController) Update(w http.ResponseWriter, r *http.Request) {
 	var u models.UsrGroup
 	decoder := json.NewDecoder(r.Body)
 	if err := decoder.Decode(&u); err != nil {
-		lw.Warning("User Group Update:", err)
+		lw.Warning("User Group Update: %v", err)
 		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
 		return
 	}
@@ -129,7 +133,7 @@ func (uc *UsrGroupController) Get(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id, err := strconv.ParseUint(vars["id"], 10, 64)
 	if err != nil {
-		lw.Warning("User Group Get:", err)
+		lw.Warning("User Group Get: %v", err)
 		respondWithError(w, http.StatusBadRequest, "Invalid usrgroup ID")
 		return
 	}
@@ -159,7 +163,7 @@ func (uc *UsrGroupController) Delete(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id, err := strconv.ParseUint(vars["id"], 10, 64)
 	if err != nil {
-		lw.Warning("User Group Delete:", err)
+		lw.Warning("User Group Delete: %v", err)
 		respondWithError(w, http.StatusBadRequest, "Invalid UsrGroup ID")
 		return
 	}
@@ -168,6 +172,15 @@ func (uc *UsrGroupController) Delete(w http.ResponseWriter, r *http.Request) {
 		ID: id,
 	}
 
+	// the group-memberships refer to the usrgroup and must be removed first
+	members := uc.ums.GetUsrGroupMembersByUsrGroupID(id)
+	err = uc.ums.DeleteUsrGroupMembersByUsrGroupID(id)
+	if err != nil {
+		lw.ErrorWithPrefixString("User Group Delete:", err)
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	err = uc.us.Delete(&usrgroup)
 	if err != nil {
 		lw.ErrorWithPrefixString("User Group Delete:", err)
@@ -178,6 +191,11 @@ func (uc *UsrGroupController) Delete(w http.ResponseWriter, r *http.Request) {
 
 	// disseminate the deleted usrgroup info to self and group-members if any
 	uc.disseminateUsrGroupChange(usrgroup.ID, true, usrgroup.GroupName, gmcom.COpDelete)
+
+	// disseminate the remaining group-memberships of the former members
+	for _, m := range members {
+		disseminateUsrMemberChange(m.UsrID, uc.ums.GetUsrGroupMembersByUsrID(m.UsrID), true, uc.internalAddress)
+	}
 }
 
 // GetUsrGroups facilitates the retrieval of all existing UsrGroups.  This method is bound
@@ -191,7 +209,11 @@ func (uc *UsrGroupController) GetUsrGroups(w http.ResponseWriter, r *http.Reques
 	urlString = strings.TrimSuffix(urlString, "s/")
 	urlString = urlString + "/"
 
-	usrgroups := uc.us.GetUsrGroups()
+	usrgroups, err := uc.us.GetUsrGroups()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
 	if usrgroups != nil {
 		for i, u := range usrgroups {
 			usrgroups[i].Href = urlString + strconv.FormatUint(uint64(u.ID), 10)
@@ -0,0 +1,165 @@
+package controllers
+
+//=============================================================================================
+// UsrInvite entity controller code
+//=============================================================================================
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/1414C/libraryapp/models"
+	"github.com/1414C/lw"
+	"github.com/gorilla/mux"
+)
+
+// defaultInviteLifetime is the validity of a UsrInvite in hours when
+// neither the request nor the registration config specify one.
+const defaultInviteLifetime = 72
+
+// UsrInviteController is the UsrInvite controller type for route binding
+type UsrInviteController struct {
+	is       models.UsrInviteService
+	ugs      models.UsrGroupService
+	lifetime uint
+}
+
+// NewUsrInviteController creates a new UsrInviteController.  lifetime is the
+// default validity of a new UsrInvite in hours.
+func NewUsrInviteController(is models.UsrInviteService, ugs models.UsrGroupService, lifetime uint) *UsrInviteController {
+	if lifetime == 0 {
+		lifetime = defaultInviteLifetime
+	}
+	return &UsrInviteController{
+		is:       is,
+		ugs:      ugs,
+		lifetime: lifetime,
+	}
+}
+
+// Create facilitates the issue of a new UsrInvite for an email address.  The
+// groups of the invite, if provided, must name existing UsrGroups.  The
+// plain-text token is contained in the response body and cannot be retrieved
+// again.  This method is bound to the gorilla.mux router in appobj.go.
+//
+// POST /usrinvite
+func (ic *UsrInviteController) Create(w http.ResponseWriter, r *http.Request) {
+
+	var i models.UsrInvite
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&i); err != nil {
+		lw.ErrorWithPrefixString("UsrInvite Create:", err)
+		respondWithError(w, http.StatusBadRequest, "usr_invitec: Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	if i.Groups != nil {
+		err := ic.checkGroups(*i.Groups)
+		if err != nil {
+			lw.Warning("UsrInvite Create: %v", err)
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	expiresOn := i.ExpiresOn
+	if expiresOn == nil {
+		e := time.Now().Add(time.Hour * time.Duration(ic.lifetime))
+		expiresOn = &e
+	}
+
+	// fill the model
+	invite := models.UsrInvite{
+		Email:     i.Email,
+		Groups:    i.Groups,
+		ExpiresOn: expiresOn,
+	}
+	invite.CreatedBy, _ = models.UsrIDFromContext(r.Context())
+
+	// build a base urlString for the JSON Body self-referencing Href tag
+	urlString := buildHrefStringFromCRUDReq(r, true)
+
+	// call the Create method on the usrinvite model
+	err := ic.is.Create(&invite)
+	if err != nil {
+		lw.ErrorWithPrefixString("UsrInvite Create:", err)
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	invite.Href = urlString + strconv.FormatUint(invite.ID, 10)
+	respondWithJSON(w, http.StatusCreated, invite)
+}
+
+// GetUsrInvites facilitates the retrieval of all existing UsrInvites.  The
+// tokens are not included in the response.  This method is bound to the
+// gorilla.mux router in appobj.go.
+//
+// GET /usrinvites
+func (ic *UsrInviteController) GetUsrInvites(w http.ResponseWriter, r *http.Request) {
+
+	invites := ic.is.GetUsrInvites()
+
+	// build base Href; common for each selected row
+	urlString := buildHrefBasic(r, true) + "usrinvite/"
+
+	if invites != nil {
+		for i, v := range invites {
+			invites[i].Href = urlString + strconv.FormatUint(v.ID, 10)
+		}
+		respondWithJSON(w, http.StatusOK, invites)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "[]")
+}
+
+// Delete facilitates the withdrawal of an existing UsrInvite.  This method is
+// bound to the gorilla.mux router in appobj.go.
+//
+// DELETE /usrinvite/:id
+func (ic *UsrInviteController) Delete(w http.ResponseWriter, r *http.Request) {
+
+	vars := mux.Vars(r)
+	id, err := strconv.ParseUint(vars["id"], 10, 64)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid usrinvite ID")
+		return
+	}
+
+	invite := models.UsrInvite{
+		ID: id,
+	}
+
+	err = ic.is.Delete(&invite)
+	if err != nil {
+		lw.Warning(err.Error())
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithHeader(w, http.StatusAccepted)
+}
+
+// checkGroups verifies that each of the semicolon-separated group names
+// refers to an existing UsrGroup.
+func (ic *UsrInviteController) checkGroups(groups string) error {
+
+	usrGroups, err := ic.ugs.GetUsrGroups()
+	if err != nil {
+		return err
+	}
+	known := make(map[string]bool)
+	for _, g := range usrGroups {
+		known[g.GroupName] = true
+	}
+	for _, n := range strings.Split(groups, ";") {
+		n = strings.TrimSpace(n)
+		if n != "" && !known[n] {
+			return fmt.Errorf("usrgroup %s does not exist", n)
+		}
+	}
+	return nil
+}
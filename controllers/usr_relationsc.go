@@ -0,0 +1,361 @@
+package controllers
+
+//=============================================================================================
+// Usr entity controller_relations code
+//=============================================================================================
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/1414C/libraryapp/group/gmcl"
+	"github.com/1414C/libraryapp/group/gmcom"
+	"github.com/1414C/libraryapp/models"
+	"github.com/1414C/lw"
+	"github.com/gorilla/mux"
+)
+
+// GetUsrToGroups facilitates the retrieval of the UsrGroups that a Usr is a
+// member of by way of the UsrGroupMember relation.
+// This method is bound to the gorilla.mux router in appobj.go.
+// N:M
+//
+// GET /usr/:id/togroups
+func (uc *UsrController) GetUsrToGroups(w http.ResponseWriter, r *http.Request) {
+
+	vars := mux.Vars(r)
+	usrID, err := strconv.ParseUint(vars["usr_id"], 10, 64)
+	if err != nil {
+		lw.Warning("Usr GetUsrToGroups: %s", err.Error())
+		respondWithError(w, http.StatusBadRequest, "Invalid usr number")
+		return
+	}
+
+	// the usr must exist in order for the access-path to be valid
+	usr := models.Usr{
+		ID: usrID,
+	}
+	err = uc.us.Get(&usr)
+	if err != nil {
+		lw.Warning(err.Error())
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, uc.usrGroupsOf(r, usrID))
+}
+
+// GetUsrMemberships facilitates the retrieval of the UsrGroupMember relations
+// of a Usr including their validity windows.  Memberships that have expired
+// or are not yet valid are included.
+// This method is bound to the gorilla.mux router in appobj.go.
+//
+// GET /usr/:id/memberships
+func (uc *UsrController) GetUsrMemberships(w http.ResponseWriter, r *http.Request) {
+
+	vars := mux.Vars(r)
+	usrID, err := strconv.ParseUint(vars["usr_id"], 10, 64)
+	if err != nil {
+		lw.Warning("Usr GetUsrMemberships: %s", err.Error())
+		respondWithError(w, http.StatusBadRequest, "Invalid usr number")
+		return
+	}
+
+	usr := models.Usr{
+		ID: usrID,
+	}
+	err = uc.us.Get(&usr)
+	if err != nil {
+		lw.Warning(err.Error())
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	members := uc.ums.GetUsrGroupMembersByUsrID(usrID)
+	if members == nil {
+		members = []models.UsrGroupMember{}
+	}
+	respondWithJSON(w, http.StatusOK, members)
+}
+
+// UpdateUsrToGroups replaces the UsrGroup memberships of a Usr.  The request
+// body contains the complete list of memberships; an element is either a
+// UsrGroup ID, or an object carrying an optional validity window, for example
+// [1,{"usr_group_id":3,"valid_from":"2020-06-01T00:00:00Z","valid_to":"2020-07-01T00:00:00Z"}].
+// The change takes effect immediately for the Usr's current access tokens.
+// This method is bound to the gorilla.mux router in appobj.go.
+//
+// PUT /usr/:id/togroups
+func (uc *UsrController) UpdateUsrToGroups(w http.ResponseWriter, r *http.Request) {
+
+	vars := mux.Vars(r)
+	usrID, err := strconv.ParseUint(vars["usr_id"], 10, 64)
+	if err != nil {
+		lw.Warning("Usr UpdateUsrToGroups: %s", err.Error())
+		respondWithError(w, http.StatusBadRequest, "Invalid usr number")
+		return
+	}
+
+	members, err := decodeUsrGroupMembers(r)
+	if err != nil {
+		lw.Warning("Usr UpdateUsrToGroups: %v", err)
+		respondWithError(w, http.StatusBadRequest, "invalid request payload; expected a list of usrgroup ids or memberships")
+		return
+	}
+
+	usr := models.Usr{
+		ID: usrID,
+	}
+	err = uc.us.Get(&usr)
+	if err != nil {
+		lw.Warning(err.Error())
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// check that each of the UsrGroups exists
+	for _, m := range members {
+		ug := models.UsrGroup{
+			ID: m.UsrGroupID,
+		}
+		err = uc.ugs.Get(&ug)
+		if err != nil {
+			lw.Warning("Usr UpdateUsrToGroups: usrgroup %d got: %v", m.UsrGroupID, err)
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("usrgroup %d does not exist", m.UsrGroupID))
+			return
+		}
+	}
+
+	err = uc.setUsrGroupMembers(usrID, members)
+	if err == models.ErrUsrGroupMemberWindowInvalid {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		lw.ErrorWithPrefixString("Usr UpdateUsrToGroups:", err)
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, uc.usrGroupsOf(r, usrID))
+}
+
+// decodeUsrGroupMembers reads the membership list of a PUT /usr/:id/togroups
+// request.  Bare UsrGroup IDs are accepted for compatibility with clients that
+// do not make use of validity windows.
+func decodeUsrGroupMembers(r *http.Request) ([]models.UsrGroupMember, error) {
+
+	var raw []json.RawMessage
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	members := make([]models.UsrGroupMember, 0, len(raw))
+	for _, e := range raw {
+		var m models.UsrGroupMember
+		var id uint64
+		if err := json.Unmarshal(e, &id); err == nil {
+			m.UsrGroupID = id
+		} else if err := json.Unmarshal(e, &m); err != nil {
+			return nil, err
+		}
+		if m.UsrGroupID == 0 {
+			return nil, fmt.Errorf("usr_group_id is required")
+		}
+		members = append(members, m)
+	}
+	return members, nil
+}
+
+// usrGroupsOf returns the UsrGroups that the specified Usr is a member of.
+func (uc *UsrController) usrGroupsOf(r *http.Request, usrID uint64) []models.UsrGroup {
+
+	urlString := buildHrefBasic(r, true) + "usrgroup/"
+	usrgroups := []models.UsrGroup{}
+	for _, m := range uc.ums.GetUsrGroupMembersByUsrID(usrID) {
+		ug := models.UsrGroup{
+			ID: m.UsrGroupID,
+		}
+		err := uc.ugs.Get(&ug)
+		if err != nil {
+			lw.Warning("usrGroupsOf: usrgroup %d got: %v", m.UsrGroupID, err)
+			continue
+		}
+		ug.Href = urlString + strconv.FormatUint(ug.ID, 10)
+		usrgroups = append(usrgroups, ug)
+	}
+	return usrgroups
+}
+
+// usrGroupIDsByName resolves a semicolon-separated list of UsrGroup names.
+func (uc *UsrController) usrGroupIDsByName(groups string) ([]uint64, error) {
+
+	usrGroups, err := uc.ugs.GetUsrGroups()
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]uint64)
+	for _, g := range usrGroups {
+		byName[g.GroupName] = g.ID
+	}
+
+	var ids []uint64
+	for _, n := range strings.Split(groups, ";") {
+		n = strings.TrimSpace(n)
+		if n == "" {
+			continue
+		}
+		id, ok := byName[n]
+		if !ok {
+			return nil, fmt.Errorf("usrgroup %s does not exist", n)
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return nil, models.ErrGroupRequired
+	}
+	return ids, nil
+}
+
+// usrGroupNames returns a map of usr ID to the semicolon-separated names of
+// the UsrGroups that the usr is a member of.
+func (uc *UsrController) usrGroupNames() (map[uint64]string, error) {
+
+	usrGroups, err := uc.ugs.GetUsrGroups()
+	if err != nil {
+		return nil, err
+	}
+	members, err := uc.ums.GetUsrGroupMembers()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[uint64]string)
+	for _, g := range usrGroups {
+		names[g.ID] = g.GroupName
+	}
+
+	gps := make(map[uint64][]string)
+	for _, m := range members {
+		if n, ok := names[m.UsrGroupID]; ok {
+			gps[m.UsrID] = append(gps[m.UsrID], n)
+		}
+	}
+
+	res := make(map[uint64]string)
+	for id, g := range gps {
+		sort.Strings(g)
+		res[id] = strings.Join(g, ";")
+	}
+	return res, nil
+}
+
+// usrGroupString returns the semicolon-separated names of the UsrGroups that
+// the specified usr is a member of.
+func (uc *UsrController) usrGroupString(usrID uint64) *string {
+
+	var g []string
+	for _, m := range uc.ums.GetUsrGroupMembersByUsrID(usrID) {
+		ug := models.UsrGroup{
+			ID: m.UsrGroupID,
+		}
+		if err := uc.ugs.Get(&ug); err == nil {
+			g = append(g, ug.GroupName)
+		}
+	}
+	sort.Strings(g)
+	s := strings.Join(g, ";")
+	return &s
+}
+
+// setUsrGroups persists the group-memberships of a usr and then disseminates
+// them to self and all group-members.  The validity windows of retained
+// memberships are kept.
+func (uc *UsrController) setUsrGroups(usrID uint64, groupIDs []uint64) error {
+
+	err := uc.ums.SetUsrGroups(usrID, groupIDs)
+	if err != nil {
+		return err
+	}
+	disseminateUsrMemberChange(usrID, uc.ums.GetUsrGroupMembersByUsrID(usrID), true, uc.internalAddress)
+	return nil
+}
+
+// setUsrGroupMembers persists the group-memberships of a usr including their
+// validity windows and then disseminates them to self and all group-members.
+func (uc *UsrController) setUsrGroupMembers(usrID uint64, members []models.UsrGroupMember) error {
+
+	err := uc.ums.SetUsrGroupMembers(usrID, members)
+	if err != nil {
+		return err
+	}
+	disseminateUsrMemberChange(usrID, uc.ums.GetUsrGroupMembersByUsrID(usrID), true, uc.internalAddress)
+	return nil
+}
+
+// CleanupUsrMembers deletes the group-memberships whose validity window ended
+// before now, and disseminates the remaining memberships of each affected usr.
+func (uc *UsrController) CleanupUsrMembers(now time.Time) {
+
+	expired, err := uc.ums.DeleteExpiredUsrGroupMembers(now)
+	if err != nil {
+		lw.Warning("CleanupUsrMembers got: %v", err)
+	}
+
+	done := make(map[uint64]bool)
+	for _, m := range expired {
+		if done[m.UsrID] {
+			continue
+		}
+		done[m.UsrID] = true
+		disseminateUsrMemberChange(m.UsrID, uc.ums.GetUsrGroupMembersByUsrID(m.UsrID), true, uc.internalAddress)
+	}
+	if len(expired) > 0 {
+		lw.Info("CleanupUsrMembers: removed %d expired usr group-memberships", len(expired))
+	}
+}
+
+// UsrMemberWindowOf returns the cache representation of the validity window
+// of a membership; ok is false for memberships without a window.
+func UsrMemberWindowOf(m models.UsrGroupMember) (w gmcom.UsrMemberWindow, ok bool) {
+
+	if m.ValidFrom != nil {
+		w.ValidFrom = m.ValidFrom.Unix()
+		ok = true
+	}
+	if m.ValidTo != nil {
+		w.ValidTo = m.ValidTo.Unix()
+		ok = true
+	}
+	return w, ok
+}
+
+// disseminateUsrMemberChange updates the local usr membership cache via the
+// group-membership server, which then forwards the memberships to all other
+// group-members that are in a non-failed status.
+func disseminateUsrMemberChange(usrID uint64, members []models.UsrGroupMember, fwd bool, internalAddress string) {
+
+	um := gmcom.UsrMemberD{
+		Forward: fwd,
+		UsrID:   usrID,
+	}
+	for _, m := range members {
+		um.GroupIDs = append(um.GroupIDs, m.UsrGroupID)
+		if w, ok := UsrMemberWindowOf(m); ok {
+			if um.Windows == nil {
+				um.Windows = make(map[uint64]gmcom.UsrMemberWindow)
+			}
+			um.Windows[m.UsrGroupID] = w
+		}
+	}
+
+	err := gmcl.AddUpdUsrMemberCache(um, internalAddress)
+	if err != nil {
+		lw.ErrorWithPrefixString("usr membership cache update error message:", err)
+	}
+}
@@ -0,0 +1,185 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/1414C/libraryapp/group/gmcom"
+	"github.com/1414C/libraryapp/models"
+	"github.com/gorilla/mux"
+)
+
+func TestDecodeUsrGroupMembers(t *testing.T) {
+
+	body := `[1,{"usr_group_id":3,"valid_from":"2020-06-01T00:00:00Z","valid_to":"2020-07-01T00:00:00Z"},{"usr_group_id":4}]`
+	r := httptest.NewRequest("PUT", "/usr/1/togroups", strings.NewReader(body))
+	members, err := decodeUsrGroupMembers(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 3 || members[0].UsrGroupID != 1 || members[1].UsrGroupID != 3 || members[2].UsrGroupID != 4 {
+		t.Fatalf("got %+v", members)
+	}
+	if members[1].ValidFrom == nil || members[1].ValidTo == nil || members[2].ValidTo != nil {
+		t.Errorf("validity windows not decoded: %+v", members)
+	}
+
+	for _, bad := range []string{`{"usr_group_id":1}`, `[{"valid_to":"2020-07-01T00:00:00Z"}]`, `["Admin"]`} {
+		r = httptest.NewRequest("PUT", "/usr/1/togroups", strings.NewReader(bad))
+		if _, err := decodeUsrGroupMembers(r); err == nil {
+			t.Errorf("%s: expected an error", bad)
+		}
+	}
+}
+
+func TestGroupNamesValidityWindow(t *testing.T) {
+
+	now := time.Now().Unix()
+	ugh := &gmcom.UsrGroupsH{GroupNames: map[uint64]string{1: "Readers", 2: "Expired", 3: "Future", 4: "Current"}}
+	umh := &gmcom.UsrMembersH{UsrGroupIDs: make(map[uint64]map[uint64]bool)}
+	umh.Set(7, []uint64{1, 2, 3, 4}, map[uint64]gmcom.UsrMemberWindow{
+		2: {ValidTo: now - 60},
+		3: {ValidFrom: now + 60},
+		4: {ValidFrom: now - 60, ValidTo: now + 60},
+	}, 0)
+
+	got := umh.GroupNames(7, ugh)
+	sort.Strings(got)
+	if strings.Join(got, ";") != "Current;Readers" {
+		t.Errorf("got %v, want [Current Readers]", got)
+	}
+
+	// replacing the memberships drops the old windows
+	umh.Set(7, []uint64{2}, nil, 0)
+	if got = umh.GroupNames(7, ugh); len(got) != 1 || got[0] != "Expired" {
+		t.Errorf("got %v, want [Expired]", got)
+	}
+}
+
+// stubUsrGroupMemberService holds the group IDs of each usr, and the
+// validity windows of the memberships that have one
+type stubUsrGroupMemberService struct {
+	models.UsrGroupMemberService
+	groups  map[uint64][]uint64
+	windows map[uint64]map[uint64]models.UsrGroupMember
+}
+
+func (s *stubUsrGroupMemberService) SetUsrGroups(usrID uint64, groupIDs []uint64) error {
+	s.groups[usrID] = groupIDs
+	return nil
+}
+
+func (s *stubUsrGroupMemberService) SetUsrGroupMembers(usrID uint64, members []models.UsrGroupMember) error {
+	var ids []uint64
+	windows := make(map[uint64]models.UsrGroupMember)
+	for _, m := range members {
+		if m.ValidFrom != nil && m.ValidTo != nil && !m.ValidTo.After(*m.ValidFrom) {
+			return models.ErrUsrGroupMemberWindowInvalid
+		}
+		ids = append(ids, m.UsrGroupID)
+		windows[m.UsrGroupID] = m
+	}
+	s.groups[usrID] = ids
+	if s.windows == nil {
+		s.windows = make(map[uint64]map[uint64]models.UsrGroupMember)
+	}
+	s.windows[usrID] = windows
+	return nil
+}
+
+func (s *stubUsrGroupMemberService) GetUsrGroupMembersByUsrID(usrID uint64) []models.UsrGroupMember {
+	var ms []models.UsrGroupMember
+	for _, id := range s.groups[usrID] {
+		m := models.UsrGroupMember{UsrID: usrID, UsrGroupID: id}
+		if w, ok := s.windows[usrID][id]; ok {
+			m.ValidFrom, m.ValidTo = w.ValidFrom, w.ValidTo
+		}
+		ms = append(ms, m)
+	}
+	return ms
+}
+
+func (s *stubUsrGroupMemberService) GetUsrGroupMembersByUsrGroupID(usrGroupID uint64) []models.UsrGroupMember {
+	var ms []models.UsrGroupMember
+	for usrID, ids := range s.groups {
+		for _, id := range ids {
+			if id == usrGroupID {
+				ms = append(ms, models.UsrGroupMember{UsrID: usrID, UsrGroupID: id})
+			}
+		}
+	}
+	sort.Slice(ms, func(i, j int) bool { return ms[i].UsrID < ms[j].UsrID })
+	return ms
+}
+
+func TestUsrToGroups(t *testing.T) {
+
+	us := &stubUsrService{usrs: map[uint64]models.Usr{1: {ID: 1, Email: "a@example.com"}, 2: {ID: 2, Email: "b@example.com"}}}
+	ums := &stubUsrGroupMemberService{groups: map[uint64][]uint64{1: {1}}}
+	ugs := stubUsrGroupService{groups: []models.UsrGroup{{ID: 1, GroupName: "Admin"}, {ID: 2, GroupName: "Staff"}}}
+	uc := NewUsrController(us, ugs, ums, nil, "HS256", 60, "127.0.0.1:1")
+
+	put := func(usrID, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("PUT", "/usr/"+usrID+"/togroups", strings.NewReader(body))
+		uc.UpdateUsrToGroups(w, mux.SetURLVars(r, map[string]string{"usr_id": usrID}))
+		return w
+	}
+
+	tests := []struct {
+		usrID string
+		body  string
+		want  int
+	}{
+		{"3", `[1]`, http.StatusBadRequest},   // the usr does not exist
+		{"2", `[1,9]`, http.StatusBadRequest}, // usrgroup 9 does not exist
+		{"2", `{"usr_group_id":1}`, http.StatusBadRequest},
+		{"2", `[{"usr_group_id":2,"valid_from":"2020-07-01T00:00:00Z","valid_to":"2020-06-01T00:00:00Z"}]`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if w := put(tt.usrID, tt.body); w.Code != tt.want {
+			t.Errorf("%s %s: got status %d; want %d", tt.usrID, tt.body, w.Code, tt.want)
+		}
+	}
+	if _, ok := ums.groups[2]; ok {
+		t.Fatalf("got memberships %v for usr 2 following rejected requests", ums.groups[2])
+	}
+
+	// the membership list replaces the memberships of the usr
+	w := put("2", `[1,{"usr_group_id":2,"valid_to":"2030-01-01T00:00:00Z"}]`)
+	var groups []models.UsrGroup
+	if err := json.Unmarshal(w.Body.Bytes(), &groups); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("got status %d, %v", w.Code, err)
+	}
+	if len(groups) != 2 || groups[0].GroupName != "Admin" || groups[1].GroupName != "Staff" || !strings.HasSuffix(groups[1].Href, "/usrgroup/2") {
+		t.Errorf("got %+v", groups)
+	}
+
+	// the validity windows are returned by the memberships route
+	w = httptest.NewRecorder()
+	uc.GetUsrMemberships(w, mux.SetURLVars(httptest.NewRequest("GET", "/usr/2/memberships", nil), map[string]string{"usr_id": "2"}))
+	var members []models.UsrGroupMember
+	if err := json.Unmarshal(w.Body.Bytes(), &members); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("got status %d, %v", w.Code, err)
+	}
+	if len(members) != 2 || members[0].ValidTo != nil || members[1].ValidTo == nil || members[1].ValidTo.Year() != 2030 {
+		t.Errorf("got %+v", members)
+	}
+
+	// the usrgroup side of the relation
+	gc := NewUsrGroupController(ugs, ums, us, "127.0.0.1:1")
+	w = httptest.NewRecorder()
+	gc.GetUsrGroupToUsrs(w, mux.SetURLVars(httptest.NewRequest("GET", "/usrgroup/1/tousrs", nil), map[string]string{"usrgroup_id": "1"}))
+	var usrs []models.Usr
+	if err := json.Unmarshal(w.Body.Bytes(), &usrs); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("got status %d, %v", w.Code, err)
+	}
+	if len(usrs) != 2 || usrs[0].ID != 1 || usrs[1].ID != 2 || usrs[0].PasswordHash != "" {
+		t.Errorf("got %+v", usrs)
+	}
+}
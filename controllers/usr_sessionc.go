@@ -0,0 +1,194 @@
+package controllers
+
+//=============================================================================================
+// Usr session controller code
+//=============================================================================================
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/1414C/libraryapp/group/gmcl"
+	"github.com/1414C/libraryapp/group/gmcom"
+	"github.com/1414C/libraryapp/models"
+	"github.com/1414C/lw"
+	"github.com/gorilla/mux"
+)
+
+// InitSessions enables the tracking of the tokens issued by the UsrController.
+// Each token carries the sid of a UsrSession, which can be listed and revoked
+// per usr.
+func (uc *UsrController) InitSessions(uss models.UsrSessionService) {
+	uc.uss = uss
+}
+
+// GetUsrSessions lists the sessions of a Usr whose tokens have not yet
+// expired, including revoked sessions.
+// This method is bound to the gorilla.mux router in appobj.go.
+//
+// GET /usr/:id/sessions
+func (uc *UsrController) GetUsrSessions(w http.ResponseWriter, r *http.Request) {
+
+	usrID, ok := uc.sessionUsrID(w, r)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	sessions := make([]models.UsrSession, 0)
+	for _, s := range uc.uss.GetUsrSessionsByUsrID(usrID) {
+		if s.ExpiresOn != nil && s.ExpiresOn.Before(now) {
+			continue
+		}
+		s.Href = "http://" + r.Host + "/usr/" + strconv.FormatUint(usrID, 10) + "/sessions/" + s.SID
+		sessions = append(sessions, s)
+	}
+	respondWithJSON(w, http.StatusOK, sessions)
+}
+
+// DeleteUsrSession revokes a session of a Usr, thereby signing the usr out of
+// the device the session's token was issued to.  The revocation is
+// disseminated to all group-members.
+// This method is bound to the gorilla.mux router in appobj.go.
+//
+// DELETE /usr/:id/sessions/:sid
+func (uc *UsrController) DeleteUsrSession(w http.ResponseWriter, r *http.Request) {
+
+	usrID, ok := uc.sessionUsrID(w, r)
+	if !ok {
+		return
+	}
+
+	sid := mux.Vars(r)["sid"]
+	s, err := uc.uss.BySID(sid)
+	if err != nil || s.UsrID != usrID {
+		respondWithError(w, http.StatusNotFound, "usr session not found")
+		return
+	}
+
+	revoked, err := uc.uss.MarkRevoked(sid, time.Now())
+	if err != nil {
+		lw.Warning("Usr DeleteUsrSession: %s", err.Error())
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// disseminate even if the session had already been revoked in the db, so
+	// that a group-member that missed the first revocation catches up.
+	if !revoked {
+		lw.Info("Usr DeleteUsrSession: session %s had already been revoked", sid)
+	}
+	uc.disseminateSessionRevoke(s)
+	respondWithHeader(w, http.StatusAccepted)
+}
+
+// sessionUsrID reads the usr_id of a session route and checks that the usr
+// exists.  An error response is written if it does not.
+func (uc *UsrController) sessionUsrID(w http.ResponseWriter, r *http.Request) (uint64, bool) {
+
+	usrID, err := strconv.ParseUint(mux.Vars(r)["usr_id"], 10, 64)
+	if err != nil {
+		lw.Warning("Usr sessions: %s", err.Error())
+		respondWithError(w, http.StatusBadRequest, "Invalid usr number")
+		return 0, false
+	}
+
+	if uc.uss == nil {
+		respondWithError(w, http.StatusNotFound, "usr session tracking is not enabled")
+		return 0, false
+	}
+
+	usr := models.Usr{
+		ID: usrID,
+	}
+	err = uc.us.Get(&usr)
+	if err != nil {
+		lw.Warning(err.Error())
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return 0, false
+	}
+	return usrID, true
+}
+
+// recordSession creates the UsrSession of a token that is about to be issued
+// and returns its sid.  An empty sid is returned if session tracking is not
+// enabled.
+func (uc *UsrController) recordSession(usrID uint64, r *http.Request, issuedOn, expiresOn time.Time) (string, error) {
+
+	if uc.uss == nil {
+		return "", nil
+	}
+
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	s := models.UsrSession{
+		SID:       hex.EncodeToString(b),
+		UsrID:     usrID,
+		IssuedOn:  &issuedOn,
+		ExpiresOn: &expiresOn,
+	}
+	if r != nil {
+		s.UserAgent = r.UserAgent()
+		s.IPAddress = requestIP(r)
+	}
+	err = uc.uss.Create(&s)
+	if err != nil {
+		return "", err
+	}
+	return s.SID, nil
+}
+
+// requestIP returns the client address of the request; the first address of
+// the X-Forwarded-For header is used when the app is behind a proxy.
+func requestIP(r *http.Request) string {
+
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		return strings.TrimSpace(strings.Split(xff, ",")[0])
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// disseminateSessionRevoke updates the local revoked-session cache via the
+// group-membership server, which then forwards the revocation to all other
+// group-members that are in a non-failed status.
+func (uc *UsrController) disseminateSessionRevoke(s *models.UsrSession) {
+
+	sd := gmcom.SessionD{
+		Forward: true,
+		SID:     s.SID,
+		UsrID:   s.UsrID,
+	}
+	if s.ExpiresOn != nil {
+		sd.ExpiresOn = s.ExpiresOn.Unix()
+	}
+
+	err := gmcl.AddUpdSessionCache(sd, uc.internalAddress)
+	if err != nil {
+		lw.ErrorWithPrefixString("UsrController session cache update error message:", err)
+	}
+}
+
+// CleanupUsrSessions deletes the sessions whose tokens expired before now.
+func (uc *UsrController) CleanupUsrSessions(now time.Time) {
+
+	if uc.uss == nil {
+		return
+	}
+	err := uc.uss.DeleteExpiredUsrSessions(now)
+	if err != nil {
+		lw.Warning("CleanupUsrSessions got: %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+package controllers
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/1414C/libraryapp/models"
+)
+
+// stubUsrSessionService records the created sessions
+type stubUsrSessionService struct {
+	models.UsrSessionService
+	created []models.UsrSession
+}
+
+func (s *stubUsrSessionService) Create(session *models.UsrSession) error {
+	s.created = append(s.created, *session)
+	return nil
+}
+
+func TestRecordSession(t *testing.T) {
+
+	uc := &UsrController{}
+	now := time.Now()
+	sid, err := uc.recordSession(1, nil, now, now.Add(time.Hour))
+	if err != nil || sid != "" {
+		t.Fatalf("without session tracking got sid %q, err %v", sid, err)
+	}
+
+	uss := &stubUsrSessionService{}
+	uc.InitSessions(uss)
+
+	r := httptest.NewRequest("POST", "/login", nil)
+	r.RemoteAddr = "192.168.1.20:51234"
+	r.Header.Set("User-Agent", "curl/7.68.0")
+	sid, err = uc.recordSession(7, r, now, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sid) != 32 || len(uss.created) != 1 {
+		t.Fatalf("got sid %q and %d sessions", sid, len(uss.created))
+	}
+	s := uss.created[0]
+	if s.SID != sid || s.UsrID != 7 || s.UserAgent != "curl/7.68.0" || s.IPAddress != "192.168.1.20" {
+		t.Errorf("got session %+v", s)
+	}
+
+	other, _ := uc.recordSession(7, r, now, now.Add(time.Hour))
+	if other == sid {
+		t.Error("sessions should not share a sid")
+	}
+}
+
+func TestRequestIP(t *testing.T) {
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.5:443"
+	if got := requestIP(r); got != "10.0.0.5" {
+		t.Errorf("got %s; want 10.0.0.5", got)
+	}
+
+	r.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.1")
+	if got := requestIP(r); got != "203.0.113.7" {
+		t.Errorf("got %s; want 203.0.113.7", got)
+	}
+}
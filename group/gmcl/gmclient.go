@@ -11,7 +11,6 @@ import (
 	"fmt"
 
 	"github.com/1414C/libraryapp/group/gmcom"
-	"golang.org/x/net/websocket"
 
 	"github.com/1414C/lw"
 )
@@ -77,9 +76,7 @@ func AddUpdUsrCache(u gmcom.ActUsrD, address string) error {
 	encUsr := encBuf.Bytes()
 
 	// connect to remote cache server
-	origin := "http://localhost/"
-	url := "ws://" + address + "/updateusrcache"
-	ws, err := websocket.Dial(url, "", origin)
+	ws, err := gmcom.DialGM(address, "/updateusrcache", 0)
 	if err != nil {
 		lw.ErrorWithPrefixString("AddUpdUsrCache() ws connection failed - got:", err)
 		return err
@@ -87,26 +84,24 @@ func AddUpdUsrCache(u gmcom.ActUsrD, address string) error {
 	defer ws.Close()
 
 	// push the encoded (reduced) Usr
-	_, err = ws.Write(encUsr)
+	err = gmcom.SendPayload(ws, encUsr)
 	if err != nil {
 		lw.ErrorWithPrefixString("AddUpdUsrCache() ws.Write error - got:", err)
 		return err
 	}
 
-	var msg = make([]byte, 64)
-
-	// single read from the ws is okay here
-	n, err := ws.Read(msg)
+	// the confirmation is sealed in the same way as the request
+	msg, err := gmcom.ReceivePayload(ws)
 	if err != nil {
-		lw.ErrorWithPrefixString("AddUpdUsrCache() ws.Read error - got:", err)
+		lw.ErrorWithPrefixString("AddUpdUsrCache() ReceivePayload error - got:", err)
 		return err
 	}
 
 	// if update is confirmed do a little dance =)
-	if string(msg[:n]) == "true" {
+	if string(msg) == "true" {
 		// cw <- na
 	} else {
-		e := fmt.Errorf("AddUpdUsrCache() appeared to fail - got %v(raw),%v(string)", msg[:n], string(msg[:n]))
+		e := fmt.Errorf("AddUpdUsrCache() appeared to fail - got %v(raw),%v(string)", msg, string(msg))
 		lw.Error(e)
 		return e
 	}
@@ -128,9 +123,7 @@ func AddUpdGroupAuthCache(g gmcom.GroupAuthD, address string) error {
 	encGA := encBuf.Bytes()
 
 	// connect to remote cache server
-	origin := "http://localhost/"
-	url := "ws://" + address + "/updategroupauthcache"
-	ws, err := websocket.Dial(url, "", origin)
+	ws, err := gmcom.DialGM(address, "/updategroupauthcache", 0)
 	if err != nil {
 		lw.ErrorWithPrefixString("AddUpdGroupAuthCache() ws connection failed - got:", err)
 		return err
@@ -138,26 +131,24 @@ func AddUpdGroupAuthCache(g gmcom.GroupAuthD, address string) error {
 	defer ws.Close()
 
 	// push the encoded (reduced) GroupAuth
-	_, err = ws.Write(encGA)
+	err = gmcom.SendPayload(ws, encGA)
 	if err != nil {
 		lw.ErrorWithPrefixString("AddUpdGroupAuthCache() ws.Write error - got:", err)
 		return err
 	}
 
-	var msg = make([]byte, 64)
-
-	// single read from the ws is okay here
-	n, err := ws.Read(msg)
+	// the confirmation is sealed in the same way as the request
+	msg, err := gmcom.ReceivePayload(ws)
 	if err != nil {
-		lw.ErrorWithPrefixString("AddUpdGroupAuthCache() ws.Read error - got:", err)
+		lw.ErrorWithPrefixString("AddUpdGroupAuthCache() ReceivePayload error - got:", err)
 		return err
 	}
 
 	// if update is confirmed do a little dance =)
-	if string(msg[:n]) == "true" {
+	if string(msg) == "true" {
 		// cw <- na
 	} else {
-		e := fmt.Errorf("AddUpdGroupAuthCache() appeared to fail - got %v(raw),%v(string)", msg[:n], string(msg[:n]))
+		e := fmt.Errorf("AddUpdGroupAuthCache() appeared to fail - got %v(raw),%v(string)", msg, string(msg))
 		lw.Error(e)
 		return e
 	}
@@ -179,9 +170,7 @@ func AddUpdAuthCache(a gmcom.AuthD, address string) error {
 	encAuth := encBuf.Bytes()
 
 	// connect to remote cache server
-	origin := "http://localhost/"
-	url := "ws://" + address + "/updateauthcache"
-	ws, err := websocket.Dial(url, "", origin)
+	ws, err := gmcom.DialGM(address, "/updateauthcache", 0)
 	if err != nil {
 		lw.ErrorWithPrefixString("AddUpdAuthCache() ws connection failed - got:", err)
 		return err
@@ -189,26 +178,24 @@ func AddUpdAuthCache(a gmcom.AuthD, address string) error {
 	defer ws.Close()
 
 	// push the encoded Auth
-	_, err = ws.Write(encAuth)
+	err = gmcom.SendPayload(ws, encAuth)
 	if err != nil {
 		lw.ErrorWithPrefixString("AddUpdAuthCache() ws.Write error - got:", err)
 		return err
 	}
 
-	var msg = make([]byte, 64)
-
-	// single read from the ws is okay here
-	n, err := ws.Read(msg)
+	// the confirmation is sealed in the same way as the request
+	msg, err := gmcom.ReceivePayload(ws)
 	if err != nil {
-		lw.ErrorWithPrefixString("AddUpdAuthCache() ws.Read error - got:", err)
+		lw.ErrorWithPrefixString("AddUpdAuthCache() ReceivePayload error - got:", err)
 		return err
 	}
 
 	// if update is confirmed do a little dance =)
-	if string(msg[:n]) == "true" {
+	if string(msg) == "true" {
 		// cw <- na
 	} else {
-		e := fmt.Errorf("AddUpdAuthCache() appeared to fail - got %v(raw),%v(string)", msg[:n], string(msg[:n]))
+		e := fmt.Errorf("AddUpdAuthCache() appeared to fail - got %v(raw),%v(string)", msg, string(msg))
 		lw.Error(e)
 		return e
 	}
@@ -230,9 +217,7 @@ func AddUpdUsrGroupCache(a gmcom.UsrGroupD, address string) error {
 	encAuth := encBuf.Bytes()
 
 	// connect to remote cache server
-	origin := "http://localhost/"
-	url := "ws://" + address + "/updateusrgroupcache"
-	ws, err := websocket.Dial(url, "", origin)
+	ws, err := gmcom.DialGM(address, "/updateusrgroupcache", 0)
 	if err != nil {
 		lw.ErrorWithPrefixString("AddUpdUsrGroupCache() ws connection failed - got:", err)
 		return err
@@ -240,28 +225,197 @@ func AddUpdUsrGroupCache(a gmcom.UsrGroupD, address string) error {
 	defer ws.Close()
 
 	// push the encoded UsrGroup
-	_, err = ws.Write(encAuth)
+	err = gmcom.SendPayload(ws, encAuth)
 	if err != nil {
 		lw.ErrorWithPrefixString("AddUpdUsrGroupdCache() ws.Write error - got:", err)
 		return err
 	}
 
-	var msg = make([]byte, 64)
-
-	// single read from the ws is okay here
-	n, err := ws.Read(msg)
+	// the confirmation is sealed in the same way as the request
+	msg, err := gmcom.ReceivePayload(ws)
 	if err != nil {
-		lw.ErrorWithPrefixString("AddUpdUsrGroupCache() ws.Read error - got:", err)
+		lw.ErrorWithPrefixString("AddUpdUsrGroupCache() ReceivePayload error - got:", err)
 		return err
 	}
 
 	// if update is confirmed do a little dance =)
-	if string(msg[:n]) == "true" {
+	if string(msg) == "true" {
 		// cw <- na
 	} else {
-		e := fmt.Errorf("AddUpdUsrGroupCache() appeared to fail - got %v(raw),%v(string)", msg[:n], string(msg[:n]))
+		e := fmt.Errorf("AddUpdUsrGroupCache() appeared to fail - got %v(raw),%v(string)", msg, string(msg))
 		lw.Error(e)
 		return e
 	}
 	return nil
 }
+
+// AddUpdJWTKeyCache replaces the jwt key-set in the local cache, resulting in a
+// cascaded dispatch of the same call to all non-failed group-members.  The key-set
+// is sent as a single ws message-frame, as it will not fit into a small buffer.
+// err := AddUpdJWTKeyCache(gmcom.JWTKeysD{Forward: true, SignKID: kid, Keys: keys}, "192.168.1.66:4444")
+func AddUpdJWTKeyCache(k gmcom.JWTKeysD, address string) error {
+
+	// gob encode the key-set
+	encBuf := new(bytes.Buffer)
+	err := gob.NewEncoder(encBuf).Encode(k)
+	if err != nil {
+		lw.ErrorWithPrefixString("failed to gob-encode JWT key-set - got:", err)
+		return err
+	}
+
+	// connect to remote cache server
+	ws, err := gmcom.DialGM(address, "/updatejwtkeycache", 0)
+	if err != nil {
+		lw.ErrorWithPrefixString("AddUpdJWTKeyCache() ws connection failed - got:", err)
+		return err
+	}
+	defer ws.Close()
+
+	// push the encoded key-set
+	err = gmcom.SendPayload(ws, encBuf.Bytes())
+	if err != nil {
+		lw.ErrorWithPrefixString("AddUpdJWTKeyCache() ws.Write error - got:", err)
+		return err
+	}
+
+	// the confirmation is sealed in the same way as the request
+	msg, err := gmcom.ReceivePayload(ws)
+	if err != nil {
+		lw.ErrorWithPrefixString("AddUpdJWTKeyCache() ReceivePayload error - got:", err)
+		return err
+	}
+
+	if string(msg) != "true" {
+		e := fmt.Errorf("AddUpdJWTKeyCache() appeared to fail - got %v(raw),%v(string)", msg, string(msg))
+		lw.Error(e)
+		return e
+	}
+	return nil
+}
+
+// AddUpdUsrMemberCache replaces the group-memberships of a usr in the local usr
+// membership cache, resulting in dissemination to all other group-members if
+// Forward == true.
+// err := AddUpdUsrMemberCache(gmcom.UsrMemberD{UsrID:1, GroupIDs:[]uint64{1,2}}, "192.168.1.66:4444")
+func AddUpdUsrMemberCache(m gmcom.UsrMemberD, address string) error {
+
+	// gob encode the membership data
+	encBuf := new(bytes.Buffer)
+	err := gob.NewEncoder(encBuf).Encode(m)
+	if err != nil {
+		lw.ErrorWithPrefixString("failed to gob-encode UsrMember data - got:", err)
+		return err
+	}
+
+	// connect to remote cache server
+	ws, err := gmcom.DialGM(address, "/updateusrmembercache", 0)
+	if err != nil {
+		lw.ErrorWithPrefixString("AddUpdUsrMemberCache() ws connection failed - got:", err)
+		return err
+	}
+	defer ws.Close()
+
+	// push the encoded memberships
+	err = gmcom.SendPayload(ws, encBuf.Bytes())
+	if err != nil {
+		lw.ErrorWithPrefixString("AddUpdUsrMemberCache() ws.Write error - got:", err)
+		return err
+	}
+
+	// the confirmation is sealed in the same way as the request
+	msg, err := gmcom.ReceivePayload(ws)
+	if err != nil {
+		lw.ErrorWithPrefixString("AddUpdUsrMemberCache() ReceivePayload error - got:", err)
+		return err
+	}
+
+	if string(msg) != "true" {
+		e := fmt.Errorf("AddUpdUsrMemberCache() appeared to fail - got %v(raw),%v(string)", msg, string(msg))
+		lw.Error(e)
+		return e
+	}
+	return nil
+}
+
+// AddUpdSessionCache records the revocation of a usr session in the local
+// revoked-session cache, resulting in dissemination to all other group-members
+// if Forward == true.
+// err := AddUpdSessionCache(gmcom.SessionD{Forward: true, SID: sid, UsrID: 1, ExpiresOn: exp}, "192.168.1.66:4444")
+func AddUpdSessionCache(s gmcom.SessionD, address string) error {
+
+	// gob encode the session data
+	encBuf := new(bytes.Buffer)
+	err := gob.NewEncoder(encBuf).Encode(s)
+	if err != nil {
+		lw.ErrorWithPrefixString("failed to gob-encode Session data - got:", err)
+		return err
+	}
+
+	// connect to remote cache server
+	ws, err := gmcom.DialGM(address, "/updatesessioncache", 0)
+	if err != nil {
+		lw.ErrorWithPrefixString("AddUpdSessionCache() ws connection failed - got:", err)
+		return err
+	}
+	defer ws.Close()
+
+	// push the encoded session
+	err = gmcom.SendPayload(ws, encBuf.Bytes())
+	if err != nil {
+		lw.ErrorWithPrefixString("AddUpdSessionCache() ws.Write error - got:", err)
+		return err
+	}
+
+	// the confirmation is sealed in the same way as the request
+	msg, err := gmcom.ReceivePayload(ws)
+	if err != nil {
+		lw.ErrorWithPrefixString("AddUpdSessionCache() ReceivePayload error - got:", err)
+		return err
+	}
+
+	if string(msg) != "true" {
+		e := fmt.Errorf("AddUpdSessionCache() appeared to fail - got %v(raw),%v(string)", msg, string(msg))
+		lw.Error(e)
+		return e
+	}
+	return nil
+}
+
+// ReconcileCaches sends the digests of the local authorization caches to the
+// group-member at address, and returns the entries of the buckets in which
+// the member's caches differ.  Caches that match are not included.
+// deltas, err := ReconcileCaches([]gmcom.CacheDigest{actUsrs.Digest()}, "192.168.1.66:4444")
+func ReconcileCaches(digests []gmcom.CacheDigest, address string) ([]gmcom.CacheDelta, error) {
+
+	// gob encode the digests
+	encBuf := new(bytes.Buffer)
+	err := gob.NewEncoder(encBuf).Encode(digests)
+	if err != nil {
+		lw.ErrorWithPrefixString("failed to gob-encode cache digests - got:", err)
+		return nil, err
+	}
+
+	// connect to remote cache server
+	ws, err := gmcom.DialGM(address, "/reconcilecaches", 0)
+	if err != nil {
+		return nil, err
+	}
+	defer ws.Close()
+
+	err = gmcom.SendPayload(ws, encBuf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	// the deltas are received as a single message
+	raw, err := gmcom.ReceivePayload(ws)
+	if err != nil {
+		return nil, err
+	}
+	var deltas []gmcom.CacheDelta
+	err = gob.NewDecoder(bytes.NewBuffer(raw)).Decode(&deltas)
+	if err != nil {
+		return nil, fmt.Errorf("ReconcileCaches() gob.Decode() error: %s", err)
+	}
+	return deltas, nil
+}
@@ -0,0 +1,541 @@
+package gmcom
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Anti-entropy reconciliation of the authorization caches.
+//
+// The ActUsrsH, GroupAuthsH, AuthsH, UsrGroupsH and UsrMembersH caches are
+// kept in step by pushing each change to all group-members.  A member that
+// misses a push, for example while it is suspect, would keep the stale entry
+// until it restarts.
+// Members therefore periodically send a digest of each cache to a random peer,
+// which returns the entries of the buckets in which its own digest differs.
+//
+// A digest holds a hash of the entries in each of CCacheBuckets buckets; an
+// entry is placed in bucket ID % CCacheBuckets.  Conflicting entries are
+// resolved last-writer-wins using the stamps held in CacheVersions: Base is
+// the time at which the cache was last loaded from the db, and Stamps holds
+// the stamp of each change made since, removals included.  A key without a
+// stamp dates from Base.  Stamps are taken from the clock of the member where
+// a change originated, so the clocks of the group-members are expected to be
+// synchronized.
+//
+// An entry that is absent from a bucket pulled from a peer is removed locally
+// if the peer loaded its cache after the local entry was written.  Reloading
+// the caches of a single member from the db therefore repairs the caches of
+// the other members as they reconcile with it.
+
+// CCacheBuckets is the number of buckets in a CacheDigest.
+const CCacheBuckets = 16
+
+// CacheName identifies a reconcilable cache.
+type CacheName string
+
+// reconcilable caches
+const (
+	CCacheActUsrs    CacheName = "ACTUSRS"
+	CCacheGroupAuths CacheName = "GROUPAUTHS"
+	CCacheAuths      CacheName = "AUTHS"
+	CCacheUsrGroups  CacheName = "USRGROUPS"
+	CCacheUsrMembers CacheName = "USRMEMBERS"
+)
+
+// ReconcilableCache is implemented by the caches that take part in the
+// anti-entropy reconciliation.  The methods acquire the cache's lock.
+type ReconcilableCache interface {
+	Cache() CacheName
+	Digest() CacheDigest
+	Delta(buckets []int) CacheDelta
+	Merge(d CacheDelta) int
+}
+
+// ensure consistency against interface
+var _ ReconcilableCache = &ActUsrsH{}
+var _ ReconcilableCache = &GroupAuthsH{}
+var _ ReconcilableCache = &AuthsH{}
+var _ ReconcilableCache = &UsrGroupsH{}
+var _ ReconcilableCache = &UsrMembersH{}
+
+// CacheVersions holds the version stamps of a cache.  Stamps are unix
+// nanoseconds.
+type CacheVersions struct {
+	Base   int64
+	Stamps map[uint64]int64
+}
+
+// Reset discards the stamps of the cache following a load from the db that
+// was started at base.  The caller must hold the cache's write-lock.
+func (v *CacheVersions) Reset(base int64) {
+	v.Base = base
+	v.Stamps = make(map[uint64]int64)
+}
+
+// Touch records the stamp of a change to the entry with the specified ID.
+// The caller must hold the cache's write-lock.
+func (v *CacheVersions) Touch(id uint64, stamp int64) {
+	if v.Stamps == nil {
+		v.Stamps = make(map[uint64]int64)
+	}
+	v.Stamps[id] = stamp
+}
+
+// Stamp returns the stamp of the entry with the specified ID.
+func (v *CacheVersions) Stamp(id uint64) int64 {
+	if s, ok := v.Stamps[id]; ok {
+		return s
+	}
+	return v.Base
+}
+
+// CacheDigest holds the bucket hashes of a cache.
+type CacheDigest struct {
+	Cache   CacheName
+	Buckets [CCacheBuckets]uint64
+}
+
+// Diff returns the buckets in which the digests differ.
+func (d CacheDigest) Diff(o CacheDigest) []int {
+
+	var buckets []int
+	for i := range d.Buckets {
+		if d.Buckets[i] != o.Buckets[i] {
+			buckets = append(buckets, i)
+		}
+	}
+	return buckets
+}
+
+// CacheEntry is a cache entry in transit.  Live is false for a removed entry;
+// Name holds the AuthName, the GroupName, the GroupName of a GroupAuth, or the
+// encoded group-memberships of a Usr.
+type CacheEntry struct {
+	ID       uint64
+	Stamp    int64
+	Live     bool
+	Name     string
+	AuthName string // GroupAuths only
+	Scope    string // GroupAuths only
+}
+
+// value returns the content of the entry that is covered by the digest.
+// removed entries have an empty value, which sorts before all live entries.
+func (e CacheEntry) value() string {
+	if !e.Live {
+		return ""
+	}
+	return "+" + e.Name + "\x00" + e.AuthName + "\x00" + e.Scope
+}
+
+// CacheDelta holds the entries and removals of the requested buckets of a
+// cache, along with the time at which the cache was last loaded from the db.
+type CacheDelta struct {
+	Cache   CacheName
+	Base    int64
+	Buckets []int
+	Entries []CacheEntry
+}
+
+// digestOf hashes the live entries of a cache into buckets.
+func digestOf(cache CacheName, live map[uint64]CacheEntry) CacheDigest {
+
+	var ids [CCacheBuckets][]uint64
+	for id := range live {
+		b := id % CCacheBuckets
+		ids[b] = append(ids[b], id)
+	}
+
+	d := CacheDigest{Cache: cache}
+	for b := range ids {
+		if len(ids[b]) == 0 {
+			continue
+		}
+		sort.Slice(ids[b], func(i, j int) bool { return ids[b][i] < ids[b][j] })
+		h := sha256.New()
+		for _, id := range ids[b] {
+			h.Write([]byte(strconv.FormatUint(id, 10) + live[id].value() + "\n"))
+		}
+		d.Buckets[b] = binary.BigEndian.Uint64(h.Sum(nil))
+	}
+	return d
+}
+
+// deltaOf returns the live entries and the recorded removals of the specified
+// buckets of a cache.
+func deltaOf(cache CacheName, v *CacheVersions, live map[uint64]CacheEntry, buckets []int) CacheDelta {
+
+	d := CacheDelta{Cache: cache, Base: v.Base, Buckets: buckets}
+	in := make(map[uint64]bool)
+	for _, b := range buckets {
+		in[uint64(b)] = true
+	}
+	for id, e := range live {
+		if in[id%CCacheBuckets] {
+			e.Stamp = v.Stamp(id)
+			d.Entries = append(d.Entries, e)
+		}
+	}
+	for id, s := range v.Stamps {
+		if _, ok := live[id]; !ok && in[id%CCacheBuckets] {
+			d.Entries = append(d.Entries, CacheEntry{ID: id, Stamp: s})
+		}
+	}
+	return d
+}
+
+// mergeDelta applies the entries of d that are newer than their local
+// counterparts via apply, and returns the number of entries applied.  Equal
+// stamps are resolved in favour of the greater value so that all members
+// settle on the same entry.
+func mergeDelta(v *CacheVersions, live map[uint64]CacheEntry, d CacheDelta, apply func(e CacheEntry)) int {
+
+	n := 0
+	remote := make(map[uint64]bool)
+	for _, e := range d.Entries {
+		remote[e.ID] = true
+		l := live[e.ID]
+		if e.value() == l.value() {
+			continue
+		}
+		s := v.Stamp(e.ID)
+		if e.Stamp > s || (e.Stamp == s && e.value() > l.value()) {
+			apply(e)
+			v.Touch(e.ID, e.Stamp)
+			n++
+		}
+	}
+
+	// entries absent from the peer's buckets were removed by the peer, or
+	// were not in the db when the peer loaded its cache.
+	in := make(map[uint64]bool)
+	for _, b := range d.Buckets {
+		in[uint64(b)] = true
+	}
+	for id := range live {
+		if !in[id%CCacheBuckets] || remote[id] {
+			continue
+		}
+		if d.Base > v.Stamp(id) {
+			apply(CacheEntry{ID: id, Stamp: d.Base})
+			v.Touch(id, d.Base)
+			n++
+		}
+	}
+	return n
+}
+
+// Cache returns the name of the active-usrs cache.
+func (h *ActUsrsH) Cache() CacheName {
+	return CCacheActUsrs
+}
+
+// live returns the active usrs; inactive usrs are treated as absent.  The
+// caller must hold the lock.
+func (h *ActUsrsH) live() map[uint64]CacheEntry {
+
+	live := make(map[uint64]CacheEntry, len(h.ActiveUsrs))
+	for id, active := range h.ActiveUsrs {
+		if active {
+			live[id] = CacheEntry{ID: id, Live: true}
+		}
+	}
+	return live
+}
+
+// Digest returns the digest of the active-usrs cache.
+func (h *ActUsrsH) Digest() CacheDigest {
+	h.RLock()
+	defer h.RUnlock()
+	return digestOf(CCacheActUsrs, h.live())
+}
+
+// Delta returns the entries of the specified buckets of the active-usrs cache.
+func (h *ActUsrsH) Delta(buckets []int) CacheDelta {
+	h.RLock()
+	defer h.RUnlock()
+	return deltaOf(CCacheActUsrs, &h.Versions, h.live(), buckets)
+}
+
+// Merge applies a delta pulled from a peer to the active-usrs cache.
+func (h *ActUsrsH) Merge(d CacheDelta) int {
+
+	h.Lock()
+	defer h.Unlock()
+	return mergeDelta(&h.Versions, h.live(), d, func(e CacheEntry) {
+		if e.Live {
+			h.ActiveUsrs[e.ID] = true
+		} else {
+			delete(h.ActiveUsrs, e.ID)
+		}
+	})
+}
+
+// Cache returns the name of the auths cache.
+func (h *AuthsH) Cache() CacheName {
+	return CCacheAuths
+}
+
+// live returns the auths.  The caller must hold the lock.
+func (h *AuthsH) live() map[uint64]CacheEntry {
+
+	live := make(map[uint64]CacheEntry, len(h.Auths))
+	for id, n := range h.Auths {
+		live[id] = CacheEntry{ID: id, Live: true, Name: n}
+	}
+	return live
+}
+
+// Digest returns the digest of the auths cache.
+func (h *AuthsH) Digest() CacheDigest {
+	h.RLock()
+	defer h.RUnlock()
+	return digestOf(CCacheAuths, h.live())
+}
+
+// Delta returns the entries of the specified buckets of the auths cache.
+func (h *AuthsH) Delta(buckets []int) CacheDelta {
+	h.RLock()
+	defer h.RUnlock()
+	return deltaOf(CCacheAuths, &h.Versions, h.live(), buckets)
+}
+
+// Merge applies a delta pulled from a peer to the auths cache.
+func (h *AuthsH) Merge(d CacheDelta) int {
+
+	h.Lock()
+	defer h.Unlock()
+	return mergeDelta(&h.Versions, h.live(), d, func(e CacheEntry) {
+		if e.Live {
+			h.Auths[e.ID] = e.Name
+		} else {
+			delete(h.Auths, e.ID)
+		}
+	})
+}
+
+// Cache returns the name of the usrgroups cache.
+func (h *UsrGroupsH) Cache() CacheName {
+	return CCacheUsrGroups
+}
+
+// live returns the usrgroups.  The caller must hold the lock.
+func (h *UsrGroupsH) live() map[uint64]CacheEntry {
+
+	live := make(map[uint64]CacheEntry, len(h.GroupNames))
+	for id, n := range h.GroupNames {
+		live[id] = CacheEntry{ID: id, Live: true, Name: n}
+	}
+	return live
+}
+
+// Digest returns the digest of the usrgroups cache.
+func (h *UsrGroupsH) Digest() CacheDigest {
+	h.RLock()
+	defer h.RUnlock()
+	return digestOf(CCacheUsrGroups, h.live())
+}
+
+// Delta returns the entries of the specified buckets of the usrgroups cache.
+func (h *UsrGroupsH) Delta(buckets []int) CacheDelta {
+	h.RLock()
+	defer h.RUnlock()
+	return deltaOf(CCacheUsrGroups, &h.Versions, h.live(), buckets)
+}
+
+// Merge applies a delta pulled from a peer to the usrgroups cache.  The
+// renaming of a UsrGroup is not cascaded to the group-authorization cache,
+// which is reconciled in its own right.
+func (h *UsrGroupsH) Merge(d CacheDelta) int {
+
+	h.Lock()
+	defer h.Unlock()
+	return mergeDelta(&h.Versions, h.live(), d, func(e CacheEntry) {
+		if e.Live {
+			h.GroupNames[e.ID] = e.Name
+		} else {
+			delete(h.GroupNames, e.ID)
+		}
+	})
+}
+
+// Cache returns the name of the group-authorization cache.
+func (h *GroupAuthsH) Cache() CacheName {
+	return CCacheGroupAuths
+}
+
+// live returns the groupauths by ID.  The caller must hold the lock.
+func (h *GroupAuthsH) live() map[uint64]CacheEntry {
+
+	live := make(map[uint64]CacheEntry, len(h.GroupAuthsID))
+	for id, n := range h.GroupAuthsID {
+		live[id] = CacheEntry{
+			ID:       id,
+			Live:     true,
+			Name:     n.GroupName,
+			AuthName: n.AuthName,
+			Scope:    h.GroupAuthScopes[n.GroupName][n.AuthName],
+		}
+	}
+	return live
+}
+
+// Digest returns the digest of the group-authorization cache.
+func (h *GroupAuthsH) Digest() CacheDigest {
+	h.RLock()
+	defer h.RUnlock()
+	return digestOf(CCacheGroupAuths, h.live())
+}
+
+// Delta returns the entries of the specified buckets of the group-authorization
+// cache.
+func (h *GroupAuthsH) Delta(buckets []int) CacheDelta {
+	h.RLock()
+	defer h.RUnlock()
+	return deltaOf(CCacheGroupAuths, &h.Versions, h.live(), buckets)
+}
+
+// Merge applies a delta pulled from a peer to the group-authorization cache.
+// The GroupAuths and GroupAuthScopes maps are rebuilt from GroupAuthsID once
+// the entries have been applied.
+func (h *GroupAuthsH) Merge(d CacheDelta) int {
+
+	h.Lock()
+	defer h.Unlock()
+	n := mergeDelta(&h.Versions, h.live(), d, func(e CacheEntry) {
+		if e.Live {
+			h.GroupAuthsID[e.ID] = GroupAuthNames{GroupName: e.Name, AuthName: e.AuthName}
+			h.SetScope(e.Name, e.AuthName, e.Scope)
+		} else {
+			delete(h.GroupAuthsID, e.ID)
+		}
+	})
+	if n == 0 {
+		return 0
+	}
+
+	scopes := h.GroupAuthScopes
+	h.GroupAuths = make(map[string]map[string]bool)
+	h.GroupAuthScopes = make(map[string]map[string]string)
+	for _, ga := range h.GroupAuthsID {
+		if h.GroupAuths[ga.GroupName] == nil {
+			h.GroupAuths[ga.GroupName] = make(map[string]bool)
+		}
+		h.GroupAuths[ga.GroupName][ga.AuthName] = true
+		h.SetScope(ga.GroupName, ga.AuthName, scopes[ga.GroupName][ga.AuthName])
+	}
+	return n
+}
+
+// Cache returns the name of the usr group-membership cache.
+func (h *UsrMembersH) Cache() CacheName {
+	return CCacheUsrMembers
+}
+
+// live returns the group-memberships by Usr ID.  The caller must hold the
+// lock.
+func (h *UsrMembersH) live() map[uint64]CacheEntry {
+
+	live := make(map[uint64]CacheEntry, len(h.UsrGroupIDs))
+	for id, groupIDs := range h.UsrGroupIDs {
+		if len(groupIDs) > 0 {
+			live[id] = CacheEntry{ID: id, Live: true, Name: encodeUsrMembers(groupIDs, h.Windows[id])}
+		}
+	}
+	return live
+}
+
+// Digest returns the digest of the usr group-membership cache.
+func (h *UsrMembersH) Digest() CacheDigest {
+	h.RLock()
+	defer h.RUnlock()
+	return digestOf(CCacheUsrMembers, h.live())
+}
+
+// Delta returns the entries of the specified buckets of the usr group-membership
+// cache.
+func (h *UsrMembersH) Delta(buckets []int) CacheDelta {
+	h.RLock()
+	defer h.RUnlock()
+	return deltaOf(CCacheUsrMembers, &h.Versions, h.live(), buckets)
+}
+
+// Merge applies a delta pulled from a peer to the usr group-membership cache.
+// An entry that cannot be decoded is skipped.
+func (h *UsrMembersH) Merge(d CacheDelta) int {
+
+	h.Lock()
+	defer h.Unlock()
+	return mergeDelta(&h.Versions, h.live(), d, func(e CacheEntry) {
+		if !e.Live {
+			h.set(e.ID, nil, nil)
+			return
+		}
+		groupIDs, windows, err := decodeUsrMembers(e.Name)
+		if err != nil {
+			return
+		}
+		h.set(e.ID, groupIDs, windows)
+	})
+}
+
+// encodeUsrMembers encodes the group-memberships of a Usr as the sorted list
+// of UsrGroup IDs, each followed by its validity window if it is temporal;
+// e.g. "3,7@1600000000:1700000000".
+func encodeUsrMembers(groupIDs map[uint64]bool, windows map[uint64]UsrMemberWindow) string {
+
+	ids := make([]uint64, 0, len(groupIDs))
+	for id := range groupIDs {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	parts := make([]string, 0, len(ids))
+	for _, id := range ids {
+		p := strconv.FormatUint(id, 10)
+		if w, ok := windows[id]; ok {
+			p += "@" + strconv.FormatInt(w.ValidFrom, 10) + ":" + strconv.FormatInt(w.ValidTo, 10)
+		}
+		parts = append(parts, p)
+	}
+	return strings.Join(parts, ",")
+}
+
+// decodeUsrMembers reverses encodeUsrMembers.
+func decodeUsrMembers(s string) ([]uint64, map[uint64]UsrMemberWindow, error) {
+
+	var groupIDs []uint64
+	windows := make(map[uint64]UsrMemberWindow)
+	for _, p := range strings.Split(s, ",") {
+		w := ""
+		if i := strings.IndexByte(p, '@'); i >= 0 {
+			p, w = p[:i], p[i+1:]
+		}
+		id, err := strconv.ParseUint(p, 10, 64)
+		if err != nil {
+			return nil, nil, err
+		}
+		groupIDs = append(groupIDs, id)
+		if w == "" {
+			continue
+		}
+		i := strings.IndexByte(w, ':')
+		if i < 0 {
+			return nil, nil, fmt.Errorf("malformed membership window %q", w)
+		}
+		var mw UsrMemberWindow
+		if mw.ValidFrom, err = strconv.ParseInt(w[:i], 10, 64); err != nil {
+			return nil, nil, err
+		}
+		if mw.ValidTo, err = strconv.ParseInt(w[i+1:], 10, 64); err != nil {
+			return nil, nil, err
+		}
+		windows[id] = mw
+	}
+	return groupIDs, windows, nil
+}
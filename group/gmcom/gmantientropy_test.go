@@ -0,0 +1,199 @@
+package gmcom
+
+import (
+	"reflect"
+	"testing"
+)
+
+// pull reconciles dst with src in the way a group-member reconciles its caches
+// with a peer.
+func pull(dst, src ReconcilableCache) int {
+
+	buckets := src.Digest().Diff(dst.Digest())
+	if len(buckets) == 0 {
+		return 0
+	}
+	return dst.Merge(src.Delta(buckets))
+}
+
+func newAuthsH(base int64, auths map[uint64]string) *AuthsH {
+	h := &AuthsH{Auths: auths}
+	h.Versions.Reset(base)
+	return h
+}
+
+func TestCacheDigest(t *testing.T) {
+
+	a := newAuthsH(100, map[uint64]string{1: "usr.GET", 17: "usr.CREATE", 2: "auth.GET"})
+	b := newAuthsH(200, map[uint64]string{1: "usr.GET", 17: "usr.CREATE", 2: "auth.GET"})
+
+	// the stamps are not part of the digest
+	b.Versions.Touch(2, 300)
+	if d := a.Digest().Diff(b.Digest()); len(d) != 0 {
+		t.Errorf("got differing buckets %v; want none", d)
+	}
+
+	b.Auths[17] = "usr.UPDATE"
+	if d := a.Digest().Diff(b.Digest()); !reflect.DeepEqual(d, []int{1}) {
+		t.Errorf("got differing buckets %v; want [1]", d)
+	}
+}
+
+func TestCacheMerge(t *testing.T) {
+
+	a := newAuthsH(100, map[uint64]string{1: "usr.GET", 2: "auth.GET", 3: "book.GET"})
+	b := newAuthsH(100, map[uint64]string{1: "usr.GET", 2: "auth.GET", 3: "book.GET"})
+
+	// b missed the creation of 4, the renaming of 1 and the deletion of 3
+	a.Auths[4] = "library.GET"
+	a.Versions.Touch(4, 110)
+	a.Auths[1] = "usr.GET_ID"
+	a.Versions.Touch(1, 120)
+	delete(a.Auths, 3)
+	a.Versions.Touch(3, 130)
+
+	// b renamed 2 after a did
+	a.Auths[2] = "auth.GET_SET"
+	a.Versions.Touch(2, 140)
+	b.Auths[2] = "auth.GET_ID"
+	b.Versions.Touch(2, 150)
+
+	if n := pull(b, a); n != 3 {
+		t.Errorf("got %d entries applied to b; want 3", n)
+	}
+	if n := pull(a, b); n != 1 {
+		t.Errorf("got %d entries applied to a; want 1", n)
+	}
+	want := map[uint64]string{1: "usr.GET_ID", 2: "auth.GET_ID", 4: "library.GET"}
+	if !reflect.DeepEqual(a.Auths, want) || !reflect.DeepEqual(b.Auths, want) {
+		t.Errorf("got %v and %v; want %v", a.Auths, b.Auths, want)
+	}
+	if n := pull(a, b) + pull(b, a); n != 0 {
+		t.Errorf("got %d entries applied after convergence; want 0", n)
+	}
+}
+
+func TestCacheMergeReload(t *testing.T) {
+
+	// a reloaded its cache from the db after b's entries were written
+	a := newAuthsH(200, map[uint64]string{1: "usr.GET", 2: "auth.GET"})
+	b := newAuthsH(100, map[uint64]string{1: "usr.GET", 2: "auth.STALE", 5: "gone.GET"})
+	b.Versions.Touch(5, 150)
+
+	pull(b, a)
+	want := map[uint64]string{1: "usr.GET", 2: "auth.GET"}
+	if !reflect.DeepEqual(b.Auths, want) {
+		t.Errorf("got %v; want %v", b.Auths, want)
+	}
+
+	// an entry written after the reload survives
+	b.Auths[6] = "new.GET"
+	b.Versions.Touch(6, 250)
+	pull(b, a)
+	pull(a, b)
+	want[6] = "new.GET"
+	if !reflect.DeepEqual(a.Auths, want) || !reflect.DeepEqual(b.Auths, want) {
+		t.Errorf("got %v and %v; want %v", a.Auths, b.Auths, want)
+	}
+}
+
+func TestCacheMergeTie(t *testing.T) {
+
+	a := newAuthsH(100, map[uint64]string{1: "usr.A"})
+	b := newAuthsH(100, map[uint64]string{1: "usr.B"})
+
+	// equal stamps are resolved in favour of the greater value on both sides
+	pull(a, b)
+	pull(b, a)
+	if a.Auths[1] != "usr.B" || b.Auths[1] != "usr.B" {
+		t.Errorf("got %q and %q; want usr.B", a.Auths[1], b.Auths[1])
+	}
+}
+
+func TestActUsrsMerge(t *testing.T) {
+
+	a := &ActUsrsH{ActiveUsrs: map[uint64]bool{1: true, 2: false}}
+	a.Versions.Reset(100)
+	b := &ActUsrsH{ActiveUsrs: map[uint64]bool{1: true, 2: true}}
+	b.Versions.Reset(100)
+
+	// usr 2 was deactivated; inactive usrs are treated as absent
+	a.Versions.Touch(2, 110)
+	pull(b, a)
+	if b.ActiveUsrs[2] {
+		t.Errorf("got usr 2 active; want inactive")
+	}
+	if d := a.Digest().Diff(b.Digest()); len(d) != 0 {
+		t.Errorf("got differing buckets %v; want none", d)
+	}
+}
+
+func TestGroupAuthsMerge(t *testing.T) {
+
+	newGroupAuthsH := func() *GroupAuthsH {
+		h := &GroupAuthsH{
+			GroupAuths:      map[string]map[string]bool{"Readers": {"book.GET": true}},
+			GroupAuthsID:    map[uint64]GroupAuthNames{1: {GroupName: "Readers", AuthName: "book.GET"}},
+			GroupAuthScopes: make(map[string]map[string]string),
+		}
+		h.Versions.Reset(100)
+		return h
+	}
+	a := newGroupAuthsH()
+	b := newGroupAuthsH()
+
+	// a renamed the group and scoped its auth; b missed both
+	a.GroupAuths = map[string]map[string]bool{"Patrons": {"book.GET": true}}
+	a.GroupAuthsID[1] = GroupAuthNames{GroupName: "Patrons", AuthName: "book.GET"}
+	a.SetScope("Patrons", "book.GET", "library_id = 1")
+	a.Versions.Touch(1, 110)
+
+	if n := pull(b, a); n != 1 {
+		t.Fatalf("got %d entries applied; want 1", n)
+	}
+	if !reflect.DeepEqual(b.GroupAuths, a.GroupAuths) || !reflect.DeepEqual(b.GroupAuthScopes, a.GroupAuthScopes) {
+		t.Errorf("got %v, %v; want %v, %v", b.GroupAuths, b.GroupAuthScopes, a.GroupAuths, a.GroupAuthScopes)
+	}
+	if scopes, _ := b.Scopes("book.GET", []string{"Patrons"}); !reflect.DeepEqual(scopes, []string{"library_id = 1"}) {
+		t.Errorf("got scopes %v", scopes)
+	}
+}
+
+func TestUsrMembersMerge(t *testing.T) {
+
+	newUsrMembersH := func() *UsrMembersH {
+		h := &UsrMembersH{
+			UsrGroupIDs: map[uint64]map[uint64]bool{1: {10: true}, 2: {10: true, 11: true}, 3: {12: true}},
+			Windows:     make(map[uint64]map[uint64]UsrMemberWindow),
+		}
+		h.Versions.Reset(100)
+		return h
+	}
+	a := newUsrMembersH()
+	b := newUsrMembersH()
+
+	// b missed the temporal membership of usr 1, the removal of usr 2 from
+	// group 11 and the removal of all memberships of usr 3
+	a.Set(1, []uint64{10, 11}, map[uint64]UsrMemberWindow{11: {ValidFrom: -60, ValidTo: 1700000000}}, 110)
+	a.Set(2, []uint64{10}, nil, 120)
+	a.Set(3, nil, nil, 130)
+
+	if n := pull(b, a); n != 3 {
+		t.Fatalf("got %d entries applied; want 3", n)
+	}
+	if !reflect.DeepEqual(b.UsrGroupIDs, a.UsrGroupIDs) || !reflect.DeepEqual(b.Windows, a.Windows) {
+		t.Errorf("got %v, %v; want %v, %v", b.UsrGroupIDs, b.Windows, a.UsrGroupIDs, a.Windows)
+	}
+	if d := a.Digest().Diff(b.Digest()); len(d) != 0 {
+		t.Errorf("got differing buckets %v; want none", d)
+	}
+
+	// a membership that b changed later is kept
+	b.Set(2, []uint64{10, 12}, nil, 140)
+	if n := pull(b, a); n != 0 {
+		t.Errorf("got %d entries applied; want 0", n)
+	}
+	if n := pull(a, b); n != 1 || !a.UsrGroupIDs[2][12] {
+		t.Errorf("got %d entries applied and %v; want usr 2 in group 12", n, a.UsrGroupIDs[2])
+	}
+}
@@ -0,0 +1,93 @@
+package gmcom
+
+import (
+	"sort"
+)
+
+// AuthReason identifies the outcome of an authorization check.
+type AuthReason string
+
+const (
+	AuthGranted      AuthReason = "GRANTED"
+	AuthNoGroupAuths AuthReason = "NO_GROUP_AUTHS"
+	AuthUsrInactive  AuthReason = "USR_INACTIVE"
+	AuthNoGroups     AuthReason = "NO_GROUPS"
+	AuthMissing      AuthReason = "AUTH_MISSING"
+)
+
+// AuthDecision describes why an authorization check allowed or denied access.
+// GrantedBy lists the usr's groups that hold the auth.
+type AuthDecision struct {
+	Allowed   bool
+	Reason    AuthReason
+	GrantedBy []string
+}
+
+// AuthGrant is a single auth granted to a usr by way of one of its groups.
+type AuthGrant struct {
+	AuthName  string
+	GroupName string
+	Scope     string
+}
+
+// DecideAuth determines whether the usr with the specified ID and group
+// memberships holds the auth.  This is the decision made by the auth
+// middleware for each protected route.
+func DecideAuth(authName string, groups []string, id uint64, actUsrs *ActUsrsH, groupAuths *GroupAuthsH) AuthDecision {
+
+	groupAuths.RLock()
+	defer groupAuths.RUnlock()
+
+	// no group auths setup - disallow all access
+	if len(groupAuths.GroupAuths) == 0 {
+		return AuthDecision{Reason: AuthNoGroupAuths}
+	}
+
+	// is the user active?
+	actUsrs.RLock()
+	active := actUsrs.ActiveUsrs[id]
+	actUsrs.RUnlock()
+	if !active {
+		return AuthDecision{Reason: AuthUsrInactive}
+	}
+
+	if len(groups) == 0 {
+		return AuthDecision{Reason: AuthNoGroups}
+	}
+
+	d := AuthDecision{Reason: AuthMissing}
+	for _, g := range groups {
+		if groupAuths.GroupAuths[g][authName] {
+			d.Allowed = true
+			d.Reason = AuthGranted
+			d.GrantedBy = append(d.GrantedBy, g)
+		}
+	}
+	sort.Strings(d.GrantedBy)
+	return d
+}
+
+// Grants returns the auths held by the supplied groups ordered by auth and
+// group name.
+func (h *GroupAuthsH) Grants(groups []string) []AuthGrant {
+
+	h.RLock()
+	defer h.RUnlock()
+
+	grants := []AuthGrant{}
+	for _, g := range groups {
+		for a, ok := range h.GroupAuths[g] {
+			if !ok {
+				continue
+			}
+			grants = append(grants, AuthGrant{AuthName: a, GroupName: g, Scope: h.GroupAuthScopes[g][a]})
+		}
+	}
+	sort.Slice(grants, func(i, j int) bool {
+		if grants[i].AuthName == grants[j].AuthName {
+			return grants[i].GroupName < grants[j].GroupName
+		}
+		return grants[i].AuthName < grants[j].AuthName
+	})
+	return grants
+}
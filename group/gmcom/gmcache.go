@@ -2,6 +2,7 @@ package gmcom
 
 import (
 	"sync"
+	"time"
 )
 
 type OpType string
@@ -17,13 +18,16 @@ const (
 type ActUsrsH struct {
 	sync.RWMutex
 	ActiveUsrs map[uint64]bool
+	Versions   CacheVersions
 }
 
 // ActUsrD is a carrier structure for disseminating USRUPDATE messages to group-members.
+// Stamp is set by the group-member where the change originated; see gmantientropy.go.
 type ActUsrD struct {
 	Forward bool
 	ID      uint64
 	Active  bool
+	Stamp   int64
 }
 
 // GroupAuthNames is used in the GroupAuthsID map (enable deletion)
@@ -33,10 +37,14 @@ type GroupAuthNames struct {
 }
 
 // GroupAuthsH is used as the runtime-type of the group-authorization cache on the GroupAuth controller.
+// GroupAuthScopes holds the data-scope expressions of scoped GroupAuths; an auth that is not
+// present in a group's scope map is unrestricted.
 type GroupAuthsH struct {
 	sync.RWMutex
-	GroupAuths   map[string]map[string]bool
-	GroupAuthsID map[uint64]GroupAuthNames
+	GroupAuths      map[string]map[string]bool
+	GroupAuthsID    map[uint64]GroupAuthNames
+	GroupAuthScopes map[string]map[string]string // map[groupName]map[authName]scope
+	Versions        CacheVersions                // by GroupAuth ID
 }
 
 // GroupAuthD is a carrier structure for disseminating GROUPAUTHUPDATE messages to group-members.
@@ -47,13 +55,55 @@ type GroupAuthD struct {
 	AuthName  string
 	GroupID   uint64
 	AuthID    uint64
+	Scope     string
 	Op        OpType
+	Stamp     int64
+}
+
+// SetScope records the data-scope of an auth allocated to a group.  An empty
+// scope removes the restriction.  The caller must hold the write-lock.
+func (h *GroupAuthsH) SetScope(groupName, authName, scope string) {
+
+	if h.GroupAuthScopes == nil {
+		h.GroupAuthScopes = make(map[string]map[string]string)
+	}
+	if scope == "" {
+		delete(h.GroupAuthScopes[groupName], authName)
+		return
+	}
+	mapScope := h.GroupAuthScopes[groupName]
+	if mapScope == nil {
+		mapScope = make(map[string]string)
+		h.GroupAuthScopes[groupName] = mapScope
+	}
+	mapScope[authName] = scope
+}
+
+// Scopes returns the data-scopes under which the supplied groups grant the
+// auth.  unrestricted is true if at least one of the groups grants the auth
+// without a data-scope.
+func (h *GroupAuthsH) Scopes(authName string, groups []string) (scopes []string, unrestricted bool) {
+
+	h.RLock()
+	defer h.RUnlock()
+	for _, g := range groups {
+		if !h.GroupAuths[g][authName] {
+			continue
+		}
+		s, ok := h.GroupAuthScopes[g][authName]
+		if !ok {
+			return nil, true
+		}
+		scopes = append(scopes, s)
+	}
+	return scopes, false
 }
 
 // AuthsH is used as the runtime-type of the authorization-description cache on the Auth controller.
 type AuthsH struct {
 	sync.RWMutex
-	Auths map[uint64]string
+	Auths    map[uint64]string
+	Versions CacheVersions
 }
 
 // AuthD is a carrier structure for disseminating AUTHUPDATE messages to group-members.
@@ -62,12 +112,14 @@ type AuthD struct {
 	ID       uint64
 	AuthName string
 	Op       OpType
+	Stamp    int64
 }
 
 // UsrGroupsH is used as the runtime-type of the usrgroup-description cache on the UsrGroup controller.
 type UsrGroupsH struct {
 	sync.RWMutex
 	GroupNames map[uint64]string
+	Versions   CacheVersions
 }
 
 // UsrGroupD is a carrier structure for disseminating USRGROUPUPDATE messages to group-members.
@@ -76,4 +128,108 @@ type UsrGroupD struct {
 	ID        uint64
 	GroupName string
 	Op        OpType
+	Stamp     int64
+}
+
+// UsrMembersH is used as the runtime-type of the usr group-membership cache on the Usr
+// controller.  Memberships are held by UsrGroup ID so that the renaming of a UsrGroup
+// does not affect them; names are resolved via the UsrGroupsH cache.  Windows holds
+// the validity windows of temporal memberships only.
+type UsrMembersH struct {
+	sync.RWMutex
+	UsrGroupIDs map[uint64]map[uint64]bool            // map[usrID]map[usrGroupID]bool
+	Windows     map[uint64]map[uint64]UsrMemberWindow // map[usrID]map[usrGroupID]UsrMemberWindow
+	Versions    CacheVersions                         // by Usr ID
+}
+
+// UsrMemberWindow is the validity window of a temporal group-membership in
+// unix seconds.  A zero value leaves the respective end of the window open.
+type UsrMemberWindow struct {
+	ValidFrom int64
+	ValidTo   int64
+}
+
+// Contains reports whether the window contains the unix time t.
+func (w UsrMemberWindow) Contains(t int64) bool {
+	if w.ValidFrom != 0 && t < w.ValidFrom {
+		return false
+	}
+	if w.ValidTo != 0 && t >= w.ValidTo {
+		return false
+	}
+	return true
+}
+
+// UsrMemberD is a carrier structure for disseminating USRMEMBERUPDATE messages to
+// group-members.  GroupIDs replaces the complete set of memberships of the Usr; an
+// empty set removes the Usr from the cache.  Windows holds the validity windows of
+// the temporal memberships in GroupIDs.
+type UsrMemberD struct {
+	Forward  bool
+	UsrID    uint64
+	GroupIDs []uint64
+	Windows  map[uint64]UsrMemberWindow // map[usrGroupID]UsrMemberWindow
+	Stamp    int64
+}
+
+// Set replaces the group-memberships of the specified usr and records the
+// stamp of the change.  windows may be nil if none of the memberships is
+// temporal.
+func (h *UsrMembersH) Set(usrID uint64, groupIDs []uint64, windows map[uint64]UsrMemberWindow, stamp int64) {
+
+	h.Lock()
+	defer h.Unlock()
+	h.set(usrID, groupIDs, windows)
+	h.Versions.Touch(usrID, stamp)
+}
+
+// set replaces the group-memberships of the specified usr.  The caller must
+// hold the write-lock.
+func (h *UsrMembersH) set(usrID uint64, groupIDs []uint64, windows map[uint64]UsrMemberWindow) {
+
+	if h.UsrGroupIDs == nil {
+		h.UsrGroupIDs = make(map[uint64]map[uint64]bool)
+	}
+	if h.Windows == nil {
+		h.Windows = make(map[uint64]map[uint64]UsrMemberWindow)
+	}
+	delete(h.Windows, usrID)
+	if len(groupIDs) == 0 {
+		delete(h.UsrGroupIDs, usrID)
+		return
+	}
+	m := make(map[uint64]bool)
+	for _, id := range groupIDs {
+		m[id] = true
+		if w, ok := windows[id]; ok {
+			if h.Windows[usrID] == nil {
+				h.Windows[usrID] = make(map[uint64]UsrMemberWindow)
+			}
+			h.Windows[usrID][id] = w
+		}
+	}
+	h.UsrGroupIDs[usrID] = m
+}
+
+// GroupNames returns the names of the UsrGroups that the specified usr is
+// presently a member of.  Temporal memberships are only included within
+// their validity window.
+func (h *UsrMembersH) GroupNames(usrID uint64, usrGroups *UsrGroupsH) []string {
+
+	h.RLock()
+	defer h.RUnlock()
+	usrGroups.RLock()
+	defer usrGroups.RUnlock()
+
+	now := time.Now().Unix()
+	names := make([]string, 0, len(h.UsrGroupIDs[usrID]))
+	for id := range h.UsrGroupIDs[usrID] {
+		if w, ok := h.Windows[usrID][id]; ok && !w.Contains(now) {
+			continue
+		}
+		if n, ok := usrGroups.GroupNames[id]; ok {
+			names = append(names, n)
+		}
+	}
+	return names
 }
@@ -23,6 +23,7 @@ const (
 	CCoordinator = "COORDINATOR"
 	COkay        = "OKAY"
 	CDeparting   = "DEPARTING"
+	CPingReq     = "PINGREQ"
 )
 
 const (
@@ -40,6 +41,7 @@ const (
 	CinNoAck            = "inNoAck"
 	CinDoSendPrep       = "inDoSendPrep"
 	CinFlushMemberMap   = "inFlushMemberMap"
+	CinGetUpdates       = "inGetUpdates"
 )
 
 // election 'error' codes
@@ -87,16 +89,23 @@ type GMMessage struct {
 	SubjectIPAddress        string // ping-ack etc.
 	ExpectResponse          bool
 	MemberMap               *OMap
+	Updates                 []GMMember // membership changes piggybacked on a PING
 	CheckElection           bool
 	InElection              bool
 	ErrCode                 string // optional error code
 	Value                   []byte // general carrier field - use Type as a guide to decode
+	ProtocolVersion         uint   // highest wire-protocol version spoken by the sender
+	LeaderEpoch             uint64 // epoch of the leader known to the sender
 }
 
 // GMLeader holds leader information and is used during the initialization of a process.
+// Epoch is the fencing epoch of the leader; it is advanced each time a process
+// claims the leadership, so a leader holding a lower epoch than another process
+// has been superseded.
 type GMLeader struct {
 	LeaderID        uint
 	LeaderIPAddress string
+	Epoch           uint64
 }
 
 // GMTarget is a convenience struct to hold target process information
@@ -109,16 +118,24 @@ type GMTarget struct {
 // record.  Applications using this library may choose to store the persisted 'current'
 // leader in a number of ways; flat-file, db table record, NVS etc.  GMLeaderSetterGetter
 // is used as a parameter in the GMServ.Serve(...) method.
+//
+// CompareAndSetDBLeader is used when a process claims the leadership.  It must
+// store l only if the Epoch of the persisted leader record is still epoch, and
+// report whether l was stored; a missing record has an Epoch of 0.  Of several
+// processes claiming the leadership concurrently, only one can succeed.
 type GMLeaderSetterGetter interface {
 	GetDBLeader() (*GMLeader, error)
 	SetDBLeader(l GMLeader) error
+	CompareAndSetDBLeader(l GMLeader, epoch uint64) (bool, error)
 	Cleanup() error
 }
 
 // EncodeGMMessage is used to gob-encode a GMMessage for transmission
-// to interested parties.
+// to interested parties.  The encoded message announces the protocol
+// version of the sender.
 func EncodeGMMessage(m GMMessage) ([]byte, error) {
 
+	m.ProtocolVersion = CProtocolVersion
 	encBuf := new(bytes.Buffer)
 	err := gob.NewEncoder(encBuf).Encode(m)
 	if err != nil {
@@ -128,21 +145,12 @@ func EncodeGMMessage(m GMMessage) ([]byte, error) {
 }
 
 // DecodeGMMessage is used to decode any incoming GMMessage from
-// its gob-encoded format into something usable.
+// its framed or legacy gob-encoded format into something usable.
 func DecodeGMMessage(ws *websocket.Conn) (*GMMessage, error) {
 
-	// gob decoding
-	var m GMMessage
-	var msg = make([]byte, 2048)
-	l, err := ws.Read(msg)
+	m, _, err := ReadGMMessage(ws)
 	if err != nil {
-		return nil, fmt.Errorf("DecodeGMMessage() ws.Read() error: %s", err)
+		return nil, fmt.Errorf("DecodeGMMessage() %s", err)
 	}
-	raw := msg[0:l]
-	decBuf := bytes.NewBuffer(raw)
-	err = gob.NewDecoder(decBuf).Decode(&m)
-	if err != nil {
-		return nil, fmt.Errorf("DecodeGMMessage() gob.Decode() error: %s", err)
-	}
-	return &m, nil
+	return m, nil
 }
@@ -12,6 +12,8 @@ const (
 	CerrJoinUnknownErr        = "errJoinUnknownErr"
 	CerrPingIncorrectReceiver = "errPingIncorrectReceiver"
 	CerrMemberMapFlushFailed  = "errMemberMapFlushFailed"
+	CerrPingReqNoAck          = "errPingReqNoAck"
+	CerrLeaderClaimLost       = "errLeaderClaimLost"
 )
 
 // JoinError is used to differentiate between join failures
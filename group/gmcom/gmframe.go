@@ -0,0 +1,128 @@
+package gmcom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+
+	"golang.org/x/net/websocket"
+)
+
+// GMMessage wire-protocol versions.  CProtocolLegacy messages are bare gob
+// payloads, which releases prior to CProtocolFramed read with a single 2048 byte
+// ws.Read; larger messages are truncated by such readers.  CProtocolFramed
+// messages carry a header holding the protocol version and the length of the
+// gob payload, and may be of any size.
+//
+// Each GMMessage carries the highest version spoken by its sender.  A process
+// sends CProtocolLegacy messages to peers it has not yet heard from, and replies
+// in the version announced by the requester; JoinHandler therefore settles the
+// version spoken between a joining process and the leader during the JOIN.
+// Incoming messages are accepted in either version, so mixed-version groups
+// keep working during a rolling upgrade.
+//
+// CProtocolSWIM uses the CProtocolFramed wire format.  PINGs sent to processes
+// speaking it carry piggybacked membership changes in place of the member map,
+// and such processes serve PINGREQ messages; see gmpiggyback.go.
+//
+// Processes speaking CProtocolAntiEntropy reconcile their authorization caches
+// with one another; see gmantientropy.go.
+const (
+	CProtocolLegacy      uint = 1
+	CProtocolFramed      uint = 2
+	CProtocolSWIM        uint = 3
+	CProtocolAntiEntropy uint = 4
+	CProtocolVersion          = CProtocolAntiEntropy
+)
+
+// frame header: magic(3) | version(1) | payload length(4, big-endian).  A gob
+// stream never starts with a 0x00 byte, so framed and legacy messages can be
+// told apart.
+var frameMagic = []byte{0x00, 'G', 'M'}
+
+const frameHeaderLen = 8
+
+// NegotiateProtocol returns the version to be used with a peer announcing
+// version v; peers that do not announce a version speak CProtocolLegacy.
+func NegotiateProtocol(v uint) uint {
+
+	switch {
+	case v == 0:
+		return CProtocolLegacy
+	case v > CProtocolVersion:
+		return CProtocolVersion
+	}
+	return v
+}
+
+// FrameGMMessage encodes a GMMessage for transmission to a peer speaking the
+// specified protocol version.
+func FrameGMMessage(m GMMessage, version uint) ([]byte, error) {
+
+	raw, err := EncodeGMMessage(m)
+	if err != nil {
+		return nil, err
+	}
+	if version < CProtocolFramed {
+		return raw, nil
+	}
+
+	b := make([]byte, frameHeaderLen, frameHeaderLen+len(raw))
+	copy(b, frameMagic)
+	b[3] = byte(CProtocolFramed)
+	binary.BigEndian.PutUint32(b[4:frameHeaderLen], uint32(len(raw)))
+	return append(b, raw...), nil
+}
+
+// UnframeGMMessage decodes a framed or legacy GMMessage and returns it along
+// with the protocol version it was sent in.
+func UnframeGMMessage(b []byte) (*GMMessage, uint, error) {
+
+	version := CProtocolLegacy
+	raw := b
+	if len(b) >= len(frameMagic) && bytes.Equal(b[:len(frameMagic)], frameMagic) {
+		if len(b) < frameHeaderLen {
+			return nil, 0, fmt.Errorf("GMMessage frame header is truncated")
+		}
+		version = uint(b[3])
+		if version < CProtocolFramed || version > CProtocolVersion {
+			return nil, 0, fmt.Errorf("GMMessage frame has unsupported protocol version %d", version)
+		}
+		l := binary.BigEndian.Uint32(b[4:frameHeaderLen])
+		raw = b[frameHeaderLen:]
+		if uint64(len(raw)) != uint64(l) {
+			return nil, 0, fmt.Errorf("GMMessage frame length mismatch; header %d, payload %d", l, len(raw))
+		}
+	}
+
+	var m GMMessage
+	err := gob.NewDecoder(bytes.NewBuffer(raw)).Decode(&m)
+	if err != nil {
+		return nil, 0, fmt.Errorf("GMMessage gob.Decode() error: %s", err)
+	}
+	return &m, version, nil
+}
+
+// ReadGMMessage reads a complete framed or legacy GMMessage from the websocket
+// and returns it along with the protocol version it was sent in.  The seal of
+// the message is verified if a cluster key is set.
+func ReadGMMessage(ws *websocket.Conn) (*GMMessage, uint, error) {
+
+	b, err := ReceivePayload(ws)
+	if err != nil {
+		return nil, 0, fmt.Errorf("ReadGMMessage() ws.Read() error: %s", err)
+	}
+	return UnframeGMMessage(b)
+}
+
+// WriteGMMessage sends a GMMessage over the websocket in the specified
+// protocol version, sealed with the cluster key if one is set.
+func WriteGMMessage(ws *websocket.Conn, m GMMessage, version uint) error {
+
+	b, err := FrameGMMessage(m, version)
+	if err != nil {
+		return err
+	}
+	return SendPayload(ws, b)
+}
@@ -0,0 +1,107 @@
+package gmcom
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/websocket"
+)
+
+// largeGMMessage returns a PING carrying a MemberMap that encodes to well
+// over 2048 bytes.
+func largeGMMessage(t *testing.T) GMMessage {
+
+	mm := &OMap{}
+	mm.Init()
+	for i := uint(1); i <= 100; i++ {
+		err := mm.Add(i, GMMember{ID: i, IPAddress: fmt.Sprintf("192.168.1.%d:4444", i), Status: CStatusAlive, StatusCount: 1, IncarnationNumber: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return GMMessage{Type: CPing, SrcID: 1, SrcIPAddress: "192.168.1.1:4444", MemberMap: mm}
+}
+
+func TestFrameGMMessage(t *testing.T) {
+
+	m := largeGMMessage(t)
+	for _, version := range []uint{CProtocolLegacy, CProtocolFramed} {
+		b, err := FrameGMMessage(m, version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(b) <= 2048 {
+			t.Fatalf("version %d: message of %d bytes is too small for the test", version, len(b))
+		}
+		got, gotVersion, err := UnframeGMMessage(b)
+		if err != nil {
+			t.Fatalf("version %d: %v", version, err)
+		}
+		if gotVersion != version || got.ProtocolVersion != CProtocolVersion {
+			t.Errorf("version %d: got wire version %d, announced %d", version, gotVersion, got.ProtocolVersion)
+		}
+		if got.MemberMap.Count() != 100 {
+			t.Errorf("version %d: got %d members; want 100", version, got.MemberMap.Count())
+		}
+	}
+
+	b, _ := FrameGMMessage(m, CProtocolFramed)
+	if _, _, err := UnframeGMMessage(b[:len(b)-10]); err == nil {
+		t.Error("a truncated frame should be rejected")
+	}
+	b[3] = byte(CProtocolVersion + 1)
+	if _, _, err := UnframeGMMessage(b); err == nil || !strings.Contains(err.Error(), "unsupported") {
+		t.Errorf("a frame of an unknown version should be rejected; got %v", err)
+	}
+}
+
+func TestNegotiateProtocol(t *testing.T) {
+
+	tests := []struct{ announced, want uint }{
+		{0, CProtocolLegacy},
+		{CProtocolLegacy, CProtocolLegacy},
+		{CProtocolFramed, CProtocolFramed},
+		{CProtocolVersion + 5, CProtocolVersion},
+	}
+	for _, tt := range tests {
+		if got := NegotiateProtocol(tt.announced); got != tt.want {
+			t.Errorf("NegotiateProtocol(%d) = %d; want %d", tt.announced, got, tt.want)
+		}
+	}
+}
+
+func TestReadWriteGMMessage(t *testing.T) {
+
+	srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		m, version, err := ReadGMMessage(ws)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		m.Type = CAck
+		WriteGMMessage(ws, *m, version)
+	}))
+	defer srv.Close()
+
+	m := largeGMMessage(t)
+	for _, version := range []uint{CProtocolLegacy, CProtocolFramed} {
+		ws, err := websocket.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), "", "http://localhost/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = WriteGMMessage(ws, m, version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, gotVersion, err := ReadGMMessage(ws)
+		ws.Close()
+		if err != nil {
+			t.Fatalf("version %d: %v", version, err)
+		}
+		if r.Type != CAck || gotVersion != version || r.MemberMap.Count() != 100 {
+			t.Errorf("version %d: got %s in version %d with %d members", version, r.Type, gotVersion, r.MemberMap.Count())
+		}
+	}
+}
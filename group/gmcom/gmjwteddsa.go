@@ -0,0 +1,101 @@
+package gmcom
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// SigningMethodEdDSA implements the RFC 8037 EdDSA (Ed25519) jwt signing-method,
+// which is not provided by jwt-go v3.  The method is registered under the alg
+// name 'EdDSA' so that jwt.GetSigningMethod and jwt.Parse are aware of it.
+type SigningMethodEdDSA struct{}
+
+// EdDSA is the one and only instance of SigningMethodEdDSA
+var EdDSA = &SigningMethodEdDSA{}
+
+func init() {
+	jwt.RegisterSigningMethod(EdDSA.Alg(), func() jwt.SigningMethod {
+		return EdDSA
+	})
+}
+
+// Alg returns the jwt alg header value of the signing-method.
+func (m *SigningMethodEdDSA) Alg() string {
+	return "EdDSA"
+}
+
+// Verify checks the base64url-encoded signature of signingString with an
+// ed25519.PublicKey.
+func (m *SigningMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+	if len(pub) != ed25519.PublicKeySize {
+		return jwt.ErrInvalidKey
+	}
+
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(pub, []byte(signingString), sig) {
+		return fmt.Errorf("ed25519: signature is invalid")
+	}
+	return nil
+}
+
+// Sign signs signingString with an ed25519.PrivateKey and returns the
+// base64url-encoded signature.
+func (m *SigningMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+	if len(priv) != ed25519.PrivateKeySize {
+		return "", jwt.ErrInvalidKey
+	}
+	return jwt.EncodeSegment(ed25519.Sign(priv, []byte(signingString))), nil
+}
+
+// ParseEdPrivateKeyFromPEM parses a PKCS#8 PEM-encoded Ed25519 private key.
+func ParseEdPrivateKeyFromPEM(b []byte) (ed25519.PrivateKey, error) {
+
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, jwt.ErrKeyMustBePEMEncoded
+	}
+	k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	priv, ok := k.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("key is of type %T, not an Ed25519 private key", k)
+	}
+	return priv, nil
+}
+
+// ParseEdPublicKeyFromPEM parses a PKIX PEM-encoded Ed25519 public key.
+func ParseEdPublicKeyFromPEM(b []byte) (ed25519.PublicKey, error) {
+
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, jwt.ErrKeyMustBePEMEncoded
+	}
+	k, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := k.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("key is of type %T, not an Ed25519 public key", k)
+	}
+	return pub, nil
+}
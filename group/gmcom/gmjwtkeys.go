@@ -0,0 +1,244 @@
+package gmcom
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// JWTKey is a single member of the jwt key-set.  Only the public keys are
+// exchanged between group-members in PEM format; the parsed keys are held in
+// unexported fields and the verification key is rebuilt via Parse() on receipt.
+// Private signing keys never leave the process - each group-member reads the
+// signing key from the db via the SignKeyLoader of its key-set cache.
+type JWTKey struct {
+	KID       string
+	Alg       string
+	PubPEM    []byte
+	CreatedOn int64 // unix
+	ExpiresOn int64 // unix; 0 == no expiry
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// JWTKeysH is used as the runtime-type of the jwt key-set cache.  SignKID
+// identifies the key that is presently used to sign new tokens; all other
+// non-expired keys remain valid for verification.  SignKeyLoader reads the
+// private key of a newly announced signing key from the db.
+type JWTKeysH struct {
+	sync.RWMutex
+	SignKID       string
+	Keys          map[string]*JWTKey
+	SignKeyLoader func(kid string) (interface{}, error)
+}
+
+// JWTKeysD is a carrier structure for disseminating JWTKEYUPDATE messages to
+// group-members.  The public part of the complete key-set is always sent and
+// replaces the key-set held by the receiver, which then reloads the signing
+// key identified by SignKID.
+type JWTKeysD struct {
+	Forward bool
+	SignKID string
+	Keys    []JWTKey
+}
+
+// NewJWTKey creates a JWTKey from a parsed signing (private) key and/or
+// verification (public) key.  When kid is empty, it is derived from the
+// public key via JWTKeyID.
+func NewJWTKey(kid, alg string, signKey, verifyKey interface{}) (*JWTKey, error) {
+
+	if verifyKey == nil {
+		return nil, fmt.Errorf("jwt key %s: a verification key is required", alg)
+	}
+
+	k := &JWTKey{
+		KID:       kid,
+		Alg:       alg,
+		CreatedOn: time.Now().Unix(),
+		signKey:   signKey,
+		verifyKey: verifyKey,
+	}
+
+	pubDER, err := x509.MarshalPKIXPublicKey(verifyKey)
+	if err != nil {
+		return nil, err
+	}
+	k.PubPEM = pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})
+
+	if k.KID == "" {
+		k.KID, err = JWTKeyID(verifyKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+// JWTKeyID derives a stable key-id from the SHA-256 hash of the DER-encoded
+// public key.
+func JWTKeyID(verifyKey interface{}) (string, error) {
+
+	der, err := x509.MarshalPKIXPublicKey(verifyKey)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256(der)
+	return base64.RawURLEncoding.EncodeToString(h[:12]), nil
+}
+
+// EncodeJWTSignKey returns the PKCS#8 PEM encoding of a signing key.
+func EncodeJWTSignKey(signKey interface{}) ([]byte, error) {
+
+	der, err := x509.MarshalPKCS8PrivateKey(signKey)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// DecodeJWTSignKey parses a PKCS#8 PEM encoded signing key.
+func DecodeJWTSignKey(privPEM []byte) (interface{}, error) {
+
+	block, _ := pem.Decode(privPEM)
+	if block == nil {
+		return nil, fmt.Errorf("jwt private key is not PEM encoded")
+	}
+	return x509.ParsePKCS8PrivateKey(block.Bytes)
+}
+
+// Parse rebuilds the parsed verification key from the PEM data.  The signing
+// key, if any, is left as-is.
+func (k *JWTKey) Parse() error {
+
+	block, _ := pem.Decode(k.PubPEM)
+	if block == nil {
+		return fmt.Errorf("jwt key %s: public key is not PEM encoded", k.KID)
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return err
+	}
+	k.verifyKey = pub
+	return nil
+}
+
+// SetSignKey sets the parsed private key of a key read from the db.
+func (k *JWTKey) SetSignKey(signKey interface{}) {
+	k.signKey = signKey
+}
+
+// SignKey returns the parsed private key, or nil for verification-only keys.
+func (k *JWTKey) SignKey() interface{} {
+	return k.signKey
+}
+
+// VerifyKey returns the parsed public key.
+func (k *JWTKey) VerifyKey() interface{} {
+	return k.verifyKey
+}
+
+// Expired reports whether the key has passed its expiry time.
+func (k *JWTKey) Expired() bool {
+	return k.ExpiresOn != 0 && time.Now().Unix() > k.ExpiresOn
+}
+
+// Replace parses the keys in the carrier structure and then swaps them into
+// the cache as a single operation.  Expired keys are discarded.  The private
+// keys already held by the cache are retained; a signing key that is not held
+// yet is read via the SignKeyLoader.
+func (h *JWTKeysH) Replace(d JWTKeysD) error {
+
+	h.RLock()
+	held := h.Keys
+	loader := h.SignKeyLoader
+	h.RUnlock()
+
+	keys := make(map[string]*JWTKey)
+	for i := range d.Keys {
+		k := d.Keys[i]
+		if k.Expired() {
+			continue
+		}
+		if err := k.Parse(); err != nil {
+			return err
+		}
+		if hk, ok := held[k.KID]; ok && k.signKey == nil {
+			k.signKey = hk.signKey
+		}
+		keys[k.KID] = &k
+	}
+
+	if d.SignKID != "" {
+		sk := keys[d.SignKID]
+		if sk == nil {
+			return fmt.Errorf("jwt key-set does not contain the signing key %s", d.SignKID)
+		}
+		if sk.signKey == nil {
+			if loader == nil {
+				return fmt.Errorf("jwt signing key %s is not available", d.SignKID)
+			}
+			signKey, err := loader(d.SignKID)
+			if err != nil {
+				return err
+			}
+			sk.signKey = signKey
+		}
+	}
+
+	h.Lock()
+	h.Keys = keys
+	h.SignKID = d.SignKID
+	h.Unlock()
+	return nil
+}
+
+// Lookup returns the non-expired key for the specified kid.
+func (h *JWTKeysH) Lookup(kid string) (*JWTKey, bool) {
+
+	h.RLock()
+	defer h.RUnlock()
+	k, ok := h.Keys[kid]
+	if !ok || k.Expired() {
+		return nil, false
+	}
+	return k, true
+}
+
+// Signer returns the key that is presently used to sign new tokens.
+func (h *JWTKeysH) Signer() (*JWTKey, bool) {
+
+	h.RLock()
+	defer h.RUnlock()
+	k, ok := h.Keys[h.SignKID]
+	if !ok || k.signKey == nil {
+		return nil, false
+	}
+	return k, true
+}
+
+// Snapshot returns a copy of the key-set in carrier format, ordered by
+// creation time.  Expired keys are omitted.
+func (h *JWTKeysH) Snapshot() JWTKeysD {
+
+	h.RLock()
+	defer h.RUnlock()
+	d := JWTKeysD{SignKID: h.SignKID}
+	for _, k := range h.Keys {
+		if k.Expired() {
+			continue
+		}
+		d.Keys = append(d.Keys, *k)
+	}
+	sort.Slice(d.Keys, func(i, j int) bool {
+		if d.Keys[i].CreatedOn == d.Keys[j].CreatedOn {
+			return d.Keys[i].KID < d.Keys[j].KID
+		}
+		return d.Keys[i].CreatedOn < d.Keys[j].CreatedOn
+	})
+	return d
+}
@@ -0,0 +1,128 @@
+package gmcom
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/gob"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func newTestJWTKey(t *testing.T) (*JWTKey, ed25519.PrivateKey) {
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := NewJWTKey("", "EdDSA", priv, pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k, priv
+}
+
+func TestJWTKeysCarrier(t *testing.T) {
+
+	k, priv := newTestJWTKey(t)
+	h := &JWTKeysH{}
+	if err := h.Replace(JWTKeysD{SignKID: k.KID, Keys: []JWTKey{*k}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the disseminated key-set carries the public keys only
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(h.Snapshot()); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(buf.Bytes(), priv.Seed()) {
+		t.Fatalf("got private key material in the encoded key-set")
+	}
+	var d JWTKeysD
+	if err := gob.NewDecoder(buf).Decode(&d); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Keys) != 1 || d.Keys[0].SignKey() != nil {
+		t.Fatalf("got %+v", d)
+	}
+
+	// a receiver without the signing key and without a loader refuses the key-set
+	if err := (&JWTKeysH{}).Replace(d); err == nil {
+		t.Errorf("expected the key-set to be refused")
+	}
+
+	// a receiver holding the signing key retains it
+	if err := h.Replace(d); err != nil {
+		t.Fatal(err)
+	}
+	if s, ok := h.Signer(); !ok || s.KID != k.KID {
+		t.Errorf("got signer %v, %v", s, ok)
+	}
+}
+
+func TestJWTKeysRotation(t *testing.T) {
+
+	old, _ := newTestJWTKey(t)
+	h := &JWTKeysH{}
+	if err := h.Replace(JWTKeysD{SignKID: old.KID, Keys: []JWTKey{*old}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the new signing key is read via the loader; the old key is retired
+	next, nextPriv := newTestJWTKey(t)
+	snap := h.Snapshot()
+	snap.Keys[0].ExpiresOn = time.Now().Add(time.Hour).Unix()
+	snap.Keys = append(snap.Keys, JWTKey{KID: next.KID, Alg: next.Alg, PubPEM: next.PubPEM, CreatedOn: next.CreatedOn})
+	snap.SignKID = next.KID
+
+	var loaded []string
+	h.SignKeyLoader = func(kid string) (interface{}, error) {
+		loaded = append(loaded, kid)
+		if kid != next.KID {
+			return nil, fmt.Errorf("unknown kid %s", kid)
+		}
+		return nextPriv, nil
+	}
+	if err := h.Replace(snap); err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != 1 || loaded[0] != next.KID {
+		t.Errorf("got loaded kids %v; want [%s]", loaded, next.KID)
+	}
+	if s, ok := h.Signer(); !ok || s.KID != next.KID {
+		t.Fatalf("got signer %v, %v; want %s", s, ok, next.KID)
+	}
+
+	// both keys can be looked up by kid for verification
+	for _, kid := range []string{old.KID, next.KID} {
+		if k, ok := h.Lookup(kid); !ok || k.VerifyKey() == nil {
+			t.Errorf("kid %s: got %v, %v", kid, k, ok)
+		}
+	}
+	if _, ok := h.Lookup("unknown"); ok {
+		t.Errorf("expected an unknown kid not to be found")
+	}
+
+	// the retired key is dropped once it has expired
+	h.Keys[old.KID].ExpiresOn = time.Now().Add(-time.Second).Unix()
+	if _, ok := h.Lookup(old.KID); ok {
+		t.Errorf("expected the expired kid not to be found")
+	}
+	if d := h.Snapshot(); len(d.Keys) != 1 || d.Keys[0].KID != next.KID {
+		t.Errorf("got %+v", d.Keys)
+	}
+
+	// a failing loader leaves the key-set unchanged
+	other, _ := newTestJWTKey(t)
+	snap = h.Snapshot()
+	snap.Keys = append(snap.Keys, *other)
+	snap.Keys[1].SetSignKey(nil)
+	snap.SignKID = other.KID
+	if err := h.Replace(snap); err == nil {
+		t.Errorf("expected the replacement to fail")
+	}
+	if s, ok := h.Signer(); !ok || s.KID != next.KID {
+		t.Errorf("got signer %v, %v; want %s", s, ok, next.KID)
+	}
+}
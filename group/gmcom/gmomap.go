@@ -28,6 +28,8 @@ type OrderedMapI interface {
 	ReadActiveProcessList() (g []GMMember)
 	UpdFromPing(localID uint, id uint, mm *OMap, failureThreshold uint, leaderID uint) (checkElect bool, err error)
 	UpdFailedDepartedProcesses() error
+	TrackChanges()
+	ReadChanges(limit uint, max int) (g []GMMember)
 	Delete(id uint) bool
 	DeleteByIndex(idx int) bool
 	Flush() bool
@@ -37,8 +39,9 @@ type OrderedMapI interface {
 
 // OMap struct
 type OMap struct {
-	IDList IDList
-	IDMap  IDMap
+	IDList  IDList
+	IDMap   IDMap
+	changes map[uint]uint // map[id]dissemination count of the recorded change
 	OrderedMapI
 }
 
@@ -64,7 +67,7 @@ func (o *OMap) Add(id uint, g GMMember) error {
 	}
 
 	// add or update?
-	_, ok := o.IDMap[id]
+	old, ok := o.IDMap[id]
 	if !ok {
 		if id == 0 {
 			panic(id)
@@ -72,6 +75,7 @@ func (o *OMap) Add(id uint, g GMMember) error {
 		o.IDList = append(o.IDList, id)
 	}
 	o.IDMap[id] = g
+	o.noteChange(id, old, ok, g)
 	return nil
 }
 
@@ -104,6 +108,7 @@ func (o *OMap) AddWithoutID(g *GMMember) error {
 	g.ID = maxID + 1
 	o.IDMap[g.ID] = *g
 	o.IDList = append(o.IDList, g.ID)
+	o.noteChange(g.ID, GMMember{}, false, *g)
 	lw.Info("AddWithoutID resulted in o.IDMap: &v", o.IDMap)
 	// log.Println("AddWithoutID resulted in o.IDMap:", o.IDMap)
 	return nil
@@ -0,0 +1,110 @@
+package gmcom
+
+import (
+	"math/bits"
+	"sort"
+	"time"
+)
+
+// SWIM-style dissemination and indirect probing.
+//
+// Processes speaking CProtocolSWIM or later do not ship the complete member map
+// on every PING.  Instead, each change to the status or incarnation number of a
+// member is recorded by the member map of the local process and piggybacked on
+// the PINGs of the next PiggybackLimit(n) protocol periods.  The receiver applies
+// the piggybacked members as a partial member map, which records the changes in
+// its own member map in turn, so changes spread through the group in an
+// infection-style manner.
+//
+// A process that does not receive an ACK for a PING asks up to CPingReqFanout
+// other members to PING the target on its behalf (PINGREQ) before it suspects
+// the target.  A single lost message or a congested link between two processes
+// is therefore not enough to suspect a process.
+const (
+	CPiggybackMax   = 16              // maximum number of members piggybacked on a single PING
+	CPingReqFanout  = 3               // number of members asked to probe an unresponsive process
+	CPingReqTimeout = 6 * time.Second // time allowed for the indirect probing of a process
+)
+
+// PiggybackLimit returns the number of protocol periods a membership change is
+// piggybacked for in a group of n processes; 3 * ceil(log2(n+1)).
+func PiggybackLimit(n int) uint {
+
+	if n < 1 {
+		n = 1
+	}
+	return 3 * uint(bits.Len(uint(n)))
+}
+
+// TrackChanges enables the recording of membership changes made via Add and
+// AddWithoutID.  It is enabled on the member map of the local process only;
+// copies of the member map made for transmission do not record changes.
+func (o *OMap) TrackChanges() {
+	o.changes = make(map[uint]uint)
+}
+
+// noteChange records a change to the status or incarnation number of member id.
+// Changes to the status count alone are not recorded.
+func (o *OMap) noteChange(id uint, old GMMember, existed bool, g GMMember) {
+
+	if o.changes == nil {
+		return
+	}
+	if existed && old.Status == g.Status && old.IncarnationNumber == g.IncarnationNumber {
+		return
+	}
+	o.changes[id] = 0
+}
+
+// ReadChanges returns up to max recorded membership changes for piggybacking,
+// preferring the changes that have been disseminated the fewest times.  Each
+// returned change is counted as disseminated once, and changes disseminated
+// limit times are dropped.
+func (o *OMap) ReadChanges(limit uint, max int) (g []GMMember) {
+
+	if len(o.changes) == 0 {
+		return nil
+	}
+
+	ids := make([]uint, 0, len(o.changes))
+	for id := range o.changes {
+		if _, ok := o.IDMap[id]; !ok {
+			delete(o.changes, id) // member has been removed from the map
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if o.changes[ids[i]] != o.changes[ids[j]] {
+			return o.changes[ids[i]] < o.changes[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+	if len(ids) > max {
+		ids = ids[:max]
+	}
+
+	for _, id := range ids {
+		g = append(g, o.IDMap[id])
+		o.changes[id]++
+		if o.changes[id] >= limit {
+			delete(o.changes, id)
+		}
+	}
+	return g
+}
+
+// UpdatesMap returns the membership changes piggybacked on a PING as a partial
+// member map suitable for UpdFromPing.  Malformed entries are dropped.
+func UpdatesMap(updates []GMMember) *OMap {
+
+	mm := &OMap{}
+	mm.Init()
+	for _, u := range updates {
+		if u.ID == 0 || u.IncarnationNumber == 0 || u.Status == "" {
+			continue
+		}
+		mm.Add(u.ID, u)
+	}
+	return mm
+}
@@ -0,0 +1,90 @@
+package gmcom
+
+import (
+	"testing"
+)
+
+func TestPiggybackLimit(t *testing.T) {
+
+	tests := []struct {
+		n    int
+		want uint
+	}{
+		{0, 3},
+		{1, 3},
+		{3, 6},
+		{4, 9},
+		{100, 21},
+	}
+	for _, tt := range tests {
+		if got := PiggybackLimit(tt.n); got != tt.want {
+			t.Errorf("PiggybackLimit(%d) = %d; want %d", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestReadChanges(t *testing.T) {
+
+	o := &OMap{}
+	o.Init()
+	o.TrackChanges()
+	o.Add(1, GMMember{ID: 1, IPAddress: "192.168.1.1:4444", Status: CStatusAlive, StatusCount: 1, IncarnationNumber: 1})
+	o.Add(2, GMMember{ID: 2, IPAddress: "192.168.1.2:4444", Status: CStatusAlive, StatusCount: 1, IncarnationNumber: 1})
+
+	// new members are changes; each read counts as a dissemination
+	for i := 0; i < 2; i++ {
+		g := o.ReadChanges(2, CPiggybackMax)
+		if len(g) != 2 {
+			t.Fatalf("read %d: got %d changes; want 2", i, len(g))
+		}
+	}
+	if g := o.ReadChanges(2, CPiggybackMax); len(g) != 0 {
+		t.Fatalf("changes should be dropped after 2 disseminations; got %v", g)
+	}
+
+	// status count changes are not membership changes
+	o.Add(1, GMMember{ID: 1, IPAddress: "192.168.1.1:4444", Status: CStatusAlive, StatusCount: 7, IncarnationNumber: 1})
+	if g := o.ReadChanges(2, CPiggybackMax); len(g) != 0 {
+		t.Fatalf("a status count update should not be recorded; got %v", g)
+	}
+
+	// status and incarnation changes are, and the least disseminated come first
+	o.Add(2, GMMember{ID: 2, IPAddress: "192.168.1.2:4444", Status: CStatusSuspect, StatusCount: 1, IncarnationNumber: 1})
+	o.ReadChanges(2, CPiggybackMax)
+	o.Add(1, GMMember{ID: 1, IPAddress: "192.168.1.1:4444", Status: CStatusAlive, StatusCount: 1, IncarnationNumber: 2})
+	g := o.ReadChanges(2, 1)
+	if len(g) != 1 || g[0].ID != 1 || g[0].IncarnationNumber != 2 {
+		t.Fatalf("got %v; want the incarnation change of process 1", g)
+	}
+
+	// removed members are not disseminated
+	o.Delete(2)
+	g = o.ReadChanges(2, CPiggybackMax)
+	if len(g) != 1 || g[0].ID != 1 {
+		t.Fatalf("got %v; want process 1 only", g)
+	}
+
+	// copies of the member map do not track changes
+	c := &OMap{}
+	c.Init()
+	c.Add(1, GMMember{ID: 1, IPAddress: "192.168.1.1:4444", Status: CStatusAlive, StatusCount: 1, IncarnationNumber: 1})
+	if g := c.ReadChanges(2, CPiggybackMax); len(g) != 0 {
+		t.Fatalf("untracked map returned changes %v", g)
+	}
+}
+
+func TestUpdatesMap(t *testing.T) {
+
+	mm := UpdatesMap([]GMMember{
+		{ID: 1, IPAddress: "192.168.1.1:4444", Status: CStatusSuspect, StatusCount: 1, IncarnationNumber: 3},
+		{ID: 0, IPAddress: "192.168.1.2:4444", Status: CStatusAlive, StatusCount: 1, IncarnationNumber: 1},
+		{ID: 3, IPAddress: "192.168.1.3:4444", Status: CStatusAlive, StatusCount: 1},
+		{ID: 4, IPAddress: "192.168.1.4:4444", StatusCount: 1, IncarnationNumber: 1},
+	})
+	if mm.Count() != 1 {
+		t.Fatalf("got %d members; want 1", mm.Count())
+	}
+	if m, ok := mm.Read(1); !ok || m.Status != CStatusSuspect || m.IncarnationNumber != 3 {
+		t.Errorf("got %v; want the suspect process 1", m)
+	}
+}
@@ -0,0 +1,161 @@
+package gmcom
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// CSealMaxSkew is the maximum age of a sealed payload, and the maximum amount
+// of clock-skew tolerated between group-members.
+const CSealMaxSkew = 30 * time.Second
+
+// seal layout: magic(3) | version(1) | unix-nano timestamp(8) | nonce(16) |
+// payload | hmac-sha256(32).  The hmac covers everything preceding it along
+// with the endpoint path and the direction of the message, so a payload cannot
+// be replayed to another endpoint or reflected as a response.
+var sealMagic = []byte{0x00, 'G', 'S'}
+
+const (
+	sealVersion   = 1
+	sealNonceLen  = 16
+	sealHeaderLen = 4 + 8 + sealNonceLen
+	sealMACLen    = sha256.Size
+)
+
+// clusterKey holds the shared secret used to authenticate the group-membership
+// and cache-update traffic of the process, along with the nonces seen within
+// the last CSealMaxSkew.
+var clusterKey struct {
+	sync.Mutex
+	key    []byte
+	nonces map[string]time.Time
+}
+
+// SetClusterKey enables the authentication of group-membership traffic with the
+// shared cluster key.  Once set, every GMMessage and cache-update payload is
+// sealed with an hmac, and unsealed or incorrectly sealed payloads are rejected.
+// All group-members must use the same key; passing nil disables authentication.
+func SetClusterKey(key []byte) {
+	clusterKey.Lock()
+	defer clusterKey.Unlock()
+	clusterKey.key = key
+	clusterKey.nonces = make(map[string]time.Time)
+}
+
+// SealPayload seals b for transmission to the endpoint at path.  reply is true
+// for responses.  b is returned unchanged if no cluster key is set.
+func SealPayload(b []byte, path string, reply bool) ([]byte, error) {
+
+	clusterKey.Lock()
+	key := clusterKey.key
+	clusterKey.Unlock()
+	if key == nil {
+		return b, nil
+	}
+
+	s := make([]byte, sealHeaderLen, sealHeaderLen+len(b)+sealMACLen)
+	copy(s, sealMagic)
+	s[3] = sealVersion
+	binary.BigEndian.PutUint64(s[4:12], uint64(time.Now().UnixNano()))
+	_, err := rand.Read(s[12:sealHeaderLen])
+	if err != nil {
+		return nil, err
+	}
+	s = append(s, b...)
+	return append(s, sealMAC(key, s, path, reply)...), nil
+}
+
+// OpenPayload verifies the seal of a payload received from the endpoint at
+// path and returns the payload.  reply is true for responses.  b is returned
+// unchanged if no cluster key is set.
+func OpenPayload(b []byte, path string, reply bool) ([]byte, error) {
+
+	clusterKey.Lock()
+	defer clusterKey.Unlock()
+	if clusterKey.key == nil {
+		return b, nil
+	}
+
+	if len(b) < sealHeaderLen+sealMACLen || !bytes.Equal(b[:len(sealMagic)], sealMagic) {
+		return nil, fmt.Errorf("payload for %s is not sealed with the cluster key", path)
+	}
+	if b[3] != sealVersion {
+		return nil, fmt.Errorf("payload for %s has unsupported seal version %d", path, b[3])
+	}
+	body, mac := b[:len(b)-sealMACLen], b[len(b)-sealMACLen:]
+	if !hmac.Equal(mac, sealMAC(clusterKey.key, body, path, reply)) {
+		return nil, fmt.Errorf("payload for %s has an invalid seal", path)
+	}
+
+	now := time.Now()
+	ts := time.Unix(0, int64(binary.BigEndian.Uint64(b[4:12])))
+	if ts.Before(now.Add(-CSealMaxSkew)) || ts.After(now.Add(CSealMaxSkew)) {
+		return nil, fmt.Errorf("payload for %s is stale; sealed at %v", path, ts)
+	}
+
+	// drop the nonces that can no longer be replayed and check for a replay
+	for n, exp := range clusterKey.nonces {
+		if exp.Before(now) {
+			delete(clusterKey.nonces, n)
+		}
+	}
+	nonce := string(b[12:sealHeaderLen])
+	if _, ok := clusterKey.nonces[nonce]; ok {
+		return nil, fmt.Errorf("payload for %s is a replay", path)
+	}
+	clusterKey.nonces[nonce] = ts.Add(2 * CSealMaxSkew)
+	return b[sealHeaderLen : len(b)-sealMACLen], nil
+}
+
+// sealMAC computes the hmac of a sealed payload.
+func sealMAC(key, body []byte, path string, reply bool) []byte {
+
+	direction := "request"
+	if reply {
+		direction = "reply"
+	}
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(path + "\x00" + direction + "\x00"))
+	h.Write(body)
+	return h.Sum(nil)
+}
+
+// wsPath returns the endpoint path of the websocket connection.
+func wsPath(ws *websocket.Conn) string {
+
+	if ws.IsServerConn() {
+		return ws.Request().URL.Path
+	}
+	return ws.Config().Location.Path
+}
+
+// SendPayload sends b over the websocket as a single message, sealed with the
+// cluster key if one is set.
+func SendPayload(ws *websocket.Conn, b []byte) error {
+
+	s, err := SealPayload(b, wsPath(ws), ws.IsServerConn())
+	if err != nil {
+		return err
+	}
+	return websocket.Message.Send(ws, s)
+}
+
+// ReceivePayload receives a complete message from the websocket and verifies
+// its seal if a cluster key is set.
+func ReceivePayload(ws *websocket.Conn) ([]byte, error) {
+
+	var b []byte
+	err := websocket.Message.Receive(ws, &b)
+	if err != nil {
+		return nil, err
+	}
+	return OpenPayload(b, wsPath(ws), !ws.IsServerConn())
+}
@@ -0,0 +1,174 @@
+package gmcom
+
+import (
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestSealPayload(t *testing.T) {
+
+	payload := []byte("gob-encoded ActUsrD")
+
+	// without a cluster key payloads pass through unchanged
+	SetClusterKey(nil)
+	b, err := SealPayload(payload, "/updateusrcache", false)
+	if err != nil || string(b) != string(payload) {
+		t.Fatalf("got %q, %v", b, err)
+	}
+
+	SetClusterKey([]byte("0123456789abcdef0123456789abcdef"))
+	defer SetClusterKey(nil)
+
+	b, err = SealPayload(payload, "/updateusrcache", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := OpenPayload(b, "/updateusrcache", false)
+	if err != nil || string(got) != string(payload) {
+		t.Fatalf("got %q, %v", got, err)
+	}
+
+	// a payload may be opened only once
+	if _, err = OpenPayload(b, "/updateusrcache", false); err == nil || !strings.Contains(err.Error(), "replay") {
+		t.Errorf("replay got %v", err)
+	}
+
+	seal := func() []byte {
+		b, err := SealPayload(payload, "/updateusrcache", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	tampered := seal()
+	tampered[sealHeaderLen] ^= 0x01
+
+	stale := seal()
+	binary.BigEndian.PutUint64(stale[4:12], uint64(time.Now().Add(-2*CSealMaxSkew).UnixNano()))
+
+	tests := []struct {
+		name  string
+		b     []byte
+		path  string
+		reply bool
+	}{
+		{"unsealed", payload, "/updateusrcache", false},
+		{"tampered", tampered, "/updateusrcache", false},
+		{"other endpoint", seal(), "/updategroupauthcache", false},
+		{"reflected as a reply", seal(), "/updateusrcache", true},
+		{"stale", stale, "/updateusrcache", false},
+	}
+	for _, tt := range tests {
+		if _, err := OpenPayload(tt.b, tt.path, tt.reply); err == nil {
+			t.Errorf("%s payload was accepted", tt.name)
+		}
+	}
+
+	SetClusterKey([]byte("another-key-another-key-another-k"))
+	if _, err := OpenPayload(seal(), "/updateusrcache", false); err != nil {
+		t.Errorf("the payload should open with the key it was sealed with; got %v", err)
+	}
+	b = seal()
+	SetClusterKey([]byte("0123456789abcdef0123456789abcdef"))
+	if _, err := OpenPayload(b, "/updateusrcache", false); err == nil {
+		t.Error("a payload sealed with another key was accepted")
+	}
+}
+
+func TestSealedGMMessage(t *testing.T) {
+
+	SetClusterKey([]byte("0123456789abcdef0123456789abcdef"))
+	defer SetClusterKey(nil)
+
+	srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		m, version, err := ReadGMMessage(ws)
+		if err != nil {
+			ws.Write([]byte(err.Error()))
+			return
+		}
+		m.Type = CAck
+		WriteGMMessage(ws, *m, version)
+	}))
+	defer srv.Close()
+	address := strings.TrimPrefix(srv.URL, "http://")
+
+	ws, err := DialGM(address, "/ping", 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = WriteGMMessage(ws, GMMessage{Type: CPing, SrcID: 2}, CProtocolFramed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, _, err := ReadGMMessage(ws)
+	ws.Close()
+	if err != nil || r.Type != CAck || r.SrcID != 2 {
+		t.Fatalf("got %v, %v", r, err)
+	}
+
+	// an unsealed PING is rejected by the receiver
+	ws, err = DialGM(address, "/ping", 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := FrameGMMessage(GMMessage{Type: CPing, SrcID: 3}, CProtocolFramed)
+	websocket.Message.Send(ws, b)
+	var msg string
+	websocket.Message.Receive(ws, &msg)
+	ws.Close()
+	if !strings.Contains(msg, "not sealed") {
+		t.Errorf("unsealed PING got %q", msg)
+	}
+}
+
+func TestSealedAck(t *testing.T) {
+
+	SetClusterKey([]byte("0123456789abcdef0123456789abcdef"))
+	defer SetClusterKey(nil)
+
+	mux := http.NewServeMux()
+	mux.Handle("/updateusrcache", websocket.Handler(func(ws *websocket.Conn) {
+		if _, err := ReceivePayload(ws); err != nil {
+			SendPayload(ws, []byte("false"))
+			return
+		}
+		SendPayload(ws, []byte("true"))
+	}))
+	mux.Handle("/updateauthcache", websocket.Handler(func(ws *websocket.Conn) {
+		ReceivePayload(ws)
+		ws.Write([]byte("true"))
+	}))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	address := strings.TrimPrefix(srv.URL, "http://")
+
+	ack := func(path string) ([]byte, error) {
+		ws, err := DialGM(address, path, 5*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ws.Close()
+		if err = SendPayload(ws, []byte("update")); err != nil {
+			t.Fatal(err)
+		}
+		return ReceivePayload(ws)
+	}
+
+	msg, err := ack("/updateusrcache")
+	if err != nil || string(msg) != "true" {
+		t.Errorf("sealed ack got %q, %v", msg, err)
+	}
+
+	// an unsealed ack must not be taken as confirmation
+	msg, err = ack("/updateauthcache")
+	if err == nil {
+		t.Errorf("unsealed ack was accepted: %q", msg)
+	}
+}
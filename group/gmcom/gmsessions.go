@@ -0,0 +1,53 @@
+package gmcom
+
+import (
+	"sync"
+	"time"
+)
+
+// SessionsH is used as the runtime-type of the revoked-session cache.  An access
+// token carrying the sid of a revoked session is rejected by the auth middleware
+// until the token expires; entries are held until then.
+type SessionsH struct {
+	sync.RWMutex
+	Revoked map[string]int64 // map[sid]expiry of the session's token (unix)
+}
+
+// SessionD is a carrier structure for disseminating SESSIONUPDATE messages to
+// group-members.  At present sessions are only ever revoked.
+type SessionD struct {
+	Forward   bool
+	SID       string
+	UsrID     uint64
+	ExpiresOn int64 // unix
+}
+
+// Revoke adds the session to the cache and drops the entries of sessions that
+// have expired in the meantime.
+func (h *SessionsH) Revoke(sid string, expiresOn int64) {
+
+	h.Lock()
+	defer h.Unlock()
+	if h.Revoked == nil {
+		h.Revoked = make(map[string]int64)
+	}
+	now := time.Now().Unix()
+	for k, exp := range h.Revoked {
+		if exp != 0 && exp < now {
+			delete(h.Revoked, k)
+		}
+	}
+	h.Revoked[sid] = expiresOn
+}
+
+// IsRevoked reports whether the session has been revoked.
+func (h *SessionsH) IsRevoked(sid string) bool {
+
+	if h == nil || sid == "" {
+		return false
+	}
+	h.RLock()
+	defer h.RUnlock()
+	_, ok := h.Revoked[sid]
+	return ok
+}
@@ -0,0 +1,115 @@
+package gmcom
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// ClusterTLS holds the mutual-TLS configuration of the internal group-membership
+// listener and of the processes dialing it.  Every process presents a certificate
+// issued by the cluster CA and accepts only peers presenting such a certificate.
+// The certificate of a process must be valid for the host of its internal
+// address; for IP addresses this means an IP SAN.
+type ClusterTLS struct {
+	server *tls.Config
+	client *tls.Config
+}
+
+var clusterTLS struct {
+	sync.RWMutex
+	ct *ClusterTLS
+}
+
+// NewClusterTLS reads the cluster CA certificate and the certificate and key of
+// the local process from PEM files.
+func NewClusterTLS(caFile, certFile, keyFile string) (*ClusterTLS, error) {
+
+	caPEM, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("cluster CA: %s", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("cluster CA: no certificates found in %s", caFile)
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("cluster certificate: %s", err)
+	}
+
+	return &ClusterTLS{
+		server: &tls.Config{
+			MinVersion:   tls.VersionTLS12,
+			Certificates: []tls.Certificate{cert},
+			ClientCAs:    pool,
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+		},
+		client: &tls.Config{
+			MinVersion:   tls.VersionTLS12,
+			Certificates: []tls.Certificate{cert},
+			RootCAs:      pool,
+		},
+	}, nil
+}
+
+// SetClusterTLS enables mutual-TLS for the group-membership traffic of the
+// process.  It must be called before the group-membership server is started;
+// passing nil reverts to plain ws:// connections.
+func SetClusterTLS(ct *ClusterTLS) {
+	clusterTLS.Lock()
+	defer clusterTLS.Unlock()
+	clusterTLS.ct = ct
+}
+
+// ServerTLSConfig returns the tls configuration of the group-membership
+// listener, or nil if mutual-TLS is not enabled.
+func ServerTLSConfig() *tls.Config {
+	clusterTLS.RLock()
+	defer clusterTLS.RUnlock()
+	if clusterTLS.ct == nil {
+		return nil
+	}
+	return clusterTLS.ct.server.Clone()
+}
+
+// DialGM opens a websocket connection to the group-membership server at
+// address; wss:// is used when mutual-TLS is enabled.  A zero timeout leaves
+// the connection attempt unbounded.
+func DialGM(address, path string, timeout time.Duration) (*websocket.Conn, error) {
+
+	clusterTLS.RLock()
+	ct := clusterTLS.ct
+	clusterTLS.RUnlock()
+
+	scheme, origin := "ws://", "http://localhost/"
+	if ct != nil {
+		scheme, origin = "wss://", "https://localhost/"
+	}
+
+	config, err := websocket.NewConfig(scheme+address+path, origin)
+	if err != nil {
+		return nil, err
+	}
+	if ct != nil {
+		config.TlsConfig = ct.client.Clone()
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		config.TlsConfig.ServerName = host
+	}
+	if timeout > 0 {
+		config.Dialer = &net.Dialer{
+			Deadline: time.Now().Add(timeout),
+		}
+	}
+	return websocket.DialConfig(config)
+}
@@ -0,0 +1,132 @@
+package gmcom
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// writeTestPKI writes a CA and a certificate for 127.0.0.1 issued by it to dir
+// and returns the file names.
+func writeTestPKI(t *testing.T, dir, name string) (caFile, certFile, keyFile string) {
+
+	writePEM := func(fName, typ string, der []byte) string {
+		fName = filepath.Join(dir, fName)
+		err := ioutil.WriteFile(fName, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fName
+	}
+
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name + " CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := x509.ParseCertificate(caDER)
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return writePEM(name+"-ca.pem", "CERTIFICATE", caDER),
+		writePEM(name+".pem", "CERTIFICATE", der),
+		writePEM(name+".key", "EC PRIVATE KEY", keyDER)
+}
+
+func TestClusterTLS(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gmtls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cluster, err := NewClusterTLS(writeTestPKI(t, dir, "cluster"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rogue, err := NewClusterTLS(writeTestPKI(t, dir, "rogue"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer SetClusterTLS(nil)
+
+	SetClusterTLS(cluster)
+	srv := httptest.NewUnstartedServer(websocket.Handler(func(ws *websocket.Conn) {
+		ws.Write([]byte("true"))
+	}))
+	srv.TLS = ServerTLSConfig()
+	srv.StartTLS()
+	defer srv.Close()
+	address := strings.TrimPrefix(srv.URL, "https://")
+
+	ws, err := DialGM(address, "/updateusrcache", 5*time.Second)
+	if err != nil {
+		t.Fatalf("cluster member dial got: %v", err)
+	}
+	msg := make([]byte, 8)
+	n, err := ws.Read(msg)
+	ws.Close()
+	if err != nil || string(msg[:n]) != "true" {
+		t.Errorf("got %q, %v", msg[:n], err)
+	}
+
+	// a peer with a certificate from another CA must be rejected
+	SetClusterTLS(rogue)
+	ws, err = DialGM(address, "/updateusrcache", 5*time.Second)
+	if err == nil {
+		_, err = ws.Read(msg)
+		ws.Close()
+	}
+	if err == nil {
+		t.Error("a peer without a cluster certificate was accepted")
+	}
+
+	// as must a plain ws:// peer
+	SetClusterTLS(nil)
+	ws, err = DialGM(address, "/updateusrcache", 5*time.Second)
+	if err == nil {
+		ws.Close()
+		t.Error("a plain ws:// peer was accepted")
+	}
+}
@@ -0,0 +1,643 @@
+// Package gmraft provides an embedded, raft-based store for the group-leader
+// record.  The nodes of a cluster elect a raft leader among themselves, and the
+// group-leader record is only written via the raft leader and committed once a
+// quorum of the nodes has accepted it.  Nodes in a minority partition can
+// neither elect a raft leader nor commit a group-leader record, which rules out
+// split-brain without an external KVS.
+//
+// The replicated state is a single record, so the raft log is kept compacted to
+// its last entry; each write replaces the entry, and a newly elected raft leader
+// rewrites its entry in its own term in order to commit it.
+package gmraft
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/1414C/libraryapp/group/gmcom"
+	"github.com/1414C/lw"
+)
+
+// raft errors
+var (
+	ErrNoLeader  = errors.New("raft: no leader could be reached")
+	ErrNoQuorum  = errors.New("raft: a quorum of the nodes could not be reached")
+	ErrNotLeader = errors.New("raft: node is not the leader")
+	ErrStale     = errors.New("raft: the epoch of the group-leader record has changed")
+)
+
+type role int
+
+const (
+	follower role = iota
+	candidate
+	leader
+)
+
+// Entry is the replicated group-leader record along with the raft term and
+// index it was written in.
+type Entry struct {
+	Term   uint64
+	Index  uint64
+	Leader gmcom.GMLeader
+}
+
+// newer reports whether entry e is more recent than entry o.
+func (e Entry) newer(o Entry) bool {
+	return e.Term > o.Term || (e.Term == o.Term && e.Index > o.Index)
+}
+
+// VoteRequest is sent by a candidate to request the vote of a node.
+type VoteRequest struct {
+	Term        uint64
+	CandidateID string
+	LastTerm    uint64
+	LastIndex   uint64
+}
+
+// VoteResponse is the response to a VoteRequest.
+type VoteResponse struct {
+	Term    uint64
+	Granted bool
+}
+
+// AppendRequest is sent by the raft leader to replicate its entry; it doubles
+// as the leader's heartbeat.
+type AppendRequest struct {
+	Term     uint64
+	LeaderID string
+	Entry    Entry
+}
+
+// AppendResponse is the response to an AppendRequest.
+type AppendResponse struct {
+	Term    uint64
+	Success bool
+}
+
+// ForwardRequest is sent by a node to the raft leader in order to read or
+// write the group-leader record.  If CAS is set, the record is only written if
+// its epoch is Epoch.
+type ForwardRequest struct {
+	Set    bool
+	CAS    bool
+	Epoch  uint64
+	Leader gmcom.GMLeader
+}
+
+// ForwardResponse is the response to a ForwardRequest.
+type ForwardResponse struct {
+	Leader gmcom.GMLeader
+	Err    string
+}
+
+// Transport sends the raft RPCs to the node with id peer.
+type Transport interface {
+	RequestVote(peer string, req VoteRequest) (*VoteResponse, error)
+	AppendEntries(peer string, req AppendRequest) (*AppendResponse, error)
+	Forward(peer string, req ForwardRequest) (*ForwardResponse, error)
+}
+
+// Config holds the configuration of a raft node.  The ID of a node is the
+// address of its raft listener.  Peers holds the IDs of all nodes of the
+// cluster; the local node is added if it is missing.  The term, vote and entry
+// of the node are persisted in DataFile; they are kept in memory only if
+// DataFile is empty, which is not safe across restarts.
+type Config struct {
+	ID                string
+	Peers             []string
+	DataFile          string
+	ElectionTimeout   time.Duration // randomized between ElectionTimeout and 2*ElectionTimeout
+	HeartbeatInterval time.Duration
+	OpTimeout         time.Duration // time allowed for reads and writes of the group-leader record
+}
+
+// persistentState is the state of a node that must survive restarts.
+type persistentState struct {
+	Term     uint64
+	VotedFor string
+	Entry    Entry
+}
+
+// Node is a member of a raft cluster.
+type Node struct {
+	cfg              Config
+	tr               Transport
+	mu               sync.Mutex
+	role             role
+	term             uint64
+	votedFor         string
+	entry            Entry
+	commitIndex      uint64
+	leaderID         string
+	electionDeadline time.Time
+	lastHeartbeat    time.Time
+	stop             chan struct{}
+	done             chan struct{}
+}
+
+// NewNode creates a raft node and reads its persisted state.  Zero durations
+// in cfg select an election timeout of 1s, a heartbeat interval of 200ms and an
+// operation timeout of 10s.
+func NewNode(cfg Config, tr Transport) (*Node, error) {
+
+	if cfg.ID == "" {
+		return nil, errors.New("raft: node id is missing")
+	}
+	if cfg.ElectionTimeout <= 0 {
+		cfg.ElectionTimeout = time.Second
+	}
+	if cfg.HeartbeatInterval <= 0 {
+		cfg.HeartbeatInterval = 200 * time.Millisecond
+	}
+	if cfg.OpTimeout <= 0 {
+		cfg.OpTimeout = 10 * time.Second
+	}
+
+	peers := []string{cfg.ID}
+	for _, p := range cfg.Peers {
+		if p != cfg.ID {
+			peers = append(peers, p)
+		}
+	}
+	cfg.Peers = peers
+
+	n := &Node{
+		cfg:  cfg,
+		tr:   tr,
+		role: follower,
+	}
+	err := n.load()
+	if err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// Start starts the election timer and heartbeats of the node.
+func (n *Node) Start() {
+
+	n.mu.Lock()
+	n.stop = make(chan struct{})
+	n.done = make(chan struct{})
+	n.resetElectionDeadline()
+	n.mu.Unlock()
+	go n.run()
+}
+
+// Stop stops the node.  RPCs are still answered until the listener of the
+// node is closed.
+func (n *Node) Stop() {
+	close(n.stop)
+	<-n.done
+}
+
+// Leader returns the id of the raft leader known to the node, which is empty
+// while an election is in progress.
+func (n *Node) Leader() string {
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.leaderID
+}
+
+// IsLeader reports whether the node is the raft leader.
+func (n *Node) IsLeader() bool {
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.role == leader
+}
+
+// Term returns the current raft term of the node.
+func (n *Node) Term() uint64 {
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.term
+}
+
+// Get returns the committed group-leader record.  The record is read via the
+// raft leader, which confirms its leadership with a quorum before answering.
+func (n *Node) Get() (gmcom.GMLeader, error) {
+	return n.do(ForwardRequest{})
+}
+
+// Set writes the group-leader record via the raft leader and returns once a
+// quorum of the nodes has accepted it.
+func (n *Node) Set(l gmcom.GMLeader) error {
+	_, err := n.do(ForwardRequest{Set: true, Leader: l})
+	return err
+}
+
+// CompareAndSet writes the group-leader record like Set, but only if the epoch
+// of the current record is epoch.  false is returned if it is not.
+func (n *Node) CompareAndSet(l gmcom.GMLeader, epoch uint64) (bool, error) {
+
+	_, err := n.do(ForwardRequest{Set: true, CAS: true, Epoch: epoch, Leader: l})
+	if err == ErrStale {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// do performs req on the raft leader, waiting up to cfg.OpTimeout for a
+// leader to be elected.
+func (n *Node) do(req ForwardRequest) (gmcom.GMLeader, error) {
+
+	deadline := time.Now().Add(n.cfg.OpTimeout)
+	for {
+		n.mu.Lock()
+		r, lid := n.role, n.leaderID
+		n.mu.Unlock()
+
+		switch {
+		case r == leader:
+			l, err := n.apply(req)
+			if err != ErrNotLeader {
+				return l, err
+			}
+		case lid != "":
+			resp, err := n.tr.Forward(lid, req)
+			if err == nil {
+				if resp.Err == "" {
+					return resp.Leader, nil
+				}
+				if resp.Err == ErrStale.Error() {
+					return resp.Leader, ErrStale
+				}
+				if resp.Err != ErrNotLeader.Error() {
+					return gmcom.GMLeader{}, errors.New(resp.Err)
+				}
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return gmcom.GMLeader{}, ErrNoLeader
+		}
+		time.Sleep(n.cfg.HeartbeatInterval)
+	}
+}
+
+// apply performs req on the local node, which must be the raft leader.  A
+// conditional write whose record is already in place succeeds without writing,
+// so that a forwarded request may be retried.
+func (n *Node) apply(req ForwardRequest) (gmcom.GMLeader, error) {
+
+	n.mu.Lock()
+	if n.role != leader {
+		n.mu.Unlock()
+		return gmcom.GMLeader{}, ErrNotLeader
+	}
+	if req.CAS && n.entry.Leader != req.Leader && n.entry.Leader.Epoch != req.Epoch {
+		l := n.entry.Leader
+		n.mu.Unlock()
+		return l, ErrStale
+	}
+	if req.Set && (!req.CAS || n.entry.Leader != req.Leader) {
+		n.entry = Entry{
+			Term:   n.term,
+			Index:  n.entry.Index + 1,
+			Leader: req.Leader,
+		}
+		n.persist()
+	}
+	n.mu.Unlock()
+
+	e, err := n.replicate()
+	if err != nil {
+		return gmcom.GMLeader{}, err
+	}
+	return e.Leader, nil
+}
+
+// run drives the election timer and the heartbeats of the node.
+func (n *Node) run() {
+
+	defer close(n.done)
+	tick := n.cfg.HeartbeatInterval / 2
+	if tick <= 0 {
+		tick = time.Millisecond
+	}
+	t := time.NewTicker(tick)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-n.stop:
+			return
+		case now := <-t.C:
+			n.mu.Lock()
+			heartbeat := n.role == leader && now.Sub(n.lastHeartbeat) >= n.cfg.HeartbeatInterval
+			if heartbeat {
+				n.lastHeartbeat = now
+			}
+			campaign := n.role != leader && now.After(n.electionDeadline)
+			if campaign {
+				n.resetElectionDeadline()
+			}
+			n.mu.Unlock()
+
+			if heartbeat {
+				go n.replicate()
+			}
+			if campaign {
+				go n.campaign()
+			}
+		}
+	}
+}
+
+// campaign starts an election in a new term.
+func (n *Node) campaign() {
+
+	n.mu.Lock()
+	n.role = candidate
+	n.term++
+	n.votedFor = n.cfg.ID
+	n.leaderID = ""
+	n.persist()
+	req := VoteRequest{
+		Term:        n.term,
+		CandidateID: n.cfg.ID,
+		LastTerm:    n.entry.Term,
+		LastIndex:   n.entry.Index,
+	}
+	n.mu.Unlock()
+	lw.Info("RAFT: %s campaigning in term %d", n.cfg.ID, req.Term)
+
+	others := n.cfg.Peers[1:]
+	ch := make(chan *VoteResponse, len(others))
+	for _, p := range others {
+		go func(p string) {
+			resp, err := n.tr.RequestVote(p, req)
+			if err != nil {
+				resp = nil
+			}
+			ch <- resp
+		}(p)
+	}
+
+	votes := 1
+	timeout := time.After(n.cfg.ElectionTimeout)
+wait:
+	for range others {
+		if votes >= n.quorum() {
+			break
+		}
+		select {
+		case resp := <-ch:
+			if resp == nil {
+				continue
+			}
+			if resp.Granted {
+				votes++
+				continue
+			}
+			n.mu.Lock()
+			if resp.Term > n.term {
+				n.stepDown(resp.Term)
+			}
+			n.mu.Unlock()
+		case <-timeout:
+			break wait
+		}
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if votes < n.quorum() || n.role != candidate || n.term != req.Term {
+		return
+	}
+
+	// rewrite the entry in the new term; it is committed by the first heartbeat
+	n.role = leader
+	n.leaderID = n.cfg.ID
+	n.entry = Entry{
+		Term:   n.term,
+		Index:  n.entry.Index + 1,
+		Leader: n.entry.Leader,
+	}
+	n.persist()
+	n.lastHeartbeat = time.Now()
+	lw.Info("RAFT: %s is the leader of term %d", n.cfg.ID, n.term)
+	go n.replicate()
+}
+
+// replicate sends the entry of the raft leader to all nodes and returns the
+// entry once a quorum has accepted it, at which point it is committed.
+func (n *Node) replicate() (Entry, error) {
+
+	n.mu.Lock()
+	if n.role != leader {
+		n.mu.Unlock()
+		return Entry{}, ErrNotLeader
+	}
+	req := AppendRequest{
+		Term:     n.term,
+		LeaderID: n.cfg.ID,
+		Entry:    n.entry,
+	}
+	n.mu.Unlock()
+
+	others := n.cfg.Peers[1:]
+	ch := make(chan bool, len(others))
+	for _, p := range others {
+		go func(p string) {
+			resp, err := n.tr.AppendEntries(p, req)
+			if err != nil {
+				ch <- false
+				return
+			}
+			if resp.Term > req.Term {
+				n.mu.Lock()
+				if resp.Term > n.term {
+					n.stepDown(resp.Term)
+				}
+				n.mu.Unlock()
+			}
+			ch <- resp.Success
+		}(p)
+	}
+
+	acks := 1
+	timeout := time.After(n.cfg.ElectionTimeout)
+wait:
+	for range others {
+		if acks >= n.quorum() {
+			break
+		}
+		select {
+		case ok := <-ch:
+			if ok {
+				acks++
+			}
+		case <-timeout:
+			break wait
+		}
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.role != leader || n.term != req.Term {
+		return Entry{}, ErrNotLeader
+	}
+	if acks < n.quorum() {
+		return Entry{}, ErrNoQuorum
+	}
+	if req.Entry.Index > n.commitIndex {
+		n.commitIndex = req.Entry.Index
+	}
+	return req.Entry, nil
+}
+
+// HandleVote answers a VoteRequest.  The vote is granted if the node has not
+// voted for another candidate in the term, and the candidate's entry is at
+// least as recent as the node's entry.
+func (n *Node) HandleVote(req VoteRequest) VoteResponse {
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if req.Term < n.term {
+		return VoteResponse{Term: n.term}
+	}
+	if req.Term > n.term {
+		n.stepDown(req.Term)
+	}
+
+	last := Entry{Term: req.LastTerm, Index: req.LastIndex}
+	if (n.votedFor == "" || n.votedFor == req.CandidateID) && !n.entry.newer(last) {
+		n.votedFor = req.CandidateID
+		if n.persist() != nil {
+			return VoteResponse{Term: n.term}
+		}
+		n.resetElectionDeadline()
+		return VoteResponse{Term: n.term, Granted: true}
+	}
+	return VoteResponse{Term: n.term}
+}
+
+// HandleAppend answers an AppendRequest.  Entries older than the entry of the
+// node are acknowledged without being applied, as requests of the same leader
+// may arrive out of order.
+func (n *Node) HandleAppend(req AppendRequest) AppendResponse {
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if req.Term < n.term {
+		return AppendResponse{Term: n.term}
+	}
+	if req.Term > n.term || n.role != follower {
+		n.stepDown(req.Term)
+	}
+	if n.leaderID != req.LeaderID {
+		lw.Info("RAFT: %s follows leader %s in term %d", n.cfg.ID, req.LeaderID, req.Term)
+	}
+	n.leaderID = req.LeaderID
+	n.resetElectionDeadline()
+
+	if req.Entry.newer(n.entry) {
+		n.entry = req.Entry
+		n.persist()
+	}
+	return AppendResponse{Term: n.term, Success: true}
+}
+
+// HandleForward answers a ForwardRequest if the node is the raft leader.
+func (n *Node) HandleForward(req ForwardRequest) ForwardResponse {
+
+	l, err := n.apply(req)
+	if err != nil {
+		return ForwardResponse{Leader: l, Err: err.Error()}
+	}
+	return ForwardResponse{Leader: l}
+}
+
+// stepDown reverts the node to a follower, moving to term if it is later
+// than the current term.  n.mu must be held.
+func (n *Node) stepDown(term uint64) {
+
+	if term > n.term {
+		n.term = term
+		n.votedFor = ""
+		n.leaderID = ""
+	}
+	if n.role == leader {
+		lw.Info("RAFT: %s steps down in term %d", n.cfg.ID, n.term)
+	}
+	n.role = follower
+	n.persist()
+}
+
+// quorum returns the number of nodes forming a majority of the cluster.
+func (n *Node) quorum() int {
+	return len(n.cfg.Peers)/2 + 1
+}
+
+// resetElectionDeadline sets a randomized election deadline.  n.mu must be held.
+func (n *Node) resetElectionDeadline() {
+
+	t := n.cfg.ElectionTimeout
+	n.electionDeadline = time.Now().Add(t + time.Duration(rand.Int63n(int64(t))))
+}
+
+// persist writes the term, vote and entry of the node to cfg.DataFile.
+// n.mu must be held.
+func (n *Node) persist() error {
+
+	if n.cfg.DataFile == "" {
+		return nil
+	}
+
+	buf := new(bytes.Buffer)
+	err := gob.NewEncoder(buf).Encode(persistentState{
+		Term:     n.term,
+		VotedFor: n.votedFor,
+		Entry:    n.entry,
+	})
+	if err == nil {
+		tmp := n.cfg.DataFile + ".tmp"
+		err = ioutil.WriteFile(tmp, buf.Bytes(), 0600)
+		if err == nil {
+			err = os.Rename(tmp, n.cfg.DataFile)
+		}
+	}
+	if err != nil {
+		lw.ErrorWithPrefixString("RAFT: failed to persist the node state - got:", err)
+	}
+	return err
+}
+
+// load reads the persisted state of the node; a missing cfg.DataFile leaves
+// the node in its initial state.
+func (n *Node) load() error {
+
+	if n.cfg.DataFile == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(n.cfg.DataFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var ps persistentState
+	err = gob.NewDecoder(bytes.NewBuffer(b)).Decode(&ps)
+	if err != nil {
+		return err
+	}
+	n.term = ps.Term
+	n.votedFor = ps.VotedFor
+	n.entry = ps.Entry
+	return nil
+}
@@ -0,0 +1,310 @@
+package gmraft
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/1414C/libraryapp/group/gmcom"
+)
+
+// memNet connects the nodes of a test cluster in-process.  Isolated nodes can
+// neither send nor receive RPCs.
+type memNet struct {
+	mu       sync.Mutex
+	nodes    map[string]*Node
+	isolated map[string]bool
+}
+
+var errUnreachable = errors.New("unreachable")
+
+func (mn *memNet) node(from, to string) (*Node, error) {
+
+	mn.mu.Lock()
+	defer mn.mu.Unlock()
+	if mn.isolated[from] || mn.isolated[to] {
+		return nil, errUnreachable
+	}
+	return mn.nodes[to], nil
+}
+
+func (mn *memNet) isolate(id string, isolated bool) {
+	mn.mu.Lock()
+	defer mn.mu.Unlock()
+	mn.isolated[id] = isolated
+}
+
+// memTransport is the Transport of a single node of a memNet.
+type memTransport struct {
+	net  *memNet
+	from string
+}
+
+func (t *memTransport) RequestVote(peer string, req VoteRequest) (*VoteResponse, error) {
+	n, err := t.net.node(t.from, peer)
+	if err != nil {
+		return nil, err
+	}
+	resp := n.HandleVote(req)
+	return &resp, nil
+}
+
+func (t *memTransport) AppendEntries(peer string, req AppendRequest) (*AppendResponse, error) {
+	n, err := t.net.node(t.from, peer)
+	if err != nil {
+		return nil, err
+	}
+	resp := n.HandleAppend(req)
+	return &resp, nil
+}
+
+func (t *memTransport) Forward(peer string, req ForwardRequest) (*ForwardResponse, error) {
+	n, err := t.net.node(t.from, peer)
+	if err != nil {
+		return nil, err
+	}
+	resp := n.HandleForward(req)
+	return &resp, nil
+}
+
+func testConfig(id string, peers []string) Config {
+	return Config{
+		ID:                id,
+		Peers:             peers,
+		ElectionTimeout:   50 * time.Millisecond,
+		HeartbeatInterval: 10 * time.Millisecond,
+		OpTimeout:         time.Second,
+	}
+}
+
+// newCluster starts a cluster of size nodes.
+func newCluster(t *testing.T, size int) (*memNet, []*Node) {
+
+	mn := &memNet{
+		nodes:    make(map[string]*Node),
+		isolated: make(map[string]bool),
+	}
+	peers := make([]string, size)
+	for i := range peers {
+		peers[i] = fmt.Sprintf("node%d", i+1)
+	}
+
+	nodes := make([]*Node, size)
+	for i, id := range peers {
+		n, err := NewNode(testConfig(id, peers), &memTransport{net: mn, from: id})
+		if err != nil {
+			t.Fatal(err)
+		}
+		mn.nodes[id] = n
+		nodes[i] = n
+	}
+	for _, n := range nodes {
+		n.Start()
+	}
+	t.Cleanup(func() {
+		for _, n := range nodes {
+			n.Stop()
+		}
+	})
+	return mn, nodes
+}
+
+// waitLeader waits for exactly one of nodes to be the leader.
+func waitLeader(t *testing.T, nodes []*Node) *Node {
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		var leaders []*Node
+		for _, n := range nodes {
+			if n.IsLeader() {
+				leaders = append(leaders, n)
+			}
+		}
+		if len(leaders) == 1 {
+			return leaders[0]
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("no single leader was elected")
+	return nil
+}
+
+func TestElectionAndReplication(t *testing.T) {
+
+	_, nodes := newCluster(t, 3)
+	ldr := waitLeader(t, nodes)
+
+	// the leader record is empty until it has been set
+	l, err := nodes[0].Get()
+	if err != nil || l.LeaderID != 0 {
+		t.Fatalf("got %v, %v; want an empty record", l, err)
+	}
+
+	// write via a follower
+	var fol *Node
+	for _, n := range nodes {
+		if n != ldr {
+			fol = n
+			break
+		}
+	}
+	want := gmcom.GMLeader{LeaderID: 1, LeaderIPAddress: "192.168.1.1:4444"}
+	if err := fol.Set(want); err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range nodes {
+		l, err := n.Get()
+		if err != nil || l != want {
+			t.Errorf("%s: got %v, %v; want %v", n.cfg.ID, l, err, want)
+		}
+	}
+}
+
+func TestCompareAndSet(t *testing.T) {
+
+	_, nodes := newCluster(t, 3)
+	waitLeader(t, nodes)
+
+	// two claims against the same epoch; only the first succeeds
+	first := gmcom.GMLeader{LeaderID: 1, LeaderIPAddress: "192.168.1.1:4444", Epoch: 1}
+	ok, err := nodes[0].CompareAndSet(first, 0)
+	if err != nil || !ok {
+		t.Fatalf("got %v, %v; want the first claim to succeed", ok, err)
+	}
+	ok, err = nodes[1].CompareAndSet(gmcom.GMLeader{LeaderID: 2, LeaderIPAddress: "192.168.1.2:4444", Epoch: 1}, 0)
+	if err != nil || ok {
+		t.Errorf("got %v, %v; want the second claim to fail", ok, err)
+	}
+
+	// a retried claim that is already in place succeeds
+	ok, err = nodes[2].CompareAndSet(first, 0)
+	if err != nil || !ok {
+		t.Errorf("got %v, %v; want the retried claim to succeed", ok, err)
+	}
+	for _, n := range nodes {
+		l, err := n.Get()
+		if err != nil || l != first {
+			t.Errorf("%s: got %v, %v; want %v", n.cfg.ID, l, err, first)
+		}
+	}
+}
+
+func TestMinorityCannotWrite(t *testing.T) {
+
+	mn, nodes := newCluster(t, 3)
+	old := waitLeader(t, nodes)
+	first := gmcom.GMLeader{LeaderID: 1, LeaderIPAddress: "192.168.1.1:4444"}
+	if err := old.Set(first); err != nil {
+		t.Fatal(err)
+	}
+	oldTerm := old.Term()
+
+	// partition the leader from the other nodes
+	mn.isolate(old.cfg.ID, true)
+	var rest []*Node
+	for _, n := range nodes {
+		if n != old {
+			rest = append(rest, n)
+		}
+	}
+	ldr := waitLeader(t, rest)
+	if ldr.Term() <= oldTerm {
+		t.Errorf("new leader has term %d; want > %d", ldr.Term(), oldTerm)
+	}
+
+	// the old leader can no longer commit, the majority can
+	old.cfg.OpTimeout = 200 * time.Millisecond
+	if err := old.Set(gmcom.GMLeader{LeaderID: 9, LeaderIPAddress: "192.168.1.9:4444"}); err == nil {
+		t.Error("the isolated leader should not be able to write")
+	}
+	second := gmcom.GMLeader{LeaderID: 2, LeaderIPAddress: "192.168.1.2:4444"}
+	if err := rest[0].Set(second); err != nil {
+		t.Fatal(err)
+	}
+
+	// after healing the partition the old leader steps down and sees the majority's record
+	mn.isolate(old.cfg.ID, false)
+	old.cfg.OpTimeout = time.Second
+	deadline := time.Now().Add(2 * time.Second)
+	for old.IsLeader() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	l, err := old.Get()
+	if err != nil || l != second {
+		t.Errorf("got %v, %v; want %v", l, err, second)
+	}
+}
+
+func TestPersistence(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gmraft")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := testConfig("node1", nil)
+	cfg.DataFile = filepath.Join(dir, "raft.state")
+	mn := &memNet{nodes: make(map[string]*Node), isolated: make(map[string]bool)}
+	n, err := NewNode(cfg, &memTransport{net: mn, from: "node1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mn.nodes["node1"] = n
+	n.Start()
+	want := gmcom.GMLeader{LeaderID: 3, LeaderIPAddress: "192.168.1.3:4444"}
+	if err := n.Set(want); err != nil {
+		t.Fatal(err)
+	}
+	term := n.Term()
+	n.Stop()
+
+	n2, err := NewNode(cfg, &memTransport{net: mn, from: "node1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n2.term != term || n2.votedFor != "node1" || n2.entry.Leader != want {
+		t.Errorf("got term %d, vote %q, record %v; want %d, node1, %v", n2.term, n2.votedFor, n2.entry.Leader, term, want)
+	}
+}
+
+func TestWSTransport(t *testing.T) {
+
+	n, err := NewNode(testConfig("node1", nil), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(Handler(n))
+	defer srv.Close()
+	addr := strings.TrimPrefix(srv.URL, "http://")
+
+	tr := &WSTransport{Timeout: time.Second}
+	vr, err := tr.RequestVote(addr, VoteRequest{Term: 4, CandidateID: "node2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !vr.Granted || vr.Term != 4 {
+		t.Errorf("got %+v; want the vote in term 4", vr)
+	}
+
+	e := Entry{Term: 4, Index: 1, Leader: gmcom.GMLeader{LeaderID: 2, LeaderIPAddress: "192.168.1.2:4444"}}
+	ar, err := tr.AppendEntries(addr, AppendRequest{Term: 4, LeaderID: "node2", Entry: e})
+	if err != nil || !ar.Success {
+		t.Fatalf("got %+v, %v; want success", ar, err)
+	}
+	if n.Leader() != "node2" || n.entry != e {
+		t.Errorf("got leader %q, entry %v", n.Leader(), n.entry)
+	}
+
+	fr, err := tr.Forward(addr, ForwardRequest{})
+	if err != nil || fr.Err != ErrNotLeader.Error() {
+		t.Errorf("got %+v, %v; want %v", fr, err, ErrNotLeader)
+	}
+}
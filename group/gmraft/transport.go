@@ -0,0 +1,154 @@
+package gmraft
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/1414C/libraryapp/group/gmcom"
+	"github.com/1414C/lw"
+	"golang.org/x/net/websocket"
+)
+
+// WSTransport sends the raft RPCs over websockets to the raft listeners of the
+// other nodes.  Like the group-membership traffic, the RPCs use mutual-TLS and
+// are sealed with the cluster key when these are enabled in gmcom.
+type WSTransport struct {
+	Timeout time.Duration
+}
+
+// ensure consistency against interface
+var _ Transport = &WSTransport{}
+
+// RequestVote sends a VoteRequest to peer.
+func (t *WSTransport) RequestVote(peer string, req VoteRequest) (*VoteResponse, error) {
+
+	resp := &VoteResponse{}
+	err := t.call(peer, "/raft/vote", req, resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// AppendEntries sends an AppendRequest to peer.
+func (t *WSTransport) AppendEntries(peer string, req AppendRequest) (*AppendResponse, error) {
+
+	resp := &AppendResponse{}
+	err := t.call(peer, "/raft/append", req, resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Forward sends a ForwardRequest to peer.
+func (t *WSTransport) Forward(peer string, req ForwardRequest) (*ForwardResponse, error) {
+
+	resp := &ForwardResponse{}
+	err := t.call(peer, "/raft/forward", req, resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// call sends req to the endpoint at path of peer and decodes the response into
+// resp.
+func (t *WSTransport) call(peer, path string, req, resp interface{}) error {
+
+	timeout := t.Timeout
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+
+	ws, err := gmcom.DialGM(peer, path, timeout)
+	if err != nil {
+		return err
+	}
+	defer ws.Close()
+	ws.SetDeadline(time.Now().Add(timeout))
+
+	err = sendRPC(ws, req)
+	if err != nil {
+		return err
+	}
+	return receiveRPC(ws, resp)
+}
+
+// sendRPC gob-encodes v and sends it over the websocket.
+func sendRPC(ws *websocket.Conn, v interface{}) error {
+
+	buf := new(bytes.Buffer)
+	err := gob.NewEncoder(buf).Encode(v)
+	if err != nil {
+		return fmt.Errorf("raft: failed to gob-encode %T: %s", v, err)
+	}
+	return gmcom.SendPayload(ws, buf.Bytes())
+}
+
+// receiveRPC receives a message from the websocket and gob-decodes it into v.
+func receiveRPC(ws *websocket.Conn, v interface{}) error {
+
+	b, err := gmcom.ReceivePayload(ws)
+	if err != nil {
+		return err
+	}
+	err = gob.NewDecoder(bytes.NewBuffer(b)).Decode(v)
+	if err != nil {
+		return fmt.Errorf("raft: failed to gob-decode %T: %s", v, err)
+	}
+	return nil
+}
+
+// Handler returns the http.Handler serving the raft RPCs of node n.
+func Handler(n *Node) http.Handler {
+
+	mux := http.NewServeMux()
+	mux.Handle("/raft/vote", websocket.Handler(func(ws *websocket.Conn) {
+		var req VoteRequest
+		if receiveRPC(ws, &req) == nil {
+			sendRPC(ws, n.HandleVote(req))
+		}
+	}))
+	mux.Handle("/raft/append", websocket.Handler(func(ws *websocket.Conn) {
+		var req AppendRequest
+		if receiveRPC(ws, &req) == nil {
+			sendRPC(ws, n.HandleAppend(req))
+		}
+	}))
+	mux.Handle("/raft/forward", websocket.Handler(func(ws *websocket.Conn) {
+		var req ForwardRequest
+		if receiveRPC(ws, &req) == nil {
+			sendRPC(ws, n.HandleForward(req))
+		}
+	}))
+	return mux
+}
+
+// ListenAndServe starts serving the raft RPCs of node n on address in a new
+// goroutine and returns the server, which can be stopped by calling its
+// Shutdown method.  Mutual-TLS is used when it has been enabled via
+// gmcom.SetClusterTLS.
+func ListenAndServe(n *Node, address string) *http.Server {
+
+	srv := &http.Server{
+		Addr:      address,
+		Handler:   Handler(n),
+		TLSConfig: gmcom.ServerTLSConfig(),
+	}
+	go func() {
+		var err error
+		if srv.TLSConfig != nil {
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			lw.ErrorWithPrefixString("RAFT: ListenAndServe() error - got:", err)
+		}
+	}()
+	return srv
+}
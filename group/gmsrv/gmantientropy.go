@@ -0,0 +1,137 @@
+package gmsrv
+
+import (
+	"bytes"
+	"encoding/gob"
+	"math/rand"
+	"time"
+
+	"github.com/1414C/libraryapp/group/gmcl"
+	"github.com/1414C/libraryapp/group/gmcom"
+	"github.com/1414C/lw"
+	"golang.org/x/net/websocket"
+)
+
+// reconcilableCaches returns the authorization caches that take part in the
+// anti-entropy reconciliation.  The usrgroups are reconciled ahead of the
+// groupauths that refer to them by name, and of the usr memberships that
+// refer to them by ID.
+func (gm *GMServ) reconcilableCaches() []gmcom.ReconcilableCache {
+
+	var rcs []gmcom.ReconcilableCache
+	if gm.UsrGroupsH != nil {
+		rcs = append(rcs, gm.UsrGroupsH)
+	}
+	if gm.AuthsH != nil {
+		rcs = append(rcs, gm.AuthsH)
+	}
+	if gm.GroupAuthsH != nil {
+		rcs = append(rcs, gm.GroupAuthsH)
+	}
+	if gm.ActUsrsH != nil {
+		rcs = append(rcs, gm.ActUsrsH)
+	}
+	if gm.UsrMembersH != nil {
+		rcs = append(rcs, gm.UsrMembersH)
+	}
+	return rcs
+}
+
+// runAntiEntropy reconciles the authorization caches with a random group-member
+// every AntiEntropyCycle.
+func (gm *GMServ) runAntiEntropy() {
+
+	for {
+		time.Sleep(gm.AntiEntropyCycle)
+		lw.Debug("ANTI-ENTROPY: starting reconciliation...")
+		gm.reconcileCaches()
+	}
+}
+
+// reconcileCaches sends the digests of the local authorization caches to a
+// random non-failed group-member and merges the entries it returns for the
+// buckets that differ.
+func (gm *GMServ) reconcileCaches() {
+
+	m := gm.SendGetLocalDetails()
+	if m == nil || m.MemberMap == nil {
+		lw.Warning("ANTI-ENTROPY: failed to read group server details in SendGetLocalDetails()")
+		return
+	}
+
+	// processes speaking an earlier protocol version do not serve /reconcilecaches
+	var peers []gmcom.GMMember
+	for _, g := range m.MemberMap.ReadActiveProcessList() {
+		if g.ID != gm.MyID && gm.peerProtocol(g.IPAddress) >= gmcom.CProtocolAntiEntropy {
+			peers = append(peers, g)
+		}
+	}
+	if len(peers) == 0 {
+		return
+	}
+	peer := peers[rand.Intn(len(peers))]
+
+	rcs := gm.reconcilableCaches()
+	digests := make([]gmcom.CacheDigest, 0, len(rcs))
+	for _, rc := range rcs {
+		digests = append(digests, rc.Digest())
+	}
+
+	deltas, err := gmcl.ReconcileCaches(digests, peer.IPAddress)
+	if err != nil {
+		lw.Warning("ANTI-ENTROPY: reconciliation with process-id %d failed - got: %s", peer.ID, err)
+		return
+	}
+	for _, d := range deltas {
+		for _, rc := range rcs {
+			if rc.Cache() != d.Cache {
+				continue
+			}
+			if n := rc.Merge(d); n > 0 {
+				lw.Info("ANTI-ENTROPY: applied %d %s cache entries from process-id %d", n, d.Cache, peer.ID)
+			}
+		}
+	}
+}
+
+// CacheReconcileHandler deals with incoming cache digests from group-members
+// that reconcile their authorization caches with the local process.  The
+// entries of the buckets in which the local caches differ are returned.
+func (gm *GMServ) CacheReconcileHandler(ws *websocket.Conn) {
+	lw.Debug("In CacheReconcileHandler()")
+
+	raw, err := gmcom.ReceivePayload(ws)
+	if err != nil {
+		lw.ErrorWithPrefixString("CacheReconcileHandler() ws.Read() error:", err)
+		return
+	}
+	var digests []gmcom.CacheDigest
+	err = gob.NewDecoder(bytes.NewBuffer(raw)).Decode(&digests)
+	if err != nil {
+		lw.ErrorWithPrefixString("CacheReconcileHandler() gob.Decode() error:", err)
+		return
+	}
+
+	deltas := make([]gmcom.CacheDelta, 0)
+	for _, rc := range gm.reconcilableCaches() {
+		for _, d := range digests {
+			if d.Cache != rc.Cache() {
+				continue
+			}
+			if buckets := rc.Digest().Diff(d); len(buckets) > 0 {
+				deltas = append(deltas, rc.Delta(buckets))
+			}
+		}
+	}
+
+	encBuf := new(bytes.Buffer)
+	err = gob.NewEncoder(encBuf).Encode(deltas)
+	if err != nil {
+		lw.ErrorWithPrefixString("CacheReconcileHandler() gob.Encode() error:", err)
+		return
+	}
+	err = gmcom.SendPayload(ws, encBuf.Bytes())
+	if err != nil {
+		lw.ErrorWithPrefixString("CacheReconcileHandler() ws.Write response error:", err)
+	}
+}
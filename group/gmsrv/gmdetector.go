@@ -0,0 +1,200 @@
+package gmsrv
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// FailureDetector decides when a suspect process is declared failed.  A process
+// that does not ACK a PING, either directly or via PINGREQ, is suspected by the
+// local process; the FailureDetector is then consulted each time the suspect
+// misses a further PING.  The ACKs received from a process are reported to the
+// FailureDetector as heartbeats.
+//
+// The suspect counts disseminated by other processes are evaluated against
+// GMServ.FailureThreshold irrespective of the FailureDetector in use.
+type FailureDetector interface {
+	// Heartbeat records an ACK received from process id at time t.
+	Heartbeat(id uint, t time.Time)
+
+	// Failed reports whether suspect process id, which has missed statusCount
+	// PINGs since it was suspected, is to be declared failed at time t.
+	Failed(id uint, statusCount uint, t time.Time) bool
+
+	// Forget drops the heartbeat history of process id.
+	Forget(id uint)
+}
+
+// CounterDetector declares a suspect process failed once it has missed more
+// than Threshold PINGs.  It is the default FailureDetector.
+type CounterDetector struct {
+	Threshold uint32
+}
+
+// ensure consistency against interface
+var _ FailureDetector = &CounterDetector{}
+
+// NewCounterDetector creates a CounterDetector with the specified threshold.
+func NewCounterDetector(threshold uint32) *CounterDetector {
+	return &CounterDetector{
+		Threshold: threshold,
+	}
+}
+
+// Heartbeat is a no-op; the CounterDetector only counts missed PINGs.
+func (d *CounterDetector) Heartbeat(id uint, t time.Time) {}
+
+// Failed reports whether statusCount exceeds the threshold.
+func (d *CounterDetector) Failed(id uint, statusCount uint, t time.Time) bool {
+	return statusCount > uint(atomic.LoadUint32(&d.Threshold))
+}
+
+// Forget is a no-op; the CounterDetector keeps no history.
+func (d *CounterDetector) Forget(id uint) {}
+
+// PhiAccrualDetector is the phi-accrual failure detector of Hayashibara et al.
+// Rather than counting missed PINGs, it keeps a window of the intervals between
+// the heartbeats of each process and computes phi, the suspicion level, from the
+// time elapsed since the last heartbeat.  A phi of 1 means that the probability
+// of the process being declared failed in error is 10%, a phi of 2 1%, and so on.
+// Since phi adapts to the observed intervals, processes on loaded hosts are given
+// more time than those on quiet ones.
+type PhiAccrualDetector struct {
+	Threshold  float64       // phi at which a suspect process is declared failed
+	WindowSize int           // number of intervals kept per process
+	MinStdDev  time.Duration // lower bound of the standard deviation of the intervals
+	Expected   time.Duration // interval assumed until heartbeats have been observed
+	mu         sync.Mutex
+	history    map[uint]*heartbeatHistory
+}
+
+// heartbeatHistory holds the heartbeat intervals of a process in milliseconds.
+type heartbeatHistory struct {
+	last      time.Time
+	intervals []float64
+	sum       float64
+	sqSum     float64
+}
+
+// ensure consistency against interface
+var _ FailureDetector = &PhiAccrualDetector{}
+
+// NewPhiAccrualDetector creates a PhiAccrualDetector.  expected should be the
+// ping cycle of the process; zero values select a threshold of 8, a window of
+// 100 intervals and the default ping cycle of 5 seconds.
+func NewPhiAccrualDetector(threshold float64, windowSize int, expected time.Duration) *PhiAccrualDetector {
+
+	if threshold <= 0 {
+		threshold = 8
+	}
+	if windowSize <= 0 {
+		windowSize = 100
+	}
+	if expected <= 0 {
+		expected = 5 * time.Second
+	}
+	return &PhiAccrualDetector{
+		Threshold:  threshold,
+		WindowSize: windowSize,
+		MinStdDev:  expected / 10,
+		Expected:   expected,
+		history:    make(map[uint]*heartbeatHistory),
+	}
+}
+
+// Heartbeat records the interval since the previous heartbeat of process id.
+func (d *PhiAccrualDetector) Heartbeat(id uint, t time.Time) {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	h, ok := d.history[id]
+	if !ok {
+		d.history[id] = d.newHistory(t)
+		return
+	}
+	h.add(float64(t.Sub(h.last))/float64(time.Millisecond), d.WindowSize)
+	h.last = t
+}
+
+// Failed reports whether the phi of process id at time t has reached the
+// threshold.  statusCount is not evaluated.
+func (d *PhiAccrualDetector) Failed(id uint, statusCount uint, t time.Time) bool {
+	return d.Phi(id, t) >= d.Threshold
+}
+
+// Forget drops the heartbeat history of process id.
+func (d *PhiAccrualDetector) Forget(id uint) {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.history, id)
+}
+
+// Phi returns the suspicion level of process id at time t.  The history of a
+// process that has not been heard from starts at t.
+func (d *PhiAccrualDetector) Phi(id uint, t time.Time) float64 {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	h, ok := d.history[id]
+	if !ok {
+		h = d.newHistory(t)
+		d.history[id] = h
+	}
+
+	n := float64(len(h.intervals))
+	mean := h.sum / n
+	stdDev := math.Sqrt(math.Max(h.sqSum/n-mean*mean, 0))
+	minStdDev := float64(d.MinStdDev) / float64(time.Millisecond)
+	if stdDev < minStdDev {
+		stdDev = minStdDev
+	}
+	elapsed := float64(t.Sub(h.last)) / float64(time.Millisecond)
+	return phi(elapsed, mean, stdDev)
+}
+
+// newHistory returns a heartbeat history starting at t, which is seeded with
+// two intervals averaging d.Expected so that phi can be computed before any
+// intervals have been observed.
+func (d *PhiAccrualDetector) newHistory(t time.Time) *heartbeatHistory {
+
+	h := &heartbeatHistory{
+		last: t,
+	}
+	e := float64(d.Expected) / float64(time.Millisecond)
+	h.add(e-e/4, d.WindowSize)
+	h.add(e+e/4, d.WindowSize)
+	return h
+}
+
+// add appends interval to the history and drops the oldest interval if the
+// window is full.
+func (h *heartbeatHistory) add(interval float64, windowSize int) {
+
+	if len(h.intervals) >= windowSize {
+		old := h.intervals[0]
+		h.intervals = h.intervals[1:]
+		h.sum -= old
+		h.sqSum -= old * old
+	}
+	h.intervals = append(h.intervals, interval)
+	h.sum += interval
+	h.sqSum += interval * interval
+}
+
+// phi computes -log10 of the probability that a heartbeat arrives later than
+// elapsed, assuming normally distributed intervals.  The logistic approximation
+// of the normal cdf is used.
+func phi(elapsed, mean, stdDev float64) float64 {
+
+	y := (elapsed - mean) / stdDev
+	e := math.Exp(-y * (1.5976 + 0.070566*y*y))
+	if elapsed > mean {
+		return -math.Log10(e / (1.0 + e))
+	}
+	return -math.Log10(1.0 - 1.0/(1.0+e))
+}
@@ -0,0 +1,110 @@
+package gmsrv
+
+import (
+	"testing"
+	"time"
+
+	"github.com/1414C/libraryapp/group/gmcom"
+)
+
+func TestCounterDetector(t *testing.T) {
+
+	d := NewCounterDetector(5)
+	now := time.Now()
+	if d.Failed(1, 5, now) {
+		t.Error("a suspect at the threshold should not be failed")
+	}
+	if !d.Failed(1, 6, now) {
+		t.Error("a suspect beyond the threshold should be failed")
+	}
+}
+
+func TestPhiAccrualDetector(t *testing.T) {
+
+	d := NewPhiAccrualDetector(8, 100, time.Second)
+	start := time.Now()
+
+	// regular heartbeats every second
+	at := start
+	for i := 0; i < 20; i++ {
+		d.Heartbeat(1, at)
+		at = at.Add(time.Second)
+	}
+	last := at.Add(-time.Second)
+
+	if d.Failed(1, 100, last.Add(time.Second)) {
+		t.Errorf("process should not be failed one interval after its last heartbeat; phi %f", d.Phi(1, last.Add(time.Second)))
+	}
+	if !d.Failed(1, 1, last.Add(10*time.Second)) {
+		t.Errorf("process should be failed ten intervals after its last heartbeat; phi %f", d.Phi(1, last.Add(10*time.Second)))
+	}
+	if d.Phi(1, last.Add(2*time.Second)) <= d.Phi(1, last.Add(1500*time.Millisecond)) {
+		t.Error("phi should increase with the time since the last heartbeat")
+	}
+
+	// irregular heartbeats widen the distribution, so the same gap is less suspicious
+	d.Heartbeat(2, start)
+	at = start
+	for i := 0; i < 20; i++ {
+		gap := 500 * time.Millisecond
+		if i%2 == 0 {
+			gap = 1500 * time.Millisecond
+		}
+		at = at.Add(gap)
+		d.Heartbeat(2, at)
+	}
+	gap := 2500 * time.Millisecond
+	if d.Phi(2, at.Add(gap)) >= d.Phi(1, last.Add(gap)) {
+		t.Error("phi of the irregular process should be lower than that of the regular process")
+	}
+
+	// a process that has not been heard from is judged against the expected interval
+	if d.Failed(3, 1, start) {
+		t.Error("an unknown process should not be failed immediately")
+	}
+	if !d.Failed(3, 1, start.Add(10*time.Second)) {
+		t.Error("an unknown process should be failed ten expected intervals later")
+	}
+
+	d.Forget(1)
+	if d.Failed(1, 1, last.Add(10*time.Second)) {
+		t.Error("a forgotten process should start a new history")
+	}
+}
+
+func TestAckHeartbeatStaleSuspect(t *testing.T) {
+
+	gm := &GMServ{FailureDetector: NewPhiAccrualDetector(8, 100, time.Second)}
+	alive := gmcom.GMMessage{TargetID: 1, TargetStatus: gmcom.CStatusAlive, TargetIncarnationNumber: 2}
+	suspect := gmcom.GMMessage{TargetID: 1, TargetStatus: gmcom.CStatusSuspect, TargetIncarnationNumber: 2}
+	stale := gmcom.GMMessage{Type: gmcom.CAck, SrcID: 1, SrcIncarnationNumber: 2}
+	refuted := gmcom.GMMessage{Type: gmcom.CAck, SrcID: 1, SrcIncarnationNumber: 3}
+
+	// regular ACKs every second while alive
+	at := time.Now()
+	for i := 0; i < 20; i++ {
+		if !gm.ackHeartbeat(alive, stale, at) {
+			t.Fatal("the ACK of an alive process should be recorded")
+		}
+		at = at.Add(time.Second)
+	}
+
+	// the suspect keeps ACKing with a stale incarnation number; its phi must
+	// keep rising until it is declared failed
+	failed := false
+	for i := 0; i < 20 && !failed; i++ {
+		if gm.ackHeartbeat(suspect, stale, at) {
+			t.Fatal("the stale ACK of a suspect process should not be recorded")
+		}
+		failed = gm.FailureDetector.Failed(1, uint(i+1), at)
+		at = at.Add(time.Second)
+	}
+	if !failed {
+		t.Error("a suspect ACKing with a stale incarnation number should be declared failed")
+	}
+
+	// an ACK refuting the suspicion clears it
+	if !gm.ackHeartbeat(suspect, refuted, at) || gm.FailureDetector.Failed(1, 1, at) {
+		t.Error("an ACK with a newer incarnation number should clear the suspicion")
+	}
+}
@@ -42,6 +42,7 @@ func (gm *GMServ) processPing(p gmcom.GMMessage) (rm gmcom.GMMessage) {
 		TargetID:                p.SrcID,
 		TargetIPAddress:         p.SrcIPAddress,
 		TargetIncarnationNumber: p.SrcIncarnationNumber,
+		LeaderEpoch:             gm.Leader.Epoch,
 	}
 
 	// am I the leader?
@@ -113,6 +114,12 @@ func (gm *GMServ) processPing(p gmcom.GMMessage) (rm gmcom.GMMessage) {
 			return rm
 		}
 
+		// senders speaking gmcom.CProtocolSWIM piggyback the recent membership
+		// changes in place of their memberMap; apply them as a partial memberMap.
+		if p.MemberMap == nil && len(p.Updates) > 0 {
+			p.MemberMap = gmcom.UpdatesMap(p.Updates)
+		}
+
 		// read the sender process-id from the memberMap
 		pj, ok := gm.memberMap.Read(p.SrcID)
 
@@ -221,6 +228,9 @@ func (gm *GMServ) processPing(p gmcom.GMMessage) (rm gmcom.GMMessage) {
 // from the perspective of the pinger.  If the pinger has the local process-id (Pi)
 // marked as suspect, Pi's incarnation number must be updated, and the incoming
 // PingMap is updated so that our localMap will get updated correctly in the
+//
+// A partial memberMap built from piggybacked updates only holds Pi if the
+// pinger's view of Pi has changed recently.
 func (gm *GMServ) updateOwnStatusInPingMap(p *gmcom.GMMessage) error {
 
 	lw.Info("updateOwnStatusInPingMap() called...")
@@ -245,8 +255,72 @@ func (gm *GMServ) updateOwnStatusInPingMap(p *gmcom.GMMessage) error {
 		default:
 			panic(fmt.Errorf("process %d sent ping to process %d (me) and process-id %d was not in memberMap", p.SrcID, gm.MyID, gm.MyID))
 		}
-	} else {
+	} else if p.Updates == nil {
 		panic(fmt.Errorf("updateOwnStatusInPingMap: gm.MyID: %d not read in p.MemberMap.IDMap: %v", gm.MyID, p.MemberMap.IDMap))
 	}
 	return nil
 }
+
+// claimDBLeader attempts to record process-id id at ipAddress as the leader in
+// the persistent store.  The claim advances the epoch of the persisted leader
+// and only succeeds if no other process has claimed the leadership since the
+// record was read, which fences off any previous leader.  If the claim succeeds
+// gm.Leader is set to the new leader, otherwise gm.Leader is set to the leader
+// that won and false is returned.
+func (gm *GMServ) claimDBLeader(id uint, ipAddress string) (bool, error) {
+
+	cur, err := gm.LSG.GetDBLeader()
+	if err != nil {
+		return false, err
+	}
+
+	l := gmcom.GMLeader{
+		LeaderID:        id,
+		LeaderIPAddress: ipAddress,
+		Epoch:           cur.Epoch + 1,
+	}
+	ok, err := gm.LSG.CompareAndSetDBLeader(l, cur.Epoch)
+	if err != nil {
+		return false, err
+	}
+	if ok {
+		gm.Leader = l
+		return true, nil
+	}
+
+	// another process claimed the leadership first
+	cur, err = gm.LSG.GetDBLeader()
+	if err != nil {
+		return false, err
+	}
+	lw.Warning("LEADER: claim of process-id %d lost to process-id %d in epoch %d", id, cur.LeaderID, cur.Epoch)
+	gm.Leader = *cur
+	return false, nil
+}
+
+// observeLeaderEpoch checks the leader epoch carried by an incoming message.  A
+// sender knowing a higher epoch than the local process has seen a more recent
+// leadership claim, so the local leader information is refreshed from the
+// persistent store.  If the local process was the leader, it steps down as a
+// result.  Senders that do not carry an epoch are ignored.
+func (gm *GMServ) observeLeaderEpoch(epoch uint64) {
+
+	if epoch <= gm.Leader.Epoch {
+		return
+	}
+
+	l, err := gm.LSG.GetDBLeader()
+	if err != nil {
+		lw.ErrorWithPrefixString("LEADER: failed to refresh the leader for epoch", err)
+		return
+	}
+	if l.Epoch <= gm.Leader.Epoch {
+		lw.Warning("LEADER: epoch %d was seen, but the persistent store holds epoch %d", epoch, l.Epoch)
+		return
+	}
+	if gm.Leader.LeaderID == gm.MyID && l.LeaderID != gm.MyID {
+		lw.Warning("LEADER: process-id %d stepping down in favour of process-id %d (epoch %d)", gm.MyID, l.LeaderID, l.Epoch)
+	}
+	gm.Leader = *l
+	lw.Info("local leader set to %d, %s in epoch %d", l.LeaderID, l.LeaderIPAddress, l.Epoch)
+}
@@ -0,0 +1,108 @@
+package gmsrv
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/1414C/libraryapp/group/gmcom"
+)
+
+// memLSG is an in-memory gmcom.GMLeaderSetterGetter.  beforeCAS, if set, is
+// called between the read and the write of a claim in order to simulate a
+// concurrent claim.
+type memLSG struct {
+	mu        sync.Mutex
+	leader    gmcom.GMLeader
+	beforeCAS func()
+}
+
+func (m *memLSG) GetDBLeader() (*gmcom.GMLeader, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l := m.leader
+	return &l, nil
+}
+
+func (m *memLSG) SetDBLeader(l gmcom.GMLeader) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.leader = l
+	return nil
+}
+
+func (m *memLSG) CompareAndSetDBLeader(l gmcom.GMLeader, epoch uint64) (bool, error) {
+	if f := m.beforeCAS; f != nil {
+		m.beforeCAS = nil
+		f()
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.leader.Epoch != epoch {
+		return false, nil
+	}
+	m.leader = l
+	return true, nil
+}
+
+func (m *memLSG) Cleanup() error {
+	return nil
+}
+
+func TestClaimDBLeader(t *testing.T) {
+
+	lsg := &memLSG{}
+	a := &GMServ{MyID: 2, MyIPAddress: "192.168.1.2:4444", LSG: lsg}
+	b := &GMServ{MyID: 3, MyIPAddress: "192.168.1.3:4444", LSG: lsg}
+
+	won, err := a.claimDBLeader(a.MyID, a.MyIPAddress)
+	if err != nil || !won {
+		t.Fatalf("got %v, %v; want the claim to succeed", won, err)
+	}
+	if a.Leader.LeaderID != 2 || a.Leader.Epoch != 1 {
+		t.Errorf("got leader %v; want process-id 2 in epoch 1", a.Leader)
+	}
+
+	// b claims the leadership while a claims it again; a loses and adopts b
+	lsg.beforeCAS = func() {
+		if won, err := b.claimDBLeader(b.MyID, b.MyIPAddress); err != nil || !won {
+			t.Errorf("got %v, %v; want the concurrent claim to succeed", won, err)
+		}
+	}
+	won, err = a.claimDBLeader(a.MyID, a.MyIPAddress)
+	if err != nil || won {
+		t.Fatalf("got %v, %v; want the claim to be lost", won, err)
+	}
+	want := gmcom.GMLeader{LeaderID: 3, LeaderIPAddress: "192.168.1.3:4444", Epoch: 2}
+	if a.Leader != want || b.Leader != want || lsg.leader != want {
+		t.Errorf("got %v, %v, %v; want %v", a.Leader, b.Leader, lsg.leader, want)
+	}
+}
+
+func TestObserveLeaderEpoch(t *testing.T) {
+
+	lsg := &memLSG{}
+	a := &GMServ{MyID: 2, MyIPAddress: "192.168.1.2:4444", LSG: lsg}
+	if won, err := a.claimDBLeader(a.MyID, a.MyIPAddress); err != nil || !won {
+		t.Fatalf("got %v, %v; want the claim to succeed", won, err)
+	}
+
+	// messages carrying the current or no epoch leave the leader in place
+	a.observeLeaderEpoch(0)
+	a.observeLeaderEpoch(1)
+	if a.Leader.LeaderID != 2 {
+		t.Errorf("got leader %v; want process-id 2", a.Leader)
+	}
+
+	// an epoch that is not yet in the persistent store is not adopted
+	a.observeLeaderEpoch(5)
+	if a.Leader.LeaderID != 2 || a.Leader.Epoch != 1 {
+		t.Errorf("got leader %v; want process-id 2 in epoch 1", a.Leader)
+	}
+
+	// a newer leader has been claimed elsewhere; the stale leader steps down
+	lsg.SetDBLeader(gmcom.GMLeader{LeaderID: 3, LeaderIPAddress: "192.168.1.3:4444", Epoch: 2})
+	a.observeLeaderEpoch(2)
+	if a.Leader.LeaderID != 3 || a.Leader.Epoch != 2 {
+		t.Errorf("got leader %v; want process-id 3 in epoch 2", a.Leader)
+	}
+}
@@ -6,9 +6,10 @@ package gmsrv
 //=============================================================================================
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
-	"sync/atomic"
+	"time"
 
 	"github.com/1414C/libraryapp/group/gmcom"
 
@@ -87,6 +88,42 @@ func (gm *GMServ) SendGetLocalDetails() *gmcom.GMMessage {
 	return &m
 }
 
+// GetLocalDetails is SendGetLocalDetails for callers outside of the group-membership
+// server.  It gives up once ctx is done if the command channel is not being read,
+// for example because the server is not serving.  Once the command has been
+// accepted the response is always read, as the command channel processing blocks
+// until it has been.
+func (gm *GMServ) GetLocalDetails(ctx context.Context) (*gmcom.GMMessage, error) {
+
+	m := gmcom.GMMessage{
+		Type: gmcom.CinGetLocalDetails,
+	}
+	select {
+	case gm.chin <- m:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	m = <-gm.chout
+	return &m, nil
+}
+
+// ackHeartbeat records the ACK r of the pinged process p as a heartbeat with the
+// failure detector if the ACK leaves p alive, and reports whether it did.  The
+// ACK of a suspect process carrying a stale incarnation number does not clear
+// the suspicion and is not recorded, so that the suspicion level of a
+// phi-accrual detector keeps rising until the process is declared failed.
+func (gm *GMServ) ackHeartbeat(p, r gmcom.GMMessage, t time.Time) bool {
+
+	switch {
+	case p.TargetStatus == gmcom.CStatusAlive:
+	case p.TargetStatus == gmcom.CStatusSuspect && r.SrcIncarnationNumber > p.TargetIncarnationNumber:
+	default:
+		return false
+	}
+	gm.FailureDetector.Heartbeat(p.TargetID, t)
+	return true
+}
+
 // SendJoin is called during the group server initialization using
 // the leader information read from the external persistent store.
 // A false return indicates that the Join request was not acknowledged
@@ -226,6 +263,13 @@ func (gm *GMServ) sendPing(l *gmcom.GMLeader) error {
 	// normal ping use case
 	if l == nil {
 
+		// get the membership changes to piggyback on the pings of this protocol period
+		u := gmcom.GMMessage{
+			Type: gmcom.CinGetUpdates,
+		}
+		gm.chin <- u
+		u = <-gm.chout
+
 		// get the ping target information
 		p.Type = gmcom.CinDoSendPrep
 		gm.chin <- p
@@ -242,6 +286,7 @@ func (gm *GMServ) sendPing(l *gmcom.GMLeader) error {
 			p.Type = gmcom.CPing
 			p.TargetPath = "/ping"
 			p.ExpectResponse = true
+			p.Updates = u.Updates
 			pl = append(pl, p)
 			o = append(o, c)
 			c++
@@ -269,6 +314,7 @@ func (gm *GMServ) sendPing(l *gmcom.GMLeader) error {
 		p.TargetStatus = gmcom.CStatusAlive // make the assumption that the leader is alive
 		p.TargetPath = "/join"
 		p.ExpectResponse = true
+		p.LeaderEpoch = l.Epoch
 		pl = append(pl, p)
 		o = append(o, 0)
 	}
@@ -284,9 +330,29 @@ func (gm *GMServ) sendPing(l *gmcom.GMLeader) error {
 		// received for the ping.
 		p = pl[iv]
 		if p.TargetStatus != gmcom.CStatusFailed && p.TargetStatus != gmcom.CStatusDeparted {
+
+			// processes speaking gmcom.CProtocolSWIM get the piggybacked membership
+			// changes, older processes get the complete memberMap.
+			if p.Type == gmcom.CPing {
+				if gm.peerProtocol(p.TargetIPAddress) >= gmcom.CProtocolSWIM {
+					p.MemberMap = nil
+				} else {
+					p.Updates = nil
+				}
+			}
 			lw.Info("sending PING: %v", p)
 
 			r, err := gm.TxRxGMMessage(p) //(pl[iv])
+
+			// the target may only be unreachable from the local process, so ask
+			// other members to probe it before suspecting it.
+			if err != nil && l == nil && p.SrcID != p.TargetID {
+				ir, ok := gm.sendPingReq(p, pl)
+				if ok {
+					lw.Info("process-id %d was reached via indirect ping", p.TargetID)
+					r, err = ir, nil
+				}
+			}
 			if err != nil {
 				lw.ErrorWithPrefixString("gm.TxRxGMMessage got error in sendPing:", err)
 				// no ACK message received, so set the internal inNoAck message
@@ -324,8 +390,8 @@ func (gm *GMServ) sendPing(l *gmcom.GMLeader) error {
 
 				case gmcom.CStatusSuspect:
 					// old status was suspect, add to suspect count or set Pj to failed
-					// if p.TargetStatusCount < 200 {
-					if p.TargetStatusCount <= uint(atomic.LoadUint32(&gm.FailureThreshold)) {
+					// as decided by the failure detector.
+					if !gm.FailureDetector.Failed(p.TargetID, p.TargetStatusCount, time.Now()) {
 						p.TargetStatusCount++
 
 						// put the inNoAck command into the channel and read it back (wait)
@@ -337,6 +403,7 @@ func (gm *GMServ) sendPing(l *gmcom.GMLeader) error {
 						// list as well.  set the failure on P for good measure.
 						p.TargetStatus = gmcom.CStatusFailed
 						p.TargetStatusCount = 1
+						gm.FailureDetector.Forget(p.TargetID)
 
 						// put the inNoAck command into the channel and read it back (wait)
 						gm.chin <- p
@@ -404,6 +471,11 @@ func (gm *GMServ) sendPing(l *gmcom.GMLeader) error {
 					}
 				}
 
+				// report the ACK to the failure detector
+				if l == nil {
+					gm.ackHeartbeat(p, *r, time.Now())
+				}
+
 				// log.Printf("ACKproc: p.TargetStatus of proc-id %d is %s with incarnation %d\n", p.TargetID, p.TargetStatus, p.TargetIncarnationNumber)
 				// log.Printf("ACKproc: r.SrcStatus of    proc-id %d is %s with incarnation %d\n", r.SrcID, r.SrcStatus, r.SrcIncarnationNumber)
 				switch p.TargetStatus {
@@ -428,8 +500,7 @@ func (gm *GMServ) sendPing(l *gmcom.GMLeader) error {
 					} else {
 						// old status was suspect and the ping has a stale incarnation number,
 						// so leave the process as suspect and increment the TargetStatusCount.
-						// if p.TargetStatusCount < 200 {
-						if p.TargetStatusCount <= uint(atomic.LoadUint32(&gm.FailureThreshold)) {
+						if !gm.FailureDetector.Failed(p.TargetID, p.TargetStatusCount, time.Now()) {
 							p.TargetStatusCount++
 							gm.chin <- p
 							p = <-gm.chout
@@ -439,6 +510,7 @@ func (gm *GMServ) sendPing(l *gmcom.GMLeader) error {
 							// list as well.  set the failure on P for good measure.
 							p.TargetStatus = gmcom.CStatusFailed
 							p.TargetStatusCount = 1
+							gm.FailureDetector.Forget(p.TargetID)
 							gm.chin <- p
 							p = <-gm.chout
 
@@ -485,3 +557,70 @@ func (gm *GMServ) sendPing(l *gmcom.GMLeader) error {
 	}
 	return nil
 }
+
+// sendPingReq asks up to gmcom.CPingReqFanout randomly chosen members of the ping
+// list pl to ping the target of the unacknowledged PING p on behalf of the local
+// process.  The first ACK relayed by one of the members is returned; ok is false
+// if none of them reached the target within gmcom.CPingReqTimeout.  Only members
+// speaking gmcom.CProtocolSWIM serve PINGREQ messages.
+func (gm *GMServ) sendPingReq(p gmcom.GMMessage, pl []gmcom.GMMessage) (r *gmcom.GMMessage, ok bool) {
+
+	helpers := make([]gmcom.GMMessage, 0, len(pl))
+	for _, h := range pl {
+		if h.TargetID == p.TargetID || h.TargetID == p.SrcID || h.TargetStatus != gmcom.CStatusAlive {
+			continue
+		}
+		if gm.peerProtocol(h.TargetIPAddress) < gmcom.CProtocolSWIM {
+			continue
+		}
+		helpers = append(helpers, h)
+	}
+	if len(helpers) == 0 {
+		return nil, false
+	}
+	rand.Shuffle(len(helpers), func(i, j int) {
+		helpers[i], helpers[j] = helpers[j], helpers[i]
+	})
+	if len(helpers) > gmcom.CPingReqFanout {
+		helpers = helpers[:gmcom.CPingReqFanout]
+	}
+
+	// send the PINGREQs concurrently; late responses are dropped into the buffered channel
+	ch := make(chan *gmcom.GMMessage, len(helpers))
+	for _, h := range helpers {
+		go func(h gmcom.GMMessage) {
+			q := gmcom.GMMessage{
+				Type:                 gmcom.CPingReq,
+				SrcID:                p.SrcID,
+				SrcIPAddress:         p.SrcIPAddress,
+				SrcIncarnationNumber: p.SrcIncarnationNumber,
+				TargetID:             h.TargetID,
+				TargetIPAddress:      h.TargetIPAddress,
+				TargetPath:           "/pingreq",
+				SubjectID:            p.TargetID,
+				SubjectIPAddress:     p.TargetIPAddress,
+				ExpectResponse:       true,
+			}
+			lw.Info("sending PINGREQ for process-id %d to process-id %d", p.TargetID, h.TargetID)
+			r, err := gm.TxRxGMMessage(q)
+			if err != nil || r.Type != gmcom.CAck || r.SrcID != p.TargetID {
+				ch <- nil
+				return
+			}
+			ch <- r
+		}(h)
+	}
+
+	timeout := time.After(gmcom.CPingReqTimeout)
+	for range helpers {
+		select {
+		case r = <-ch:
+			if r != nil {
+				return r, true
+			}
+		case <-timeout:
+			return nil, false
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,39 @@
+package gmsrv
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/1414C/libraryapp/group/gmcom"
+)
+
+func TestGetLocalDetails(t *testing.T) {
+
+	// a server that is not serving has no command channel
+	gm := &GMServ{}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := gm.GetLocalDetails(ctx); err == nil {
+		t.Fatal("expected an error from a server that is not serving")
+	}
+
+	// a command channel that nobody reads
+	gm.chin = make(chan gmcom.GMMessage)
+	gm.chout = make(chan gmcom.GMMessage)
+	ctx2, cancel2 := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel2()
+	if _, err := gm.GetLocalDetails(ctx2); err == nil {
+		t.Fatal("expected an error from an unread command channel")
+	}
+
+	go func() {
+		v := <-gm.chin
+		v.TargetID = 3
+		gm.chout <- v
+	}()
+	m, err := gm.GetLocalDetails(context.Background())
+	if err != nil || m.Type != gmcom.CinGetLocalDetails || m.TargetID != 3 {
+		t.Errorf("got %v, %v", m, err)
+	}
+}
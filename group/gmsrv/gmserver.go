@@ -26,12 +26,13 @@ import (
 // GMServInt outlines the core group membership interface.
 type GMServInt interface {
 	processCmdChannel()
-	Serve(myIPAddress string, lsg gmcom.GMLeaderSetterGetter, actUsrs *gmcom.ActUsrsH, groupAuths *gmcom.GroupAuthsH, auths *gmcom.AuthsH, usrGroups *gmcom.UsrGroupsH, logging bool, evlCycle uint, failureThreshold uint64)
+	Serve(myIPAddress string, lsg gmcom.GMLeaderSetterGetter, actUsrs *gmcom.ActUsrsH, groupAuths *gmcom.GroupAuthsH, auths *gmcom.AuthsH, usrGroups *gmcom.UsrGroupsH, usrMembers *gmcom.UsrMembersH, jwtKeys *gmcom.JWTKeysH, sessions *gmcom.SessionsH, logging bool, evlCycle uint, failureThreshold uint64)
 }
 
 // GMServHandlerInt outlines the group-membership related web-socket handlers.
 type GMServHandlerInt interface {
 	PingHandler(ws *websocket.Conn)
+	PingReqHandler(ws *websocket.Conn)
 	JoinHandler(ws *websocket.Conn)
 	CoordinatorHandler(ws *websocket.Conn)
 	UsrUpdateHandler(ws *websocket.Conn)
@@ -72,6 +73,8 @@ type GMServ struct {
 	MyIncarnation    uint
 	MyNetworkOffline bool // true = unable to ping myself
 	FailureThreshold uint32
+	FailureDetector  FailureDetector // defaults to a CounterDetector using FailureThreshold
+	AntiEntropyCycle time.Duration   // interval of the cache reconciliation; 0 disables it
 	chin             chan gmcom.GMMessage
 	chout            chan gmcom.GMMessage
 	count            int
@@ -81,7 +84,12 @@ type GMServ struct {
 	GroupAuthsH      *gmcom.GroupAuthsH
 	AuthsH           *gmcom.AuthsH
 	UsrGroupsH       *gmcom.UsrGroupsH
+	UsrMembersH      *gmcom.UsrMembersH
+	JWTKeysH         *gmcom.JWTKeysH
+	SessionsH        *gmcom.SessionsH
 	InElection       bool // true/false
+	peerProtocolMu   sync.RWMutex
+	peerProtocols    map[string]uint // map[ipAddress]negotiated wire-protocol version
 	GMServInt
 	GMServHandlerInt
 	GMServSenderInt
@@ -95,7 +103,7 @@ var _ GMServSenderInt = &GMServ{}
 var _ GMServHandlerInt = &GMServ{}
 
 // init an empty gm server
-func (gm *GMServ) initialize(myIPAddress string, lsg gmcom.GMLeaderSetterGetter, actUsrs *gmcom.ActUsrsH, groupAuths *gmcom.GroupAuthsH, auths *gmcom.AuthsH, usrGroups *gmcom.UsrGroupsH, logging bool, failureThreshold uint32) error {
+func (gm *GMServ) initialize(myIPAddress string, lsg gmcom.GMLeaderSetterGetter, actUsrs *gmcom.ActUsrsH, groupAuths *gmcom.GroupAuthsH, auths *gmcom.AuthsH, usrGroups *gmcom.UsrGroupsH, usrMembers *gmcom.UsrMembersH, jwtKeys *gmcom.JWTKeysH, sessions *gmcom.SessionsH, logging bool, failureThreshold uint32) error {
 
 	//log.SetFlags(0)
 
@@ -113,6 +121,11 @@ func (gm *GMServ) initialize(myIPAddress string, lsg gmcom.GMLeaderSetterGetter,
 		gm.FailureThreshold = failureThreshold
 	}
 
+	// the failure detector may be selected before Serve is called
+	if gm.FailureDetector == nil {
+		gm.FailureDetector = NewCounterDetector(gm.FailureThreshold)
+	}
+
 	// initialize the local server attributes
 	gm.MyID = 0
 	gm.MyIPAddress = myIPAddress
@@ -123,12 +136,16 @@ func (gm *GMServ) initialize(myIPAddress string, lsg gmcom.GMLeaderSetterGetter,
 	gm.GroupAuthsH = groupAuths
 	gm.AuthsH = auths
 	gm.UsrGroupsH = usrGroups
+	gm.UsrMembersH = usrMembers
+	gm.JWTKeysH = jwtKeys
+	gm.SessionsH = sessions
 
 	// initialize the members ordered map
 	if gm.memberMap == nil {
 		gm.memberMap = &gmcom.OMap{}
 		gm.memberMap.Init()
 	}
+	gm.memberMap.TrackChanges()
 
 	// initialize the command/serialization channel
 	gm.chin = make(chan gmcom.GMMessage)
@@ -151,11 +168,13 @@ func (gm *GMServ) processCmdChannel() {
 		switch v.Type {
 		case gmcom.CJoin:
 			lw.Info("JOIN received by processCmdChannel()")
+			gm.observeLeaderEpoch(v.LeaderEpoch)
 			rm := gm.processPing(v)
 			gm.chout <- rm
 
 		case gmcom.CJoinAck:
 			lw.Info("JOIN has been accepted")
+			gm.observeLeaderEpoch(v.LeaderEpoch)
 			jm := gmcom.GMMember{
 				ID:                v.TargetID,
 				IPAddress:         v.TargetIPAddress,
@@ -199,11 +218,13 @@ func (gm *GMServ) processCmdChannel() {
 
 		case gmcom.CPing:
 			lw.Info("PING received from process-id: %v", v.SrcID)
+			gm.observeLeaderEpoch(v.LeaderEpoch)
 			rm := gm.processPing(v)
 			gm.chout <- rm
 
 		case gmcom.CAck:
 			lw.Info("ACK received from process-id: %v", v.SrcID)
+			gm.observeLeaderEpoch(v.LeaderEpoch)
 			// lw.Debug("ACK received")
 			// lw.Debug("v.SrcID: %v", v.SrcID)
 			// lw.Debug("v.SrcIPAddress: %v", v.SrcIPAddress)
@@ -278,6 +299,9 @@ func (gm *GMServ) processCmdChannel() {
 				lw.ErrorWithPrefixString("error: gm.memberMap.UpdFailedDepartedProcesses() got:", err)
 			}
 
+			// stamp the pings with the epoch of the local leader so that stale leaders step down
+			v.LeaderEpoch = gm.Leader.Epoch
+
 			// populate the dissemination membermap with a deep-copy of the local membermap in order
 			// to avoid goroutine contention during the encoding of the outgoing message.
 			v.MemberMap = new(gmcom.OMap)
@@ -308,6 +332,11 @@ func (gm *GMServ) processCmdChannel() {
 			v.TargetIPAddress = ""
 			gm.chout <- v
 
+		case gmcom.CinGetUpdates:
+			// read the membership changes to be piggybacked on the pings of this protocol period
+			v.Updates = gm.memberMap.ReadChanges(gmcom.PiggybackLimit(gm.memberMap.Count()), gmcom.CPiggybackMax)
+			gm.chout <- v
+
 		case gmcom.CinGetMySrcInfo:
 			v.SrcID = gm.MyID
 			v.SrcIPAddress = gm.MyIPAddress
@@ -331,31 +360,48 @@ func (gm *GMServ) processCmdChannel() {
 				rm := v
 				rm.TargetID = leader.LeaderID
 				rm.TargetIPAddress = leader.LeaderIPAddress
+				rm.LeaderEpoch = leader.Epoch
 				gm.chout <- rm
 			}
 
 		case gmcom.CinSetDBLeader:
 
-			gm.Leader.LeaderID = v.TargetID
-			gm.Leader.LeaderIPAddress = v.TargetIPAddress
-
-			err := gm.LSG.SetDBLeader(gm.Leader)
+			// claim the leadership for the target process; the response carries
+			// gmcom.CerrLeaderClaimLost if another process claimed it first.
+			ok, err := gm.claimDBLeader(v.TargetID, v.TargetIPAddress)
 			if err != nil {
 				lw.ErrorWithPrefixString("CinSetDBLeader failed:", err)
+				gm.Leader.LeaderID = v.TargetID
+				gm.Leader.LeaderIPAddress = v.TargetIPAddress
+			} else if !ok {
+				v.ErrCode = gmcom.CerrLeaderClaimLost
+				gm.chout <- v
+				continue
 			}
-			lw.Info("db and local leader set to %d, %s", v.TargetID, v.TargetIPAddress)
+			lw.Info("db and local leader set to %d, %s in epoch %d", v.TargetID, v.TargetIPAddress, gm.Leader.Epoch)
+			v.LeaderEpoch = gm.Leader.Epoch
 			gm.chout <- v
 
 		case gmcom.CinSetLeader:
+			// ignore coordinators of leaders that have since been superseded
+			if v.LeaderEpoch != 0 && v.LeaderEpoch < gm.Leader.Epoch {
+				lw.Warning("ignoring leader %d, %s with stale epoch %d (current epoch %d)", v.TargetID, v.TargetIPAddress, v.LeaderEpoch, gm.Leader.Epoch)
+				gm.chout <- v
+				continue
+			}
 			gm.Leader.LeaderID = v.TargetID
 			gm.Leader.LeaderIPAddress = v.TargetIPAddress
+			if v.LeaderEpoch > gm.Leader.Epoch {
+				gm.Leader.Epoch = v.LeaderEpoch
+			}
 			gm.InElection = v.InElection // should be false
-			lw.Info("local leader set to %d, %s", v.TargetID, v.TargetIPAddress)
+			lw.Info("local leader set to %d, %s in epoch %d", v.TargetID, v.TargetIPAddress, gm.Leader.Epoch)
 			gm.chout <- v
 
 		case gmcom.CinGetLocalLeader:
 			v.TargetID = gm.Leader.LeaderID
 			v.TargetIPAddress = gm.Leader.LeaderIPAddress
+			v.LeaderEpoch = gm.Leader.Epoch
 			gm.chout <- v
 
 		case gmcom.CinStartElection:
@@ -389,15 +435,10 @@ func (gm *GMServ) processCmdChannel() {
 				})
 
 				lw.Info("COORDINATOR: local process setting local process-id %d as leader in persistent store.", gm.MyID)
-				err := gm.LSG.SetDBLeader(gmcom.GMLeader{
-					LeaderID:        gm.MyID,
-					LeaderIPAddress: gm.MyIPAddress,
-				})
+				_, err := gm.claimDBLeader(gm.MyID, gm.MyIPAddress)
 				if err != nil {
-					panic("failed calling gm.LSG.SetDBLeader() in gmcom.CinRunElection")
+					panic("failed calling gm.LSG.CompareAndSetDBLeader() in gmcom.CinRunElection")
 				}
-				gm.Leader.LeaderID = gm.MyID
-				gm.Leader.LeaderIPAddress = gm.MyIPAddress
 				gm.InElection = false
 				v.ErrCode = gmcom.CElectComplete
 				gm.chout <- v
@@ -421,18 +462,20 @@ func (gm *GMServ) processCmdChannel() {
 				})
 
 				lw.Info("COORDINATOR: local process setting local process-id %d as leader in persistent store", gm.MyID)
-				err := gm.LSG.SetDBLeader(gmcom.GMLeader{
-					LeaderID:        gm.MyID,
-					LeaderIPAddress: gm.MyIPAddress,
-				})
+				won, err := gm.claimDBLeader(gm.MyID, gm.MyIPAddress)
 				if err != nil {
-					panic("failed calling gm.LSG.SetDBLeader() in gmcom.CinRunElection")
+					panic("failed calling gm.LSG.CompareAndSetDBLeader() in gmcom.CinRunElection")
 				}
-
-				gm.Leader.LeaderID = gm.MyID
-				gm.Leader.LeaderIPAddress = gm.MyIPAddress
 				gm.InElection = false
 
+				// another process claimed the leadership concurrently and is the leader
+				if !won {
+					v.ErrCode = gmcom.CElectComplete
+					gm.chout <- v
+					lw.Info("COORDINATOR: COMPLETE - leadership lost to leader-id: %d", gm.Leader.LeaderID)
+					continue
+				}
+
 				lw.Debug("COORDINATOR: sending coordinator messages to group members")
 				c := gmcom.GMMessage{
 					Type:                 gmcom.CCoordinator,
@@ -441,6 +484,7 @@ func (gm *GMServ) processCmdChannel() {
 					SrcIncarnationNumber: gm.MyIncarnation,
 					SubjectID:            gm.MyID,
 					SubjectIPAddress:     gm.MyIPAddress,
+					LeaderEpoch:          gm.Leader.Epoch,
 				}
 				// send coordinator messages to all non-failed processes with lower-ids than that of the local process.
 				for i := 0; i < gm.memberMap.Count(); i++ {
@@ -564,6 +608,10 @@ func (gm *GMServ) processCmdChannel() {
 		case gmcom.CinGetLocalDetails:
 			v.TargetID = gm.MyID
 			v.TargetIPAddress = gm.MyIPAddress
+			v.TargetIncarnationNumber = gm.MyIncarnation
+			v.SubjectID = gm.Leader.LeaderID
+			v.SubjectIPAddress = gm.Leader.LeaderIPAddress
+			v.InElection = gm.InElection
 
 			// deep-copy / race mitigation
 			v.MemberMap = new(gmcom.OMap)
@@ -580,9 +628,9 @@ func (gm *GMServ) processCmdChannel() {
 }
 
 // Serve starts the group membership server
-func (gm *GMServ) Serve(myIPAddress string, lsg gmcom.GMLeaderSetterGetter, actUsrs *gmcom.ActUsrsH, groupAuths *gmcom.GroupAuthsH, auths *gmcom.AuthsH, usrGroups *gmcom.UsrGroupsH, logging bool, evlCycle uint, failureThreshold uint64) {
+func (gm *GMServ) Serve(myIPAddress string, lsg gmcom.GMLeaderSetterGetter, actUsrs *gmcom.ActUsrsH, groupAuths *gmcom.GroupAuthsH, auths *gmcom.AuthsH, usrGroups *gmcom.UsrGroupsH, usrMembers *gmcom.UsrMembersH, jwtKeys *gmcom.JWTKeysH, sessions *gmcom.SessionsH, logging bool, evlCycle uint, failureThreshold uint64) {
 	ft := uint32(failureThreshold) // 64-bit atomic alignment mitigation for 32-bit ARM
-	err := gm.initialize(myIPAddress, lsg, actUsrs, groupAuths, auths, usrGroups, logging, ft)
+	err := gm.initialize(myIPAddress, lsg, actUsrs, groupAuths, auths, usrGroups, usrMembers, jwtKeys, sessions, logging, ft)
 	if err != nil {
 		panic("Serve()" + err.Error())
 	}
@@ -591,6 +639,7 @@ func (gm *GMServ) Serve(myIPAddress string, lsg gmcom.GMLeaderSetterGetter, actU
 
 	// failure detector handlers
 	mux.Handle("/ping", websocket.Handler(gm.PingHandler))
+	mux.Handle("/pingreq", websocket.Handler(gm.PingReqHandler))
 	mux.Handle("/join", websocket.Handler(gm.JoinHandler))
 	mux.Handle("/departing", websocket.Handler(gm.DepartingHandler))
 	mux.Handle("/coordinator", websocket.Handler(gm.CoordinatorHandler))
@@ -599,6 +648,10 @@ func (gm *GMServ) Serve(myIPAddress string, lsg gmcom.GMLeaderSetterGetter, actU
 	mux.Handle("/updategroupauthcache", websocket.Handler(gm.GroupAuthUpdateHandler))
 	mux.Handle("/updateauthcache", websocket.Handler(gm.AuthUpdateHandler))
 	mux.Handle("/updateusrgroupcache", websocket.Handler(gm.UsrGroupUpdateHandler))
+	mux.Handle("/updateusrmembercache", websocket.Handler(gm.UsrMemberUpdateHandler))
+	mux.Handle("/updatejwtkeycache", websocket.Handler(gm.JWTKeyUpdateHandler))
+	mux.Handle("/updatesessioncache", websocket.Handler(gm.SessionUpdateHandler))
+	mux.Handle("/reconcilecaches", websocket.Handler(gm.CacheReconcileHandler))
 	mux.Handle("/set", websocket.Handler(gm.SetHandler))
 
 	wg := sync.WaitGroup{}
@@ -608,13 +661,22 @@ func (gm *GMServ) Serve(myIPAddress string, lsg gmcom.GMLeaderSetterGetter, actU
 	lw.Console("Listening for ws traffic on: %s", gm.MyIPAddress)
 
 	// create the ws server - this can be stopped by calling cs.httpServer.Shutdown(...)
+	// when mutual-TLS is enabled, peers without a certificate issued by the cluster
+	// CA are rejected during the handshake.
 	gm.HTTPServer = &http.Server{
-		Addr:    myIPAddress,
-		Handler: mux,
+		Addr:      myIPAddress,
+		Handler:   mux,
+		TLSConfig: gmcom.ServerTLSConfig(),
 	}
 
 	go func() {
-		err := gm.HTTPServer.ListenAndServe()
+		var err error
+		if gm.HTTPServer.TLSConfig != nil {
+			lw.Console("group-membership traffic is protected by mutual-TLS")
+			err = gm.HTTPServer.ListenAndServeTLS("", "")
+		} else {
+			err = gm.HTTPServer.ListenAndServe()
+		}
 		if err != nil {
 			// this will write something in a Shutdown scenario too
 			lw.ErrorWithPrefixString("cs.httpServer.ListenAndServe() error - got:", err)
@@ -675,6 +737,7 @@ func (gm *GMServ) Serve(myIPAddress string, lsg gmcom.GMLeaderSetterGetter, actU
 			l := gmcom.GMLeader{
 				LeaderID:        lm.TargetID,
 				LeaderIPAddress: lm.TargetIPAddress,
+				Epoch:           lm.LeaderEpoch,
 			}
 			// need a better way of determining how the join failed - need to be certain that it is
 			// okay to attempt to seize leadership here.
@@ -739,8 +802,14 @@ func (gm *GMServ) Serve(myIPAddress string, lsg gmcom.GMLeaderSetterGetter, actU
 		}
 		gm.memberMap.Add(gm.MyID, mb)
 
-		// set gm.MyID as leader locally and in the persistent store
+		// set gm.MyID as leader locally and in the persistent store.  if another
+		// process claimed the leadership in the meantime, the local process-id
+		// is no longer valid.
 		gm.SendSetDBLeader(&lm)
+		if lm.ErrCode == gmcom.CerrLeaderClaimLost {
+			lw.Error(fmt.Errorf("JOIN: another process claimed the leadership while process-id %d was claiming it", gm.MyID))
+			os.Exit(-1)
+		}
 
 	case gmcom.CerrJoinNotLeader:
 		lw.Error(lastJoinErr) //.ErrorSummary())
@@ -774,6 +843,11 @@ func (gm *GMServ) Serve(myIPAddress string, lsg gmcom.GMLeaderSetterGetter, actU
 		}
 	}()
 
+	// start the anti-entropy reconciliation of the authorization caches
+	if gm.AntiEntropyCycle > 0 {
+		go gm.runAntiEntropy()
+	}
+
 	lw.Console("initialization complete...")
 	lw.Console("leader info is: %v", gm.Leader)
 	wg.Wait()
@@ -810,6 +884,7 @@ func (gm *GMServ) PingHandler(ws *websocket.Conn) {
 	// decode the incoming PING message and feed to inbound channel
 	m, err := gmcom.DecodeGMMessage(ws)
 	if err == nil {
+		gm.notePeerProtocol(m.SrcIPAddress, m.ProtocolVersion)
 
 		// process the ping and wait for the outbound channel to provide a ACK message
 		gm.chin <- *m
@@ -833,20 +908,14 @@ func (gm *GMServ) PingHandler(ws *websocket.Conn) {
 			return // do not send ACK
 		}
 
-		// gob-encode the ACK message for transmission
-		rawM2, err := gmcom.EncodeGMMessage(m2)
+		// encode the ACK message in the pinger's protocol version and send it
+		err = gmcom.WriteGMMessage(ws, m2, gmcom.NegotiateProtocol(m.ProtocolVersion))
 		if err != nil {
-			// timeout the ws connection and drop a log message to stderr
-			lw.ErrorWithPrefixString("PingHandler() gobEncodeError:", err)
-		} else {
-			_, err = ws.Write(rawM2)
-			if err != nil {
-				lw.ErrorWithPrefixString("PingHandler() ws.Write(rawM2) got error:", err)
-				// did the sender disappear?  if so, this is suspicious,
-				// but the sender's pinger should pickup on the fact that
-				// the process is not responding.  if logging is enabled
-				// drop a message to stderr.
-			}
+			lw.ErrorWithPrefixString("PingHandler() ws.Write(rawM2) got error:", err)
+			// did the sender disappear?  if so, this is suspicious,
+			// but the sender's pinger should pickup on the fact that
+			// the process is not responding.  if logging is enabled
+			// drop a message to stderr.
 		}
 
 		// is there a need to trigger an elelection?
@@ -873,6 +942,62 @@ func (gm *GMServ) PingHandler(ws *websocket.Conn) {
 	}
 }
 
+// PingReqHandler deals with incoming indirect ping requests.  The sender did not
+// receive an ACK for its PING of the subject process, and asks the local process
+// to PING the subject on its behalf.  The subject's ACK is relayed to the sender;
+// if the subject does not respond, a PINGREQ response carrying CerrPingReqNoAck
+// is returned instead.  The local process's view of the subject is not updated.
+// Message Type == PingReq
+func (gm *GMServ) PingReqHandler(ws *websocket.Conn) {
+
+	// decode the incoming PINGREQ message
+	m, err := gmcom.DecodeGMMessage(ws)
+	if err != nil {
+		lw.ErrorWithPrefixString("PingReqHandler error:", err)
+		return
+	}
+	gm.notePeerProtocol(m.SrcIPAddress, m.ProtocolVersion)
+
+	if m.Type != gmcom.CPingReq || m.SubjectID == 0 || m.SubjectIPAddress == "" {
+		lw.Error(fmt.Errorf("PINGREQ: received /pingreq call with incorrect message-type or subject.  got: %v", m))
+		return
+	}
+
+	// ping the subject from the local process
+	p := gmcom.GMMessage{
+		Type: gmcom.CinGetMySrcInfo,
+	}
+	gm.chin <- p
+	p = <-gm.chout
+	p.Type = gmcom.CPing
+	p.TargetID = m.SubjectID
+	p.TargetIPAddress = m.SubjectIPAddress
+	p.TargetPath = "/ping"
+	p.ExpectResponse = true
+
+	rm := gmcom.GMMessage{
+		Type:         gmcom.CPingReq,
+		SrcID:        p.SrcID,
+		SrcIPAddress: p.SrcIPAddress,
+		ErrCode:      gmcom.CerrPingReqNoAck,
+	}
+	r, err := gm.TxRxGMMessage(p)
+	if err != nil {
+		lw.Info("PINGREQ: process-id %d did not ACK the indirect ping for process-id %d", m.SubjectID, m.SrcID)
+	} else if r.Type == gmcom.CAck && r.SrcID == m.SubjectID {
+		rm = *r
+	}
+	rm.TargetID = m.SrcID
+	rm.TargetIPAddress = m.SrcIPAddress
+	rm.MemberMap = nil
+
+	// relay the result in the requester's protocol version
+	err = gmcom.WriteGMMessage(ws, rm, gmcom.NegotiateProtocol(m.ProtocolVersion))
+	if err != nil {
+		lw.ErrorWithPrefixString("PingReqHandler() ws.Write response error:", err)
+	}
+}
+
 // CoordinatorHandler deals with incoming coordinator messages sent by processes
 // stating that they are the new leader.
 func (gm *GMServ) CoordinatorHandler(ws *websocket.Conn) {
@@ -884,6 +1009,7 @@ func (gm *GMServ) CoordinatorHandler(ws *websocket.Conn) {
 		lw.Error(fmt.Errorf("COORDINATOR: received /coordinator call with unreadable payload"))
 		return
 	}
+	gm.notePeerProtocol(c.SrcIPAddress, c.ProtocolVersion)
 
 	// check that the incoming message-type is correct
 	if c.Type != gmcom.CCoordinator {
@@ -897,6 +1023,7 @@ func (gm *GMServ) CoordinatorHandler(ws *websocket.Conn) {
 		TargetID:        c.SubjectID,
 		TargetIPAddress: c.SubjectIPAddress,
 		InElection:      false,
+		LeaderEpoch:     c.LeaderEpoch,
 	}
 	gm.chin <- l
 	l = <-gm.chout
@@ -912,6 +1039,7 @@ func (gm *GMServ) DepartingHandler(ws *websocket.Conn) {
 	if err == nil {
 
 		lw.Debug("DEPARTING: In DepartingHandler for: %v", m)
+		gm.notePeerProtocol(m.SrcIPAddress, m.ProtocolVersion)
 
 		// process the departing message and wait for the outbound channel to provide a response
 		gm.chin <- *m
@@ -940,31 +1068,32 @@ func (gm *GMServ) DepartingHandler(ws *websocket.Conn) {
 // JoinHandler deals with join websocket requests that have been accepted by the router.
 // It is implied that the caller is attempting to join the group by contacting the leader.
 // What to do if the local service is no longer the leader?
+// The JOIN is sent in the legacy protocol version, as the joining process does not
+// know which version the leader speaks.  The leader records the version announced
+// in the JOIN and replies in it; the joining process learns the leader's version
+// from the reply.
 func (gm *GMServ) JoinHandler(ws *websocket.Conn) {
 
 	// decode the incoming JOIN message and feed to inbound channel
 	m, err := gmcom.DecodeGMMessage(ws)
 	if err == nil {
+		version := gmcom.NegotiateProtocol(m.ProtocolVersion)
+		gm.notePeerProtocol(m.SrcIPAddress, m.ProtocolVersion)
+		lw.Info("JoinHandler() %s speaks protocol version %d", m.SrcIPAddress, version)
 		gm.chin <- *m
 
 		// wait for the outbound channel to provide a ACK message
 		m2 := <-gm.chout
 
-		// gob-encode the ACK message for transmission
+		// encode the ACK message in the negotiated protocol version and send it
 		lw.Debug("JoinHandler() response GMMessage: %v", m2)
-		rawM2, err := gmcom.EncodeGMMessage(m2)
+		err = gmcom.WriteGMMessage(ws, m2, version)
 		if err != nil {
-			// timeout the ws connection and drop a log message to stderr
-			lw.ErrorWithPrefixString("JoinHandler():", err)
-		} else {
-			_, err = ws.Write(rawM2)
-			if err != nil {
-				// did the sender disappear?  if so, this is suspicious,
-				// but the sender's pinger should pickup on the fact that
-				// the process is not responding.  if logging is enabled
-				// drop a message to stderr.
-				lw.ErrorWithPrefixString("JoinHandler() ws.Write response error:", err)
-			}
+			// did the sender disappear?  if so, this is suspicious,
+			// but the sender's pinger should pickup on the fact that
+			// the process is not responding.  if logging is enabled
+			// drop a message to stderr.
+			lw.ErrorWithPrefixString("JoinHandler() ws.Write response error:", err)
 		}
 	} else {
 		lw.ErrorWithPrefixString("JoinHandler error:", err)
@@ -978,13 +1107,11 @@ func (gm *GMServ) UsrUpdateHandler(ws *websocket.Conn) {
 
 	// gob decoding
 	var u gmcom.ActUsrD
-	var msg = make([]byte, 1024)
-	l, err := ws.Read(msg)
+	raw, err := gmcom.ReceivePayload(ws)
 	if err != nil {
 		lw.ErrorWithPrefixString("UsrUpdateHandler() ws.Read() error:", err)
 		return
 	}
-	raw := msg[0:l]
 	decBuf := bytes.NewBuffer(raw)
 	err = gob.NewDecoder(decBuf).Decode(&u)
 	if err != nil {
@@ -992,14 +1119,20 @@ func (gm *GMServ) UsrUpdateHandler(ws *websocket.Conn) {
 		return
 	}
 
+	// the change is stamped by the group-member where it originated
+	if u.Stamp == 0 {
+		u.Stamp = time.Now().UnixNano()
+	}
+
 	// update the local server's ActiveUsrs cache (map)
 	gm.ActUsrsH.Lock()
 	gm.ActUsrsH.ActiveUsrs[u.ID] = u.Active
+	gm.ActUsrsH.Versions.Touch(u.ID, u.Stamp)
 	gm.ActUsrsH.Unlock()
 
 	// send to other group members?
 	if !u.Forward {
-		ws.Write([]byte("true"))
+		gmcom.SendPayload(ws, []byte("true"))
 		return
 	}
 	u.Forward = false
@@ -1009,7 +1142,8 @@ func (gm *GMServ) UsrUpdateHandler(ws *websocket.Conn) {
 	m := gm.SendGetLocalDetails()
 	if m == nil {
 		lw.Warning("Failed to read usr cache group server details in SendGetLocalDetails()")
-		ws.Write([]byte("false"))
+		gmcom.SendPayload(ws, []byte("false"))
+		return
 	}
 
 	// send process-id/article-key to group members
@@ -1024,7 +1158,7 @@ func (gm *GMServ) UsrUpdateHandler(ws *websocket.Conn) {
 			lw.ErrorWithPrefixString("wscl.AddUpdUsrCache() error:", err)
 		}
 	}
-	ws.Write([]byte("true"))
+	gmcom.SendPayload(ws, []byte("true"))
 }
 
 // GroupAuthUpdateHandler handles incoming traffic from other group-members containing information regarding
@@ -1034,13 +1168,11 @@ func (gm *GMServ) GroupAuthUpdateHandler(ws *websocket.Conn) {
 
 	// gob decoding
 	var ga gmcom.GroupAuthD
-	var msg = make([]byte, 1024)
-	l, err := ws.Read(msg)
+	raw, err := gmcom.ReceivePayload(ws)
 	if err != nil {
 		lw.ErrorWithPrefixString("GroupAuthUpdateHandler() ws.Read() error:", err)
 		return
 	}
-	raw := msg[0:l]
 	decBuf := bytes.NewBuffer(raw)
 	err = gob.NewDecoder(decBuf).Decode(&ga)
 	if err != nil {
@@ -1048,14 +1180,31 @@ func (gm *GMServ) GroupAuthUpdateHandler(ws *websocket.Conn) {
 		return
 	}
 
-	// lookup the groupName and authName
-	ga.GroupName = gm.UsrGroupsH.GroupNames[ga.GroupID]
-	ga.AuthName = gm.AuthsH.Auths[ga.AuthID]
+	// lookup the groupName and authName; deletions carry the groupauth ID only
+	if ga.Op == gmcom.COpDelete {
+		gm.GroupAuthsH.RLock()
+		n := gm.GroupAuthsH.GroupAuthsID[ga.ID]
+		gm.GroupAuthsH.RUnlock()
+		ga.GroupName = n.GroupName
+		ga.AuthName = n.AuthName
+	} else {
+		gm.UsrGroupsH.RLock()
+		ga.GroupName = gm.UsrGroupsH.GroupNames[ga.GroupID]
+		gm.UsrGroupsH.RUnlock()
+		gm.AuthsH.RLock()
+		ga.AuthName = gm.AuthsH.Auths[ga.AuthID]
+		gm.AuthsH.RUnlock()
+	}
 	if ga.GroupName == "" || ga.AuthName == "" {
 		lw.Warning("GroupAuthUpdateHandler() could not update with GroupName: %s and AuthName: %s", ga.GroupName, ga.AuthName)
 		return
 	}
 
+	// the change is stamped by the group-member where it originated
+	if ga.Stamp == 0 {
+		ga.Stamp = time.Now().UnixNano()
+	}
+
 	// update the local server's GroupAuths cache (map)
 	switch ga.Op {
 	case gmcom.COpCreate, gmcom.COpUpdate:
@@ -1073,6 +1222,8 @@ func (gm *GMServ) GroupAuthUpdateHandler(ws *websocket.Conn) {
 		}
 
 		gm.GroupAuthsH.GroupAuthsID[ga.ID] = gmcom.GroupAuthNames{GroupName: ga.GroupName, AuthName: ga.AuthName}
+		gm.GroupAuthsH.SetScope(ga.GroupName, ga.AuthName, ga.Scope)
+		gm.GroupAuthsH.Versions.Touch(ga.ID, ga.Stamp)
 		gm.GroupAuthsH.Unlock()
 
 	case gmcom.COpDelete:
@@ -1081,7 +1232,9 @@ func (gm *GMServ) GroupAuthUpdateHandler(ws *websocket.Conn) {
 		if mapAuth != nil {
 			delete(mapAuth, ga.AuthName)
 		}
+		gm.GroupAuthsH.SetScope(ga.GroupName, ga.AuthName, "")
 		delete(gm.GroupAuthsH.GroupAuthsID, ga.ID)
+		gm.GroupAuthsH.Versions.Touch(ga.ID, ga.Stamp)
 		gm.GroupAuthsH.Unlock()
 
 	default:
@@ -1090,7 +1243,7 @@ func (gm *GMServ) GroupAuthUpdateHandler(ws *websocket.Conn) {
 
 	// send to other group members?
 	if !ga.Forward {
-		ws.Write([]byte("true"))
+		gmcom.SendPayload(ws, []byte("true"))
 		return
 	}
 	ga.Forward = false
@@ -1100,7 +1253,8 @@ func (gm *GMServ) GroupAuthUpdateHandler(ws *websocket.Conn) {
 	m := gm.SendGetLocalDetails()
 	if m == nil {
 		lw.Warning("Failed to read groupauth cache group server details in SendGetLocalDetails()")
-		ws.Write([]byte("false"))
+		gmcom.SendPayload(ws, []byte("false"))
+		return
 	}
 
 	// send process-id/article-key to group members
@@ -1115,7 +1269,7 @@ func (gm *GMServ) GroupAuthUpdateHandler(ws *websocket.Conn) {
 			lw.ErrorWithPrefixString("wscl.AddUpdGroupAuthCache() error:", err)
 		}
 	}
-	ws.Write([]byte("true"))
+	gmcom.SendPayload(ws, []byte("true"))
 }
 
 // AuthUpdateHandler handles incoming traffic from other group-members containing information regarding
@@ -1125,13 +1279,11 @@ func (gm *GMServ) AuthUpdateHandler(ws *websocket.Conn) {
 
 	// gob decoding
 	var a gmcom.AuthD
-	var msg = make([]byte, 1024)
-	l, err := ws.Read(msg)
+	raw, err := gmcom.ReceivePayload(ws)
 	if err != nil {
 		lw.ErrorWithPrefixString("AuthUpdateHandler() ws.Read() error:", err)
 		return
 	}
-	raw := msg[0:l]
 	decBuf := bytes.NewBuffer(raw)
 	err = gob.NewDecoder(decBuf).Decode(&a)
 	if err != nil {
@@ -1139,15 +1291,22 @@ func (gm *GMServ) AuthUpdateHandler(ws *websocket.Conn) {
 		return
 	}
 
+	// the change is stamped by the group-member where it originated
+	if a.Stamp == 0 {
+		a.Stamp = time.Now().UnixNano()
+	}
+
 	// update the local server's ActiveUsrs cache (map)
 	switch a.Op {
 	case gmcom.COpCreate, gmcom.COpUpdate:
 		gm.AuthsH.Lock()
 		gm.AuthsH.Auths[a.ID] = a.AuthName
+		gm.AuthsH.Versions.Touch(a.ID, a.Stamp)
 		gm.AuthsH.Unlock()
 	case gmcom.COpDelete:
 		gm.AuthsH.Lock()
 		delete(gm.AuthsH.Auths, a.ID)
+		gm.AuthsH.Versions.Touch(a.ID, a.Stamp)
 		gm.AuthsH.Unlock()
 	default:
 		// do nothing
@@ -1155,7 +1314,7 @@ func (gm *GMServ) AuthUpdateHandler(ws *websocket.Conn) {
 
 	// send to other group members?
 	if !a.Forward {
-		ws.Write([]byte("true"))
+		gmcom.SendPayload(ws, []byte("true"))
 		return
 	}
 	a.Forward = false
@@ -1165,7 +1324,8 @@ func (gm *GMServ) AuthUpdateHandler(ws *websocket.Conn) {
 	m := gm.SendGetLocalDetails()
 	if m == nil {
 		lw.Warning("Failed to read auth cache group server details in SendGetLocalDetails()")
-		ws.Write([]byte("false"))
+		gmcom.SendPayload(ws, []byte("false"))
+		return
 	}
 
 	// send process-id/article-key to group members
@@ -1180,7 +1340,7 @@ func (gm *GMServ) AuthUpdateHandler(ws *websocket.Conn) {
 			lw.ErrorWithPrefixString("wscl.AddUpdAuthCache() error:", err)
 		}
 	}
-	ws.Write([]byte("true"))
+	gmcom.SendPayload(ws, []byte("true"))
 }
 
 // UsrGroupUpdateHandler handles incoming traffic from other group-members containing information regarding
@@ -1190,13 +1350,11 @@ func (gm *GMServ) UsrGroupUpdateHandler(ws *websocket.Conn) {
 
 	// gob decoding
 	var ug gmcom.UsrGroupD
-	var msg = make([]byte, 1024)
-	l, err := ws.Read(msg)
+	raw, err := gmcom.ReceivePayload(ws)
 	if err != nil {
 		lw.ErrorWithPrefixString("UsrGroupUpdateHandler() ws.Read() error:", err)
 		return
 	}
-	raw := msg[0:l]
 	decBuf := bytes.NewBuffer(raw)
 	err = gob.NewDecoder(decBuf).Decode(&ug)
 	if err != nil {
@@ -1204,15 +1362,26 @@ func (gm *GMServ) UsrGroupUpdateHandler(ws *websocket.Conn) {
 		return
 	}
 
+	// the change is stamped by the group-member where it originated
+	if ug.Stamp == 0 {
+		ug.Stamp = time.Now().UnixNano()
+	}
+
 	// update the local server's UsrGroup cache (map)
 	switch ug.Op {
 	case gmcom.COpCreate, gmcom.COpUpdate:
 		gm.UsrGroupsH.Lock()
+		oldName, ok := gm.UsrGroupsH.GroupNames[ug.ID]
 		gm.UsrGroupsH.GroupNames[ug.ID] = ug.GroupName
+		gm.UsrGroupsH.Versions.Touch(ug.ID, ug.Stamp)
 		gm.UsrGroupsH.Unlock()
+		if ok && oldName != ug.GroupName {
+			gm.renameGroupAuths(oldName, ug.GroupName, ug.Stamp)
+		}
 	case gmcom.COpDelete:
 		gm.UsrGroupsH.Lock()
 		delete(gm.UsrGroupsH.GroupNames, ug.ID)
+		gm.UsrGroupsH.Versions.Touch(ug.ID, ug.Stamp)
 		gm.UsrGroupsH.Unlock()
 	default:
 		// do nothing
@@ -1220,7 +1389,7 @@ func (gm *GMServ) UsrGroupUpdateHandler(ws *websocket.Conn) {
 
 	// send to other group members?
 	if !ug.Forward {
-		ws.Write([]byte("true"))
+		gmcom.SendPayload(ws, []byte("true"))
 		return
 	}
 	ug.Forward = false
@@ -1230,7 +1399,8 @@ func (gm *GMServ) UsrGroupUpdateHandler(ws *websocket.Conn) {
 	m := gm.SendGetLocalDetails()
 	if m == nil {
 		lw.Warning("Failed to read usrgroup cache group server details in SendGetLocalDetails()")
-		ws.Write([]byte("false"))
+		gmcom.SendPayload(ws, []byte("false"))
+		return
 	}
 
 	// send process-id/article-key to group members
@@ -1245,7 +1415,214 @@ func (gm *GMServ) UsrGroupUpdateHandler(ws *websocket.Conn) {
 			lw.ErrorWithPrefixString("wscl.AddUpdUsrGroupCache() error:", err)
 		}
 	}
-	ws.Write([]byte("true"))
+	gmcom.SendPayload(ws, []byte("true"))
+}
+
+// renameGroupAuths re-keys the group-authorization cache following the renaming
+// of a UsrGroup.  The re-keyed groupauths carry the stamp of the renaming.
+func (gm *GMServ) renameGroupAuths(oldName, newName string, stamp int64) {
+
+	if gm.GroupAuthsH == nil {
+		return
+	}
+	gm.GroupAuthsH.Lock()
+	defer gm.GroupAuthsH.Unlock()
+
+	if mapAuths, ok := gm.GroupAuthsH.GroupAuths[oldName]; ok {
+		gm.GroupAuthsH.GroupAuths[newName] = mapAuths
+		delete(gm.GroupAuthsH.GroupAuths, oldName)
+	}
+	if mapScopes, ok := gm.GroupAuthsH.GroupAuthScopes[oldName]; ok {
+		gm.GroupAuthsH.GroupAuthScopes[newName] = mapScopes
+		delete(gm.GroupAuthsH.GroupAuthScopes, oldName)
+	}
+	for id, ga := range gm.GroupAuthsH.GroupAuthsID {
+		if ga.GroupName == oldName {
+			ga.GroupName = newName
+			gm.GroupAuthsH.GroupAuthsID[id] = ga
+			gm.GroupAuthsH.Versions.Touch(id, stamp)
+		}
+	}
+}
+
+// UsrMemberUpdateHandler handles incoming traffic from other group-members containing
+// the replacement group-memberships of a Usr.
+func (gm *GMServ) UsrMemberUpdateHandler(ws *websocket.Conn) {
+	lw.Debug("In UsrMemberUpdateHandler()")
+
+	// the membership set is sent as a single message-frame
+	raw, err := gmcom.ReceivePayload(ws)
+	if err != nil {
+		lw.ErrorWithPrefixString("UsrMemberUpdateHandler() ws.Read() error:", err)
+		return
+	}
+
+	var um gmcom.UsrMemberD
+	err = gob.NewDecoder(bytes.NewBuffer(raw)).Decode(&um)
+	if err != nil {
+		lw.ErrorWithPrefixString("UsrMemberUpdateHandler() gob.Decode() error:", err)
+		return
+	}
+
+	// the change is stamped by the group-member where it originated
+	if um.Stamp == 0 {
+		um.Stamp = time.Now().UnixNano()
+	}
+
+	// update the local server's usr membership cache (map)
+	if gm.UsrMembersH == nil {
+		lw.Warning("UsrMemberUpdateHandler() no local usr membership cache is available")
+		gmcom.SendPayload(ws, []byte("false"))
+		return
+	}
+	gm.UsrMembersH.Set(um.UsrID, um.GroupIDs, um.Windows, um.Stamp)
+
+	// send to other group members?
+	if !um.Forward {
+		gmcom.SendPayload(ws, []byte("true"))
+		return
+	}
+	um.Forward = false
+
+	// send the update to all non-failed processes in the process group
+	// get a list of the active processes (this is inherently stale)
+	m := gm.SendGetLocalDetails()
+	if m == nil {
+		lw.Warning("Failed to read usr membership cache group server details in SendGetLocalDetails()")
+		gmcom.SendPayload(ws, []byte("false"))
+		return
+	}
+
+	r := m.MemberMap.ReadActiveProcessList()
+	for _, g := range r {
+		if g.ID == gm.MyID {
+			continue
+		}
+		lw.Info("CS: SENDING %v to %s", um, g.IPAddress)
+		err := gmcl.AddUpdUsrMemberCache(um, g.IPAddress)
+		if err != nil {
+			lw.ErrorWithPrefixString("wscl.AddUpdUsrMemberCache() error:", err)
+		}
+	}
+	gmcom.SendPayload(ws, []byte("true"))
+}
+
+// JWTKeyUpdateHandler handles incoming traffic from other group-members containing
+// a replacement jwt key-set following a signing-key rotation.
+func (gm *GMServ) JWTKeyUpdateHandler(ws *websocket.Conn) {
+	lw.Debug("In JWTKeyUpdateHandler()")
+
+	// the key-set is sent as a single message-frame
+	raw, err := gmcom.ReceivePayload(ws)
+	if err != nil {
+		lw.ErrorWithPrefixString("JWTKeyUpdateHandler() ws.Read() error:", err)
+		return
+	}
+
+	var k gmcom.JWTKeysD
+	err = gob.NewDecoder(bytes.NewBuffer(raw)).Decode(&k)
+	if err != nil {
+		lw.ErrorWithPrefixString("JWTKeyUpdateHandler() gob.Decode() error:", err)
+		return
+	}
+
+	// replace the local server's jwt key-set
+	if gm.JWTKeysH == nil {
+		lw.Warning("JWTKeyUpdateHandler() no local jwt key-set cache is available")
+		gmcom.SendPayload(ws, []byte("false"))
+		return
+	}
+	err = gm.JWTKeysH.Replace(k)
+	if err != nil {
+		lw.ErrorWithPrefixString("JWTKeyUpdateHandler() key-set replacement error:", err)
+		gmcom.SendPayload(ws, []byte("false"))
+		return
+	}
+
+	// send to other group members?
+	if !k.Forward {
+		gmcom.SendPayload(ws, []byte("true"))
+		return
+	}
+	k.Forward = false
+
+	// send the update to all non-failed processes in the process group
+	// get a list of the active processes (this is inherently stale)
+	m := gm.SendGetLocalDetails()
+	if m == nil {
+		lw.Warning("Failed to read jwt key cache group server details in SendGetLocalDetails()")
+		gmcom.SendPayload(ws, []byte("false"))
+		return
+	}
+
+	r := m.MemberMap.ReadActiveProcessList()
+	for _, g := range r {
+		if g.ID == gm.MyID {
+			continue
+		}
+		lw.Info("CS: SENDING jwt key-set %s to %s", k.SignKID, g.IPAddress)
+		err := gmcl.AddUpdJWTKeyCache(k, g.IPAddress)
+		if err != nil {
+			lw.ErrorWithPrefixString("wscl.AddUpdJWTKeyCache() error:", err)
+		}
+	}
+	gmcom.SendPayload(ws, []byte("true"))
+}
+
+// SessionUpdateHandler handles incoming traffic from other group-members
+// containing the revocation of a usr session.
+func (gm *GMServ) SessionUpdateHandler(ws *websocket.Conn) {
+	lw.Debug("In SessionUpdateHandler()")
+
+	raw, err := gmcom.ReceivePayload(ws)
+	if err != nil {
+		lw.ErrorWithPrefixString("SessionUpdateHandler() ws.Read() error:", err)
+		return
+	}
+
+	var s gmcom.SessionD
+	err = gob.NewDecoder(bytes.NewBuffer(raw)).Decode(&s)
+	if err != nil {
+		lw.ErrorWithPrefixString("SessionUpdateHandler() gob.Decode() error:", err)
+		return
+	}
+
+	// update the local server's revoked-session cache (map)
+	if gm.SessionsH == nil {
+		lw.Warning("SessionUpdateHandler() no local session cache is available")
+		gmcom.SendPayload(ws, []byte("false"))
+		return
+	}
+	gm.SessionsH.Revoke(s.SID, s.ExpiresOn)
+
+	// send to other group members?
+	if !s.Forward {
+		gmcom.SendPayload(ws, []byte("true"))
+		return
+	}
+	s.Forward = false
+
+	// send the update to all non-failed processes in the process group
+	// get a list of the active processes (this is inherently stale)
+	m := gm.SendGetLocalDetails()
+	if m == nil {
+		lw.Warning("Failed to read session cache group server details in SendGetLocalDetails()")
+		gmcom.SendPayload(ws, []byte("false"))
+		return
+	}
+
+	r := m.MemberMap.ReadActiveProcessList()
+	for _, g := range r {
+		if g.ID == gm.MyID {
+			continue
+		}
+		lw.Info("CS: SENDING session revocation %s of usr %d to %s", s.SID, s.UsrID, g.IPAddress)
+		err := gmcl.AddUpdSessionCache(s, g.IPAddress)
+		if err != nil {
+			lw.ErrorWithPrefixString("wscl.AddUpdSessionCache() error:", err)
+		}
+	}
+	gmcom.SendPayload(ws, []byte("true"))
 }
 
 // LeaveHandler handles the announced departure of a process, thereby
@@ -1264,7 +1641,9 @@ func (gm *GMServ) SetHandler(ws *websocket.Conn) {
 	// decode the incoming Ping message and feed to the inbound
 	// GMMessage channel for processing.
 	m, err := gmcom.DecodeGMMessage(ws)
+	version := gmcom.CProtocolLegacy
 	if err == nil {
+		version = gmcom.NegotiateProtocol(m.ProtocolVersion)
 		gm.chin <- *m
 
 		// wait the outbound channel to provide a Ack message
@@ -1274,12 +1653,12 @@ func (gm *GMServ) SetHandler(ws *websocket.Conn) {
 	}
 
 	m2 := <-gm.chout
-	rawM2, err := gmcom.EncodeGMMessage(m2)
+	rawM2, err := gmcom.FrameGMMessage(m2, version)
 	if err != nil {
 		// timeout the ws connection
 		time.Sleep(2 * time.Second)
 	} else {
-		_, err = ws.Write(rawM2)
+		err = gmcom.SendPayload(ws, rawM2)
 		if err != nil {
 			// did the sender disappear?
 			// this is suspicious, but the sender's pinger should
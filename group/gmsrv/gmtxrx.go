@@ -6,14 +6,10 @@ package gmsrv
 //=============================================================================================
 
 import (
-	"bytes"
-	"encoding/gob"
 	"fmt"
-	"net"
 	"time"
 
 	"github.com/1414C/libraryapp/group/gmcom"
-	"golang.org/x/net/websocket"
 
 	"github.com/1414C/lw"
 )
@@ -59,25 +55,18 @@ func (gm *GMServ) TxRxGMMessage(m gmcom.GMMessage) (*gmcom.GMMessage, error) {
 		}
 	}
 
-	// set origin and target
-	origin := "http://" + gm.MyIPAddress
-	url := "ws://" + m.TargetIPAddress + m.TargetPath
+	// set target
+	target := m.TargetIPAddress + m.TargetPath
 
-	// encode the source message as bytes
-	raw, err := gmcom.EncodeGMMessage(m)
+	// encode the source message as bytes in the protocol version of the target
+	raw, err := gmcom.FrameGMMessage(m, gm.peerProtocol(m.TargetIPAddress))
 	if err != nil {
 		panic(fmt.Errorf("gmcl.TxRxGMMessage error: %s", err))
 	}
 
 	// connect to the target
-	lw.Info("websocket.Dial: %s", url)
-	config, _ := websocket.NewConfig(url, origin)
-	config.Dialer = &net.Dialer{
-		// Deadline: time.Now().Add(-time.Minute),
-		Deadline: time.Now().Add(5 * time.Second),
-	}
-
-	ws, err := websocket.DialConfig(config)
+	lw.Info("websocket.Dial: %s", target)
+	ws, err := gmcom.DialGM(m.TargetIPAddress, m.TargetPath, 5*time.Second)
 	if err != nil {
 		lw.ErrorWithPrefixString("gm.TxRxGMMessage() ws connection failed - got:", err)
 		if ws != nil {
@@ -91,54 +80,44 @@ func (gm *GMServ) TxRxGMMessage(m gmcom.GMMessage) (*gmcom.GMMessage, error) {
 	}
 
 	defer ws.Close()
-	lw.Info("websocket.Dial: %s complete", url)
+	lw.Info("websocket.Dial: %s complete", target)
 
 	// send the encoded GMMessage
-	_, err = ws.Write(raw)
+	err = gmcom.SendPayload(ws, raw)
 	if err != nil {
 		lw.ErrorWithPrefixString("gm.TxRxGMMessage() ws.Write error - got:", err)
 		return nil, err
 	}
 
-	var msg = make([]byte, 2048)
-	raw = raw[:0]
-
-	// single read from the ws is okay here
-	l, err := ws.Read(msg)
-	if err != nil {
-		lw.ErrorWithPrefixString("gm.TxRxGMMessage() ws.Read error - got:", err)
-		return nil, err
-	}
-
-	// decode the returned message
-	rm := gmcom.GMMessage{}
-	raw = msg[0:l]
-	decBuf := bytes.NewBuffer(raw)
-	err = gob.NewDecoder(decBuf).Decode(&rm)
+	// read and decode the complete response message; the target may have been
+	// replaced by a process speaking an older protocol version, so fall back
+	// to the legacy version for the next message if the response is unreadable.
+	rm, _, err := gmcom.ReadGMMessage(ws)
 	if err != nil {
-		lw.ErrorWithPrefixString("gm.TxRxGMMessage() gob.Decode() error - got:", err)
+		lw.ErrorWithPrefixString("gm.TxRxGMMessage() got:", err)
+		gm.forgetPeerProtocol(m.TargetIPAddress)
 		return nil, err
 	}
-	return &rm, nil
+	gm.notePeerProtocol(m.TargetIPAddress, rm.ProtocolVersion)
+	return rm, nil
 }
 
 // TxGMMessage sends message m to the target process (Pj) and
 // returns an error occurs if there is a technical issue.
 func (gm *GMServ) TxGMMessage(m gmcom.GMMessage) error {
 
-	// set origin and target
-	origin := "http://" + gm.MyIPAddress
-	url := "ws://" + m.TargetIPAddress + m.TargetPath
+	// set target
+	target := m.TargetIPAddress + m.TargetPath
 
-	// encode the source message as bytes
-	raw, err := gmcom.EncodeGMMessage(m)
+	// encode the source message as bytes in the protocol version of the target
+	raw, err := gmcom.FrameGMMessage(m, gm.peerProtocol(m.TargetIPAddress))
 	if err != nil {
 		panic(fmt.Errorf("gm.TxGMMessage error: %s", err))
 	}
 
 	// connect to the target
-	lw.Info("websocket.Dial: %s", url)
-	ws, err := websocket.Dial(url, "", origin)
+	lw.Info("websocket.Dial: %s", target)
+	ws, err := gmcom.DialGM(m.TargetIPAddress, m.TargetPath, 0)
 	if err != nil {
 		lw.ErrorWithPrefixString("gm.TxGMMessage() ws connection failed - got:", err)
 		if ws != nil {
@@ -147,13 +126,54 @@ func (gm *GMServ) TxGMMessage(m gmcom.GMMessage) error {
 		return err
 	}
 	defer ws.Close()
-	lw.Info("websocket.Dial: %s complete", url)
+	lw.Info("websocket.Dial: %s complete", target)
 
 	// send the encoded GMMessage - successful write is enough validation
-	_, err = ws.Write(raw)
+	err = gmcom.SendPayload(ws, raw)
 	if err != nil {
 		lw.ErrorWithPrefixString("gm.TxGMMessage() ws.Write error - got:", err)
 		return err
 	}
 	return nil
 }
+
+// peerProtocol returns the wire-protocol version to be used when sending to the
+// process at ipAddress.  Processes that have not been heard from yet are sent
+// legacy messages, which every version is able to read.
+func (gm *GMServ) peerProtocol(ipAddress string) uint {
+
+	if ipAddress == gm.MyIPAddress {
+		return gmcom.CProtocolVersion
+	}
+	gm.peerProtocolMu.RLock()
+	defer gm.peerProtocolMu.RUnlock()
+	v, ok := gm.peerProtocols[ipAddress]
+	if !ok {
+		return gmcom.CProtocolLegacy
+	}
+	return v
+}
+
+// notePeerProtocol records the protocol version announced by the process at
+// ipAddress.
+func (gm *GMServ) notePeerProtocol(ipAddress string, announced uint) {
+
+	if ipAddress == "" {
+		return
+	}
+	gm.peerProtocolMu.Lock()
+	defer gm.peerProtocolMu.Unlock()
+	if gm.peerProtocols == nil {
+		gm.peerProtocols = make(map[string]uint)
+	}
+	gm.peerProtocols[ipAddress] = gmcom.NegotiateProtocol(announced)
+}
+
+// forgetPeerProtocol drops the recorded protocol version of the process at
+// ipAddress.
+func (gm *GMServ) forgetPeerProtocol(ipAddress string) {
+
+	gm.peerProtocolMu.Lock()
+	defer gm.peerProtocolMu.Unlock()
+	delete(gm.peerProtocols, ipAddress)
+}
@@ -0,0 +1,405 @@
+package middleware
+
+//=============================================================================================
+// Attribute-based access policy code
+//=============================================================================================
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PolicySet holds the attribute-based access policies that are evaluated once
+// the GroupAuth check of a route has passed.  Policies are declared in a JSON
+// policy file:
+//
+//	{
+//	  "timezone": "America/Chicago",
+//	  "policies": [
+//	    {
+//	      "name": "book-delete-no-copies",
+//	      "routes": ["book.DELETE"],
+//	      "conditions": [{"attr": "entity.copies", "op": "eq", "value": 0}]
+//	    },
+//	    {
+//	      "name": "library-update-weekdays",
+//	      "routes": ["library.UPDATE"],
+//	      "conditions": [{"attr": "time.weekday", "op": "in", "value": ["Mon", "Tue", "Wed", "Thu", "Fri"]}]
+//	    }
+//	  ]
+//	}
+//
+// A request to a route is permitted only if every policy naming the route is
+// satisfied.  The timezone governs the time.* attributes; it defaults to the
+// local timezone of the server.
+type PolicySet struct {
+	Timezone string   `json:"timezone"`
+	Policies []Policy `json:"policies"`
+	loc      *time.Location
+	byRoute  map[string][]*Policy
+}
+
+// Policy restricts access to a set of routes.  The policy is satisfied if all
+// of its Conditions hold and, when Any is not empty, at least one of the Any
+// conditions holds as well.
+type Policy struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	Routes      []string          `json:"routes"`
+	Conditions  []PolicyCondition `json:"conditions"`
+	Any         []PolicyCondition `json:"any,omitempty"`
+}
+
+// PolicyCondition compares an attribute of the request with a value.  The
+// supported attributes are:
+//
+//	route           the name of the requested route; e.g. book.DELETE
+//	method          the http method of the request
+//	usr.id          the ID of the requesting usr
+//	groups          the names of the usr's current UsrGroups
+//	claims.<name>   a claim of the access token; e.g. claims.email
+//	entity.<field>  a field of the stored entity addressed by the route's id,
+//	                named as in the entity's JSON representation
+//	time.weekday    Mon, Tue, Wed, Thu, Fri, Sat or Sun
+//	time.hour       the hour of the day; 0-23
+//
+// The supported operators are eq, ne, lt, le, gt, ge, in, not_in, contains,
+// prefix, suffix, exists and not_exists.  A condition on an attribute that
+// is not present in the request does not hold, with the exception of
+// not_exists.
+type PolicyCondition struct {
+	Attr  string      `json:"attr"`
+	Op    string      `json:"op"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// PolicyEntityFunc reads the entity with the specified ID for the evaluation
+// of entity.* attributes.  A func is registered per entity name; the entity
+// name is the route-name prefix, for example book in book.DELETE.
+type PolicyEntityFunc func(id uint64) (interface{}, error)
+
+// PolicyRequest holds the attributes of a request for policy evaluation.
+// Entity is called at most once and only if a policy refers to an entity.*
+// attribute; it returns false if the route does not address an entity or
+// the entity could not be read.
+type PolicyRequest struct {
+	Route  string
+	Method string
+	UsrID  uint64
+	Groups []string
+	Claims map[string]interface{}
+	Time   time.Time
+	Entity func() (map[string]interface{}, bool)
+	entity map[string]interface{}
+	loaded bool
+}
+
+var policyOps = map[string]bool{
+	"eq": true, "ne": true, "lt": true, "le": true, "gt": true, "ge": true,
+	"in": true, "not_in": true, "contains": true, "prefix": true, "suffix": true,
+	"exists": true, "not_exists": true,
+}
+
+// LoadPolicyFile reads and validates a policy file.
+func LoadPolicyFile(fName string) (*PolicySet, error) {
+
+	b, err := ioutil.ReadFile(fName)
+	if err != nil {
+		return nil, err
+	}
+	ps, err := ParsePolicies(b)
+	if err != nil {
+		return nil, fmt.Errorf("policy file %s: %v", fName, err)
+	}
+	return ps, nil
+}
+
+// ParsePolicies parses and validates the JSON representation of a PolicySet.
+func ParsePolicies(b []byte) (*PolicySet, error) {
+
+	var ps PolicySet
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&ps)
+	if err != nil {
+		return nil, err
+	}
+
+	ps.loc = time.Local
+	if ps.Timezone != "" {
+		ps.loc, err = time.LoadLocation(ps.Timezone)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ps.byRoute = make(map[string][]*Policy)
+	names := make(map[string]bool)
+	for i := range ps.Policies {
+		p := &ps.Policies[i]
+		if p.Name == "" {
+			return nil, fmt.Errorf("policy %d has no name", i+1)
+		}
+		if names[p.Name] {
+			return nil, fmt.Errorf("policy %s is declared more than once", p.Name)
+		}
+		names[p.Name] = true
+
+		if len(p.Routes) == 0 {
+			return nil, fmt.Errorf("policy %s does not name any routes", p.Name)
+		}
+		if len(p.Conditions) == 0 && len(p.Any) == 0 {
+			return nil, fmt.Errorf("policy %s has no conditions", p.Name)
+		}
+		for _, c := range append(append([]PolicyCondition{}, p.Conditions...), p.Any...) {
+			err = c.validate()
+			if err != nil {
+				return nil, fmt.Errorf("policy %s: %v", p.Name, err)
+			}
+		}
+		for _, r := range p.Routes {
+			ps.byRoute[r] = append(ps.byRoute[r], p)
+		}
+	}
+	return &ps, nil
+}
+
+// Routes returns the names of the routes that are subject to a policy.
+func (ps *PolicySet) Routes() []string {
+
+	if ps == nil {
+		return nil
+	}
+	routes := make([]string, 0, len(ps.byRoute))
+	for r := range ps.byRoute {
+		routes = append(routes, r)
+	}
+	return routes
+}
+
+// Applies reports whether any policy names the route.
+func (ps *PolicySet) Applies(route string) bool {
+	return ps != nil && len(ps.byRoute[route]) > 0
+}
+
+// Evaluate checks the request against the policies of its route.  If the
+// request is denied, the name of the first unsatisfied policy is returned.
+func (ps *PolicySet) Evaluate(pr *PolicyRequest) (bool, string) {
+
+	if ps == nil {
+		return true, ""
+	}
+	for _, p := range ps.byRoute[pr.Route] {
+		if !ps.satisfied(p, pr) {
+			return false, p.Name
+		}
+	}
+	return true, ""
+}
+
+// satisfied reports whether the request satisfies the policy.
+func (ps *PolicySet) satisfied(p *Policy, pr *PolicyRequest) bool {
+
+	for _, c := range p.Conditions {
+		if !c.holds(ps.attr(c.Attr, pr)) {
+			return false
+		}
+	}
+	if len(p.Any) == 0 {
+		return true
+	}
+	for _, c := range p.Any {
+		if c.holds(ps.attr(c.Attr, pr)) {
+			return true
+		}
+	}
+	return false
+}
+
+// attr returns the value of the named attribute of the request, or nil if the
+// request does not have the attribute.
+func (ps *PolicySet) attr(name string, pr *PolicyRequest) interface{} {
+
+	switch {
+	case name == "route":
+		return pr.Route
+	case name == "method":
+		return pr.Method
+	case name == "usr.id":
+		return pr.UsrID
+	case name == "groups":
+		return pr.Groups
+	case name == "time.weekday":
+		return pr.Time.In(ps.loc).Weekday().String()[:3]
+	case name == "time.hour":
+		return pr.Time.In(ps.loc).Hour()
+	case strings.HasPrefix(name, "claims."):
+		v, ok := pr.Claims[strings.TrimPrefix(name, "claims.")]
+		if !ok {
+			return nil
+		}
+		return v
+	case strings.HasPrefix(name, "entity."):
+		if !pr.loaded {
+			pr.loaded = true
+			if pr.Entity != nil {
+				pr.entity, _ = pr.Entity()
+			}
+		}
+		v, ok := pr.entity[strings.TrimPrefix(name, "entity.")]
+		if !ok {
+			return nil
+		}
+		return v
+	}
+	return nil
+}
+
+// validate checks the attribute, operator and value of the condition.
+func (c PolicyCondition) validate() error {
+
+	switch {
+	case c.Attr == "route", c.Attr == "method", c.Attr == "usr.id", c.Attr == "groups",
+		c.Attr == "time.weekday", c.Attr == "time.hour":
+	case strings.HasPrefix(c.Attr, "claims.") && len(c.Attr) > len("claims."):
+	case strings.HasPrefix(c.Attr, "entity.") && len(c.Attr) > len("entity."):
+	default:
+		return fmt.Errorf("unknown attribute '%s'", c.Attr)
+	}
+
+	if !policyOps[c.Op] {
+		return fmt.Errorf("unknown operator '%s' for attribute %s", c.Op, c.Attr)
+	}
+
+	switch c.Op {
+	case "exists", "not_exists":
+		return nil
+	case "in", "not_in":
+		if _, ok := c.Value.([]interface{}); !ok {
+			return fmt.Errorf("operator %s on attribute %s requires a list value", c.Op, c.Attr)
+		}
+	case "lt", "le", "gt", "ge":
+		if _, ok := toFloat(c.Value); !ok {
+			return fmt.Errorf("operator %s on attribute %s requires a numeric value", c.Op, c.Attr)
+		}
+	case "prefix", "suffix":
+		if _, ok := c.Value.(string); !ok {
+			return fmt.Errorf("operator %s on attribute %s requires a string value", c.Op, c.Attr)
+		}
+	default:
+		if c.Value == nil {
+			return fmt.Errorf("operator %s on attribute %s requires a value", c.Op, c.Attr)
+		}
+	}
+	return nil
+}
+
+// holds applies the condition to the attribute value v.
+func (c PolicyCondition) holds(v interface{}) bool {
+
+	if c.Op == "not_exists" {
+		return v == nil
+	}
+	if v == nil {
+		return false
+	}
+
+	switch c.Op {
+	case "exists":
+		return true
+	case "eq":
+		return policyEqual(v, c.Value)
+	case "ne":
+		return !policyEqual(v, c.Value)
+	case "lt", "le", "gt", "ge":
+		a, ok := toFloat(v)
+		if !ok {
+			return false
+		}
+		b, _ := toFloat(c.Value)
+		switch c.Op {
+		case "lt":
+			return a < b
+		case "le":
+			return a <= b
+		case "gt":
+			return a > b
+		}
+		return a >= b
+	case "in", "not_in":
+		found := false
+		for _, e := range c.Value.([]interface{}) {
+			if policyEqual(v, e) {
+				found = true
+				break
+			}
+		}
+		return found == (c.Op == "in")
+	case "contains":
+		switch l := v.(type) {
+		case []string:
+			for _, e := range l {
+				if policyEqual(e, c.Value) {
+					return true
+				}
+			}
+		case []interface{}:
+			for _, e := range l {
+				if policyEqual(e, c.Value) {
+					return true
+				}
+			}
+		case string:
+			s, ok := c.Value.(string)
+			return ok && strings.Contains(l, s)
+		}
+		return false
+	case "prefix":
+		s, ok := v.(string)
+		return ok && strings.HasPrefix(s, c.Value.(string))
+	case "suffix":
+		s, ok := v.(string)
+		return ok && strings.HasSuffix(s, c.Value.(string))
+	}
+	return false
+}
+
+// policyEqual compares two attribute values; numbers are compared by value
+// regardless of their type.
+func policyEqual(a, b interface{}) bool {
+
+	fa, okA := toFloat(a)
+	fb, okB := toFloat(b)
+	if okA && okB {
+		return fa == fb
+	}
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
+
+// toFloat converts a numeric attribute or policy value to float64.
+func toFloat(v interface{}) (float64, bool) {
+
+	switch n := v.(type) {
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case string:
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(fmt.Sprint(v), 64)
+	return f, err == nil
+}
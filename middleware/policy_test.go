@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const testPolicies = `{
+  "timezone": "UTC",
+  "policies": [
+    {
+      "name": "book-delete-no-copies",
+      "routes": ["book.DELETE"],
+      "any": [
+        {"attr": "entity.copies", "op": "eq", "value": 0},
+        {"attr": "groups", "op": "contains", "value": "Super"}
+      ]
+    },
+    {
+      "name": "library-update-weekdays",
+      "routes": ["library.UPDATE"],
+      "conditions": [
+        {"attr": "time.weekday", "op": "in", "value": ["Mon", "Tue", "Wed", "Thu", "Fri"]},
+        {"attr": "time.hour", "op": "lt", "value": 18},
+        {"attr": "claims.email", "op": "suffix", "value": "@example.com"}
+      ]
+    }
+  ]
+}`
+
+func TestPolicyEvaluate(t *testing.T) {
+
+	ps, err := ParsePolicies([]byte(testPolicies))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	book := func(copies int) func() (map[string]interface{}, bool) {
+		return func() (map[string]interface{}, bool) {
+			return map[string]interface{}{"id": json.Number("1"), "copies": json.Number(strconv.Itoa(copies))}, true
+		}
+	}
+	monday := time.Date(2020, 6, 1, 10, 0, 0, 0, time.UTC)
+	sunday := time.Date(2020, 6, 7, 10, 0, 0, 0, time.UTC)
+	email := map[string]interface{}{"email": "a@example.com"}
+
+	tests := []struct {
+		pr   PolicyRequest
+		want bool
+	}{
+		{PolicyRequest{Route: "book.DELETE", Entity: book(0)}, true},
+		{PolicyRequest{Route: "book.DELETE", Entity: book(3)}, false},
+		{PolicyRequest{Route: "book.DELETE", Entity: book(3), Groups: []string{"Super"}}, true},
+		{PolicyRequest{Route: "book.DELETE"}, false},
+		{PolicyRequest{Route: "book.GET_ID", Entity: book(3)}, true},
+		{PolicyRequest{Route: "library.UPDATE", Time: monday, Claims: email}, true},
+		{PolicyRequest{Route: "library.UPDATE", Time: sunday, Claims: email}, false},
+		{PolicyRequest{Route: "library.UPDATE", Time: monday.Add(9 * time.Hour), Claims: email}, false},
+		{PolicyRequest{Route: "library.UPDATE", Time: monday}, false},
+	}
+
+	for i, tt := range tests {
+		pr := tt.pr
+		got, name := ps.Evaluate(&pr)
+		if got != tt.want {
+			t.Errorf("%d: %s got %v (%s), want %v", i, tt.pr.Route, got, name, tt.want)
+		}
+	}
+}
+
+func TestParsePoliciesInvalid(t *testing.T) {
+
+	bad := []string{
+		`{"policies": [{"name": "p", "routes": [], "conditions": [{"attr": "route", "op": "eq", "value": "x"}]}]}`,
+		`{"policies": [{"name": "p", "routes": ["a.B"], "conditions": [{"attr": "color", "op": "eq", "value": "x"}]}]}`,
+		`{"policies": [{"name": "p", "routes": ["a.B"], "conditions": [{"attr": "route", "op": "like", "value": "x"}]}]}`,
+		`{"policies": [{"name": "p", "routes": ["a.B"], "conditions": [{"attr": "time.hour", "op": "lt", "value": "noon"}]}]}`,
+		`{"policies": [{"name": "p", "routes": ["a.B"], "conditions": [{"attr": "method", "op": "in", "value": "GET"}]}]}`,
+		`{"policies": [{"name": "p", "routes": ["a.B"]}]}`,
+		`{"policies": [{"name": "p", "routes": ["a.B"], "condition": []}]}`,
+		`{"timezone": "Nowhere/Special", "policies": []}`,
+	}
+	for _, b := range bad {
+		if _, err := ParsePolicies([]byte(b)); err == nil {
+			t.Errorf("expected an error for %s", b)
+		}
+	}
+}
+
+func TestEvaluatePolicies(t *testing.T) {
+
+	ps, err := ParsePolicies([]byte(testPolicies))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mw := InitMW(nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	mw.SetPolicies(ps, map[string]PolicyEntityFunc{
+		"book": func(id uint64) (interface{}, error) {
+			return map[string]interface{}{"id": id, "copies": id % 2}, nil
+		},
+	})
+
+	tests := []struct {
+		route    string
+		groups   []string
+		entityID uint64
+		want     bool
+	}{
+		{"book.DELETE", nil, 2, true},
+		{"book.DELETE", nil, 3, false},
+		{"book.DELETE", []string{"Super"}, 3, true},
+		{"book.DELETE", nil, 0, false}, // without an entity the condition does not hold
+		{"book.GET_ID", nil, 3, true},
+	}
+	for _, tt := range tests {
+		ok, name := mw.EvaluatePolicies(tt.route, "DELETE", 1, "a@example.com", tt.groups, tt.entityID)
+		if ok != tt.want || (!ok && name != "book-delete-no-copies") {
+			t.Errorf("%s %d: got %v (%s), want %v", tt.route, tt.entityID, ok, name, tt.want)
+		}
+	}
+}
@@ -6,11 +6,16 @@ package middleware
 //=============================================================================================
 
 import (
+	"bytes"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rsa"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/1414C/libraryapp/group/gmcom"
 	"github.com/1414C/libraryapp/models"
@@ -25,22 +30,30 @@ import (
 // requested page.
 type RequireUsr struct {
 	Usr               models.UsrService
+	APIKey            models.APIKeyService
 	ECDSA256VerifyKey *ecdsa.PublicKey
 	ECDSA384VerifyKey *ecdsa.PublicKey
 	ECDSA521VerifyKey *ecdsa.PublicKey
 	RSA256VerifyKey   *rsa.PublicKey
 	RSA384VerifyKey   *rsa.PublicKey
 	RSA512VerifyKey   *rsa.PublicKey
+	EdDSAVerifyKey    ed25519.PublicKey
+	HMACKey           []byte
 	// mapGA             map[string]map[string]bool // map[groupName]map[auth_name]bool
 	// mapActiveUsrs     map[string]bool            // ref to a.activeUsrs!
 	ActUsrsH    *gmcom.ActUsrsH
 	GroupAuthsH *gmcom.GroupAuthsH
 	AuthsH      *gmcom.AuthsH
 	UsrGroupsH  *gmcom.UsrGroupsH
+	UsrMembersH *gmcom.UsrMembersH
+	JWTKeysH    *gmcom.JWTKeysH
+	SessionsH   *gmcom.SessionsH
+	Policies    *PolicySet
+	PolicyEnts  map[string]PolicyEntityFunc
 }
 
 // InitMW is used to initialize the usr authorization middleware
-func InitMW(Usr models.UsrService, jwtKeyMap map[string]interface{}, groupAuths *gmcom.GroupAuthsH, actUsrs *gmcom.ActUsrsH, auths *gmcom.AuthsH, usrGroups *gmcom.UsrGroupsH) (requireUser RequireUsr) {
+func InitMW(Usr models.UsrService, APIKey models.APIKeyService, jwtKeyMap map[string]interface{}, jwtKeys *gmcom.JWTKeysH, groupAuths *gmcom.GroupAuthsH, actUsrs *gmcom.ActUsrsH, auths *gmcom.AuthsH, usrGroups *gmcom.UsrGroupsH, usrMembers *gmcom.UsrMembersH, sessions *gmcom.SessionsH) (requireUser RequireUsr) {
 
 	var esVerifyKey *ecdsa.PublicKey
 	var rsVerifyKey *rsa.PublicKey
@@ -82,24 +95,36 @@ func InitMW(Usr models.UsrService, jwtKeyMap map[string]interface{}, groupAuths
 		rsVerifyKey = nil
 	}
 
+	edVerifyKey, ok := jwtKeyMap["EdDSAVerifyKey"].(ed25519.PublicKey)
+	if ok {
+		requireUser.EdDSAVerifyKey = edVerifyKey
+	}
+
+	hmacKey, ok := jwtKeyMap["HMACKey"].([]byte)
+	if ok {
+		requireUser.HMACKey = hmacKey
+	}
+
 	requireUser.Usr = Usr
+	requireUser.APIKey = APIKey
 	requireUser.GroupAuthsH = groupAuths
 	requireUser.ActUsrsH = actUsrs
 	requireUser.AuthsH = auths
 	requireUser.UsrGroupsH = usrGroups
+	requireUser.UsrMembersH = usrMembers
+	requireUser.JWTKeysH = jwtKeys
+	requireUser.SessionsH = sessions
 	return requireUser
 }
 
 // CheckAuth verifies that the AuthName is contained in one of the supplied Groups
 func (mw *RequireUsr) CheckAuth(authName string, groups []string, id uint64) bool {
+	return mw.decideAuth(authName, groups, id).Allowed
+}
 
-	// no group auths setup - disallow all access
-	if len(mw.GroupAuthsH.GroupAuths) == 0 {
-		return false
-	}
+// decideAuth determines whether the usr holds the auth and why.
+func (mw *RequireUsr) decideAuth(authName string, groups []string, id uint64) gmcom.AuthDecision {
 
-	// is the user active?
-	active := mw.ActUsrsH.ActiveUsrs[id]
 	lw.Debug("==============================================================")
 	lw.Debug("mw.ActUsrsH.ActiveUsrs: %v", mw.ActUsrsH.ActiveUsrs)
 	lw.Debug("==============================================================")
@@ -109,26 +134,18 @@ func (mw *RequireUsr) CheckAuth(authName string, groups []string, id uint64) boo
 	lw.Debug("==============================================================")
 	lw.Debug("mw.UsrGroups.UsrGroupsH: %v", mw.UsrGroupsH.GroupNames)
 	lw.Debug("==============================================================")
-	if !active {
-		return false
-	}
-
-	for _, g := range groups {
-
-		// nil here is odd but plausible
-		mapAuths := mw.GroupAuthsH.GroupAuths[g]
-		// lw.Debug("mw.mapGA[%s] got: %v", g, mapAuths)
-		if mapAuths == nil || len(mapAuths) == 0 {
-			continue
-		}
+	return gmcom.DecideAuth(authName, groups, id, mw.ActUsrsH, mw.GroupAuthsH)
+}
 
-		// lw.Debug("mapAuths: %v", mapAuths)
-		auth := mapAuths[authName]
-		if auth == true {
-			return true
-		}
-	}
-	return false
+// CustomClaims are used to facilitate access to application-specific
+// claims that are not part of the JWT standard set.
+type CustomClaims struct {
+	*jwt.StandardClaims
+	TokenType string
+	Groups    string
+	UID       uint64
+	Email     string
+	SID       string
 }
 
 // ApplyFn assumes that Usr middleware has already been run - i.e. the application
@@ -140,111 +157,372 @@ func (mw *RequireUsr) CheckAuth(authName string, groups []string, id uint64) boo
 // ApplyFn
 func (mw *RequireUsr) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
 
-	// CustomClaims are used to facilitate access to application-specific
-	// claims that are not part of the JWT standard set.
-	type CustomClaims struct {
-		*jwt.StandardClaims
-		TokenType string
-		Groups    string
-		UID       uint64
-		Email     string
-	}
-
 	// http.HandlerFunc is casting the type of the closure here
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
-		noKey := false
-
-		// verify the JWT content
-		token, err := request.ParseFromRequestWithClaims(r, request.AuthorizationHeaderExtractor, &CustomClaims{},
-			func(token *jwt.Token) (interface{}, error) {
-				switch token.Header["alg"] {
-				case "ES256":
-					if mw.ECDSA256VerifyKey != nil {
-						return mw.ECDSA256VerifyKey, nil
-					}
-					noKey = true
-
-				case "ES384":
-					if mw.ECDSA384VerifyKey != nil {
-						return mw.ECDSA384VerifyKey, nil
-					}
-					noKey = true
-
-				case "ES521":
-					if mw.ECDSA521VerifyKey != nil {
-						return mw.ECDSA521VerifyKey, nil
-					}
-					noKey = true
-
-				case "RS256":
-					if mw.RSA256VerifyKey != nil {
-						return mw.RSA256VerifyKey, nil
-					}
-					noKey = true
-
-				case "RS384":
-					if mw.RSA384VerifyKey != nil {
-						return mw.RSA384VerifyKey, nil
-					}
-					noKey = true
-
-				case "RS512":
-					if mw.RSA512VerifyKey != nil {
-						return mw.RSA512VerifyKey, nil
-					}
-					noKey = true
-
-				case "HS256":
-					return nil, fmt.Errorf("hmac signed jwt's are not accepted")
-
-				default:
-					if noKey {
-						return nil, fmt.Errorf("unable to verify access token for %v signing algorithm", token.Header["alg"])
-					}
-					return nil, fmt.Errorf("unknown 'alg': %v in JWT header", token.Header["alg"])
-				}
-				return nil, fmt.Errorf("unknown error validating access token")
-			})
+		uid, gps, err := mw.authenticate(r)
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			lw.Warning("Unauthorized access to this resource: %s", err.Error())
+			return
+		}
 
-		if err == nil {
-			if token.Valid {
-				claims := token.Claims.(*CustomClaims)
-
-				// lw.Debug("checking auth: %s with groups: %v", mux.CurrentRoute(r).GetName(), claims.Groups)
-				// lw.Debug("token.Header: %v", token.Header)
-				// lw.Debug("claims.IssuedAt: %v", claims.IssuedAt)
-				// lw.Debug("claims.ExpiresAt: %v", claims.ExpiresAt)
-				// lw.Debug("claims.NotBefore: %v", claims.NotBefore)
-				// lw.Debug("claims.Issuer: %v", claims.Issuer)
-				// lw.Debug("claims.Subject: %v", claims.Subject)
-				// lw.Debug("claims.TokenType: %v", claims.TokenType)
-				// lw.Debug("claims.Groups: %v", claims.Groups)
-				// lw.Debug("claims.Id: %v", claims.Id)
-				// lw.Debug("claims.UID: %v", claims.UID)
-				// lw.Debug("claims.Email: %v", claims.Email)
-
-				gps := strings.Split(claims.Groups, ";")
-				for i := range gps {
-					gps[i] = strings.TrimSpace(gps[i])
-				}
-
-				// check the user's authorization for the route
-				if mw.CheckAuth(mux.CurrentRoute(r).GetName(), gps, claims.UID) {
-					next(w, r)
-					return
-				}
+		// check the user's authorization for the route
+		d := mw.decideAuth(mux.CurrentRoute(r).GetName(), gps, uid)
+		if d.Allowed {
+			// attribute-based policies are evaluated on top of the auth
+			if ok, policy := mw.checkPolicies(r, uid, gps); !ok {
+				w.WriteHeader(http.StatusForbidden)
+				lw.Warning("Access to %s for usr: %d denied by policy %s", mux.CurrentRoute(r).GetName(), uid, policy)
+				return
 			}
-			w.WriteHeader(http.StatusUnauthorized)
-			lw.Warning("Unauthorized access to this resource: %v", w)
+			r = r.WithContext(models.ContextWithUsrID(r.Context(), uid))
+			next(w, mw.withDataScope(r, gps))
 			return
 		}
 		w.WriteHeader(http.StatusUnauthorized)
-		lw.Warning("Unauthorized access to this resource: %v %s", w, err.Error())
-		return
+		lw.Warning("Unauthorized access to %s for usr: %d (%s)", mux.CurrentRoute(r).GetName(), uid, d.Reason)
+	})
+}
+
+// AuthenticateFn verifies that the request was made by an active, authenticated
+// usr, but does not check the usr's auths.  It is used for routes that act on the
+// requesting usr's own data only; the ID of the usr is passed to the handler in
+// the request context.
+func (mw *RequireUsr) AuthenticateFn(next http.HandlerFunc) http.HandlerFunc {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		uid, _, err := mw.authenticate(r)
+		if err == nil && !mw.isActive(uid) {
+			err = fmt.Errorf("usr %d is not active", uid)
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			lw.Warning("Unauthorized access to this resource: %s", err.Error())
+			return
+		}
+		next(w, r.WithContext(models.ContextWithUsrID(r.Context(), uid)))
 	})
 }
 
+// SetPolicies installs the attribute-based access policies along with the
+// funcs used to read the entities that the policies refer to.
+func (mw *RequireUsr) SetPolicies(ps *PolicySet, entities map[string]PolicyEntityFunc) {
+	mw.Policies = ps
+	mw.PolicyEnts = entities
+}
+
+// checkPolicies evaluates the policies of the requested route.  The claims of
+// the access token and the target entity are only read for routes that are
+// subject to a policy.
+func (mw *RequireUsr) checkPolicies(r *http.Request, uid uint64, groups []string) (bool, string) {
+
+	route := mux.CurrentRoute(r).GetName()
+	if !mw.Policies.Applies(route) {
+		return true, ""
+	}
+
+	pr := &PolicyRequest{
+		Route:  route,
+		Method: r.Method,
+		UsrID:  uid,
+		Groups: groups,
+		Claims: mw.requestClaims(r, uid),
+		Time:   time.Now(),
+		Entity: func() (map[string]interface{}, bool) {
+			return mw.policyEntity(r, route)
+		},
+	}
+	return mw.Policies.Evaluate(pr)
+}
+
+// requestClaims returns the claims of the access token presented with the
+// request.  Requests authenticated by api key carry the uid claim only.
+func (mw *RequireUsr) requestClaims(r *http.Request, uid uint64) map[string]interface{} {
+
+	if r.Header.Get("X-API-Key") == "" {
+		token, err := request.ParseFromRequestWithClaims(r, request.AuthorizationHeaderExtractor, jwt.MapClaims{}, mw.verifyKeyFunc)
+		if err == nil {
+			return token.Claims.(jwt.MapClaims)
+		}
+	}
+	return map[string]interface{}{"uid": uid}
+}
+
+// EvaluatePolicies evaluates the policies of a route for a request that is
+// described rather than made, as done by the authz explain endpoint.  entityID
+// addresses the entity of the route, if any; of the token claims only uid and
+// email are available.
+func (mw *RequireUsr) EvaluatePolicies(route, method string, uid uint64, email string, groups []string, entityID uint64) (bool, string) {
+
+	if !mw.Policies.Applies(route) {
+		return true, ""
+	}
+
+	pr := &PolicyRequest{
+		Route:  route,
+		Method: method,
+		UsrID:  uid,
+		Groups: groups,
+		Claims: map[string]interface{}{"uid": float64(uid), "email": email},
+		Time:   time.Now(),
+		Entity: func() (map[string]interface{}, bool) {
+			if entityID == 0 {
+				return nil, false
+			}
+			return mw.policyEntityByID(route, entityID)
+		},
+	}
+	return mw.Policies.Evaluate(pr)
+}
+
+// policyEntity reads the entity addressed by the id of the route.
+func (mw *RequireUsr) policyEntity(r *http.Request, route string) (map[string]interface{}, bool) {
+
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		return nil, false
+	}
+	return mw.policyEntityByID(route, id)
+}
+
+// policyEntityByID reads the entity with the specified ID via the
+// PolicyEntityFunc registered for the route-name prefix, and returns it in
+// its JSON representation.
+func (mw *RequireUsr) policyEntityByID(route string, id uint64) (map[string]interface{}, bool) {
+
+	fn, ok := mw.PolicyEnts[strings.SplitN(route, ".", 2)[0]]
+	if !ok {
+		return nil, false
+	}
+	e, err := fn(id)
+	if err != nil {
+		lw.Warning("policy: reading the entity of %s %d got: %v", route, id, err)
+		return nil, false
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return nil, false
+	}
+	var m map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if dec.Decode(&m) != nil {
+		return nil, false
+	}
+	return m, true
+}
+
+// authenticate verifies the credentials presented with the request and returns
+// the ID and the current UsrGroup names of the requesting usr.  Requests carrying
+// an api key are evaluated against the Groups of the (service) Usr the key was
+// issued to.
+func (mw *RequireUsr) authenticate(r *http.Request) (uint64, []string, error) {
+
+	if r.Header.Get("X-API-Key") != "" {
+		uid, gps, err := mw.authenticateAPIKey(r.Header.Get("X-API-Key"))
+		if err != nil {
+			return 0, nil, fmt.Errorf("api key: %s", err.Error())
+		}
+		return uid, gps, nil
+	}
+
+	// verify the JWT content
+	token, err := request.ParseFromRequestWithClaims(r, request.AuthorizationHeaderExtractor, &CustomClaims{}, mw.verifyKeyFunc)
+	if err != nil {
+		return 0, nil, err
+	}
+	if !token.Valid {
+		return 0, nil, fmt.Errorf("access token is not valid")
+	}
+	claims := token.Claims.(*CustomClaims)
+
+	// tokens of a revoked session are rejected until they expire
+	if mw.SessionsH.IsRevoked(claims.SID) {
+		return 0, nil, fmt.Errorf("access token session has been revoked")
+	}
+
+	// the Groups claim is informational; the current group-memberships
+	// of the usr are used so that changes take effect immediately.
+	return claims.UID, mw.UsrMembersH.GroupNames(claims.UID, mw.UsrGroupsH), nil
+}
+
+// VerifyToken verifies the signature and the standard time-based claims of a
+// jwt issued by the application and returns the claims of the token.  Neither
+// the revocation of the token's session nor the activity status of the usr is
+// checked; see IntrospectController.Introspect.
+func (mw *RequireUsr) VerifyToken(tokenString string) (jwt.MapClaims, error) {
+
+	token, err := jwt.ParseWithClaims(tokenString, jwt.MapClaims{}, mw.verifyKeyFunc)
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, fmt.Errorf("access token is not valid")
+	}
+	return token.Claims.(jwt.MapClaims), nil
+}
+
+// isActive reports whether the usr is presently active.
+func (mw *RequireUsr) isActive(uid uint64) bool {
+
+	mw.ActUsrsH.RLock()
+	defer mw.ActUsrsH.RUnlock()
+	return mw.ActUsrsH.ActiveUsrs[uid]
+}
+
+// verifyKeyFunc returns the key used to verify the signature of the token.
+func (mw *RequireUsr) verifyKeyFunc(token *jwt.Token) (interface{}, error) {
+
+	// tokens carrying a kid are verified against the key-set
+	if kid, ok := token.Header["kid"].(string); ok && kid != "" && mw.JWTKeysH != nil {
+		k, found := mw.JWTKeysH.Lookup(kid)
+		if !found {
+			return nil, fmt.Errorf("unknown or expired kid '%s' in JWT header", kid)
+		}
+		if k.Alg != token.Method.Alg() {
+			return nil, fmt.Errorf("'alg': %v does not match the algorithm of kid '%s'", token.Header["alg"], kid)
+		}
+		return k.VerifyKey(), nil
+	}
+
+	key, err := mw.configuredVerifyKey(token)
+	if err != nil {
+		return nil, err
+	}
+
+	// once the key-set is in use, a configured key verifies tokens without
+	// a kid only until it has been retired by a signing-key rotation
+	if _, hmac := key.([]byte); !hmac && mw.keySetInUse() {
+		kid, err := gmcom.JWTKeyID(key)
+		if err != nil {
+			return nil, err
+		}
+		if _, found := mw.JWTKeysH.Lookup(kid); !found {
+			return nil, fmt.Errorf("the signing key of the access token has been retired")
+		}
+	}
+	return key, nil
+}
+
+// keySetInUse reports whether the jwt key-set cache has been initialized.
+func (mw *RequireUsr) keySetInUse() bool {
+
+	if mw.JWTKeysH == nil {
+		return false
+	}
+	mw.JWTKeysH.RLock()
+	defer mw.JWTKeysH.RUnlock()
+	return len(mw.JWTKeysH.Keys) > 0
+}
+
+// configuredVerifyKey returns the verification key read from the configuration
+// for the signing algorithm of the token.
+func (mw *RequireUsr) configuredVerifyKey(token *jwt.Token) (interface{}, error) {
+
+	noKey := false
+	switch token.Header["alg"] {
+	case "ES256":
+		if mw.ECDSA256VerifyKey != nil {
+			return mw.ECDSA256VerifyKey, nil
+		}
+		noKey = true
+
+	case "ES384":
+		if mw.ECDSA384VerifyKey != nil {
+			return mw.ECDSA384VerifyKey, nil
+		}
+		noKey = true
+
+	case "ES512":
+		if mw.ECDSA521VerifyKey != nil {
+			return mw.ECDSA521VerifyKey, nil
+		}
+		noKey = true
+
+	case "RS256":
+		if mw.RSA256VerifyKey != nil {
+			return mw.RSA256VerifyKey, nil
+		}
+		noKey = true
+
+	case "RS384":
+		if mw.RSA384VerifyKey != nil {
+			return mw.RSA384VerifyKey, nil
+		}
+		noKey = true
+
+	case "RS512":
+		if mw.RSA512VerifyKey != nil {
+			return mw.RSA512VerifyKey, nil
+		}
+		noKey = true
+
+	case "EdDSA":
+		if mw.EdDSAVerifyKey != nil {
+			return mw.EdDSAVerifyKey, nil
+		}
+		noKey = true
+
+	case "HS256", "HS384", "HS512":
+		if mw.HMACKey != nil {
+			return mw.HMACKey, nil
+		}
+		noKey = true
+
+	default:
+		if noKey {
+			return nil, fmt.Errorf("unable to verify access token for %v signing algorithm", token.Header["alg"])
+		}
+		return nil, fmt.Errorf("unknown 'alg': %v in JWT header", token.Header["alg"])
+	}
+	return nil, fmt.Errorf("unknown error validating access token")
+}
+
+// withDataScope attaches the data-scope under which the usr's groups grant
+// access to the requested route to the request context.  A usr holding the
+// route's auth in at least one group without a scope is unrestricted;
+// otherwise the usr may access the union of the scopes.
+func (mw *RequireUsr) withDataScope(r *http.Request, groups []string) *http.Request {
+
+	scopes, unrestricted := mw.GroupAuthsH.Scopes(mux.CurrentRoute(r).GetName(), groups)
+	if unrestricted || len(scopes) == 0 {
+		return r
+	}
+
+	ds := &models.DataScope{LibraryIDs: make(map[uint64]bool)}
+	for _, s := range scopes {
+		d, err := models.ParseDataScope(s)
+		if err != nil {
+			// scopes are validated on maintenance; an unparsable scope grants nothing
+			lw.Warning("withDataScope: ignoring scope %s: %s", s, err.Error())
+			continue
+		}
+		if d != nil {
+			ds.Merge(d)
+		}
+	}
+	return r.WithContext(models.ContextWithDataScope(r.Context(), ds))
+}
+
+// authenticateAPIKey verifies the presented api key and returns the ID and the
+// UsrGroup names of the Usr that the key was issued to.
+func (mw *RequireUsr) authenticateAPIKey(key string) (uint64, []string, error) {
+
+	if mw.APIKey == nil {
+		return 0, nil, fmt.Errorf("api key authentication is not available")
+	}
+
+	ak, err := mw.APIKey.Authenticate(key)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return ak.UsrID, mw.UsrMembersH.GroupNames(ak.UsrID, mw.UsrGroupsH), nil
+}
+
 // Apply assumes that Usr middleware has already been run
 // otherwise it will not work correctly.
 func (mw *RequireUsr) Apply(next http.Handler) http.HandlerFunc {
@@ -0,0 +1,206 @@
+package middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/1414C/libraryapp/group/gmcom"
+	"github.com/1414C/libraryapp/models"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+)
+
+// stubAPIKeyService accepts the keys it holds, and rejects all other keys
+// with the error recorded for them or ErrAPIKeyInvalid.
+type stubAPIKeyService struct {
+	models.APIKeyService
+	keys map[string]uint64
+	errs map[string]error
+}
+
+func (s *stubAPIKeyService) Authenticate(key string) (*models.APIKey, error) {
+	if uid, ok := s.keys[key]; ok {
+		return &models.APIKey{UsrID: uid}, nil
+	}
+	if err, ok := s.errs[key]; ok {
+		return nil, err
+	}
+	return nil, models.ErrAPIKeyInvalid
+}
+
+func TestAPIKeyAuthentication(t *testing.T) {
+
+	mw := InitMW(nil, &stubAPIKeyService{
+		keys: map[string]uint64{"reader.secret": 1, "nogroup.secret": 2, "inactive.secret": 3},
+		errs: map[string]error{"revoked.secret": models.ErrAPIKeyRevoked, "expired.secret": models.ErrAPIKeyExpired},
+	},
+		nil,
+		nil,
+		&gmcom.GroupAuthsH{GroupAuths: map[string]map[string]bool{"Readers": {"book.GET_SET": true}}},
+		&gmcom.ActUsrsH{ActiveUsrs: map[uint64]bool{1: true, 2: true, 3: false}},
+		&gmcom.AuthsH{Auths: map[uint64]string{1: "book.GET_SET"}},
+		&gmcom.UsrGroupsH{GroupNames: map[uint64]string{10: "Readers"}},
+		&gmcom.UsrMembersH{UsrGroupIDs: map[uint64]map[uint64]bool{1: {10: true}, 3: {10: true}}},
+		nil,
+	)
+
+	var gotUID uint64
+	router := mux.NewRouter()
+	router.HandleFunc("/books", mw.ApplyFn(func(w http.ResponseWriter, r *http.Request) {
+		gotUID, _ = models.UsrIDFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	})).Methods("GET").Name("book.GET_SET")
+
+	tests := []struct {
+		key  string
+		want int
+		uid  uint64
+	}{
+		{"reader.secret", http.StatusOK, 1},
+		{"nogroup.secret", http.StatusUnauthorized, 0},  // the key owner holds no group
+		{"inactive.secret", http.StatusUnauthorized, 0}, // the key owner is inactive
+		{"reader.wrong", http.StatusUnauthorized, 0},
+		{"revoked.secret", http.StatusUnauthorized, 0},
+		{"expired.secret", http.StatusUnauthorized, 0},
+		{"malformed", http.StatusUnauthorized, 0},
+	}
+	for _, tt := range tests {
+		gotUID = 0
+		r := httptest.NewRequest("GET", "/books", nil)
+		r.Header.Set("X-API-Key", tt.key)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != tt.want || gotUID != tt.uid {
+			t.Errorf("%s: got status %d for usr %d; want %d for usr %d", tt.key, w.Code, gotUID, tt.want, tt.uid)
+		}
+	}
+
+	// without api key support, keys are rejected
+	mw.APIKey = nil
+	if _, _, err := mw.authenticateAPIKey("reader.secret"); err == nil {
+		t.Error("got no error; want the api key to be rejected")
+	}
+}
+
+func TestVerifyTokenKeySet(t *testing.T) {
+
+	newKey := func() (*gmcom.JWTKey, *ecdsa.PrivateKey) {
+		priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		k, err := gmcom.NewJWTKey("", "ES256", priv, &priv.PublicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k, priv
+	}
+	retired, retiredPriv := newKey()
+	current, currentPriv := newKey()
+	retired.ExpiresOn = time.Now().Add(time.Hour).Unix()
+
+	h := &gmcom.JWTKeysH{}
+	if err := h.Replace(gmcom.JWTKeysD{SignKID: current.KID, Keys: []gmcom.JWTKey{*retired, *current}}); err != nil {
+		t.Fatal(err)
+	}
+	mw := InitMW(nil, nil, nil, h, nil, nil, nil, nil, nil, nil)
+
+	// the retired key was read from the configured PEM file
+	mw.ECDSA256VerifyKey = &retiredPriv.PublicKey
+
+	sign := func(method jwt.SigningMethod, kid string, key interface{}) string {
+		token := jwt.NewWithClaims(method, jwt.MapClaims{"uid": 1, "exp": time.Now().Add(time.Minute).Unix()})
+		token.Header["kid"] = kid
+		s, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"current key", sign(jwt.SigningMethodES256, current.KID, currentPriv), true},
+		{"retired key", sign(jwt.SigningMethodES256, retired.KID, retiredPriv), true},
+		{"unknown kid", sign(jwt.SigningMethodES256, "unknown", currentPriv), false},
+		{"kid of another key", sign(jwt.SigningMethodES256, current.KID, retiredPriv), false},
+		{"alg mismatch", sign(jwt.SigningMethodHS256, current.KID, []byte("secret")), false},
+		{"retired key without kid", sign(jwt.SigningMethodES256, "", retiredPriv), true},
+	}
+	for _, tt := range tests {
+		if _, err := mw.VerifyToken(tt.token); (err == nil) != tt.ok {
+			t.Errorf("%s: got %v", tt.name, err)
+		}
+	}
+
+	// tokens of the retired key are rejected once it has expired
+	h.Keys[retired.KID].ExpiresOn = time.Now().Add(-time.Second).Unix()
+	if _, err := mw.VerifyToken(tests[1].token); err == nil {
+		t.Errorf("expected the token of the expired key to be rejected")
+	}
+	if _, err := mw.VerifyToken(tests[5].token); err == nil {
+		t.Errorf("expected the token of the expired key without kid to be rejected")
+	}
+
+	// as are those of a configured key that is no longer in the key-set
+	delete(h.Keys, retired.KID)
+	if _, err := mw.VerifyToken(tests[5].token); err == nil {
+		t.Errorf("expected the token of the removed key without kid to be rejected")
+	}
+}
+
+func TestWithDataScope(t *testing.T) {
+
+	ga := &gmcom.GroupAuthsH{
+		GroupAuths: map[string]map[string]bool{
+			"North":   {"book.GET_SET": true},
+			"South":   {"book.GET_SET": true},
+			"All":     {"book.GET_SET": true},
+			"Broken":  {"book.GET_SET": true},
+			"Writers": {"book.CREATE": true},
+		},
+		GroupAuthScopes: make(map[string]map[string]string),
+	}
+	ga.SetScope("North", "book.GET_SET", "library_id IN (1,2)")
+	ga.SetScope("South", "book.GET_SET", "library_id = 3")
+	ga.SetScope("Broken", "book.GET_SET", "title = 'x'")
+	mw := InitMW(nil, nil, nil, nil, ga, nil, nil, nil, nil, nil)
+
+	var got *models.DataScope
+	var groups []string
+	router := mux.NewRouter()
+	router.HandleFunc("/books", func(w http.ResponseWriter, r *http.Request) {
+		got = models.DataScopeFromContext(mw.withDataScope(r, groups).Context())
+	}).Methods("GET").Name("book.GET_SET")
+
+	tests := []struct {
+		groups []string
+		want   string // "" == unrestricted
+	}{
+		{[]string{"North"}, "library_id IN (1,2)"},
+		{[]string{"North", "South"}, "library_id IN (1,2,3)"},
+		{[]string{"North", "All"}, ""},                      // an unscoped groupauth lifts the scope
+		{[]string{"South", "Writers"}, "library_id IN (3)"}, // groups without the auth do not widen the scope
+		{[]string{"Broken"}, "library_id IN ()"},            // an unparsable scope grants nothing
+		{[]string{"Broken", "South"}, "library_id IN (3)"},
+	}
+	for _, tt := range tests {
+		got, groups = nil, tt.groups
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/books", nil))
+		s := ""
+		if got != nil {
+			s = got.String()
+		}
+		if s != tt.want {
+			t.Errorf("%v: got scope %q; want %q", tt.groups, s, tt.want)
+		}
+	}
+}
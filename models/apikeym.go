@@ -0,0 +1,345 @@
+package models
+
+//=============================================================================================
+// APIKey entity model code
+//=============================================================================================
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/1414C/lw"
+	"github.com/1414C/sqac"
+)
+
+// APIKey structure.  An APIKey is issued to a (service) Usr and grants the
+// holder the same Group / Auth assignment as the Usr it was issued to.  The
+// plain-text key is returned only once in the response to the creation request;
+// only the key-prefix and a peppered SHA-256 hash of the key-secret are stored
+// in the db.  Keys are presented in the format <key_prefix>.<key_secret>.
+type APIKey struct {
+	ID          uint64     `json:"id" db:"id" sqac:"primary_key:inc"`
+	Href        string     `json:"href" db:"href" sqac:"-"`
+	UsrID       uint64     `json:"usr_id" db:"usr_id" sqac:"nullable:false;index:non-unique"`
+	Description string     `json:"description" db:"description" sqac:"nullable:false"`
+	Key         string     `json:"key,omitempty" db:"key" sqac:"-"` // plain-text key; never stored
+	KeyPrefix   string     `json:"key_prefix" db:"key_prefix" sqac:"nullable:false;index:unique"`
+	KeyHash     string     `json:"-" db:"key_hash" sqac:"nullable:false"`
+	CreatedOn   *time.Time `json:"created_on,omitempty" db:"created_on" sqac:"nullable:false;default:now()"`
+	ExpiresOn   *time.Time `json:"expires_on,omitempty" db:"expires_on" sqac:"nullable:true"`
+	LastUsedOn  *time.Time `json:"last_used_on,omitempty" db:"last_used_on" sqac:"nullable:true"`
+	Revoked     bool       `json:"revoked" db:"revoked" sqac:"nullable:false;default:false"`
+}
+
+// APIKeyDB is a CRUD-type interface specifically for dealing with APIKeys.
+type APIKeyDB interface {
+	Create(apikey *APIKey) error
+	Update(apikey *APIKey) error
+	Delete(apikey *APIKey) error
+	Get(apikey *APIKey) error
+	GetAPIKeys() []APIKey
+	GetAPIKeysByUsrID(usrID uint64) []APIKey
+	ByKeyPrefix(prefix string) (*APIKey, error)
+	UpdateLastUsed(id uint64, lastUsed time.Time) error
+}
+
+// APIKeyService is the public interface to the APIKey entity
+type APIKeyService interface {
+
+	// Authenticate verifies the presented plain-text key against
+	// the stored key hash.  Errors will be:
+	// ErrAPIKeyInvalid, ErrAPIKeyRevoked, ErrAPIKeyExpired or other(!)
+	Authenticate(key string) (*APIKey, error)
+	APIKeyDB
+}
+
+// private service for apikey
+type apikeyService struct {
+	APIKeyDB
+	pepper string
+}
+
+// apikeyValidator checks and normalizes data prior to
+// db access.
+type apikeyValidator struct {
+	APIKeyDB
+	pepper string
+}
+
+// apikeyValFunc type is the prototype for discrete APIKey normalization
+// and validation functions that will be executed by func runAPIKeyValFuncs(...)
+type apikeyValFunc func(*APIKey) error
+
+// apikeySqac is a sqac-based implementation of the APIKeyDB interface.
+type apikeySqac struct {
+	handle sqac.PublicDB
+}
+
+var _ APIKeyDB = &apikeySqac{}
+
+// newAPIKeyValidator returns a new apikeyValidator
+func newAPIKeyValidator(adb APIKeyDB, pepper string) *apikeyValidator {
+	return &apikeyValidator{
+		APIKeyDB: adb,
+		pepper:   pepper,
+	}
+}
+
+// runAPIKeyValFuncs executes a list of discrete validation
+// functions against an apikey.
+func runAPIKeyValFuncs(apikey *APIKey, fns ...apikeyValFunc) error {
+
+	// iterate over the slice of function names and execute
+	// each in-turn.  the order in which the lists are made
+	// can matter...
+	for _, fn := range fns {
+		err := fn(apikey)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewAPIKeyService creates a new APIKeyService.  The pepper is the same
+// value that is used to salt Usr passwords.
+func NewAPIKeyService(handle sqac.PublicDB, pepper string) APIKeyService {
+
+	as := &apikeySqac{handle}
+
+	av := newAPIKeyValidator(as, pepper) // *db
+	return &apikeyService{
+		APIKeyDB: av,
+		pepper:   pepper,
+	}
+}
+
+// ensure consistency (build error if delta exists)
+var _ APIKeyDB = &apikeyValidator{}
+
+// Authenticate splits the presented key into its prefix and secret, reads the
+// APIKey via the prefix and then compares the hash of the secret with the stored
+// hash.  Revoked and expired keys are rejected.  A successful authentication
+// updates the last-used timestamp of the APIKey.
+func (as *apikeyService) Authenticate(key string) (*APIKey, error) {
+
+	parts := strings.SplitN(strings.TrimSpace(key), ".", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, ErrAPIKeyInvalid
+	}
+
+	foundKey, err := as.ByKeyPrefix(parts[0])
+	if err != nil {
+		return nil, ErrAPIKeyInvalid
+	}
+
+	if subtle.ConstantTimeCompare([]byte(foundKey.KeyHash), []byte(hashAPIKeySecret(parts[1], as.pepper))) != 1 {
+		return nil, ErrAPIKeyInvalid
+	}
+
+	if foundKey.Revoked {
+		return nil, ErrAPIKeyRevoked
+	}
+
+	now := time.Now()
+	if foundKey.ExpiresOn != nil && now.After(*foundKey.ExpiresOn) {
+		return nil, ErrAPIKeyExpired
+	}
+
+	err = as.UpdateLastUsed(foundKey.ID, now)
+	if err != nil {
+		lw.Warning("APIKey %d last-used update got: %s", foundKey.ID, err.Error())
+	}
+	foundKey.LastUsedOn = &now
+	return foundKey, nil
+}
+
+//-------------------------------------------------------------------------------------------------------
+// CRUD-type model methods for APIKey
+//-------------------------------------------------------------------------------------------------------
+//
+// Create validates the apikey, generates a new key-prefix and key-secret and
+// then calls the creation code contained in APIKeyService.  The plain-text key
+// is left in apikey.Key so that it can be handed to the caller exactly once.
+func (av *apikeyValidator) Create(apikey *APIKey) error {
+
+	err := runAPIKeyValFuncs(apikey,
+		av.requireUsrID,
+		av.expiryInFuture,
+		av.generateKey,
+	)
+
+	if err != nil {
+		return err
+	}
+	return av.APIKeyDB.Create(apikey)
+}
+
+// Update is limited to a change of the description, expiry and revocation
+// status of an existing APIKey; the key material itself is never changed.
+func (av *apikeyValidator) Update(apikey *APIKey) error {
+
+	err := runAPIKeyValFuncs(apikey,
+		av.requireUsrID,
+	)
+
+	if err != nil {
+		return err
+	}
+	return av.APIKeyDB.Update(apikey)
+}
+
+// Delete is passed through to the ORM with no real
+// validations.  id is checked in the controller.
+func (av *apikeyValidator) Delete(apikey *APIKey) error {
+
+	return av.APIKeyDB.Delete(apikey)
+}
+
+// Get is passed through to the ORM with no real
+// validations.  id is checked in the controller.
+func (av *apikeyValidator) Get(apikey *APIKey) error {
+
+	return av.APIKeyDB.Get(apikey)
+}
+
+// GetAPIKeys is passed through to the ORM with no validation
+func (av *apikeyValidator) GetAPIKeys() []APIKey {
+
+	return av.APIKeyDB.GetAPIKeys()
+}
+
+// GetAPIKeysByUsrID is passed through to the ORM with no validation
+func (av *apikeyValidator) GetAPIKeysByUsrID(usrID uint64) []APIKey {
+
+	return av.APIKeyDB.GetAPIKeysByUsrID(usrID)
+}
+
+//-------------------------------------------------------------------------------------------------------
+// internal apikeyValidator funcs
+//-------------------------------------------------------------------------------------------------------
+
+// requireUsrID checks that the APIKey has been issued to a Usr
+func (av *apikeyValidator) requireUsrID(apikey *APIKey) error {
+
+	if apikey.UsrID == 0 {
+		return ErrAPIKeyUsrRequired
+	}
+	return nil
+}
+
+// expiryInFuture checks that a newly issued APIKey does not expire in the past
+func (av *apikeyValidator) expiryInFuture(apikey *APIKey) error {
+
+	if apikey.ExpiresOn != nil && !apikey.ExpiresOn.After(time.Now()) {
+		return ErrAPIKeyExpiryInvalid
+	}
+	return nil
+}
+
+// generateKey creates a random key-prefix and key-secret for the APIKey.  Only
+// the peppered hash of the key-secret is persisted.
+func (av *apikeyValidator) generateKey(apikey *APIKey) error {
+
+	prefix := make([]byte, 6)
+	if _, err := rand.Read(prefix); err != nil {
+		return err
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return err
+	}
+
+	apikey.KeyPrefix = hex.EncodeToString(prefix)
+	s := hex.EncodeToString(secret)
+	apikey.KeyHash = hashAPIKeySecret(s, av.pepper)
+	apikey.Key = apikey.KeyPrefix + "." + s
+	apikey.Revoked = false
+	return nil
+}
+
+// hashAPIKeySecret returns the hex-encoded SHA-256 hash of the peppered key-secret.
+// bcrypt is not used here, as the key is verified on every request and the key-secret
+// is a long random value rather than a usr-chosen password.
+func hashAPIKeySecret(secret, pepper string) string {
+
+	h := sha256.Sum256([]byte(secret + pepper))
+	return hex.EncodeToString(h[:])
+}
+
+//-------------------------------------------------------------------------------------------------------
+// ORM db CRUD access methods
+//-------------------------------------------------------------------------------------------------------
+//
+// Create a new APIKey in the database via the ORM
+func (as *apikeySqac) Create(apikey *APIKey) error {
+	return as.handle.Create(apikey)
+}
+
+// Update an existng APIKey in the database via the ORM
+func (as *apikeySqac) Update(apikey *APIKey) error {
+	return as.handle.Update(apikey)
+}
+
+// Delete an existing APIKey in the database via the ORM
+func (as *apikeySqac) Delete(apikey *APIKey) error {
+	return as.handle.Delete(apikey)
+}
+
+// Get an existing APIKey from the database via the ORM
+func (as *apikeySqac) Get(apikey *APIKey) error {
+	return as.handle.GetEntity(apikey)
+}
+
+// GetAPIKeys gets all existing APIKeys from the db
+func (as *apikeySqac) GetAPIKeys() []APIKey {
+
+	var apikeys []APIKey
+	err := as.handle.Select(&apikeys, "SELECT * FROM apikey;")
+	if err != nil {
+		lw.Warning("GetAPIKeys got: %s", err.Error())
+		return nil
+	}
+	return apikeys
+}
+
+// GetAPIKeysByUsrID gets all existing APIKeys that were issued to the specified Usr
+func (as *apikeySqac) GetAPIKeysByUsrID(usrID uint64) []APIKey {
+
+	var apikeys []APIKey
+	err := as.handle.Select(&apikeys, "SELECT * FROM apikey WHERE usr_id = ?;", usrID)
+	if err != nil {
+		lw.Warning("GetAPIKeysByUsrID got: %s", err.Error())
+		return nil
+	}
+	return apikeys
+}
+
+// ByKeyPrefix - lookup an APIKey using the provided key-prefix
+// 1 - apikey, nil
+// 2 - nil, ErrNotFound
+// 3 - nil, otherError
+//
+func (as *apikeySqac) ByKeyPrefix(prefix string) (*APIKey, error) {
+
+	var apikey APIKey
+	err := as.handle.Get(&apikey, "SELECT * FROM apikey WHERE key_prefix = ?;", prefix)
+	if err != nil {
+		lw.Warning("reading APIKey by key_prefix got: %s", err.Error())
+		return nil, err
+	}
+	return &apikey, nil
+}
+
+// UpdateLastUsed sets the last-used timestamp of the specified APIKey without
+// reading and rewriting the entire record.
+func (as *apikeySqac) UpdateLastUsed(id uint64, lastUsed time.Time) error {
+
+	_, err := as.handle.Exec("UPDATE apikey SET last_used_on = ? WHERE id = ?;", lastUsed, id)
+	return err
+}
@@ -0,0 +1,106 @@
+package models
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// memAPIKeyDB is an in-memory APIKeyDB holding the keys by prefix
+type memAPIKeyDB struct {
+	APIKeyDB
+	keys     map[string]*APIKey
+	lastUsed map[uint64]time.Time
+}
+
+func newMemAPIKeyDB() *memAPIKeyDB {
+	return &memAPIKeyDB{keys: make(map[string]*APIKey), lastUsed: make(map[uint64]time.Time)}
+}
+
+func (m *memAPIKeyDB) Create(apikey *APIKey) error {
+	apikey.ID = uint64(len(m.keys) + 1)
+	k := *apikey
+	k.Key = "" // never stored
+	m.keys[k.KeyPrefix] = &k
+	return nil
+}
+
+func (m *memAPIKeyDB) ByKeyPrefix(prefix string) (*APIKey, error) {
+	k, ok := m.keys[prefix]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	c := *k
+	return &c, nil
+}
+
+func (m *memAPIKeyDB) UpdateLastUsed(id uint64, lastUsed time.Time) error {
+	m.lastUsed[id] = lastUsed
+	return nil
+}
+
+func TestAPIKeyAuthenticate(t *testing.T) {
+
+	db := newMemAPIKeyDB()
+	as := &apikeyService{APIKeyDB: newAPIKeyValidator(db, "pepper"), pepper: "pepper"}
+
+	issue := func(usrID uint64) *APIKey {
+		ak := &APIKey{UsrID: usrID, Description: "test"}
+		if err := as.Create(ak); err != nil {
+			t.Fatal(err)
+		}
+		return ak
+	}
+
+	valid := issue(1)
+	if db.keys[valid.KeyPrefix].KeyHash == "" || strings.Contains(db.keys[valid.KeyPrefix].KeyHash, strings.SplitN(valid.Key, ".", 2)[1]) {
+		t.Fatalf("got stored key %+v; want the hash of the secret only", db.keys[valid.KeyPrefix])
+	}
+	ak, err := as.Authenticate(valid.Key)
+	if err != nil || ak.UsrID != 1 || ak.LastUsedOn == nil {
+		t.Fatalf("got %+v, %v; want the key of usr 1", ak, err)
+	}
+	if _, ok := db.lastUsed[ak.ID]; !ok {
+		t.Error("the last-used timestamp was not updated")
+	}
+
+	revoked := issue(2)
+	db.keys[revoked.KeyPrefix].Revoked = true
+
+	expired := issue(3)
+	past := time.Now().Add(-time.Minute)
+	db.keys[expired.KeyPrefix].ExpiresOn = &past
+
+	tests := []struct {
+		name string
+		key  string
+		want error
+	}{
+		{"wrong secret", valid.KeyPrefix + ".0123456789abcdef", ErrAPIKeyInvalid},
+		{"unknown prefix", "000000000000." + strings.SplitN(valid.Key, ".", 2)[1], ErrAPIKeyInvalid},
+		{"no separator", strings.Replace(valid.Key, ".", "", 1), ErrAPIKeyInvalid},
+		{"empty prefix", "." + strings.SplitN(valid.Key, ".", 2)[1], ErrAPIKeyInvalid},
+		{"empty secret", valid.KeyPrefix + ".", ErrAPIKeyInvalid},
+		{"empty", "", ErrAPIKeyInvalid},
+		{"revoked", revoked.Key, ErrAPIKeyRevoked},
+		{"expired", expired.Key, ErrAPIKeyExpired},
+	}
+	for _, tt := range tests {
+		if _, err := as.Authenticate(tt.key); err != tt.want {
+			t.Errorf("%s: got %v; want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestAPIKeyCreateValidation(t *testing.T) {
+
+	as := &apikeyService{APIKeyDB: newAPIKeyValidator(newMemAPIKeyDB(), "pepper"), pepper: "pepper"}
+
+	if err := as.Create(&APIKey{}); err != ErrAPIKeyUsrRequired {
+		t.Errorf("got %v; want %v", err, ErrAPIKeyUsrRequired)
+	}
+	past := time.Now().Add(-time.Minute)
+	if err := as.Create(&APIKey{UsrID: 1, ExpiresOn: &past}); err != ErrAPIKeyExpiryInvalid {
+		t.Errorf("got %v; want %v", err, ErrAPIKeyExpiryInvalid)
+	}
+}
@@ -26,7 +26,7 @@ type AuthDB interface {
 	Update(auth *Auth) error
 	Delete(auth *Auth) error
 	Get(auth *Auth) error
-	GetAuths() []Auth
+	GetAuths() ([]Auth, error)
 	GetAuthsByAuthName(op string, AuthName string) []Auth
 	GetAuthsByDescription(op string, Description string) []Auth
 }
@@ -152,7 +152,7 @@ func (gv *authValidator) Get(auth *Auth) error {
 }
 
 // GetAuths is passed through to the ORM with no validation
-func (gv *authValidator) GetAuths() []Auth {
+func (gv *authValidator) GetAuths() ([]Auth, error) {
 
 	return gv.AuthDB.GetAuths()
 }
@@ -232,16 +232,16 @@ func (gs *authSqac) Get(auth *Auth) error {
 }
 
 // Get all existing Auths from the db via the ORM
-func (gs *authSqac) GetAuths() []Auth {
+func (gs *authSqac) GetAuths() ([]Auth, error) {
 
 	getEnts := AuthGetEntitiesT{}
 
 	err := gs.handle.GetEntities2(&getEnts)
 	if err != nil {
 		lw.Error(err)
-		return getEnts.ents
+		return nil, err
 	}
-	return getEnts.ents
+	return getEnts.ents, nil
 }
 
 //- - - - - - - - - - - - - - - - - - -- - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
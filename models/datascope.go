@@ -0,0 +1,109 @@
+package models
+
+//=============================================================================================
+// GroupAuth data-scope code
+//=============================================================================================
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DataScope restricts a GroupAuth to the rows of a resource that belong to a
+// set of libraries.  Scopes are maintained on GroupAuth.Scope in one of the
+// following forms:
+//
+//	library_id IN (1,2,3)
+//	library_id = 1
+//
+// A nil *DataScope is unrestricted.
+type DataScope struct {
+	LibraryIDs map[uint64]bool
+}
+
+// the only scope field presently supported is library_id
+var dataScopeExp = regexp.MustCompile(`(?i)^\s*library_id\s*(?:=\s*(\d+)|in\s*\(([\d\s,]+)\))\s*$`)
+
+// ParseDataScope parses a GroupAuth scope expression.  An empty expression
+// results in a nil (unrestricted) scope.
+func ParseDataScope(s string) (*DataScope, error) {
+
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+
+	m := dataScopeExp.FindStringSubmatch(s)
+	if m == nil {
+		return nil, ErrGroupAuthScopeInvalid
+	}
+
+	list := m[1]
+	if list == "" {
+		list = m[2]
+	}
+
+	ds := &DataScope{LibraryIDs: make(map[uint64]bool)}
+	for _, v := range strings.Split(list, ",") {
+		id, err := strconv.ParseUint(strings.TrimSpace(v), 10, 64)
+		if err != nil || id == 0 {
+			return nil, ErrGroupAuthScopeInvalid
+		}
+		ds.LibraryIDs[id] = true
+	}
+	return ds, nil
+}
+
+// Merge adds the libraries of o to the scope.
+func (ds *DataScope) Merge(o *DataScope) {
+	for id := range o.LibraryIDs {
+		ds.LibraryIDs[id] = true
+	}
+}
+
+// AllowsLibrary reports whether the scope permits access to the rows of the
+// specified library.
+func (ds *DataScope) AllowsLibrary(id uint64) bool {
+	if ds == nil {
+		return true
+	}
+	return ds.LibraryIDs[id]
+}
+
+// Libraries returns the IDs of the libraries in the scope in ascending order.
+func (ds *DataScope) Libraries() []uint64 {
+
+	ids := make([]uint64, 0, len(ds.LibraryIDs))
+	for id := range ds.LibraryIDs {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// String returns the normalized scope expression.
+func (ds *DataScope) String() string {
+
+	var s []string
+	for _, id := range ds.Libraries() {
+		s = append(s, strconv.FormatUint(id, 10))
+	}
+	return "library_id IN (" + strings.Join(s, ",") + ")"
+}
+
+type dataScopeKey struct{}
+
+// ContextWithDataScope returns a copy of ctx carrying the data-scope of the
+// requesting usr.
+func ContextWithDataScope(ctx context.Context, ds *DataScope) context.Context {
+	return context.WithValue(ctx, dataScopeKey{}, ds)
+}
+
+// DataScopeFromContext returns the data-scope of the requesting usr, or nil
+// if the usr's access is unrestricted.
+func DataScopeFromContext(ctx context.Context) *DataScope {
+	ds, _ := ctx.Value(dataScopeKey{}).(*DataScope)
+	return ds
+}
@@ -65,3 +65,57 @@ const ErrInvalidPassword modelError = "models: the provided password is not vali
 //=============================================================================================
 
 // const ErrNewCustomerErr modelError = "models: your message here"
+
+// ErrAPIKeyInvalid - the presented api key is malformed or does not match a stored key
+const ErrAPIKeyInvalid modelError = "models: the provided api key is not valid"
+
+// ErrAPIKeyRevoked - the presented api key has been revoked
+const ErrAPIKeyRevoked modelError = "models: the provided api key has been revoked"
+
+// ErrAPIKeyExpired - the presented api key has passed its expiry date
+const ErrAPIKeyExpired modelError = "models: the provided api key has expired"
+
+// ErrAPIKeyUsrRequired - an api key must be issued to an existing Usr
+const ErrAPIKeyUsrRequired modelError = "models: an api key must be issued to a usr"
+
+// ErrAPIKeyExpiryInvalid - the expiry date of a new api key must be in the future
+const ErrAPIKeyExpiryInvalid modelError = "models: the api key expiry date must be in the future"
+
+// ErrJWTKeyIncomplete - a persisted jwt signing key requires a kid, alg and key material
+const ErrJWTKeyIncomplete modelError = "models: a jwt key requires a kid, alg and key material"
+
+// ErrJWTKeyEncKeyRequired - jwt signing keys are only stored encrypted
+const ErrJWTKeyEncKeyRequired modelError = "models: a jwt key encryption key must be configured to store jwt signing keys"
+
+// ErrUsrGroupMemberIncomplete - a usr group-membership requires a usr and a usrgroup
+const ErrUsrGroupMemberIncomplete modelError = "models: a usr group-membership requires a usr_id and a usr_group_id"
+
+// ErrUsrGroupMemberExists - the usr is already a member of the usrgroup
+const ErrUsrGroupMemberExists modelError = "models: the usr is already a member of the usrgroup"
+
+// ErrGroupAuthScopeInvalid - the groupauth scope is not a supported data-scope expression
+const ErrGroupAuthScopeInvalid modelError = "models: the groupauth scope must be of the form library_id IN (1,2) or library_id = 1"
+
+// ErrUsrInviteInvalid - the presented invitation token does not match a stored invite
+const ErrUsrInviteInvalid modelError = "models: the provided invitation token is not valid"
+
+// ErrUsrInviteExpired - the invite has passed its expiry date
+const ErrUsrInviteExpired modelError = "models: the invitation has expired"
+
+// ErrUsrInviteRedeemed - the invite has already been used to register a usr
+const ErrUsrInviteRedeemed modelError = "models: the invitation has already been redeemed"
+
+// ErrUsrInviteEmailMismatch - the invite was issued to a different email address
+const ErrUsrInviteEmailMismatch modelError = "models: the invitation was issued to a different email address"
+
+// ErrUsrInviteExpiryInvalid - the expiry date of a new invite must be in the future
+const ErrUsrInviteExpiryInvalid modelError = "models: the invitation expiry date must be in the future"
+
+// ErrUsrGroupMemberWindowInvalid - the validity window of a group-membership must end after it starts
+const ErrUsrGroupMemberWindowInvalid modelError = "models: the valid_to date of a usr group-membership must be after its valid_from date"
+
+// ErrUsrSessionInvalid - a usr session must carry a session ID, usr ID and expiry
+const ErrUsrSessionInvalid modelError = "models: a usr session requires a sid, usr_id and expires_on"
+
+// ErrGroupLeaderNameRequired - a group-leader record must be named
+const ErrGroupLeaderNameRequired modelError = "models: a group-leader record requires a name"
@@ -13,14 +13,15 @@ import (
 
 // GroupAuth structure
 type GroupAuth struct {
-	ID          uint64 `json:"id" db:"id" sqac:"primary_key:inc"`
-	Href        string `json:"href" db:"href" sqac:"-"`
-	GroupID     uint64 `json:"group_id" db:"group_id" sqac:"nullable:false"`
-	GroupName   string `json:"group_name" db:"group_name" sqac:"-"`
-	AuthID      uint64 `json:"auth_id" db:"auth_id" sqac:"nullable:false"`
-	AuthName    string `json:"auth_name" db:"auth_name" sqac:"-"`
-	AuthType    string `json:"auth_type" db:"auth_type" sqac:"-"`
-	Description string `json:"description" db:"description" sqac:"-"`
+	ID          uint64  `json:"id" db:"id" sqac:"primary_key:inc"`
+	Href        string  `json:"href" db:"href" sqac:"-"`
+	GroupID     uint64  `json:"group_id" db:"group_id" sqac:"nullable:false"`
+	GroupName   string  `json:"group_name" db:"group_name" sqac:"-"`
+	AuthID      uint64  `json:"auth_id" db:"auth_id" sqac:"nullable:false"`
+	Scope       *string `json:"scope,omitempty" db:"scope" sqac:"nullable:true"`
+	AuthName    string  `json:"auth_name" db:"auth_name" sqac:"-"`
+	AuthType    string  `json:"auth_type" db:"auth_type" sqac:"-"`
+	Description string  `json:"description" db:"description" sqac:"-"`
 }
 
 // GroupAuthDB is a CRUD-type interface specifically for dealing with GroupAuths.
@@ -29,7 +30,7 @@ type GroupAuthDB interface {
 	Update(groupauth *GroupAuth) error
 	Delete(groupauth *GroupAuth) error
 	Get(groupauth *GroupAuth) error
-	GetGroupAuths() []GroupAuth
+	GetGroupAuths() ([]GroupAuth, error)
 	GetGroupAuthsByAuthName(op string, AuthName string) []GroupAuth
 	GetGroupAuthsByDescription(op string, Description string) []GroupAuth
 	GetGroupAuthsByGroupID(op string, GroupID string) []GroupAuth
@@ -116,6 +117,7 @@ func (gv *groupauthValidator) Create(groupauth *GroupAuth) error {
 	// relationships between the fields in the entity structure.
 	err := runGroupAuthValFuncs(groupauth,
 		gv.normvalGroupName,
+		gv.normvalScope,
 	)
 
 	if err != nil {
@@ -136,6 +138,7 @@ func (gv *groupauthValidator) Update(groupauth *GroupAuth) error {
 	// relationships between the fields in the entity structure.
 	err := runGroupAuthValFuncs(groupauth,
 		gv.normvalGroupName,
+		gv.normvalScope,
 	)
 
 	if err != nil {
@@ -159,7 +162,7 @@ func (gv *groupauthValidator) Get(groupauth *GroupAuth) error {
 }
 
 // GetGroupAuths is passed through to the ORM with no validation
-func (gv *groupauthValidator) GetGroupAuths() []GroupAuth {
+func (gv *groupauthValidator) GetGroupAuths() ([]GroupAuth, error) {
 
 	return gv.GroupAuthDB.GetGroupAuths()
 }
@@ -178,6 +181,26 @@ func (gv *groupauthValidator) normvalGroupName(groupauth *GroupAuth) error {
 	return nil
 }
 
+// normvalScope validates the data-scope expression of the GroupAuth and
+// stores it in normalized form.  An empty scope is stored as NULL.
+func (gv *groupauthValidator) normvalScope(groupauth *GroupAuth) error {
+
+	if groupauth.Scope == nil {
+		return nil
+	}
+	ds, err := ParseDataScope(*groupauth.Scope)
+	if err != nil {
+		return err
+	}
+	if ds == nil {
+		groupauth.Scope = nil
+		return nil
+	}
+	s := ds.String()
+	groupauth.Scope = &s
+	return nil
+}
+
 //-------------------------------------------------------------------------------------------------------
 // internal book relations Validator funcs
 //-------------------------------------------------------------------------------------------------------
@@ -248,7 +271,7 @@ func (gs *groupauthSqac) Get(groupauth *GroupAuth) error {
 	// nice to provide the caller with some text from the resource
 	c := fmt.Sprintf("groupauth.id = ?")
 
-	qs := fmt.Sprintf(`SELECT groupauth.id, groupauth.group_id, usrgroup.group_name, groupauth.auth_id, auth.auth_name, auth.auth_type, auth.description
+	qs := fmt.Sprintf(`SELECT groupauth.id, groupauth.group_id, usrgroup.group_name, groupauth.auth_id, groupauth.scope, auth.auth_name, auth.auth_type, auth.description
  		FROM groupauth INNER JOIN auth ON (groupauth.auth_id = auth.id) 
  		               INNER JOIN usrgroup ON (usrgroup.id = groupauth.group_id) WHERE %s;`, c)
 
@@ -279,12 +302,12 @@ func (gs *groupauthSqac) Get(groupauth *GroupAuth) error {
 }
 
 // Get all existing GroupAuths from the db via the ORM
-func (gs *groupauthSqac) GetGroupAuths() []GroupAuth {
+func (gs *groupauthSqac) GetGroupAuths() ([]GroupAuth, error) {
 
 	groupauth := GroupAuth{}
 	groupauths := []GroupAuth{}
 
-	qs := fmt.Sprintf(`SELECT groupauth.id, groupauth.group_id, usrgroup.group_name, groupauth.auth_id, auth.auth_name, auth.auth_type, auth.description
+	qs := fmt.Sprintf(`SELECT groupauth.id, groupauth.group_id, usrgroup.group_name, groupauth.auth_id, groupauth.scope, auth.auth_name, auth.auth_type, auth.description
  		FROM groupauth INNER JOIN auth ON (groupauth.auth_id = auth.id) 
  		               INNER JOIN usrgroup ON (usrgroup.id = groupauth.group_id);`)
 
@@ -292,7 +315,7 @@ func (gs *groupauthSqac) GetGroupAuths() []GroupAuth {
 	rows, err := gs.handle.ExecuteQueryx(qs)
 	if err != nil {
 		lw.ErrorWithPrefixString("Get for table groupauth returned error: ", err)
-		return nil
+		return nil, err
 	}
 	defer rows.Close()
 
@@ -300,15 +323,19 @@ func (gs *groupauthSqac) GetGroupAuths() []GroupAuth {
 		err = rows.StructScan(&groupauth)
 		if err != nil {
 			lw.ErrorWithPrefixString("error reading GroupAuth rows via StructScan: ", err)
-			return nil
+			return nil, err
 		}
 		groupauths = append(groupauths, groupauth)
 	}
+	if err = rows.Err(); err != nil {
+		lw.ErrorWithPrefixString("error iterating GroupAuth rows: ", err)
+		return nil, err
+	}
 
 	if gs.handle.IsLog() {
 		lw.Debug("Get for table groupauth got: %v", groupauths)
 	}
-	return groupauths
+	return groupauths, nil
 }
 
 //- - - - - - - - - - - - - - - - - - -- - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
@@ -327,7 +354,7 @@ type GroupAuthGetEntitiesT struct {
 // GroupAuthGetEntitiesT struct's ents []GroupAuth field.
 func (ge *GroupAuthGetEntitiesT) Exec(sqh sqac.PublicDB) error {
 
-	selQuery := `SELECT groupauth.id, groupauth.group_id, usrgroup.group_name, groupauth.auth_id, auth.auth_name, auth.auth_type, auth.description
+	selQuery := `SELECT groupauth.id, groupauth.group_id, usrgroup.group_name, groupauth.auth_id, groupauth.scope, auth.auth_name, auth.auth_type, auth.description
  	FROM groupauth INNER JOIN auth ON (groupauth.auth_id = auth.id) 
  				   INNER JOIN usrgroup ON (usrgroup.id = groupauth.group_id);`
 
@@ -408,7 +435,7 @@ func (gs *groupauthSqac) GetGroupAuthsByAuthName(op string, AuthName string) []G
 	default:
 		return nil
 	}
-	qs := fmt.Sprintf(`SELECT groupauth.id, groupauth.group_id, usrgroup.group_name, groupauth.auth_id, auth.auth_name, auth.auth_type, auth.description
+	qs := fmt.Sprintf(`SELECT groupauth.id, groupauth.group_id, usrgroup.group_name, groupauth.auth_id, groupauth.scope, auth.auth_name, auth.auth_type, auth.description
  		FROM groupauth INNER JOIN auth ON (groupauth.auth_id = auth.id) 
  		               INNER JOIN usrgroup ON (usrgroup.id = groupauth.group_id) WHERE %s;`, c)
 
@@ -439,7 +466,7 @@ func (gs *groupauthSqac) GetGroupAuthsByDescription(op string, Description strin
 		return nil
 	}
 
-	qs := fmt.Sprintf(`SELECT groupauth.id, groupauth.group_id, usrgroup.group_name, groupauth.auth_id, auth.auth_name, auth.auth_type, auth.description
+	qs := fmt.Sprintf(`SELECT groupauth.id, groupauth.group_id, usrgroup.group_name, groupauth.auth_id, groupauth.scope, auth.auth_name, auth.auth_type, auth.description
  		FROM groupauth INNER JOIN auth ON (groupauth.auth_id = auth.id) 
  		               INNER JOIN usrgroup ON (usrgroup.id = groupauth.group_id) WHERE %s;`, c)
 
@@ -472,7 +499,7 @@ func (gs *groupauthSqac) GetGroupAuthsByGroupID(op string, GroupID string) []Gro
 		return nil
 	}
 
-	qs := fmt.Sprintf(`SELECT groupauth.id, groupauth.group_id, usrgroup.group_name, groupauth.auth_id, auth.auth_name, auth.auth_type, auth.description
+	qs := fmt.Sprintf(`SELECT groupauth.id, groupauth.group_id, usrgroup.group_name, groupauth.auth_id, groupauth.scope, auth.auth_name, auth.auth_type, auth.description
  		FROM groupauth INNER JOIN auth ON (groupauth.auth_id = auth.id) 
  		               INNER JOIN usrgroup ON (usrgroup.id = groupauth.group_id) WHERE %s;`, c)
 
@@ -0,0 +1,149 @@
+package models
+
+//=============================================================================================
+// GroupLeader entity model code
+//=============================================================================================
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/1414C/lw"
+	"github.com/1414C/sqac"
+)
+
+// GroupLeader structure.  A GroupLeader holds the group-leader record of the
+// group-membership subsystem when the application's own db is being used as
+// the group-leadership KVS.  Each record is identified by its Name; Version is
+// incremented by every update so that concurrent writers can be detected.
+// Epoch is the fencing epoch of the leader, which is maintained by the
+// group-membership subsystem.
+type GroupLeader struct {
+	ID              uint64     `json:"id" db:"id" sqac:"primary_key:inc"`
+	Href            string     `json:"href" db:"href" sqac:"-"`
+	Name            string     `json:"name" db:"name" sqac:"nullable:false;index:unique"`
+	LeaderID        uint64     `json:"leader_id" db:"leader_id" sqac:"nullable:false;default:0"`
+	LeaderIPAddress string     `json:"leader_ip_address" db:"leader_ip_address" sqac:"nullable:false;default:"`
+	Epoch           uint64     `json:"epoch" db:"epoch" sqac:"nullable:false;default:0"`
+	Version         uint64     `json:"version" db:"version" sqac:"nullable:false;default:0"`
+	UpdatedOn       *time.Time `json:"updated_on,omitempty" db:"updated_on" sqac:"nullable:false;default:now()"`
+}
+
+// GroupLeaderDB is a CRUD-type interface specifically for dealing with GroupLeaders.
+type GroupLeaderDB interface {
+	Create(leader *GroupLeader) error
+	ByName(name string) (*GroupLeader, error)
+	CompareAndSwap(leader *GroupLeader, version uint64) (bool, error)
+}
+
+// GroupLeaderService is the public interface to the GroupLeader entity
+type GroupLeaderService interface {
+	GroupLeaderDB
+}
+
+// private service for groupleader
+type groupLeaderService struct {
+	GroupLeaderDB
+}
+
+// groupLeaderValidator checks and normalizes data prior to
+// db access.
+type groupLeaderValidator struct {
+	GroupLeaderDB
+}
+
+// groupLeaderSqac is a sqac-based implementation of the GroupLeaderDB interface.
+type groupLeaderSqac struct {
+	handle sqac.PublicDB
+}
+
+var _ GroupLeaderDB = &groupLeaderSqac{}
+
+// NewGroupLeaderService creates a new GroupLeaderService
+func NewGroupLeaderService(handle sqac.PublicDB) GroupLeaderService {
+
+	gs := &groupLeaderSqac{handle}
+
+	gv := &groupLeaderValidator{
+		GroupLeaderDB: gs,
+	}
+	return &groupLeaderService{
+		GroupLeaderDB: gv,
+	}
+}
+
+// ensure consistency (build error if delta exists)
+var _ GroupLeaderDB = &groupLeaderValidator{}
+
+// Create checks that the record is named and starts the record at
+// version 1 before calling the creation code contained in GroupLeaderService.
+func (gv *groupLeaderValidator) Create(leader *GroupLeader) error {
+
+	if leader.Name == "" {
+		return ErrGroupLeaderNameRequired
+	}
+	leader.Version = 1
+	return gv.GroupLeaderDB.Create(leader)
+}
+
+// CompareAndSwap checks that the record is named before calling the
+// update code contained in GroupLeaderService.
+func (gv *groupLeaderValidator) CompareAndSwap(leader *GroupLeader, version uint64) (bool, error) {
+
+	if leader.Name == "" {
+		return false, ErrGroupLeaderNameRequired
+	}
+	return gv.GroupLeaderDB.CompareAndSwap(leader, version)
+}
+
+//-------------------------------------------------------------------------------------------------------
+// ORM db CRUD access methods
+//-------------------------------------------------------------------------------------------------------
+//
+// Create a new GroupLeader in the database via the ORM
+func (gs *groupLeaderSqac) Create(leader *GroupLeader) error {
+	return gs.handle.Create(leader)
+}
+
+// ByName - lookup a GroupLeader using its name
+// 1 - leader, nil
+// 2 - nil, ErrNotFound
+// 3 - nil, otherError
+//
+func (gs *groupLeaderSqac) ByName(name string) (*GroupLeader, error) {
+
+	var leader GroupLeader
+	err := gs.handle.Get(&leader, "SELECT * FROM groupleader WHERE name = ?;", name)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		lw.Warning("reading GroupLeader by name got: %s", err.Error())
+		return nil, err
+	}
+	return &leader, nil
+}
+
+// CompareAndSwap writes the leader information of the named GroupLeader if
+// its version is still the specified version, and advances the version.  false
+// is returned if the record was changed by another writer in the meantime, in
+// which case leader is left untouched.
+func (gs *groupLeaderSqac) CompareAndSwap(leader *GroupLeader, version uint64) (bool, error) {
+
+	now := time.Now()
+	res, err := gs.handle.Exec("UPDATE groupleader SET leader_id = ?, leader_ip_address = ?, epoch = ?, version = ?, updated_on = ? WHERE name = ? AND version = ?;",
+		leader.LeaderID, leader.LeaderIPAddress, leader.Epoch, version+1, now, leader.Name, version)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n != 1 {
+		return false, nil
+	}
+	leader.Version = version + 1
+	leader.UpdatedOn = &now
+	return true, nil
+}
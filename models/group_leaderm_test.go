@@ -0,0 +1,54 @@
+package models
+
+import "testing"
+
+func TestGroupLeaderCompareAndSwap(t *testing.T) {
+
+	s, cleanup := newTestServices(t)
+	defer cleanup()
+
+	l := GroupLeader{Name: "LEADER", LeaderID: 1, LeaderIPAddress: "127.0.0.1:4444", Epoch: 1}
+	if err := s.GroupLeader.Create(&l); err != nil {
+		t.Fatal(err)
+	}
+	if l.Version != 1 {
+		t.Fatalf("created with version %d", l.Version)
+	}
+
+	// two writers read the same version; only the first update is applied
+	a, err := s.GroupLeader.ByName("LEADER")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := s.GroupLeader.ByName("LEADER")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.LeaderID, a.Epoch = 2, 2
+	ok, err := s.GroupLeader.CompareAndSwap(a, a.Version)
+	if err != nil || !ok || a.Version != 2 {
+		t.Fatalf("first swap got %v, %v, version %d", ok, err, a.Version)
+	}
+	b.LeaderID, b.Epoch = 3, 2
+	ok, err = s.GroupLeader.CompareAndSwap(b, b.Version)
+	if err != nil || ok {
+		t.Fatalf("stale swap got %v, %v", ok, err)
+	}
+	if b.Version != 1 {
+		t.Errorf("stale swap advanced the version to %d", b.Version)
+	}
+
+	cur, err := s.GroupLeader.ByName("LEADER")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cur.LeaderID != 2 || cur.Version != 2 {
+		t.Errorf("got leader %d version %d, want leader 2 version 2", cur.LeaderID, cur.Version)
+	}
+
+	// an unknown record is not updated either
+	ok, err = s.GroupLeader.CompareAndSwap(&GroupLeader{Name: "OTHER"}, 0)
+	if err != nil || ok {
+		t.Errorf("swap of an unknown record got %v, %v", ok, err)
+	}
+}
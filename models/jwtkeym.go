@@ -0,0 +1,240 @@
+package models
+
+//=============================================================================================
+// JWTKey entity model code
+//=============================================================================================
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/1414C/lw"
+	"github.com/1414C/sqac"
+)
+
+// JWTKey structure.  JWTKeys hold the signing keys created via signing-key
+// rotation so that the key-set survives an application restart.  Keys that
+// are read from the PEM files in the application configuration are only
+// stored once they have been retired by a rotation, without their private
+// key.  The newest key without an expiry date is the signing key; older keys
+// remain available for verification until ExpiresOn.  PrivKeyPEM is stored
+// encrypted with the configured jwt key encryption key.
+type JWTKey struct {
+	ID         uint64     `json:"id" db:"id" sqac:"primary_key:inc"`
+	Href       string     `json:"href" db:"href" sqac:"-"`
+	KID        string     `json:"kid" db:"kid" sqac:"nullable:false;index:unique"`
+	Alg        string     `json:"alg" db:"alg" sqac:"nullable:false"`
+	PrivKeyPEM string     `json:"-" db:"priv_key_pem" sqac:"nullable:false"`
+	PubKeyPEM  string     `json:"pub_key_pem" db:"pub_key_pem" sqac:"nullable:false"`
+	CreatedOn  *time.Time `json:"created_on,omitempty" db:"created_on" sqac:"nullable:false;default:now()"`
+	ExpiresOn  *time.Time `json:"expires_on,omitempty" db:"expires_on" sqac:"nullable:true"`
+}
+
+// JWTKeyDB is a CRUD-type interface specifically for dealing with JWTKeys.
+type JWTKeyDB interface {
+	Create(jwtkey *JWTKey) error
+	Update(jwtkey *JWTKey) error
+	Get(jwtkey *JWTKey) error
+	GetJWTKeys() []JWTKey
+}
+
+// JWTKeyService is the public interface to the JWTKey entity
+type JWTKeyService interface {
+	JWTKeyDB
+}
+
+// private service for jwtkey
+type jwtkeyService struct {
+	JWTKeyDB
+}
+
+// jwtkeyValidator checks and normalizes data prior to
+// db access.  The private keys are sealed on their way into the db and
+// opened on their way out.
+type jwtkeyValidator struct {
+	JWTKeyDB
+	aead cipher.AEAD
+}
+
+// jwtKeySealPrefix marks a PrivKeyPEM that has been sealed with the
+// jwt key encryption key.
+const jwtKeySealPrefix = "aesgcm:"
+
+// jwtkeySqac is a sqac-based implementation of the JWTKeyDB interface.
+type jwtkeySqac struct {
+	handle sqac.PublicDB
+}
+
+var _ JWTKeyDB = &jwtkeySqac{}
+
+// NewJWTKeyService creates a new JWTKeyService.  The private keys of the
+// JWTKeys are encrypted with an AES-256 key derived from encKey; signing keys
+// cannot be persisted if no encKey is provided.
+func NewJWTKeyService(handle sqac.PublicDB, encKey []byte) JWTKeyService {
+
+	js := &jwtkeySqac{handle}
+
+	jv, err := newJWTKeyValidator(js, encKey)
+	if err != nil {
+		lw.Warning("NewJWTKeyService got: %s", err.Error())
+	}
+	return &jwtkeyService{
+		JWTKeyDB: jv,
+	}
+}
+
+// newJWTKeyValidator creates a jwtkeyValidator sealing the private keys with
+// the provided encKey.
+func newJWTKeyValidator(jdb JWTKeyDB, encKey []byte) (*jwtkeyValidator, error) {
+
+	jv := &jwtkeyValidator{
+		JWTKeyDB: jdb,
+	}
+	if len(encKey) == 0 {
+		return jv, nil
+	}
+	key := sha256.Sum256(encKey)
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return jv, err
+	}
+	jv.aead, err = cipher.NewGCM(block)
+	return jv, err
+}
+
+// ensure consistency (build error if delta exists)
+var _ JWTKeyDB = &jwtkeyValidator{}
+
+// Create checks that the key material is present before calling the
+// creation code contained in JWTKeyService.  Only retired keys may be
+// created without a private key.
+func (jv *jwtkeyValidator) Create(jwtkey *JWTKey) error {
+
+	if jwtkey.KID == "" || jwtkey.Alg == "" || jwtkey.PubKeyPEM == "" {
+		return ErrJWTKeyIncomplete
+	}
+	if jwtkey.PrivKeyPEM == "" && jwtkey.ExpiresOn == nil {
+		return ErrJWTKeyIncomplete
+	}
+	return jv.sealed(jwtkey, jv.JWTKeyDB.Create)
+}
+
+// Update seals the private key before calling the update code
+// contained in JWTKeyService.
+func (jv *jwtkeyValidator) Update(jwtkey *JWTKey) error {
+	return jv.sealed(jwtkey, jv.JWTKeyDB.Update)
+}
+
+// Get opens the private key of the JWTKey read by the code
+// contained in JWTKeyService.
+func (jv *jwtkeyValidator) Get(jwtkey *JWTKey) error {
+
+	err := jv.JWTKeyDB.Get(jwtkey)
+	if err != nil {
+		return err
+	}
+	jwtkey.PrivKeyPEM, err = jv.open(jwtkey.KID, jwtkey.PrivKeyPEM)
+	return err
+}
+
+// GetJWTKeys opens the private keys of the JWTKeys read by the code
+// contained in JWTKeyService.  Keys whose private key cannot be opened
+// are returned without it.
+func (jv *jwtkeyValidator) GetJWTKeys() []JWTKey {
+
+	jwtkeys := jv.JWTKeyDB.GetJWTKeys()
+	for i := range jwtkeys {
+		pem, err := jv.open(jwtkeys[i].KID, jwtkeys[i].PrivKeyPEM)
+		if err != nil {
+			lw.Warning("GetJWTKeys kid %s got: %s", jwtkeys[i].KID, err.Error())
+		}
+		jwtkeys[i].PrivKeyPEM = pem
+	}
+	return jwtkeys
+}
+
+// sealed calls fn with the private key of jwtkey sealed, leaving the
+// caller's copy of the private key in plain-text.
+func (jv *jwtkeyValidator) sealed(jwtkey *JWTKey, fn func(*JWTKey) error) error {
+
+	plain := jwtkey.PrivKeyPEM
+	if plain != "" {
+		if jv.aead == nil {
+			return ErrJWTKeyEncKeyRequired
+		}
+		nonce := make([]byte, jv.aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return err
+		}
+		ct := jv.aead.Seal(nonce, nonce, []byte(plain), []byte(jwtkey.KID))
+		jwtkey.PrivKeyPEM = jwtKeySealPrefix + base64.StdEncoding.EncodeToString(ct)
+	}
+	err := fn(jwtkey)
+	jwtkey.PrivKeyPEM = plain
+	return err
+}
+
+// open returns the plain-text of a sealed private key.  The kid is bound
+// to the ciphertext, so sealed keys cannot be swapped between rows.
+func (jv *jwtkeyValidator) open(kid, sealed string) (string, error) {
+
+	if sealed == "" {
+		return "", nil
+	}
+	if !strings.HasPrefix(sealed, jwtKeySealPrefix) {
+		return "", fmt.Errorf("jwt private key of kid %s is not sealed", kid)
+	}
+	if jv.aead == nil {
+		return "", ErrJWTKeyEncKeyRequired
+	}
+	ct, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(sealed, jwtKeySealPrefix))
+	if err != nil {
+		return "", err
+	}
+	ns := jv.aead.NonceSize()
+	if len(ct) < ns {
+		return "", fmt.Errorf("jwt private key of kid %s is truncated", kid)
+	}
+	plain, err := jv.aead.Open(nil, ct[:ns], ct[ns:], []byte(kid))
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+//-------------------------------------------------------------------------------------------------------
+// ORM db CRUD access methods
+//-------------------------------------------------------------------------------------------------------
+//
+// Create a new JWTKey in the database via the ORM
+func (js *jwtkeySqac) Create(jwtkey *JWTKey) error {
+	return js.handle.Create(jwtkey)
+}
+
+// Update an existing JWTKey in the database via the ORM
+func (js *jwtkeySqac) Update(jwtkey *JWTKey) error {
+	return js.handle.Update(jwtkey)
+}
+
+// Get an existing JWTKey from the database via the ORM
+func (js *jwtkeySqac) Get(jwtkey *JWTKey) error {
+	return js.handle.GetEntity(jwtkey)
+}
+
+// GetJWTKeys gets all existing JWTKeys from the db ordered by creation
+func (js *jwtkeySqac) GetJWTKeys() []JWTKey {
+
+	var jwtkeys []JWTKey
+	err := js.handle.Select(&jwtkeys, "SELECT * FROM jwtkey ORDER BY id;")
+	if err != nil {
+		lw.Warning("GetJWTKeys got: %s", err.Error())
+		return nil
+	}
+	return jwtkeys
+}
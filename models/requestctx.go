@@ -0,0 +1,24 @@
+package models
+
+//=============================================================================================
+// request context code
+//=============================================================================================
+
+import (
+	"context"
+)
+
+type usrIDKey struct{}
+
+// ContextWithUsrID returns a copy of ctx carrying the ID of the authenticated
+// usr that made the request.
+func ContextWithUsrID(ctx context.Context, usrID uint64) context.Context {
+	return context.WithValue(ctx, usrIDKey{}, usrID)
+}
+
+// UsrIDFromContext returns the ID of the authenticated usr that made the
+// request.  ok is false for requests that did not pass the auth middleware.
+func UsrIDFromContext(ctx context.Context) (usrID uint64, ok bool) {
+	usrID, ok = ctx.Value(usrIDKey{}).(uint64)
+	return usrID, ok
+}
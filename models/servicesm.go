@@ -10,12 +10,18 @@ import (
 
 // Services contains the set of services used by the application
 type Services struct {
-	Usr       UsrService
-	UsrGroup  UsrGroupService
-	Auth      AuthService
-	GroupAuth GroupAuthService
-	Library   LibraryService
-	Book      BookService
+	Usr            UsrService
+	UsrGroup       UsrGroupService
+	UsrGroupMember UsrGroupMemberService
+	Auth           AuthService
+	GroupAuth      GroupAuthService
+	Library        LibraryService
+	Book           BookService
+	APIKey         APIKeyService
+	JWTKey         JWTKeyService
+	UsrInvite      UsrInviteService
+	UsrSession     UsrSessionService
+	GroupLeader    GroupLeaderService
 	// Product ProductService
 	handle sqac.PublicDB
 }
@@ -56,6 +62,14 @@ func WithUsrGroup() ServicesConfig {
 	}
 }
 
+// WithUsrGroupMember creates a UsrGroupMember service
+func WithUsrGroupMember() ServicesConfig {
+	return func(s *Services) error {
+		s.UsrGroupMember = NewUsrGroupMemberService(s.handle)
+		return nil
+	}
+}
+
 // WithAuth creates a Auth service
 func WithAuth() ServicesConfig {
 	return func(s *Services) error {
@@ -72,6 +86,46 @@ func WithGroupAuth() ServicesConfig {
 	}
 }
 
+// WithAPIKey creates an APIKey service
+func WithAPIKey(pepper string) ServicesConfig {
+	return func(s *Services) error {
+		s.APIKey = NewAPIKeyService(s.handle, pepper)
+		return nil
+	}
+}
+
+// WithUsrInvite creates a UsrInvite service
+func WithUsrInvite(pepper string) ServicesConfig {
+	return func(s *Services) error {
+		s.UsrInvite = NewUsrInviteService(s.handle, pepper)
+		return nil
+	}
+}
+
+// WithUsrSession creates a UsrSession service
+func WithUsrSession() ServicesConfig {
+	return func(s *Services) error {
+		s.UsrSession = NewUsrSessionService(s.handle)
+		return nil
+	}
+}
+
+// WithGroupLeader creates a GroupLeader service
+func WithGroupLeader() ServicesConfig {
+	return func(s *Services) error {
+		s.GroupLeader = NewGroupLeaderService(s.handle)
+		return nil
+	}
+}
+
+// WithJWTKey creates a JWTKey service
+func WithJWTKey(encKey []byte) ServicesConfig {
+	return func(s *Services) error {
+		s.JWTKey = NewJWTKeyService(s.handle, encKey)
+		return nil
+	}
+}
+
 // WithLibrary creates a Library service
 func WithLibrary() ServicesConfig {
 	return func(s *Services) error {
@@ -114,5 +168,5 @@ func (s *Services) DestructiveReset() error {
 
 // AlterAllTables runs AlterTables for each listed entity.  Supports additive columns only.
 func (s *Services) AlterAllTables() error {
-	return s.handle.AlterTables(Library{}, Book{}, Usr{}, UsrGroup{}, Auth{}, GroupAuth{})
+	return s.handle.AlterTables(Library{}, Book{}, Usr{}, UsrGroup{}, UsrGroupMember{}, Auth{}, GroupAuth{}, APIKey{}, JWTKey{}, UsrInvite{}, UsrSession{}, GroupLeader{})
 }
@@ -0,0 +1,428 @@
+package models
+
+//=============================================================================================
+// UsrGroupMember entity model code
+//=============================================================================================
+
+import (
+	"strings"
+	"time"
+
+	"github.com/1414C/lw"
+	"github.com/1414C/sqac"
+)
+
+// UsrGroupMember structure.  UsrGroupMember relates a Usr to a UsrGroup and
+// replaces the semicolon-separated Usr.Groups string.  The relation refers to
+// the UsrGroup by ID, so the renaming of a UsrGroup does not affect it.
+// A membership may be limited to a validity window via ValidFrom / ValidTo;
+// memberships outside of their window grant nothing.
+type UsrGroupMember struct {
+	ID         uint64     `json:"id" db:"id" sqac:"primary_key:inc"`
+	Href       string     `json:"href" db:"href" sqac:"-"`
+	UsrID      uint64     `json:"usr_id" db:"usr_id" sqac:"nullable:false;index:idx_usrgroupmember_usr_id_usr_group_id;fkey:usr(id)"`
+	UsrGroupID uint64     `json:"usr_group_id" db:"usr_group_id" sqac:"nullable:false;index:idx_usrgroupmember_usr_id_usr_group_id;fkey:usrgroup(id)"`
+	ValidFrom  *time.Time `json:"valid_from,omitempty" db:"valid_from" sqac:"nullable:true"`
+	ValidTo    *time.Time `json:"valid_to,omitempty" db:"valid_to" sqac:"nullable:true"`
+}
+
+// ValidAt reports whether the membership is valid at time t.
+func (m *UsrGroupMember) ValidAt(t time.Time) bool {
+	if m.ValidFrom != nil && t.Before(*m.ValidFrom) {
+		return false
+	}
+	if m.ValidTo != nil && !t.Before(*m.ValidTo) {
+		return false
+	}
+	return true
+}
+
+// UsrGroupMemberDB is a CRUD-type interface specifically for dealing with UsrGroupMembers.
+type UsrGroupMemberDB interface {
+	Create(usrgroupmember *UsrGroupMember) error
+	Update(usrgroupmember *UsrGroupMember) error
+	Delete(usrgroupmember *UsrGroupMember) error
+	Get(usrgroupmember *UsrGroupMember) error
+	GetUsrGroupMembers() ([]UsrGroupMember, error)
+	GetUsrGroupMembersByUsrID(usrID uint64) []UsrGroupMember
+	GetUsrGroupMembersByUsrGroupID(usrGroupID uint64) []UsrGroupMember
+	DeleteUsrGroupMembersByUsrID(usrID uint64) error
+	DeleteUsrGroupMembersByUsrGroupID(usrGroupID uint64) error
+	GetTemporalUsrGroupMembers() []UsrGroupMember
+}
+
+// UsrGroupMemberService is the public interface to the UsrGroupMember entity
+type UsrGroupMemberService interface {
+
+	// SetUsrGroups replaces the group-memberships of the specified Usr
+	SetUsrGroups(usrID uint64, usrGroupIDs []uint64) error
+
+	// SetUsrGroupMembers replaces the group-memberships of the specified Usr
+	// including their validity windows
+	SetUsrGroupMembers(usrID uint64, members []UsrGroupMember) error
+
+	// DeleteExpiredUsrGroupMembers deletes the memberships whose validity
+	// window ended before now and returns them
+	DeleteExpiredUsrGroupMembers(now time.Time) ([]UsrGroupMember, error)
+	UsrGroupMemberDB
+}
+
+// private service for usrgroupmember
+type usrgroupmemberService struct {
+	UsrGroupMemberDB
+}
+
+// usrgroupmemberValidator checks and normalizes data prior to
+// db access.
+type usrgroupmemberValidator struct {
+	UsrGroupMemberDB
+}
+
+// usrgroupmemberValFunc type is the prototype for discrete UsrGroupMember normalization
+// and validation functions that will be executed by func runUsrGroupMemberValFuncs(...)
+type usrgroupmemberValFunc func(*UsrGroupMember) error
+
+// usrgroupmemberSqac is a sqac-based implementation of the UsrGroupMemberDB interface.
+type usrgroupmemberSqac struct {
+	handle sqac.PublicDB
+}
+
+var _ UsrGroupMemberDB = &usrgroupmemberSqac{}
+
+// NewUsrGroupMemberService creates a new UsrGroupMemberService
+func NewUsrGroupMemberService(handle sqac.PublicDB) UsrGroupMemberService {
+
+	ms := &usrgroupmemberSqac{handle}
+
+	mv := &usrgroupmemberValidator{
+		UsrGroupMemberDB: ms,
+	}
+	return &usrgroupmemberService{
+		UsrGroupMemberDB: mv,
+	}
+}
+
+// SetUsrGroups replaces the group-memberships of the specified Usr.  Memberships
+// that are not in usrGroupIDs are removed and missing memberships are created.
+func (ms *usrgroupmemberService) SetUsrGroups(usrID uint64, usrGroupIDs []uint64) error {
+
+	want := make(map[uint64]bool)
+	for _, id := range usrGroupIDs {
+		want[id] = true
+	}
+
+	for _, m := range ms.GetUsrGroupMembersByUsrID(usrID) {
+		if want[m.UsrGroupID] {
+			delete(want, m.UsrGroupID)
+			continue
+		}
+		err := ms.Delete(&m)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, id := range usrGroupIDs {
+		if !want[id] {
+			continue
+		}
+		delete(want, id)
+		err := ms.Create(&UsrGroupMember{UsrID: usrID, UsrGroupID: id})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetUsrGroupMembers replaces the group-memberships of the specified Usr with
+// members.  Unlike SetUsrGroups, the validity windows of retained memberships
+// are replaced with those in members.
+func (ms *usrgroupmemberService) SetUsrGroupMembers(usrID uint64, members []UsrGroupMember) error {
+
+	want := make(map[uint64]UsrGroupMember)
+	for _, m := range members {
+		if m.ValidFrom != nil && m.ValidTo != nil && !m.ValidTo.After(*m.ValidFrom) {
+			return ErrUsrGroupMemberWindowInvalid
+		}
+		want[m.UsrGroupID] = m
+	}
+
+	for _, cur := range ms.GetUsrGroupMembersByUsrID(usrID) {
+		m, ok := want[cur.UsrGroupID]
+		if !ok {
+			err := ms.Delete(&cur)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		delete(want, cur.UsrGroupID)
+		if sameTime(cur.ValidFrom, m.ValidFrom) && sameTime(cur.ValidTo, m.ValidTo) {
+			continue
+		}
+		cur.ValidFrom = m.ValidFrom
+		cur.ValidTo = m.ValidTo
+		err := ms.Update(&cur)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, m := range members {
+		nm, ok := want[m.UsrGroupID]
+		if !ok {
+			continue
+		}
+		delete(want, m.UsrGroupID)
+		err := ms.Create(&UsrGroupMember{UsrID: usrID, UsrGroupID: nm.UsrGroupID, ValidFrom: nm.ValidFrom, ValidTo: nm.ValidTo})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteExpiredUsrGroupMembers deletes the memberships whose validity window
+// ended before now.  The deleted memberships are returned so that the changed
+// membership sets can be disseminated.
+func (ms *usrgroupmemberService) DeleteExpiredUsrGroupMembers(now time.Time) ([]UsrGroupMember, error) {
+
+	var expired []UsrGroupMember
+	for _, m := range ms.GetTemporalUsrGroupMembers() {
+		if m.ValidTo == nil || now.Before(*m.ValidTo) {
+			continue
+		}
+		err := ms.Delete(&m)
+		if err != nil {
+			return expired, err
+		}
+		expired = append(expired, m)
+	}
+	return expired, nil
+}
+
+// sameTime reports whether two optional timestamps are equal.
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+// runUsrGroupMemberValFuncs executes a list of discrete validation
+// functions against a usrgroupmember.
+func runUsrGroupMemberValFuncs(usrgroupmember *UsrGroupMember, fns ...usrgroupmemberValFunc) error {
+
+	// iterate over the slice of function names and execute
+	// each in-turn.  the order in which the lists are made
+	// can matter...
+	for _, fn := range fns {
+		err := fn(usrgroupmember)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensure consistency (build error if delta exists)
+var _ UsrGroupMemberDB = &usrgroupmemberValidator{}
+
+// requireKeys checks that both sides of the relation have been provided.
+func (mv *usrgroupmemberValidator) requireKeys(usrgroupmember *UsrGroupMember) error {
+	if usrgroupmember.UsrID == 0 || usrgroupmember.UsrGroupID == 0 {
+		return ErrUsrGroupMemberIncomplete
+	}
+	return nil
+}
+
+// rejectDuplicate checks that the Usr is not already a member of the UsrGroup.
+func (mv *usrgroupmemberValidator) rejectDuplicate(usrgroupmember *UsrGroupMember) error {
+	for _, m := range mv.GetUsrGroupMembersByUsrID(usrgroupmember.UsrID) {
+		if m.UsrGroupID == usrgroupmember.UsrGroupID {
+			return ErrUsrGroupMemberExists
+		}
+	}
+	return nil
+}
+
+// validWindow checks that the validity window of the membership, if any, ends
+// after it starts.
+func (mv *usrgroupmemberValidator) validWindow(usrgroupmember *UsrGroupMember) error {
+	if usrgroupmember.ValidFrom != nil && usrgroupmember.ValidTo != nil && !usrgroupmember.ValidTo.After(*usrgroupmember.ValidFrom) {
+		return ErrUsrGroupMemberWindowInvalid
+	}
+	return nil
+}
+
+// Create validates and normalizes data used in the usrgroupmember creation.
+// Create then calls the creation code contained in UsrGroupMemberService.
+func (mv *usrgroupmemberValidator) Create(usrgroupmember *UsrGroupMember) error {
+
+	err := runUsrGroupMemberValFuncs(usrgroupmember,
+		mv.requireKeys,
+		mv.validWindow,
+		mv.rejectDuplicate,
+	)
+	if err != nil {
+		return err
+	}
+	return mv.UsrGroupMemberDB.Create(usrgroupmember)
+}
+
+// Update validates the usrgroupmember prior to its update; only the validity
+// window of a membership is expected to change.
+func (mv *usrgroupmemberValidator) Update(usrgroupmember *UsrGroupMember) error {
+
+	err := runUsrGroupMemberValFuncs(usrgroupmember,
+		mv.requireKeys,
+		mv.validWindow,
+	)
+	if err != nil {
+		return err
+	}
+	return mv.UsrGroupMemberDB.Update(usrgroupmember)
+}
+
+//-------------------------------------------------------------------------------------------------------
+// ORM db CRUD access methods
+//-------------------------------------------------------------------------------------------------------
+//
+// Create a new UsrGroupMember in the database via the ORM
+func (ms *usrgroupmemberSqac) Create(usrgroupmember *UsrGroupMember) error {
+	return ms.handle.Create(usrgroupmember)
+}
+
+// Update an existing UsrGroupMember in the database via the ORM
+func (ms *usrgroupmemberSqac) Update(usrgroupmember *UsrGroupMember) error {
+	return ms.handle.Update(usrgroupmember)
+}
+
+// Delete an existing UsrGroupMember in the database via the ORM
+func (ms *usrgroupmemberSqac) Delete(usrgroupmember *UsrGroupMember) error {
+	return ms.handle.Delete(usrgroupmember)
+}
+
+// Get an existing UsrGroupMember from the database via the ORM
+func (ms *usrgroupmemberSqac) Get(usrgroupmember *UsrGroupMember) error {
+	return ms.handle.GetEntity(usrgroupmember)
+}
+
+// GetUsrGroupMembers gets all existing UsrGroupMembers from the db
+func (ms *usrgroupmemberSqac) GetUsrGroupMembers() ([]UsrGroupMember, error) {
+
+	var usrgroupmembers []UsrGroupMember
+	err := ms.handle.Select(&usrgroupmembers, "SELECT * FROM usrgroupmember;")
+	if err != nil {
+		lw.Warning("GetUsrGroupMembers got: %s", err.Error())
+		return nil, err
+	}
+	return usrgroupmembers, nil
+}
+
+// GetUsrGroupMembersByUsrID gets the UsrGroupMembers of the specified Usr
+func (ms *usrgroupmemberSqac) GetUsrGroupMembersByUsrID(usrID uint64) []UsrGroupMember {
+
+	var usrgroupmembers []UsrGroupMember
+	err := ms.handle.Select(&usrgroupmembers, "SELECT * FROM usrgroupmember WHERE usr_id = ?;", usrID)
+	if err != nil {
+		lw.Warning("GetUsrGroupMembersByUsrID got: %s", err.Error())
+		return nil
+	}
+	return usrgroupmembers
+}
+
+// GetUsrGroupMembersByUsrGroupID gets the UsrGroupMembers of the specified UsrGroup
+func (ms *usrgroupmemberSqac) GetUsrGroupMembersByUsrGroupID(usrGroupID uint64) []UsrGroupMember {
+
+	var usrgroupmembers []UsrGroupMember
+	err := ms.handle.Select(&usrgroupmembers, "SELECT * FROM usrgroupmember WHERE usr_group_id = ?;", usrGroupID)
+	if err != nil {
+		lw.Warning("GetUsrGroupMembersByUsrGroupID got: %s", err.Error())
+		return nil
+	}
+	return usrgroupmembers
+}
+
+// GetTemporalUsrGroupMembers gets the UsrGroupMembers that carry an end to
+// their validity window
+func (ms *usrgroupmemberSqac) GetTemporalUsrGroupMembers() []UsrGroupMember {
+
+	var usrgroupmembers []UsrGroupMember
+	err := ms.handle.Select(&usrgroupmembers, "SELECT * FROM usrgroupmember WHERE valid_to IS NOT NULL;")
+	if err != nil {
+		lw.Warning("GetTemporalUsrGroupMembers got: %s", err.Error())
+		return nil
+	}
+	return usrgroupmembers
+}
+
+// DeleteUsrGroupMembersByUsrID deletes all UsrGroupMembers of the specified Usr
+func (ms *usrgroupmemberSqac) DeleteUsrGroupMembersByUsrID(usrID uint64) error {
+
+	_, err := ms.handle.Exec("DELETE FROM usrgroupmember WHERE usr_id = ?;", usrID)
+	return err
+}
+
+// DeleteUsrGroupMembersByUsrGroupID deletes all UsrGroupMembers of the specified UsrGroup
+func (ms *usrgroupmemberSqac) DeleteUsrGroupMembersByUsrGroupID(usrGroupID uint64) error {
+
+	_, err := ms.handle.Exec("DELETE FROM usrgroupmember WHERE usr_group_id = ?;", usrGroupID)
+	return err
+}
+
+// MigrateUsrGroupMembers moves the legacy semicolon-separated group names that
+// were stored in the usr.groups column into the UsrGroupMember relation.  Names
+// that do not match an existing UsrGroup are logged and dropped.  The legacy
+// column is cleared once a Usr has been migrated.
+func (s *Services) MigrateUsrGroupMembers() error {
+
+	groups, err := s.UsrGroup.GetUsrGroups()
+	if err != nil {
+		return err
+	}
+	groupIDs := make(map[string]uint64)
+	for _, g := range groups {
+		groupIDs[g.GroupName] = g.ID
+	}
+
+	usrs, err := s.Usr.GetUsrs()
+	if err != nil {
+		return err
+	}
+	for _, u := range usrs {
+		if u.Groups == nil {
+			continue
+		}
+
+		// retain memberships that may have been created prior to the migration
+		var ids []uint64
+		for _, m := range s.UsrGroupMember.GetUsrGroupMembersByUsrID(u.ID) {
+			ids = append(ids, m.UsrGroupID)
+		}
+		for _, n := range strings.Split(*u.Groups, ";") {
+			n = strings.TrimSpace(n)
+			if n == "" {
+				continue
+			}
+			id, ok := groupIDs[n]
+			if !ok {
+				lw.Warning("MigrateUsrGroupMembers: UsrGroup %s of usr %d does not exist and will be dropped", n, u.ID)
+				continue
+			}
+			ids = append(ids, id)
+		}
+
+		err := s.UsrGroupMember.SetUsrGroups(u.ID, ids)
+		if err != nil {
+			return err
+		}
+		_, err = s.handle.Exec("UPDATE usr SET groups = NULL WHERE id = ?;", u.ID)
+		if err != nil {
+			return err
+		}
+		lw.Info("MigrateUsrGroupMembers: migrated the groups of usr %d", u.ID)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package models
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// newTestServices creates the Services on a scratch sqlite db
+func newTestServices(t *testing.T) (*Services, func()) {
+
+	dir, err := ioutil.TempDir("", "models")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewServices(
+		WithSqac("sqlite", filepath.Join(dir, "test.db"), false),
+		WithUsr("pepper"),
+		WithUsrGroup(),
+		WithUsrGroupMember(),
+		WithGroupLeader(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AlterAllTables(); err != nil {
+		t.Fatal(err)
+	}
+	return s, func() {
+		s.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestMigrateUsrGroupMembers(t *testing.T) {
+
+	s, done := newTestServices(t)
+	defer done()
+
+	// the legacy groups column is no longer created by the model
+	if _, err := s.handle.Exec("ALTER TABLE usr ADD COLUMN groups TEXT;"); err != nil {
+		t.Fatal(err)
+	}
+
+	var gids []uint64
+	for _, n := range []string{"Admin", "Staff"} {
+		g := UsrGroup{GroupName: n, Description: n}
+		if err := s.UsrGroup.Create(&g); err != nil {
+			t.Fatal(err)
+		}
+		gids = append(gids, g.ID)
+	}
+
+	legacy := map[string]string{"a@example.com": "Admin;Staff", "b@example.com": " Staff ;Unknown;", "c@example.com": ""}
+	ids := make(map[string]uint64)
+	for email, groups := range legacy {
+		g := "Admin"
+		u := Usr{Name: email, Email: email, Password: "password1", Active: true, Groups: &g}
+		if err := s.Usr.Create(&u); err != nil {
+			t.Fatal(err)
+		}
+		ids[email] = u.ID
+		if _, err := s.handle.Exec("UPDATE usr SET groups = ? WHERE id = ?;", groups, u.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a membership created prior to the migration is retained
+	if err := s.UsrGroupMember.SetUsrGroups(ids["c@example.com"], []uint64{gids[0]}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.MigrateUsrGroupMembers(); err != nil {
+		t.Fatal(err)
+	}
+	// the migration is idempotent
+	if err := s.MigrateUsrGroupMembers(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string][]uint64{
+		"a@example.com": {gids[0], gids[1]},
+		"b@example.com": {gids[1]},
+		"c@example.com": {gids[0]},
+	}
+	for email, w := range want {
+		var got []uint64
+		for _, m := range s.UsrGroupMember.GetUsrGroupMembersByUsrID(ids[email]) {
+			got = append(got, m.UsrGroupID)
+		}
+		sort.Slice(got, func(i, j int) bool { return got[i] < got[j] })
+		if len(got) != len(w) || (len(got) > 0 && (got[0] != w[0] || got[len(got)-1] != w[len(w)-1])) {
+			t.Errorf("%s: got groups %v; want %v", email, got, w)
+		}
+	}
+
+	usrs, err := s.Usr.GetUsrs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range usrs {
+		if u.Groups != nil {
+			t.Errorf("usr %d: got legacy groups %q; want NULL", u.ID, *u.Groups)
+		}
+	}
+}
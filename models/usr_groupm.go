@@ -25,7 +25,7 @@ type UsrGroupDB interface {
 	Update(usrgroup *UsrGroup) error
 	Delete(usrgroup *UsrGroup) error
 	Get(usrgroup *UsrGroup) error
-	GetUsrGroups() []UsrGroup
+	GetUsrGroups() ([]UsrGroup, error)
 	GetUsrGroupsByGroupName(op string, GroupName string) []UsrGroup
 	GetUsrGroupsByDescription(op string, Description string) []UsrGroup
 	// GetRelUsrGroupToUGResources(librarys *[]Library, mapParams map[string]interface{}) error
@@ -152,7 +152,7 @@ func (uv *usrgroupValidator) Get(usrgroup *UsrGroup) error {
 }
 
 // GetUsrGroups is passed through to the ORM with no validation
-func (uv *usrgroupValidator) GetUsrGroups() []UsrGroup {
+func (uv *usrgroupValidator) GetUsrGroups() ([]UsrGroup, error) {
 
 	return uv.UsrGroupDB.GetUsrGroups()
 }
@@ -232,16 +232,16 @@ func (us *usrgroupSqac) Get(usrgroup *UsrGroup) error {
 }
 
 // Get all existing UsrGroups from the db via the ORM
-func (us *usrgroupSqac) GetUsrGroups() []UsrGroup {
+func (us *usrgroupSqac) GetUsrGroups() ([]UsrGroup, error) {
 
 	getEnts := UsrGroupGetEntitiesT{}
 
 	err := us.handle.GetEntities2(&getEnts)
 	if err != nil {
 		lw.Warning("GetUsrGroups got: %s", err.Error())
-		return getEnts.ents
+		return nil, err
 	}
-	return getEnts.ents
+	return getEnts.ents, nil
 }
 
 //- - - - - - - - - - - - - - - - - - -- - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
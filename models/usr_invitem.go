@@ -0,0 +1,322 @@
+package models
+
+//=============================================================================================
+// UsrInvite entity model code
+//=============================================================================================
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/1414C/lw"
+	"github.com/1414C/sqac"
+)
+
+// UsrInvite structure.  A UsrInvite permits the holder of the invitation token
+// to register a new Usr when the application runs in invite-only registration
+// mode.  The invite is bound to an email address and may optionally carry the
+// UsrGroups (semicolon-separated group names) to be assigned to the new Usr.
+// The plain-text token is returned only once in the response to the creation
+// request; only a peppered SHA-256 hash of the token is stored in the db.
+type UsrInvite struct {
+	ID         uint64     `json:"id" db:"id" sqac:"primary_key:inc"`
+	Href       string     `json:"href" db:"href" sqac:"-"`
+	Email      string     `json:"email" db:"email" sqac:"nullable:false;index:non-unique"`
+	Groups     *string    `json:"groups,omitempty" db:"groups" sqac:"nullable:true"`
+	Token      string     `json:"token,omitempty" db:"token" sqac:"-"` // plain-text token; never stored
+	TokenHash  string     `json:"-" db:"token_hash" sqac:"nullable:false;index:unique"`
+	CreatedBy  uint64     `json:"created_by" db:"created_by" sqac:"nullable:false;default:0"`
+	CreatedOn  *time.Time `json:"created_on,omitempty" db:"created_on" sqac:"nullable:false;default:now()"`
+	ExpiresOn  *time.Time `json:"expires_on,omitempty" db:"expires_on" sqac:"nullable:false"`
+	RedeemedOn *time.Time `json:"redeemed_on,omitempty" db:"redeemed_on" sqac:"nullable:true"`
+}
+
+// UsrInviteDB is a CRUD-type interface specifically for dealing with UsrInvites.
+type UsrInviteDB interface {
+	Create(invite *UsrInvite) error
+	Delete(invite *UsrInvite) error
+	Get(invite *UsrInvite) error
+	GetUsrInvites() []UsrInvite
+	ByTokenHash(hash string) (*UsrInvite, error)
+	MarkRedeemed(id uint64, redeemedOn time.Time) (bool, error)
+	ClearRedeemed(id uint64) error
+}
+
+// UsrInviteService is the public interface to the UsrInvite entity
+type UsrInviteService interface {
+
+	// Redeem verifies the presented plain-text token and marks the
+	// invite as redeemed.  An invite can be redeemed exactly once and
+	// only for the email address it was issued to.  Errors will be:
+	// ErrUsrInviteInvalid, ErrUsrInviteExpired, ErrUsrInviteRedeemed,
+	// ErrUsrInviteEmailMismatch or other(!)
+	Redeem(token, email string) (*UsrInvite, error)
+	UsrInviteDB
+}
+
+// private service for usrinvite
+type usrInviteService struct {
+	UsrInviteDB
+	pepper string
+}
+
+// usrInviteValidator checks and normalizes data prior to
+// db access.
+type usrInviteValidator struct {
+	UsrInviteDB
+	pepper string
+}
+
+// usrInviteValFunc type is the prototype for discrete UsrInvite normalization
+// and validation functions that will be executed by func runUsrInviteValFuncs(...)
+type usrInviteValFunc func(*UsrInvite) error
+
+// usrInviteSqac is a sqac-based implementation of the UsrInviteDB interface.
+type usrInviteSqac struct {
+	handle sqac.PublicDB
+}
+
+var _ UsrInviteDB = &usrInviteSqac{}
+
+// newUsrInviteValidator returns a new usrInviteValidator
+func newUsrInviteValidator(idb UsrInviteDB, pepper string) *usrInviteValidator {
+	return &usrInviteValidator{
+		UsrInviteDB: idb,
+		pepper:      pepper,
+	}
+}
+
+// runUsrInviteValFuncs executes a list of discrete validation
+// functions against a usrinvite.
+func runUsrInviteValFuncs(invite *UsrInvite, fns ...usrInviteValFunc) error {
+
+	for _, fn := range fns {
+		err := fn(invite)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewUsrInviteService creates a new UsrInviteService.  The pepper is the
+// same value that is used to salt Usr passwords.
+func NewUsrInviteService(handle sqac.PublicDB, pepper string) UsrInviteService {
+
+	is := &usrInviteSqac{handle}
+
+	iv := newUsrInviteValidator(is, pepper) // *db
+	return &usrInviteService{
+		UsrInviteDB: iv,
+		pepper:      pepper,
+	}
+}
+
+// ensure consistency (build error if delta exists)
+var _ UsrInviteDB = &usrInviteValidator{}
+
+// Redeem reads the UsrInvite via the hash of the presented token and checks
+// that it was issued to email, has not expired and has not been redeemed.  The
+// invite is then marked as redeemed; the update is conditional on the invite
+// not having been redeemed in the meantime, so concurrent registrations with
+// the same token cannot both succeed.
+func (is *usrInviteService) Redeem(token, email string) (*UsrInvite, error) {
+
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return nil, ErrUsrInviteInvalid
+	}
+
+	invite, err := is.ByTokenHash(hashAPIKeySecret(token, is.pepper))
+	if err != nil {
+		return nil, ErrUsrInviteInvalid
+	}
+
+	if invite.RedeemedOn != nil {
+		return nil, ErrUsrInviteRedeemed
+	}
+
+	now := time.Now()
+	if invite.ExpiresOn != nil && now.After(*invite.ExpiresOn) {
+		return nil, ErrUsrInviteExpired
+	}
+
+	if invite.Email != strings.ToLower(strings.TrimSpace(email)) {
+		return nil, ErrUsrInviteEmailMismatch
+	}
+
+	ok, err := is.MarkRedeemed(invite.ID, now)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrUsrInviteRedeemed
+	}
+	invite.RedeemedOn = &now
+	return invite, nil
+}
+
+//-------------------------------------------------------------------------------------------------------
+// CRUD-type model methods for UsrInvite
+//-------------------------------------------------------------------------------------------------------
+//
+// Create validates the usrinvite, generates a new token and then calls the
+// creation code contained in UsrInviteService.  The plain-text token is left
+// in invite.Token so that it can be handed to the caller exactly once.
+func (iv *usrInviteValidator) Create(invite *UsrInvite) error {
+
+	err := runUsrInviteValFuncs(invite,
+		iv.normalizeEmail,
+		iv.requireEmail,
+		iv.expiryInFuture,
+		iv.generateToken,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	// sqac re-reads the new row on create, which clears the
+	// non-persistent token field; hold on to it for the caller.
+	token := invite.Token
+	err = iv.UsrInviteDB.Create(invite)
+	invite.Token = token
+	return err
+}
+
+// Delete is passed through to the ORM with no real
+// validations.  id is checked in the controller.
+func (iv *usrInviteValidator) Delete(invite *UsrInvite) error {
+
+	return iv.UsrInviteDB.Delete(invite)
+}
+
+// Get is passed through to the ORM with no real
+// validations.  id is checked in the controller.
+func (iv *usrInviteValidator) Get(invite *UsrInvite) error {
+
+	return iv.UsrInviteDB.Get(invite)
+}
+
+// GetUsrInvites is passed through to the ORM with no validation
+func (iv *usrInviteValidator) GetUsrInvites() []UsrInvite {
+
+	return iv.UsrInviteDB.GetUsrInvites()
+}
+
+//-------------------------------------------------------------------------------------------------------
+// internal usrInviteValidator funcs
+//-------------------------------------------------------------------------------------------------------
+
+// normalizeEmail trims and lower-cases the email address of the invite
+func (iv *usrInviteValidator) normalizeEmail(invite *UsrInvite) error {
+
+	invite.Email = strings.ToLower(strings.TrimSpace(invite.Email))
+	return nil
+}
+
+// requireEmail checks that the invite has been issued to an email address
+func (iv *usrInviteValidator) requireEmail(invite *UsrInvite) error {
+
+	if invite.Email == "" {
+		return ErrEmailRequired
+	}
+	return nil
+}
+
+// expiryInFuture checks that a new UsrInvite carries an expiry in the future
+func (iv *usrInviteValidator) expiryInFuture(invite *UsrInvite) error {
+
+	if invite.ExpiresOn == nil || !invite.ExpiresOn.After(time.Now()) {
+		return ErrUsrInviteExpiryInvalid
+	}
+	return nil
+}
+
+// generateToken creates a random invitation token.  Only the peppered hash
+// of the token is persisted.
+func (iv *usrInviteValidator) generateToken(invite *UsrInvite) error {
+
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return err
+	}
+
+	invite.Token = hex.EncodeToString(token)
+	invite.TokenHash = hashAPIKeySecret(invite.Token, iv.pepper)
+	invite.RedeemedOn = nil
+	return nil
+}
+
+//-------------------------------------------------------------------------------------------------------
+// ORM db CRUD access methods
+//-------------------------------------------------------------------------------------------------------
+//
+// Create a new UsrInvite in the database via the ORM
+func (is *usrInviteSqac) Create(invite *UsrInvite) error {
+	return is.handle.Create(invite)
+}
+
+// Delete an existing UsrInvite in the database via the ORM
+func (is *usrInviteSqac) Delete(invite *UsrInvite) error {
+	return is.handle.Delete(invite)
+}
+
+// Get an existing UsrInvite from the database via the ORM
+func (is *usrInviteSqac) Get(invite *UsrInvite) error {
+	return is.handle.GetEntity(invite)
+}
+
+// GetUsrInvites gets all existing UsrInvites from the db
+func (is *usrInviteSqac) GetUsrInvites() []UsrInvite {
+
+	var invites []UsrInvite
+	err := is.handle.Select(&invites, "SELECT * FROM usrinvite;")
+	if err != nil {
+		lw.Warning("GetUsrInvites got: %s", err.Error())
+		return nil
+	}
+	return invites
+}
+
+// ByTokenHash - lookup a UsrInvite using the hash of its token
+// 1 - invite, nil
+// 2 - nil, ErrNotFound
+// 3 - nil, otherError
+//
+func (is *usrInviteSqac) ByTokenHash(hash string) (*UsrInvite, error) {
+
+	var invite UsrInvite
+	err := is.handle.Get(&invite, "SELECT * FROM usrinvite WHERE token_hash = ?;", hash)
+	if err != nil {
+		lw.Warning("reading UsrInvite by token_hash got: %s", err.Error())
+		return nil, err
+	}
+	return &invite, nil
+}
+
+// MarkRedeemed sets the redemption timestamp of the specified UsrInvite if it
+// has not already been redeemed.  false is returned if the invite had already
+// been redeemed.
+func (is *usrInviteSqac) MarkRedeemed(id uint64, redeemedOn time.Time) (bool, error) {
+
+	res, err := is.handle.Exec("UPDATE usrinvite SET redeemed_on = ? WHERE id = ? AND redeemed_on IS NULL;", redeemedOn, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// ClearRedeemed resets the redemption timestamp of the specified UsrInvite.  It
+// is used to release an invite when the registration it was redeemed for fails.
+func (is *usrInviteSqac) ClearRedeemed(id uint64) error {
+
+	_, err := is.handle.Exec("UPDATE usrinvite SET redeemed_on = NULL WHERE id = ?;", id)
+	return err
+}
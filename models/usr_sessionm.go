@@ -0,0 +1,228 @@
+package models
+
+//=============================================================================================
+// UsrSession entity model code
+//=============================================================================================
+
+import (
+	"time"
+
+	"github.com/1414C/lw"
+	"github.com/1414C/sqac"
+)
+
+// UsrSession structure.  A UsrSession is recorded for each access token issued
+// to a Usr; the token carries the SID of its session in the sid claim.  The
+// UserAgent and IPAddress are taken from the login request and are
+// informational only.  A revoked session's token is rejected until it expires.
+type UsrSession struct {
+	ID        uint64     `json:"id" db:"id" sqac:"primary_key:inc"`
+	Href      string     `json:"href" db:"href" sqac:"-"`
+	SID       string     `json:"sid" db:"sid" sqac:"nullable:false;index:unique"`
+	UsrID     uint64     `json:"usr_id" db:"usr_id" sqac:"nullable:false;index:non-unique"`
+	UserAgent string     `json:"user_agent" db:"user_agent" sqac:"nullable:false;default:"`
+	IPAddress string     `json:"ip_address" db:"ip_address" sqac:"nullable:false;default:"`
+	IssuedOn  *time.Time `json:"issued_on,omitempty" db:"issued_on" sqac:"nullable:false;default:now()"`
+	ExpiresOn *time.Time `json:"expires_on,omitempty" db:"expires_on" sqac:"nullable:false"`
+	RevokedOn *time.Time `json:"revoked_on,omitempty" db:"revoked_on" sqac:"nullable:true"`
+}
+
+// UsrSessionDB is a CRUD-type interface specifically for dealing with UsrSessions.
+type UsrSessionDB interface {
+	Create(session *UsrSession) error
+	Get(session *UsrSession) error
+	BySID(sid string) (*UsrSession, error)
+	GetUsrSessionsByUsrID(usrID uint64) []UsrSession
+	GetRevokedUsrSessions(now time.Time) []UsrSession
+	MarkRevoked(sid string, revokedOn time.Time) (bool, error)
+	DeleteExpiredUsrSessions(now time.Time) error
+}
+
+// UsrSessionService is the public interface to the UsrSession entity
+type UsrSessionService interface {
+	UsrSessionDB
+}
+
+// private service for usrsession
+type usrSessionService struct {
+	UsrSessionDB
+}
+
+// usrSessionValidator checks and normalizes data prior to
+// db access.
+type usrSessionValidator struct {
+	UsrSessionDB
+}
+
+// usrSessionValFunc type is the prototype for discrete UsrSession normalization
+// and validation functions that will be executed by func runUsrSessionValFuncs(...)
+type usrSessionValFunc func(*UsrSession) error
+
+// usrSessionSqac is a sqac-based implementation of the UsrSessionDB interface.
+type usrSessionSqac struct {
+	handle sqac.PublicDB
+}
+
+var _ UsrSessionDB = &usrSessionSqac{}
+
+// newUsrSessionValidator returns a new usrSessionValidator
+func newUsrSessionValidator(sdb UsrSessionDB) *usrSessionValidator {
+	return &usrSessionValidator{
+		UsrSessionDB: sdb,
+	}
+}
+
+// runUsrSessionValFuncs executes a list of discrete validation
+// functions against a usrsession.
+func runUsrSessionValFuncs(session *UsrSession, fns ...usrSessionValFunc) error {
+
+	for _, fn := range fns {
+		err := fn(session)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewUsrSessionService creates a new UsrSessionService
+func NewUsrSessionService(handle sqac.PublicDB) UsrSessionService {
+
+	ss := &usrSessionSqac{handle}
+
+	sv := newUsrSessionValidator(ss) // *db
+	return &usrSessionService{
+		UsrSessionDB: sv,
+	}
+}
+
+// ensure consistency (build error if delta exists)
+var _ UsrSessionDB = &usrSessionValidator{}
+
+//-------------------------------------------------------------------------------------------------------
+// CRUD-type model methods for UsrSession
+//-------------------------------------------------------------------------------------------------------
+//
+// Create validates the usrsession and then calls the creation code contained
+// in UsrSessionService.
+func (sv *usrSessionValidator) Create(session *UsrSession) error {
+
+	err := runUsrSessionValFuncs(session,
+		sv.requireSID,
+		sv.requireExpiry,
+	)
+
+	if err != nil {
+		return err
+	}
+	return sv.UsrSessionDB.Create(session)
+}
+
+// Get is passed through to the ORM with no real
+// validations.  id is checked in the controller.
+func (sv *usrSessionValidator) Get(session *UsrSession) error {
+
+	return sv.UsrSessionDB.Get(session)
+}
+
+//-------------------------------------------------------------------------------------------------------
+// internal usrSessionValidator funcs
+//-------------------------------------------------------------------------------------------------------
+
+// requireSID checks that the session carries a session ID
+func (sv *usrSessionValidator) requireSID(session *UsrSession) error {
+
+	if session.SID == "" || session.UsrID == 0 {
+		return ErrUsrSessionInvalid
+	}
+	return nil
+}
+
+// requireExpiry checks that the session carries the expiry of its token
+func (sv *usrSessionValidator) requireExpiry(session *UsrSession) error {
+
+	if session.ExpiresOn == nil {
+		return ErrUsrSessionInvalid
+	}
+	session.RevokedOn = nil
+	return nil
+}
+
+//-------------------------------------------------------------------------------------------------------
+// ORM db CRUD access methods
+//-------------------------------------------------------------------------------------------------------
+//
+// Create a new UsrSession in the database via the ORM
+func (ss *usrSessionSqac) Create(session *UsrSession) error {
+	return ss.handle.Create(session)
+}
+
+// Get an existing UsrSession from the database via the ORM
+func (ss *usrSessionSqac) Get(session *UsrSession) error {
+	return ss.handle.GetEntity(session)
+}
+
+// BySID - lookup a UsrSession using its session ID
+// 1 - session, nil
+// 2 - nil, ErrNotFound
+// 3 - nil, otherError
+//
+func (ss *usrSessionSqac) BySID(sid string) (*UsrSession, error) {
+
+	var session UsrSession
+	err := ss.handle.Get(&session, "SELECT * FROM usrsession WHERE sid = ?;", sid)
+	if err != nil {
+		lw.Warning("reading UsrSession by sid got: %s", err.Error())
+		return nil, err
+	}
+	return &session, nil
+}
+
+// GetUsrSessionsByUsrID gets the UsrSessions of the specified Usr from the db
+func (ss *usrSessionSqac) GetUsrSessionsByUsrID(usrID uint64) []UsrSession {
+
+	var sessions []UsrSession
+	err := ss.handle.Select(&sessions, "SELECT * FROM usrsession WHERE usr_id = ? ORDER BY issued_on DESC;", usrID)
+	if err != nil {
+		lw.Warning("GetUsrSessionsByUsrID got: %s", err.Error())
+		return nil
+	}
+	return sessions
+}
+
+// GetRevokedUsrSessions gets the revoked UsrSessions whose tokens have not
+// yet expired from the db
+func (ss *usrSessionSqac) GetRevokedUsrSessions(now time.Time) []UsrSession {
+
+	var sessions []UsrSession
+	err := ss.handle.Select(&sessions, "SELECT * FROM usrsession WHERE revoked_on IS NOT NULL AND expires_on > ?;", now)
+	if err != nil {
+		lw.Warning("GetRevokedUsrSessions got: %s", err.Error())
+		return nil
+	}
+	return sessions
+}
+
+// MarkRevoked sets the revocation timestamp of the specified UsrSession if it
+// has not already been revoked.  false is returned if the session had already
+// been revoked.
+func (ss *usrSessionSqac) MarkRevoked(sid string, revokedOn time.Time) (bool, error) {
+
+	res, err := ss.handle.Exec("UPDATE usrsession SET revoked_on = ? WHERE sid = ? AND revoked_on IS NULL;", revokedOn, sid)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// DeleteExpiredUsrSessions deletes the UsrSessions whose tokens expired
+// before now
+func (ss *usrSessionSqac) DeleteExpiredUsrSessions(now time.Time) error {
+
+	_, err := ss.handle.Exec("DELETE FROM usrsession WHERE expires_on < ?;", now)
+	return err
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"database/sql"
 	"fmt"
 	"regexp"
 	"strings"
@@ -11,18 +12,24 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
-// Usr represents the signed-in usr
+// Usr represents the signed-in usr.  OIDCIssuer and OIDCSubject link the Usr
+// to an identity of the OpenID Connect identity provider; OIDCProvisioned is
+// set for Usrs created by an IdP login, whose group-memberships follow the
+// IdP groups.
 type Usr struct {
-	ID           uint64     `db:"id" sqac:"primary_key:inc"`
-	Href         string     `json:"href" db:"href" sqac:"-"`
-	Name         string     `db:"name" sqac:"nullable:false"`
-	Email        string     `db:"email" sqac:"nullable:false;index:unique"`
-	Password     string     `db:"password" sqac:"-"` // "-" indicates that the field is not to be stored in the db
-	PasswordHash string     `db:"password_hash" sqac:"nullable:false"`
-	CreatedOn    *time.Time `json:"created_on,omitempty" db:"created_on" sqac:"nullable:false;default:now()"`
-	UpdatedOn    *time.Time `json:"updated_on,omitempty" db:"updated_on" sqac:"nullable:false;default:now()"`
-	Active       bool       `db:"active" sqac:"nullable:false;default:false"`
-	Groups       *string    `json:"groups,omitempty" db:"groups" sqac:"nullable:true"` // try with inline{group1;group2;group3} for now
+	ID              uint64     `db:"id" sqac:"primary_key:inc"`
+	Href            string     `json:"href" db:"href" sqac:"-"`
+	Name            string     `db:"name" sqac:"nullable:false"`
+	Email           string     `db:"email" sqac:"nullable:false;index:unique"`
+	Password        string     `db:"password" sqac:"-"` // "-" indicates that the field is not to be stored in the db
+	PasswordHash    string     `db:"password_hash" sqac:"nullable:false"`
+	CreatedOn       *time.Time `json:"created_on,omitempty" db:"created_on" sqac:"nullable:false;default:now()"`
+	UpdatedOn       *time.Time `json:"updated_on,omitempty" db:"updated_on" sqac:"nullable:false;default:now()"`
+	Active          bool       `db:"active" sqac:"nullable:false;default:false"`
+	Groups          *string    `json:"groups,omitempty" db:"groups" sqac:"-"` // group1;group2;group3 - memberships are stored as UsrGroupMembers
+	OIDCIssuer      string     `json:"oidc_issuer,omitempty" db:"oidc_issuer" sqac:"nullable:false;default:"`
+	OIDCSubject     string     `json:"oidc_subject,omitempty" db:"oidc_subject" sqac:"nullable:false;default:"`
+	OIDCProvisioned bool       `json:"oidc_provisioned" db:"oidc_provisioned" sqac:"nullable:false;default:false"`
 }
 
 // UsrDB is an interface that outlines the methods that can be
@@ -36,10 +43,11 @@ type UsrDB interface {
 	Update(usr *Usr) error
 	Delete(usr *Usr) error
 	Get(usr *Usr) error
-	GetUsrs() []Usr
+	GetUsrs() ([]Usr, error)
 
 	// methods for querying single Usr entities
 	ByEmail(email string) (*Usr, error)
+	ByOIDCSubject(issuer, subject string) (*Usr, error)
 	// ByID(id uint) (*Usr, error) // testing-only
 }
 
@@ -51,6 +59,10 @@ type UsrService interface {
 	// correct.  Errors will be:
 	// ErrNotFound, ErrInvalidPassword, or other(!)
 	Authenticate(email string, password string) (*Usr, error)
+
+	// ChangePassword replaces the password of the usr with the specified
+	// ID after verifying the usr's current password.
+	ChangePassword(id uint64, currentPassword, newPassword string) error
 	UsrDB
 }
 
@@ -101,6 +113,32 @@ func (us *usrService) Authenticate(email string, password string) (*Usr, error)
 	return foundUsr, nil
 }
 
+// ChangePassword replaces the password of an active usr.  The usr must provide
+// the current password.
+func (us *usrService) ChangePassword(id uint64, currentPassword, newPassword string) error {
+
+	usr := Usr{
+		ID: id,
+	}
+	err := us.Get(&usr)
+	if err != nil {
+		return err
+	}
+
+	_, err = us.Authenticate(usr.Email, currentPassword)
+	if err != nil {
+		return err
+	}
+
+	if newPassword == "" {
+		return ErrPasswordTooShort
+	}
+	uTime := time.Now()
+	usr.Password = newPassword
+	usr.UpdatedOn = &uTime
+	return us.Update(&usr)
+}
+
 // usrValidator is a layer that validates things before
 // they go to the db to perform queries.  normalization
 // and validation...
@@ -169,8 +207,11 @@ func (uv *usrValidator) Create(usr *Usr) error {
 func (uv *usrValidator) Update(usr *Usr) error {
 
 	// call discrete usrValFuncs(...)
-	err := runUsrValFuncs(usr) // uv.passwordMinLength,    // check that the password meets min length criteria
-	// uv.bcryptPassword,       // bcrypt usr.Password -> usr.PasswordHash
+	// a new password is only hashed if one was provided
+	err := runUsrValFuncs(usr,
+		uv.passwordMinLength, // check that the password meets min length criteria
+		uv.bcryptPassword,    // bcrypt usr.Password -> usr.PasswordHash
+	)
 	// uv.passwordHashRequired, // check that a passwordHash was computed
 	// uv.normalizeEmail,       // normalize content of usr.Email
 	// uv.requireEmail,         // check email address format
@@ -199,7 +240,7 @@ func (uv *usrValidator) Delete(usr *Usr) error {
 }
 
 // GetUsrs is passed through to the ORM with no validation
-func (uv *usrValidator) GetUsrs() []Usr {
+func (uv *usrValidator) GetUsrs() ([]Usr, error) {
 
 	return uv.UsrDB.GetUsrs()
 }
@@ -331,7 +372,7 @@ func (uv *usrValidator) emailIsAvailable(usr *Usr) error {
 // requireGroups checks that at least one Group has been provided
 func (uv *usrValidator) requireGroups(usr *Usr) error {
 
-	if *usr.Groups == "" {
+	if usr.Groups == nil || strings.TrimSpace(*usr.Groups) == "" {
 		return ErrGroupRequired
 	}
 	return nil
@@ -382,6 +423,24 @@ func (us *usrSqac) ByEmail(email string) (*Usr, error) {
 	return &usr, nil
 }
 
+// ByOIDCSubject - lookup the Usr linked to the provided IdP identity
+// 1 - usr, nil
+// 2 - nil, ErrNotFound
+// 3 - nil, otherError
+func (us *usrSqac) ByOIDCSubject(issuer, subject string) (*Usr, error) {
+
+	var usr Usr
+	err := us.handle.Get(&usr, "SELECT * FROM usr WHERE oidc_issuer = ? AND oidc_subject = ?;", issuer, subject)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		lw.Warning("reading Usr by oidc subject got: %s", err.Error())
+		return nil, err
+	}
+	return &usr, nil
+}
+
 // ByID - lookup a Usr using the provided id
 // 1 - usr, nil
 // 2 - nil, ErrNotFound
@@ -420,16 +479,16 @@ func (us *usrSqac) Delete(usr *Usr) error {
 }
 
 // Get all existing Usrs from the db via the ORM
-func (us *usrSqac) GetUsrs() []Usr {
+func (us *usrSqac) GetUsrs() ([]Usr, error) {
 
 	getEnts := UsrGetEntitiesT{}
 
 	err := us.handle.GetEntities2(&getEnts)
 	if err != nil {
 		lw.Warning("GetUsrs got: %s", err.Error())
-		return getEnts.ents
+		return nil, err
 	}
-	return getEnts.ents
+	return getEnts.ents, nil
 }
 
 //- - - - - - - - - - - - - - - - - - -- - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -